@@ -1,36 +1,44 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"pack-calculator/internal/cache"
+	"pack-calculator/internal/config"
 	"pack-calculator/internal/handlers"
 	"pack-calculator/internal/middleware"
 	"pack-calculator/internal/repository"
-	"strconv"
+	"pack-calculator/internal/webhook"
+	"pack-calculator/internal/workerpool"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
-	// Get environment variables with defaults
-	port := getEnv("PORT", "8080")
-	dbHost := getEnv("DB_HOST", "localhost")
-	dbPort := getEnv("DB_PORT", "5432")
-	dbUser := getEnv("DB_USER", "postgres")
-	dbPassword := getEnv("DB_PASSWORD", "postgres")
-	dbName := getEnv("DB_NAME", "packcalculator")
+	// Structured JSON logs; the standard logger is routed through the same handler
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+
+	// Resolve configuration from environment variables with defaults
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize database connection with retry logic
 	var db *sql.DB
-	var err error
 	maxRetries := 30
 
 	log.Println("Connecting to database...")
 	for i := 0; i < maxRetries; i++ {
-		db, err = repository.InitDB(dbHost, dbPort, dbUser, dbPassword, dbName)
+		db, err = repository.InitDB(cfg.Database.Host, cfg.Database.Port, cfg.Database.User, cfg.Database.Password, cfg.Database.Name)
 		if err == nil {
 			break
 		}
@@ -44,16 +52,22 @@ func main() {
 	defer db.Close()
 
 	// Configure connection pool for optimal performance
-	db.SetMaxOpenConns(50)                  // Maximum number of open connections (increased for concurrency)
-	db.SetMaxIdleConns(10)                  // Maximum number of idle connections (reduced to save memory)
-	db.SetConnMaxLifetime(1 * time.Minute)  // Connection lifetime (shorter to avoid stale connections)
-	db.SetConnMaxIdleTime(30 * time.Second) // Close unused connections faster
+	db.SetMaxOpenConns(cfg.Database.MaxOpenConns)                      // Maximum number of open connections (increased for concurrency)
+	db.SetMaxIdleConns(cfg.Database.MaxIdleConns)                      // Maximum number of idle connections (reduced to save memory)
+	db.SetConnMaxLifetime(time.Duration(cfg.Database.ConnMaxLifetime)) // Connection lifetime (shorter to avoid stale connections)
+	db.SetConnMaxIdleTime(time.Duration(cfg.Database.ConnMaxIdleTime)) // Close unused connections faster
 
 	log.Println("Connected to database successfully")
-	log.Printf("Connection pool configured: max_open=50, max_idle=10, lifetime=1m, idle_timeout=30s")
+	log.Printf("Connection pool configured: max_open=%d, max_idle=%d, lifetime=%s, idle_timeout=%s",
+		cfg.Database.MaxOpenConns, cfg.Database.MaxIdleConns,
+		time.Duration(cfg.Database.ConnMaxLifetime), time.Duration(cfg.Database.ConnMaxIdleTime))
 
 	// Initialize repository
 	repo := repository.NewRepository(db)
+	if cfg.CompressPacksJSON {
+		repo.SetPacksCompression(true)
+		log.Println("Order packs compression enabled")
+	}
 
 	// Initialize database schema
 	log.Println("Initializing database schema...")
@@ -61,12 +75,36 @@ func main() {
 		log.Fatalf("Failed to initialize schema: %v", err)
 	}
 
+	// Converting zoneless timestamps rewrites tables, so it only runs when asked for.
+	// Until it has, this version does not start: it writes UTC, which a zoneless column
+	// holding local wall times would mix with them.
+	if cfg.MigrateTimestamps {
+		migrated, err := repo.MigrateTimestamps(cfg.LegacyTimeZone)
+		if err != nil {
+			log.Fatalf("Failed to migrate timestamps: %v", err)
+		}
+		log.Printf("Migrated timestamp columns to TIMESTAMPTZ (legacy zone %s): %v", cfg.LegacyTimeZone, migrated)
+	} else if legacy, err := repo.LegacyTimestampColumns(); err != nil {
+		log.Fatalf("Failed to check for legacy timestamp columns: %v", err)
+	} else if len(legacy) > 0 {
+		log.Fatalf("Timestamp columns %v have no zone; set MIGRATE_TIMESTAMPS=1 and LEGACY_TIME_ZONE to convert them", legacy)
+	}
+
 	// Seed default pack sizes
 	log.Println("Seeding default pack sizes...")
 	if err := repo.SeedDefaultPackSizes(); err != nil {
 		log.Fatalf("Failed to seed pack sizes: %v", err)
 	}
 
+	// Optionally make sure the full default set exists on non-empty deployments too
+	if cfg.ReconcileDefaults {
+		added, err := repo.ReconcileDefaultPackSizes()
+		if err != nil {
+			log.Fatalf("Failed to reconcile default pack sizes: %v", err)
+		}
+		log.Printf("Reconciled default pack sizes, added: %v", added)
+	}
+
 	// Prepare SQL statements for better performance
 	log.Println("Preparing SQL statements...")
 	if err := repo.PrepareStatements(); err != nil {
@@ -75,76 +113,323 @@ func main() {
 	log.Println("Prepared statements ready")
 
 	// Initialize cache
-	cacheSize := 1000 // Default cache size
-	if cacheSizeStr := getEnv("CACHE_SIZE", ""); cacheSizeStr != "" {
-		if size, err := strconv.Atoi(cacheSizeStr); err == nil {
-			cacheSize = size
+	memCache := cache.NewMemoryCacheWithPolicy(cfg.Cache.Size, cache.NewEvictionPolicy(cfg.Cache.Eviction))
+	log.Printf("Memory cache initialized with max size: %d, eviction: %s", cfg.Cache.Size, cfg.Cache.Eviction)
+	memCache.StartJanitor(time.Duration(cfg.Cache.SweepInterval))
+
+	// Share the cache across instances via Redis or a consistent-hashing peer ring (optional).
+	// An unreachable Redis falls back to the memory cache rather than failing startup.
+	var resultCache cache.Cache = memCache
+	var peerCache *cache.PeerCache
+	var idempotencyBackend cache.BlobStore // Shared idempotency responses, with Redis
+	if cfg.Cache.Backend == config.CacheBackendRedis {
+		redisCache, err := cache.NewRedisCache(cfg.Cache.RedisAddr, 500*time.Millisecond)
+		if err != nil {
+			log.Printf("Warning: Redis cache at %s unavailable, using memory cache: %v", cfg.Cache.RedisAddr, err)
+		} else {
+			defer redisCache.Close()
+			resultCache = redisCache
+			idempotencyBackend = redisCache
+			log.Printf("Redis cache enabled: addr=%s", cfg.Cache.RedisAddr)
 		}
+	} else if len(cfg.Cache.Peers) > 0 {
+		peerCache = cache.NewPeerCache(cfg.Cache.Self, cfg.Cache.Peers, cfg.Cache.PeerSecret, memCache, 500*time.Millisecond)
+		resultCache = peerCache
+		log.Printf("Peer cache enabled: self=%s, peers=%d", cfg.Cache.Self, len(cfg.Cache.Peers))
 	}
-	memCache := cache.NewMemoryCache(cacheSize)
-	log.Printf("Memory cache initialized with max size: %d", cacheSize)
 
 	// Initialize handlers
-	handler := handlers.NewHandler(repo, memCache)
+	handlerConfig := handlers.DefaultConfig()
+	handlerConfig.MaxPackSizes = cfg.MaxPackSizes
+	handlerConfig.MaxPackSize = cfg.MaxPackSize
+	handlerConfig.CacheTTL = time.Duration(cfg.Cache.TTL)
+	handlerConfig.CalcTimeout = time.Duration(cfg.Calc.Timeout)
+	handlerConfig.MaxCalcBudget = time.Duration(cfg.Calc.MaxBudget)
+	handlerConfig.CalcMemoryBudget = cfg.Calc.MemoryBudget
+	handlerConfig.MaxBatchAmounts = cfg.MaxBatchAmounts
+	handlerConfig.EfficiencyDecimals = cfg.EfficiencyDecimals
+	handlerConfig.CustomSizeMin = cfg.CustomSizes.MinSize
+	handlerConfig.CustomSizesAllowed = cfg.CustomSizes.Allowed
+	handlerConfig.OrderSampleRate = cfg.Orders.SampleRate
+	handlerConfig.StrictExact = cfg.StrictExact
+	if handlerConfig.StrictExact {
+		log.Printf("Strict exact mode: calculations reject overshoot unless ?allow_overshoot=1")
+	}
+	if handlerConfig.MaxPackSizes > 0 {
+		log.Printf("Pack size limit: %d distinct sizes", handlerConfig.MaxPackSizes)
+	}
+	handler := handlers.NewHandlerWithConfig(repo, resultCache, handlerConfig)
+	handler.SetEffectiveConfig(cfg)
+	handler.SetDatabase(db)
+
+	// Bounded calculation worker pool (optional)
+	if cfg.Pool.Workers > 0 {
+		handler.SetCalculationPool(workerpool.NewPool(cfg.Pool.Workers, cfg.Pool.Queue, 50*time.Millisecond))
+		log.Printf("Calculation pool: workers=%d, queue=%d", cfg.Pool.Workers, cfg.Pool.Queue)
+	}
+
+	// Webhooks for pack size changes (optional)
+	if len(cfg.WebhookURLs) > 0 {
+		handler.SetNotifier(webhook.NewNotifier(cfg.WebhookURLs, 5*time.Second))
+		log.Printf("Pack size change webhooks enabled for %d URL(s)", len(cfg.WebhookURLs))
+	}
+
+	// Periodic stats snapshots for GET /api/stats/history (optional)
+	if interval := time.Duration(cfg.Stats.SnapshotInterval); interval > 0 {
+		stopSnapshots := handler.StartStatsSnapshots(interval, time.Duration(cfg.Stats.Retention))
+		defer stopSnapshots()
+		log.Printf("Stats snapshots every %s, retained for %s", interval, time.Duration(cfg.Stats.Retention))
+	}
+
+	// Order retention (optional): expired orders are deleted in the background
+	if retention := time.Duration(cfg.Orders.Retention); retention > 0 {
+		stopReaper := handler.StartOrderReaper(time.Duration(cfg.Orders.ReapInterval), retention)
+		defer stopReaper()
+		log.Printf("Orders retained for %s, reaped every %s", retention, time.Duration(cfg.Orders.ReapInterval))
+	}
 
 	// Initialize middleware
-	// Rate limiter: 100 requests per 10 seconds per IP (burst of 20)
-	rateLimiter := middleware.NewRateLimiter(100*time.Millisecond, 20)
+	// Rate limiter: 100 requests per 10 seconds per IP (burst of 20) by default, tuned with
+	// RATE_LIMIT_RATE and RATE_LIMIT_BURST or skipped with RATE_LIMIT_ENABLED=false
+	rateLimiter := middleware.NewRateLimiter(time.Duration(cfg.RateLimit.Interval), cfg.RateLimit.Burst)
+	trustedProxies, err := config.ParseTrustedProxies(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	rateLimiter.SetTrustedProxies(trustedProxies)
 	rateLimit := middleware.RateLimitMiddleware(rateLimiter)
+	if !cfg.RateLimit.Enabled {
+		rateLimit = func(next http.HandlerFunc) http.HandlerFunc { return next }
+	}
 
 	// API key authentication (optional, for write operations on pack sizes)
-	apiKey := getEnv("API_KEY", "") // Leave empty for no auth
-	apiKeyAuth := middleware.NewAPIKeyAuth(apiKey)
+	apiKeyAuth := middleware.NewAPIKeyAuth(cfg.APIKey) // Empty means no auth; comma-separate multiple keys
+	apiKeyAuth.SetAllowQueryKey(cfg.AllowQueryAPIKey)
+	for _, tk := range cfg.TenantAPIKeys {
+		if err := apiKeyAuth.SetTenantKey(tk.Tenant, tk.Key); err != nil {
+			log.Fatalf("Invalid TENANT_API_KEYS: %v", err)
+		}
+	}
+	if len(cfg.TenantAPIKeys) > 0 {
+		log.Printf("Tenant API keys configured for %d tenants", len(cfg.TenantAPIKeys))
+	}
 
-	log.Println("Rate limiting enabled: 100 req/10s per IP")
-	if apiKey != "" {
+	if cfg.RateLimit.Enabled {
+		log.Printf("Rate limiting enabled: 1 token per %s per IP, burst %d", time.Duration(cfg.RateLimit.Interval), cfg.RateLimit.Burst)
+	} else {
+		log.Println("Rate limiting disabled (RATE_LIMIT_ENABLED=false)")
+	}
+	if cfg.RateLimit.Enabled && len(trustedProxies) > 0 {
+		log.Printf("X-Forwarded-For honored from trusted proxies: %s", strings.Join(cfg.RateLimit.TrustedProxies, ", "))
+	}
+	if cfg.APIKey != "" {
 		log.Println("API key authentication enabled for pack size modifications")
+		if cfg.AllowQueryAPIKey {
+			log.Println("API keys also accepted via the api_key query parameter")
+		}
+	}
+
+	// Optionally give each API key its own rate limit bucket instead of sharing the client IP's
+	if cfg.RateLimit.Enabled && cfg.RateLimit.ByAPIKey {
+		rateLimiter.SetKeyByAPIKey(true)
+		limitByIP := rateLimit
+		rateLimit = func(next http.HandlerFunc) http.HandlerFunc {
+			return apiKeyAuth.Identify(limitByIP(next))
+		}
+		log.Println("Rate limiting keyed by API key for authenticated requests")
+	}
+
+	// Idempotency-Key replay for requests that compute, reserve or change stock, orders or
+	// pack sizes. Without Redis the responses get a memory cache of their own, capped at
+	// IDEMPOTENCY_MAX_KEYS, so clearing results on a pack size change keeps them; expired
+	// keys are swept every minute. Keys are scoped to the caller's API key, identified
+	// here since the calculation routes do not require one.
+	if idempotencyBackend == nil {
+		idempotencyCache := cache.NewMemoryCache(cfg.IdempotencyMaxKeys)
+		idempotencyCache.StartJanitor(time.Minute)
+		defer idempotencyCache.Close()
+		idempotencyBackend = idempotencyCache
+	}
+	idempotencyStore := middleware.NewIdempotencyStore(idempotencyBackend, time.Duration(cfg.IdempotencyWindow))
+	idempotency := middleware.IdempotencyMiddleware(idempotencyStore)
+	idempotent := func(next http.HandlerFunc) http.HandlerFunc {
+		return apiKeyAuth.Identify(idempotency(next))
+	}
+	log.Printf("Idempotency keys replayed for %s", time.Duration(cfg.IdempotencyWindow))
+
+	// handle registers a route and records it for GET /api/config. Every route is
+	// scoped to the tenant of the request's API key, or the default tenant without one.
+	handle := func(pattern string, handlerFunc http.HandlerFunc) {
+		http.HandleFunc(pattern, apiKeyAuth.TenantMiddleware(handlerFunc))
+		cfg.Endpoints = append(cfg.Endpoints, pattern)
 	}
 
 	// Setup routes with middleware (rate limiting + CORS)
-	http.HandleFunc("/health", handlers.EnableCORS(handler.HealthCheck))
+	handle("/health", handlers.EnableCORS(handler.HealthCheck))
+
+	// Readiness probe: 503 while the database or a shared cache is unreachable
+	handle("/ready", handlers.EnableCORS(handler.Ready))
+
+	// Load balancer gate: 503 until the cache has been warmed from recent orders
+	handle("/api/ready-for-traffic", handlers.EnableCORS(handler.ReadyForTraffic))
 
 	// Calculator endpoint with rate limiting and CORS
-	http.HandleFunc("/api/calculate", handlers.EnableCORS(rateLimit(handler.CalculatePacks)))
+	handle("/api/calculate", handlers.EnableCORS(rateLimit(idempotent(handler.CalculatePacks))))
+
+	// Approximate greedy calculation for latency-critical callers
+	handle("/api/calculate/fast", handlers.EnableCORS(rateLimit(handler.CalculateFast)))
+
+	// Streaming calculation over a range of amounts (Server-Sent Events)
+	handle("/api/calculate/range/stream", handlers.EnableCORS(rateLimit(handler.StreamCalculationRange)))
+
+	// Feasibility check for many amounts in one DP pass
+	handle("/api/calculate/feasibility", handlers.EnableCORS(rateLimit(handler.CheckFeasibility)))
+
+	// Many independent amounts in one request, each saved as an order
+	handle("/api/calculate/batch", handlers.EnableCORS(rateLimit(idempotent(handler.CalculateBatch))))
+
+	// Per-order vs consolidated packing for a multi-order shipment
+	handle("/api/calculate/consolidated", handlers.EnableCORS(rateLimit(handler.CalculateConsolidated)))
+
+	// Printable packing slip PDF for an amount
+	handle("/api/calculate/slip", handlers.EnableCORS(rateLimit(handler.CalculationSlip)))
 
 	// Pack sizes endpoint with rate limiting and optional auth
-	http.HandleFunc("/api/packs", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+	handle("/api/packs", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
+			// Deleted sizes are for admins only
+			if r.URL.Query().Has("include_deleted") {
+				apiKeyAuth.RequireAPIKey(handler.GetPackSizes)(w, r)
+				return
+			}
 			handler.GetPackSizes(w, r)
 		case http.MethodPost:
 			handler.AddPackSize(w, r)
 		default:
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			handlers.MethodNotAllowed(w, r)
 		}
-	}))))
+	})))))
+
+	// Get, update, delete or restore (POST /api/packs/{size}/restore) a pack size with
+	// rate limiting and optional auth
+	handle("/api/packs/", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			handler.GetPackSize(w, r)
+		case http.MethodPost:
+			handler.RestorePackSize(w, r)
+		case http.MethodPut:
+			handler.UpdatePackSize(w, r)
+		case http.MethodDelete:
+			handler.DeletePackSize(w, r)
+		default:
+			handlers.MethodNotAllowed(w, r)
+		}
+	})))))
+
+	// CSV import of pack sizes with per-row errors (?strict=true aborts on the first one)
+	handle("/api/packs/import", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.ImportPackSizes)))))
+
+	// Many pack sizes in one transaction, skipping those already configured (optional auth)
+	handle("/api/packs/bulk", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.BulkAddPackSizes)))))
+
+	// Dry run of adding a pack size: size limits and calculation feasibility
+	handle("/api/packs/validate", handlers.EnableCORS(rateLimit(handler.ValidatePackSize)))
+
+	// Side-by-side efficiency of the current and a proposed pack size set
+	handle("/api/packs/compare", handlers.EnableCORS(rateLimit(handler.ComparePackSets)))
+
+	// Named pack size profiles, selected on /api/calculate with ?profile= (optional auth)
+	handle("/api/profiles", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.CreateProfile)))))
 
-	// Delete pack size with rate limiting and optional auth
-	http.HandleFunc("/api/packs/", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(handler.DeletePackSize))))
+	// Stock levels and reservations with rate limiting and optional auth
+	handle("/api/stock", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.SetStock)))))
+	handle("/api/stock/reserve", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.ReserveStock)))))
 
 	// Order history with rate limiting
-	http.HandleFunc("/api/orders", handlers.EnableCORS(rateLimit(handler.GetOrders)))
+	handle("/api/orders", handlers.EnableCORS(rateLimit(handler.GetOrders)))
+
+	// Delete an order with rate limiting and optional auth
+	handle("/api/orders/", handlers.EnableCORS(rateLimit(apiKeyAuth.AuthMiddleware(idempotent(handler.DeleteOrder)))))
+
+	// Recompute order totals from stored packs (admin only, refused without API_KEY)
+	handle("/api/orders/recompute", handlers.EnableCORS(rateLimit(apiKeyAuth.RequireAdminKey(handler.RecomputeOrders))))
+
+	// Cache memory report (admin only)
+	handle("/api/cache/memory", handlers.EnableCORS(rateLimit(apiKeyAuth.RequireAPIKey(handler.GetCacheMemory))))
+
+	// Histogram of DP table sizes, to spot pack sets that cause huge tables
+	handle("/api/stats/dp", handlers.EnableCORS(rateLimit(handler.GetDPStats)))
+
+	// Historical stats snapshots for trend analysis
+	handle("/api/stats/history", handlers.EnableCORS(rateLimit(handler.GetStatsHistory)))
+
+	// Optional capabilities, for clients adapting to the deployment
+	handle("/api/features", handlers.EnableCORS(rateLimit(handler.GetFeatures)))
+
+	// Effective configuration with secrets redacted (admin only, refused without API_KEY)
+	handle("/api/config", handlers.EnableCORS(rateLimit(apiKeyAuth.RequireAdminKey(handler.GetConfig))))
+
+	// Peer cache endpoint for other nodes on the ring
+	if peerCache != nil {
+		handle(cache.PeerPath, peerCache.ServeHTTP)
+	}
+
+	// Gzip responses larger than the configured minimum
+	compress := middleware.CompressionMiddlewareWithMinLength(cfg.CompressionMinLength)
+	log.Printf("Gzip compression enabled for responses over %d bytes", cfg.CompressionMinLength)
+
+	// Catch-all: JSON 404 for anything not matched above
+	handle("/", handlers.EnableCORS(handlers.NotFoundHandler))
 
 	// Configure HTTP server
 	server := &http.Server{
-		Addr:         fmt.Sprintf("0.0.0.0:%s", port),
-		Handler:      nil,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		Addr:         fmt.Sprintf("0.0.0.0:%s", cfg.Port),
+		Handler:      middleware.LoggingMiddleware(compress(middleware.TrimTrailingSlash(http.DefaultServeMux.ServeHTTP))),
+		ReadTimeout:  time.Duration(cfg.Server.ReadTimeout),
+		WriteTimeout: time.Duration(cfg.Server.WriteTimeout),
+		IdleTimeout:  time.Duration(cfg.Server.IdleTimeout),
 	}
 
+	// Stop on SIGINT/SIGTERM; the context also cancels background work started below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Warm the cache from recent orders in the background; /api/ready-for-traffic
+	// reports 503 until this finishes
+	go func() {
+		start := time.Now()
+		if err := handler.WarmUp(ctx, 100); err != nil {
+			log.Printf("Cache warm-up incomplete: %v", err)
+		}
+		log.Printf("Cache warm-up finished in %s, ready for traffic", time.Since(start))
+	}()
+
 	// Start server
-	log.Printf("Server starting on %s", server.Addr)
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
-	}
-}
+	go func() {
+		log.Printf("Server starting on %s", server.Addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}()
 
-func getEnv(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+	<-ctx.Done()
+	stop() // A second signal kills the process instead of waiting for the drain
+
+	// Drain in-flight requests, then release resources they may still be using
+	timeout := time.Duration(cfg.Server.ShutdownTimeout)
+	log.Printf("Shutting down, draining in-flight requests for up to %s", timeout)
+	drainStart := time.Now()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown incomplete, closing remaining connections: %v", err)
 	}
-	return value
+	log.Printf("Drained in %.1f seconds", time.Since(drainStart).Seconds())
+
+	rateLimiter.Stop()
+	memCache.Close()
+	memCache.Clear()
+	// Background jobs stop and the DB pool closes as the deferred calls run
 }
@@ -0,0 +1,66 @@
+package cache
+
+import "time"
+
+// BlobStore is implemented by caches that can also hold opaque byte values, such as
+// the responses replayed for idempotency keys. Blobs share the cache's capacity and
+// expiry but are not calculation results: Get never returns them.
+type BlobStore interface {
+	GetBlob(key string) ([]byte, bool)
+	SetBlob(key string, value []byte, ttl time.Duration)
+	AddBlob(key string, value []byte, ttl time.Duration) bool // SetBlob only if key is absent
+	DeleteBlob(key string)
+}
+
+// GetBlob retrieves a value stored by SetBlob. Blob lookups are not counted in Stats.
+func (c *MemoryCache) GetBlob(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists || item.blob == nil || time.Now().After(item.expiration) {
+		return nil, false
+	}
+	c.policy.Accessed(key)
+	return item.blob, true
+}
+
+// SetBlob stores value under key for ttl. Blobs are not published to Subscribe, since
+// a mirror only serves calculation results.
+func (c *MemoryCache) SetBlob(key string, value []byte, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putLocked(key, &cacheItem{
+		blob:       value,
+		expiration: time.Now().Add(ttl),
+		bytes:      entryOverheadBytes + len(key) + len(value),
+	})
+}
+
+// AddBlob stores value under key for ttl unless an unexpired entry already holds
+// the key, and reports whether it did
+func (c *MemoryCache) AddBlob(key string, value []byte, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, exists := c.items[key]; exists && !time.Now().After(item.expiration) {
+		return false
+	}
+	c.putLocked(key, &cacheItem{
+		blob:       value,
+		expiration: time.Now().Add(ttl),
+		bytes:      entryOverheadBytes + len(key) + len(value),
+	})
+	return true
+}
+
+// DeleteBlob removes the value stored under key, if any
+func (c *MemoryCache) DeleteBlob(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if item, exists := c.items[key]; exists {
+		c.removeLocked(key, item)
+	}
+}
@@ -1,6 +1,9 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,37 +25,60 @@ type CacheStats struct {
 	Misses   int64
 	HitRatio float64
 	Size     int
+	Pinned   int
 }
 
-// MemoryCache implements in-memory LRU cache with O(1) operations
+// MemoryCache implements in-memory cache with O(1) operations, evicting by its EvictionPolicy
 type MemoryCache struct {
 	items   map[string]*cacheItem
-	head    *lruNode // Most recently used
-	tail    *lruNode // Least recently used
 	maxSize int
+	pinned  int // Number of pinned entries
+	policy  EvictionPolicy
 	mu      sync.RWMutex
 	hits    int64
 	misses  int64
+
+	subscribers []chan CacheEvent // Change log consumers, see Subscribe
+
+	done        chan struct{} // Closed by Close to end the janitor goroutine
+	janitorOnce sync.Once
+	closeOnce   sync.Once
 }
 
+// MaxPinnedFraction is the share of maxSize that may be pinned, so eviction always has candidates
+const MaxPinnedFraction = 0.5
+
+// Errors returned by Pin
+var (
+	ErrNotCached = errors.New("key is not cached")
+	ErrPinLimit  = errors.New("pinned entry limit reached")
+)
+
 type cacheItem struct {
 	packs      map[int]int
 	total      int
+	blob       []byte // Opaque value stored by SetBlob instead of a result
 	expiration time.Time
-	node       *lruNode // Reference to LRU node for O(1) access
-}
-
-type lruNode struct {
-	key  string
-	prev *lruNode
-	next *lruNode
+	bytes      int  // Approximate memory footprint of the entry
+	pinned     bool // Pinned entries are never evicted
 }
 
 // NewMemoryCache creates a new in-memory cache with O(1) LRU
 func NewMemoryCache(maxSize int) *MemoryCache {
+	return NewMemoryCacheWithPolicy(maxSize, NewLRUPolicy())
+}
+
+// NewMemoryCacheWithPolicy creates a new in-memory cache evicting by policy, which
+// must not be shared with another cache. A nil policy means LRU.
+func NewMemoryCacheWithPolicy(maxSize int, policy EvictionPolicy) *MemoryCache {
+	if policy == nil {
+		policy = NewLRUPolicy()
+	}
 	return &MemoryCache{
 		items:   make(map[string]*cacheItem, maxSize),
 		maxSize: maxSize,
+		policy:  policy,
+		done:    make(chan struct{}),
 	}
 }
 
@@ -61,7 +87,7 @@ func (c *MemoryCache) Get(key string) (map[int]int, int, bool) {
 	// Fast path: read with RLock for concurrency
 	c.mu.RLock()
 	item, exists := c.items[key]
-	if !exists || time.Now().After(item.expiration) {
+	if !exists || item.blob != nil || time.Now().After(item.expiration) {
 		c.mu.RUnlock()
 		atomic.AddInt64(&c.misses, 1)
 		return nil, 0, false
@@ -72,97 +98,165 @@ func (c *MemoryCache) Get(key string) (map[int]int, int, bool) {
 
 	atomic.AddInt64(&c.hits, 1)
 
-	// Move to front (most recently used) with write lock
+	// Move to front (most recently used) with write lock. The entry may have been
+	// evicted or swept since the read lock was released, leaving its node unlinked.
 	c.mu.Lock()
-	c.moveToFront(item.node)
+	if c.items[key] == item {
+		c.touchLocked(key)
+	}
 	c.mu.Unlock()
 
 	return packs, total, true
 }
 
+// touchLocked records a read of key for eviction and publishes it; callers must hold c.mu
+func (c *MemoryCache) touchLocked(key string) {
+	c.policy.Accessed(key)
+	c.emit(CacheEvent{Type: CacheEventAccess, Key: key})
+}
+
 // Set stores a result in cache with O(1) LRU update
 func (c *MemoryCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	now := time.Now()
+	c.setLocked(key, packs, total, time.Now().Add(ttl))
+}
 
+// setLocked stores an entry with an absolute expiration; callers must hold c.mu
+func (c *MemoryCache) setLocked(key string, packs map[int]int, total int, expiration time.Time) {
+	c.emit(CacheEvent{Type: CacheEventSet, Key: key, Packs: packs, Total: total, Expiration: expiration})
+	c.putLocked(key, &cacheItem{
+		packs:      packs,
+		total:      total,
+		expiration: expiration,
+		bytes:      estimateEntryBytes(key, packs),
+	})
+}
+
+// putLocked inserts or replaces the value of key with entry's, evicting an item chosen
+// by the policy if the cache is full; callers must hold c.mu
+func (c *MemoryCache) putLocked(key string, entry *cacheItem) {
 	// Check if key already exists
 	if item, exists := c.items[key]; exists {
 		// Update existing item
-		item.packs = packs
-		item.total = total
-		item.expiration = now.Add(ttl)
-		c.moveToFront(item.node)
+		item.packs = entry.packs
+		item.total = entry.total
+		item.blob = entry.blob
+		item.expiration = entry.expiration
+		item.bytes = entry.bytes
+		c.policy.Accessed(key)
 		return
 	}
 
-	// If at max size, evict least recently used item
+	// If at max size, evict the unpinned item chosen by the policy
 	if len(c.items) >= c.maxSize {
-		c.evictLRU()
+		victim, ok := c.policy.Victim(func(key string) bool { return c.items[key].pinned })
+		if ok {
+			c.removeLocked(victim, c.items[victim])
+		}
 	}
 
-	// Create new node and add to front
-	node := &lruNode{key: key}
-	c.items[key] = &cacheItem{
-		packs:      packs,
-		total:      total,
-		expiration: now.Add(ttl),
-		node:       node,
+	c.items[key] = entry
+	c.policy.Added(key)
+}
+
+// removeLocked deletes an entry and records the eviction; callers must hold c.mu
+func (c *MemoryCache) removeLocked(key string, item *cacheItem) {
+	if item.pinned {
+		c.pinned--
 	}
-	c.addToFront(node)
+	c.policy.Removed(key)
+	delete(c.items, key)
+	c.emit(CacheEvent{Type: CacheEventEvict, Key: key})
 }
 
-// evictLRU removes the least recently used item in O(1)
-func (c *MemoryCache) evictLRU() {
-	if c.tail == nil {
+// StartJanitor removes expired entries every interval in a background goroutine, so
+// entries that are never read again do not wait for eviction to free their memory.
+// Pinned entries are left for Set to refresh. Only the first call has an effect and
+// a non-positive interval starts nothing; Close stops the janitor.
+func (c *MemoryCache) StartJanitor(interval time.Duration) {
+	if interval <= 0 {
 		return
 	}
-
-	// Remove tail (least recently used)
-	key := c.tail.key
-	c.removeNode(c.tail)
-	delete(c.items, key)
+	c.janitorOnce.Do(func() { go c.sweepExpired(interval) })
 }
 
-// addToFront adds a node to the front (most recently used)
-func (c *MemoryCache) addToFront(node *lruNode) {
-	node.next = c.head
-	node.prev = nil
+// sweepExpired runs the janitor loop until Close
+func (c *MemoryCache) sweepExpired(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-	if c.head != nil {
-		c.head.prev = node
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+		c.removeExpired()
 	}
-	c.head = node
+}
 
-	if c.tail == nil {
-		c.tail = node
+// removeExpired removes every expired, unpinned entry and returns how many were removed
+func (c *MemoryCache) removeExpired() int {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for key, item := range c.items {
+		if item.pinned || !now.After(item.expiration) {
+			continue
+		}
+		c.removeLocked(key, item)
+		removed++
 	}
+	return removed
 }
 
-// removeNode removes a node from the linked list
-func (c *MemoryCache) removeNode(node *lruNode) {
-	if node.prev != nil {
-		node.prev.next = node.next
-	} else {
-		c.head = node.next
-	}
+// Close stops the janitor goroutine. The cache keeps working; expired entries are
+// just removed lazily again. Safe to call more than once.
+func (c *MemoryCache) Close() error {
+	c.closeOnce.Do(func() { close(c.done) })
+	return nil
+}
 
-	if node.next != nil {
-		node.next.prev = node.prev
-	} else {
-		c.tail = node.prev
+// Pin excludes a cached entry from eviction. At most MaxPinnedFraction of maxSize
+// entries may be pinned; beyond that ErrPinLimit is returned.
+func (c *MemoryCache) Pin(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists {
+		return ErrNotCached
+	}
+	if item.pinned {
+		return nil
 	}
+	if c.pinned+1 > int(float64(c.maxSize)*MaxPinnedFraction) {
+		return ErrPinLimit
+	}
+
+	item.pinned = true
+	c.pinned++
+	return nil
 }
 
-// moveToFront moves a node to the front (most recently used)
-func (c *MemoryCache) moveToFront(node *lruNode) {
-	if node == c.head {
-		return // Already at front
+// Unpin makes a pinned entry evictable again. Returns false if it was not pinned.
+func (c *MemoryCache) Unpin(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	item, exists := c.items[key]
+	if !exists || !item.pinned {
+		return false
 	}
 
-	c.removeNode(node)
-	c.addToFront(node)
+	item.pinned = false
+	c.pinned--
+	return true
 }
 
 // Clear removes all cached items
@@ -171,8 +265,9 @@ func (c *MemoryCache) Clear() {
 	defer c.mu.Unlock()
 
 	c.items = make(map[string]*cacheItem)
-	c.head = nil
-	c.tail = nil
+	c.policy.Reset()
+	c.pinned = 0
+	c.emit(CacheEvent{Type: CacheEventClear})
 	atomic.StoreInt64(&c.hits, 0)
 	atomic.StoreInt64(&c.misses, 0)
 }
@@ -181,6 +276,7 @@ func (c *MemoryCache) Clear() {
 func (c *MemoryCache) Stats() CacheStats {
 	c.mu.RLock()
 	size := len(c.items)
+	pinned := c.pinned
 	c.mu.RUnlock()
 
 	hits := atomic.LoadInt64(&c.hits)
@@ -196,16 +292,121 @@ func (c *MemoryCache) Stats() CacheStats {
 		Misses:   misses,
 		HitRatio: hitRatio,
 		Size:     size,
+		Pinned:   pinned,
 	}
 }
 
-// GenerateCacheKey creates a cache key from amount and pack sizes
+// Approximate per-entry overheads used for memory estimation
+const (
+	entryOverheadBytes = 128 // cacheItem, eviction policy node and map bucket share
+	packEntryBytes     = 16  // One int key and one int value in the packs map
+)
+
+// estimateEntryBytes approximates the memory held by a single cache entry
+func estimateEntryBytes(key string, packs map[int]int) int {
+	return entryOverheadBytes + len(key) + len(packs)*packEntryBytes
+}
+
+// MemoryEntry describes the estimated size of a single cache entry
+type MemoryEntry struct {
+	Key   string `json:"key"`
+	Bytes int    `json:"bytes"`
+}
+
+// MemoryReport summarizes the estimated memory usage of the cache
+type MemoryReport struct {
+	Entries    int           `json:"entries"`
+	TotalBytes int           `json:"total_bytes"`
+	AvgBytes   float64       `json:"avg_bytes"`
+	MaxBytes   int           `json:"max_bytes"`
+	Largest    []MemoryEntry `json:"largest"`
+}
+
+// MemoryReport returns estimated memory usage with the topN largest entries
+func (c *MemoryCache) MemoryReport(topN int) MemoryReport {
+	c.mu.RLock()
+	entries := make([]MemoryEntry, 0, len(c.items))
+	for key, item := range c.items {
+		entries = append(entries, MemoryEntry{Key: key, Bytes: item.bytes})
+	}
+	c.mu.RUnlock()
+
+	report := MemoryReport{Entries: len(entries), Largest: []MemoryEntry{}}
+	for _, e := range entries {
+		report.TotalBytes += e.Bytes
+		if e.Bytes > report.MaxBytes {
+			report.MaxBytes = e.Bytes
+		}
+	}
+	if report.Entries > 0 {
+		report.AvgBytes = float64(report.TotalBytes) / float64(report.Entries)
+	}
+
+	// Largest first; ties broken by key for stable output
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes != entries[j].Bytes {
+			return entries[i].Bytes > entries[j].Bytes
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	if topN > len(entries) {
+		topN = len(entries)
+	}
+	if topN > 0 {
+		report.Largest = entries[:topN]
+	}
+
+	return report
+}
+
+// ModeExact marks results computed for exact-only requests
+const ModeExact = "exact"
+
+// MaxPacksMode marks results computed with at most maxPacks packs
+func MaxPacksMode(maxPacks int) string {
+	return "max_packs=" + strconv.Itoa(maxPacks)
+}
+
+// GenerateCacheKey creates a cache key from amount and pack sizes, plus any calculation
+// modes (such as ModeExact) the result depends on, so results computed under different
+// modes never share an entry. Mode order does not matter; without modes the key is the
+// same as before modes existed. Modes must not contain ':', ',' or '/'.
 // Optimized: Uses string builder instead of JSON for 10-20x performance
-func GenerateCacheKey(amount int, packSizes []int) string {
+func GenerateCacheKey(amount int, packSizes []int, modes ...string) string {
 	var b strings.Builder
 	b.Grow(32 + len(packSizes)*6) // Pre-allocate capacity
 	b.WriteString("calc:")
 	b.WriteString(strconv.Itoa(amount))
+	if len(modes) > 0 {
+		// Kept in the amount segment, which InvalidatePackSet expects to hold no ':'
+		sorted := append([]string(nil), modes...)
+		sort.Strings(sorted)
+		b.WriteByte('@')
+		b.WriteString(strings.Join(sorted, ","))
+	}
+	writePackSet(&b, packSizes)
+	return b.String()
+}
+
+// GenerateNegativeCacheKey creates the key recording that amount cannot be packed
+// exactly with packSizes. The entry's total holds the closest reachable total.
+func GenerateNegativeCacheKey(amount int, packSizes []int) string {
+	var b strings.Builder
+	b.Grow(32 + len(packSizes)*6)
+	b.WriteString("neg:")
+	b.WriteString(strconv.Itoa(amount))
+	writePackSet(&b, packSizes)
+	return b.String()
+}
+
+// NamespacedKey scopes a generated key to namespace, such as a tenant, so equal keys in
+// different namespaces are separate entries. The namespace must not contain '/' or ':'.
+func NamespacedKey(namespace, key string) string {
+	return namespace + "/" + key
+}
+
+// writePackSet writes the ":size,size,..." suffix shared by every key for a pack set
+func writePackSet(b *strings.Builder, packSizes []int) {
 	b.WriteByte(':')
 	for i, size := range packSizes {
 		if i > 0 {
@@ -213,85 +414,73 @@ func GenerateCacheKey(amount int, packSizes []int) string {
 		}
 		b.WriteString(strconv.Itoa(size))
 	}
-	return b.String()
 }
 
-// NoOpCache is a cache that does nothing (for disabling cache)
-type NoOpCache struct{}
-
-func (c *NoOpCache) Get(key string) (map[int]int, int, bool) {
-	return nil, 0, false
+// Pinger is implemented by caches backed by a server, so readiness checks can tell
+// whether it is reachable
+type Pinger interface {
+	Ping(ctx context.Context) error
 }
 
-func (c *NoOpCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
+// PackSetInvalidator is implemented by caches that can drop only the entries
+// computed for one pack set, instead of clearing everything
+type PackSetInvalidator interface {
+	InvalidatePackSet(namespace string, packSizes []int) int
 }
 
-func (c *NoOpCache) Clear() {
+// keyNamespace returns the namespace a key was built in by NamespacedKey, or "" for
+// a key built without one
+func keyNamespace(key string) (namespace, base string) {
+	if i := strings.LastIndexByte(key, '/'); i >= 0 {
+		return key[:i], key[i+1:]
+	}
+	return "", key
 }
 
-func (c *NoOpCache) Stats() CacheStats {
-	return CacheStats{}
+// isPackSetKey reports whether base, a key without its namespace, was generated for
+// the pack set whose writePackSet suffix is suffix
+func isPackSetKey(base, suffix string) bool {
+	// The amount segment holds no ':', so the suffix matches only this exact set
+	return (strings.HasPrefix(base, "calc:") || strings.HasPrefix(base, "neg:")) &&
+		strings.HasSuffix(base, suffix) && strings.Count(base, ":") == 2
 }
 
-// RedisCache implements Redis-backed cache (placeholder for Redis integration)
-// To implement: use github.com/go-redis/redis/v9
-/*
-type RedisCache struct {
-	client *redis.Client
-	ttl    time.Duration
-}
+// InvalidatePackSet removes every entry, positive or negative, whose key was generated
+// for packSizes (in the same order) in exactly namespace ("" for keys without one), and
+// returns how many were removed. Entries for the same set in other namespaces, such as
+// another tenant's, are kept.
+func (c *MemoryCache) InvalidatePackSet(namespace string, packSizes []int) int {
+	var b strings.Builder
+	writePackSet(&b, packSizes)
+	suffix := b.String()
 
-func NewRedisCache(addr string, ttl time.Duration) (*RedisCache, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr: addr,
-	})
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	ctx := context.Background()
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, err
+	removed := 0
+	for key, item := range c.items {
+		if ns, base := keyNamespace(key); ns != namespace || !isPackSetKey(base, suffix) {
+			continue
+		}
+		c.removeLocked(key, item)
+		removed++
 	}
-
-	return &RedisCache{
-		client: client,
-		ttl:    ttl,
-	}, nil
+	return removed
 }
 
-func (c *RedisCache) Get(key string) (map[int]int, int, bool) {
-	ctx := context.Background()
-	val, err := c.client.Get(ctx, key).Result()
-	if err != nil {
-		return nil, 0, false
-	}
-
-	var result struct {
-		Packs map[int]int `json:"packs"`
-		Total int         `json:"total"`
-	}
-
-	if err := json.Unmarshal([]byte(val), &result); err != nil {
-		return nil, 0, false
-	}
+// NoOpCache is a cache that does nothing (for disabling cache)
+type NoOpCache struct{}
 
-	return result.Packs, result.Total, true
+func (c *NoOpCache) Get(key string) (map[int]int, int, bool) {
+	return nil, 0, false
 }
 
-func (c *RedisCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
-	ctx := context.Background()
-	data := struct {
-		Packs map[int]int `json:"packs"`
-		Total int         `json:"total"`
-	}{
-		Packs: packs,
-		Total: total,
-	}
+func (c *NoOpCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
+}
 
-	val, _ := json.Marshal(data)
-	c.client.Set(ctx, key, val, ttl)
+func (c *NoOpCache) Clear() {
 }
 
-func (c *RedisCache) Clear() {
-	ctx := context.Background()
-	c.client.FlushDB(ctx)
+func (c *NoOpCache) Stats() CacheStats {
+	return CacheStats{}
 }
-*/
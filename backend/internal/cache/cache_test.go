@@ -0,0 +1,506 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache_MemoryReport(t *testing.T) {
+	c := NewMemoryCache(10)
+
+	small := map[int]int{250: 1}
+	medium := map[int]int{250: 1, 500: 1, 1000: 1}
+	large := map[int]int{23: 1, 31: 2, 53: 3, 250: 4, 500: 5, 1000: 6}
+
+	c.Set("a", small, 250, time.Hour)
+	c.Set("b", medium, 1750, time.Hour)
+	c.Set("c", large, 9999, time.Hour)
+
+	report := c.MemoryReport(2)
+
+	wantSizes := []int{
+		estimateEntryBytes("a", small),
+		estimateEntryBytes("b", medium),
+		estimateEntryBytes("c", large),
+	}
+	wantTotal := wantSizes[0] + wantSizes[1] + wantSizes[2]
+
+	if report.Entries != 3 {
+		t.Errorf("Entries = %d, want 3", report.Entries)
+	}
+	if report.TotalBytes != wantTotal {
+		t.Errorf("TotalBytes = %d, want %d", report.TotalBytes, wantTotal)
+	}
+	if want := float64(wantTotal) / 3; report.AvgBytes != want {
+		t.Errorf("AvgBytes = %v, want %v", report.AvgBytes, want)
+	}
+	if report.MaxBytes != wantSizes[2] {
+		t.Errorf("MaxBytes = %d, want %d", report.MaxBytes, wantSizes[2])
+	}
+
+	if len(report.Largest) != 2 {
+		t.Fatalf("Largest has %d entries, want 2", len(report.Largest))
+	}
+	if report.Largest[0].Key != "c" || report.Largest[1].Key != "b" {
+		t.Errorf("Largest keys = [%s %s], want [c b]", report.Largest[0].Key, report.Largest[1].Key)
+	}
+}
+
+func TestMemoryCache_MemoryReportEmpty(t *testing.T) {
+	report := NewMemoryCache(10).MemoryReport(5)
+
+	if report.Entries != 0 || report.TotalBytes != 0 || report.AvgBytes != 0 || report.MaxBytes != 0 {
+		t.Errorf("Empty report = %+v, want zero values", report)
+	}
+	if report.Largest == nil || len(report.Largest) != 0 {
+		t.Errorf("Largest = %v, want empty slice", report.Largest)
+	}
+}
+
+func TestMemoryCache_PinnedSurviveEviction(t *testing.T) {
+	c := NewMemoryCache(4)
+	packs := map[int]int{250: 1}
+
+	c.Set("hot", packs, 250, time.Hour)
+	if err := c.Pin("hot"); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+
+	// Push many entries through; "hot" is the least recently used throughout
+	for i := 0; i < 20; i++ {
+		c.Set(fmt.Sprintf("cold-%d", i), packs, 250, time.Hour)
+	}
+
+	if _, _, found := c.Get("hot"); !found {
+		t.Error("Pinned entry was evicted")
+	}
+	if _, _, found := c.Get("cold-0"); found {
+		t.Error("Unpinned old entry survived eviction pressure")
+	}
+	if stats := c.Stats(); stats.Pinned != 1 || stats.Size != 4 {
+		t.Errorf("Stats = %+v, want 1 pinned and size 4", stats)
+	}
+
+	// Once unpinned it becomes evictable again
+	if !c.Unpin("hot") {
+		t.Fatal("Unpin() = false, want true")
+	}
+	for i := 0; i < 4; i++ {
+		c.Set(fmt.Sprintf("after-%d", i), packs, 250, time.Hour)
+	}
+	if _, _, found := c.Get("hot"); found {
+		t.Error("Unpinned entry survived a full turnover")
+	}
+}
+
+func TestMemoryCache_Blobs(t *testing.T) {
+	c := NewMemoryCache(2)
+
+	c.SetBlob("b", []byte("response"), time.Hour)
+	if val, found := c.GetBlob("b"); !found || string(val) != "response" {
+		t.Errorf("GetBlob() = %q, %v, want the stored value", val, found)
+	}
+	if _, _, found := c.Get("b"); found {
+		t.Error("Get() returned a blob as a result")
+	}
+
+	// Blobs share the capacity and are evicted like results
+	c.Set("r1", map[int]int{250: 1}, 250, time.Hour)
+	c.Set("r2", map[int]int{250: 1}, 250, time.Hour)
+	if _, found := c.GetBlob("b"); found {
+		t.Error("Least recently used blob survived eviction")
+	}
+
+	c.SetBlob("b", []byte("response"), time.Hour)
+	c.DeleteBlob("b")
+	if _, found := c.GetBlob("b"); found {
+		t.Error("Blob found after DeleteBlob")
+	}
+}
+
+func TestMemoryCache_PinLimit(t *testing.T) {
+	c := NewMemoryCache(4) // At most 2 pinned
+	packs := map[int]int{250: 1}
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, packs, 250, time.Hour)
+	}
+
+	if err := c.Pin("a"); err != nil {
+		t.Fatalf("Pin(a) error = %v", err)
+	}
+	if err := c.Pin("b"); err != nil {
+		t.Fatalf("Pin(b) error = %v", err)
+	}
+	if err := c.Pin("c"); !errors.Is(err, ErrPinLimit) {
+		t.Errorf("Pin(c) error = %v, want ErrPinLimit", err)
+	}
+	if err := c.Pin("missing"); !errors.Is(err, ErrNotCached) {
+		t.Errorf("Pin(missing) error = %v, want ErrNotCached", err)
+	}
+
+	c.Clear()
+	if stats := c.Stats(); stats.Pinned != 0 {
+		t.Errorf("Pinned after Clear = %d, want 0", stats.Pinned)
+	}
+}
+
+func TestMemoryCache_LFUKeepsFrequentEntries(t *testing.T) {
+	c := NewMemoryCacheWithPolicy(3, NewLFUPolicy())
+	packs := map[int]int{250: 1}
+	for _, key := range []string{"hot", "warm", "cold"} {
+		c.Set(key, packs, 250, time.Hour)
+	}
+	c.Get("hot")
+	c.Get("hot")
+
+	// "hot" is the least recently used but the most frequently used
+	c.Get("cold")
+	c.Get("cold")
+	c.Get("warm")
+	c.Get("hot")
+	c.Set("new", packs, 250, time.Hour)
+	if _, _, found := c.Get("warm"); found {
+		t.Error("Least frequently used entry survived eviction")
+	}
+	for _, key := range []string{"hot", "cold", "new"} {
+		if _, _, found := c.Get(key); !found {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+
+	// Ties go to the least recently used; one-off keys keep replacing each other
+	for i := 0; i < 5; i++ {
+		c.Set(fmt.Sprintf("scan-%d", i), packs, 250, time.Hour)
+	}
+	if _, _, found := c.Get("hot"); !found {
+		t.Error("Frequent entry was evicted by a scan")
+	}
+	if _, _, found := c.Get("scan-4"); !found {
+		t.Error("Newest scan entry was evicted")
+	}
+
+	if _, ok := NewEvictionPolicy("bogus").(*lruPolicy); !ok {
+		t.Error("Unknown policy should fall back to LRU")
+	}
+}
+
+func TestMemoryCache_LFUAgesFrequencies(t *testing.T) {
+	c := NewMemoryCacheWithPolicy(4, NewLFUPolicy())
+	packs := map[int]int{250: 1}
+
+	// "old" was popular once, then stopped being read
+	c.Set("old", packs, 250, time.Hour)
+	for i := 0; i < 20; i++ {
+		c.Get("old")
+	}
+
+	// The current working set is read steadily, with enough reads to age the counts
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, packs, 250, time.Hour)
+	}
+	for i := 0; i < 30; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			c.Get(key)
+		}
+	}
+
+	c.Set("new", packs, 250, time.Hour)
+	if _, _, found := c.Get("old"); found {
+		t.Error("Formerly popular entry was never evicted")
+	}
+	for _, key := range []string{"a", "b", "c", "new"} {
+		if _, _, found := c.Get(key); !found {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+}
+
+func TestMemoryCache_LFUSkipsPinned(t *testing.T) {
+	c := NewMemoryCacheWithPolicy(3, NewLFUPolicy())
+	packs := map[int]int{250: 1}
+	for _, key := range []string{"pinned", "a", "b"} {
+		c.Set(key, packs, 250, time.Hour)
+	}
+	if err := c.Pin("pinned"); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	c.Get("a")
+	c.Get("b")
+
+	// "pinned" is the least used but cannot be evicted
+	c.Set("new", packs, 250, time.Hour)
+	if _, _, found := c.Get("pinned"); !found {
+		t.Error("Pinned entry was evicted")
+	}
+	if _, _, found := c.Get("a"); found {
+		t.Error("Least used unpinned entry survived eviction")
+	}
+}
+
+// BenchmarkMemoryCache_ScanHitRatio reports the hit ratio of each policy when a small
+// set of popular amounts, each requested twice per round, is interleaved with scans of
+// more unique amounts than the cache holds
+func BenchmarkMemoryCache_ScanHitRatio(b *testing.B) {
+	const (
+		cacheSize = 100
+		hotKeys   = 50
+		scanKeys  = 200
+	)
+	packs := map[int]int{250: 1}
+
+	for _, policy := range []string{EvictLRU, EvictLFU} {
+		b.Run(policy, func(b *testing.B) {
+			c := NewMemoryCacheWithPolicy(cacheSize, NewEvictionPolicy(policy))
+			access := func(key string) {
+				if _, _, found := c.Get(key); !found {
+					c.Set(key, packs, 250, time.Hour)
+				}
+			}
+
+			scanned := 0
+			for i := 0; i < b.N; i++ {
+				for k := 0; k < 2*hotKeys; k++ {
+					access(fmt.Sprintf("hot-%d", k%hotKeys))
+				}
+				for k := 0; k < scanKeys; k++ {
+					access(fmt.Sprintf("scan-%d", scanned))
+					scanned++
+				}
+			}
+			b.ReportMetric(c.Stats().HitRatio, "hit-ratio")
+		})
+	}
+}
+
+func TestMemoryCache_JanitorRemovesExpired(t *testing.T) {
+	c := NewMemoryCache(10)
+	defer c.Close()
+	packs := map[int]int{250: 1}
+
+	c.Set("short-1", packs, 250, 10*time.Millisecond)
+	c.Set("short-2", packs, 250, 10*time.Millisecond)
+	c.Set("pinned", packs, 250, 10*time.Millisecond)
+	if err := c.Pin("pinned"); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+	c.Set("long", packs, 250, time.Hour)
+	c.StartJanitor(20 * time.Millisecond)
+
+	// Nothing reads the short entries; only the janitor can remove them
+	deadline := time.Now().Add(2 * time.Second)
+	for c.Stats().Size != 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if size := c.Stats().Size; size != 2 {
+		t.Fatalf("Size after sweep = %d, want 2 (pinned and long)", size)
+	}
+	if _, _, found := c.Get("long"); !found {
+		t.Error("Unexpired entry was swept")
+	}
+
+	// After Close expired entries stay until read or evicted
+	c.Close()
+	c.Close() // Idempotent
+
+	time.Sleep(30 * time.Millisecond) // Let a sweep already in flight finish
+	c.Set("after-close", packs, 250, time.Millisecond)
+	time.Sleep(60 * time.Millisecond)
+	if size := c.Stats().Size; size != 3 {
+		t.Errorf("Size after Close = %d, want 3 with no sweeping", size)
+	}
+}
+
+func TestMemoryCache_InvalidatePackSet(t *testing.T) {
+	c := NewMemoryCache(10)
+	setA := []int{250, 500}
+	setB := []int{23, 31, 500}
+	packs := map[int]int{500: 1}
+
+	c.Set(GenerateCacheKey(100, setA), packs, 500, time.Hour)
+	c.Set(GenerateCacheKey(300, setA), packs, 500, time.Hour)
+	c.Set(GenerateCacheKey(100, setB), packs, 500, time.Hour)
+	c.Set(GenerateCacheKey(100, []int{500}), packs, 500, time.Hour) // Suffix of set A's keys
+	c.Set(GenerateNegativeCacheKey(120, setA), nil, 250, time.Hour)
+	c.Set(NamespacedKey("acme", GenerateCacheKey(100, setA)), packs, 500, time.Hour)
+	c.Set(NamespacedKey("acme", NamespacedKey("profile.retail", GenerateCacheKey(100, setA))), packs, 500, time.Hour)
+	if err := c.Pin(GenerateCacheKey(300, setA)); err != nil {
+		t.Fatalf("Pin() error = %v", err)
+	}
+
+	if removed := c.InvalidatePackSet("", setA); removed != 3 {
+		t.Errorf("InvalidatePackSet() removed %d, want 3", removed)
+	}
+	if _, _, found := c.Get(GenerateCacheKey(100, setA)); found {
+		t.Error("Entry for the invalidated set survived")
+	}
+	if _, _, found := c.Get(GenerateNegativeCacheKey(120, setA)); found {
+		t.Error("Negative entry for the invalidated set survived")
+	}
+	for _, key := range []string{GenerateCacheKey(100, setB), GenerateCacheKey(100, []int{500})} {
+		if _, _, found := c.Get(key); !found {
+			t.Errorf("Entry %s for another set was removed", key)
+		}
+	}
+	if stats := c.Stats(); stats.Size != 4 || stats.Pinned != 0 {
+		t.Errorf("Stats = %+v, want size 4 and no pinned entries", stats)
+	}
+
+	// Another namespace's entries for the same set are only removed with that namespace
+	if _, _, found := c.Get(NamespacedKey("acme", GenerateCacheKey(100, setA))); !found {
+		t.Error("Entry in another namespace was removed")
+	}
+	if removed := c.InvalidatePackSet("acme", setA); removed != 1 {
+		t.Errorf("InvalidatePackSet(acme) removed %d, want 1", removed)
+	}
+	if _, _, found := c.Get(NamespacedKey("acme", NamespacedKey("profile.retail", GenerateCacheKey(100, setA)))); !found {
+		t.Error("Entry in a nested namespace was removed")
+	}
+}
+
+func TestGenerateCacheKey_Modes(t *testing.T) {
+	sizes := []int{250, 500}
+	plain := GenerateCacheKey(750, sizes)
+	exact := GenerateCacheKey(750, sizes, ModeExact)
+
+	if plain != "calc:750:250,500" {
+		t.Errorf("Key without modes = %q, want the unchanged format", plain)
+	}
+	if exact == plain {
+		t.Error("Exact-mode key collides with the plain key")
+	}
+	if a, b := GenerateCacheKey(750, sizes, "b", "a"), GenerateCacheKey(750, sizes, "a", "b"); a != b {
+		t.Errorf("Keys depend on mode order: %q vs %q", a, b)
+	}
+
+	// Mode keys belong to their pack set for invalidation
+	c := NewMemoryCache(10)
+	c.Set(plain, map[int]int{500: 1, 250: 1}, 750, time.Hour)
+	c.Set(exact, map[int]int{500: 1, 250: 1}, 750, time.Hour)
+	if removed := c.InvalidatePackSet("", sizes); removed != 2 {
+		t.Errorf("InvalidatePackSet() removed %d, want 2", removed)
+	}
+}
+
+// newTestRedisCache connects to the Redis server in TEST_REDIS_ADDR, clearing the
+// cache's keys before and after the test
+func newTestRedisCache(t *testing.T) *RedisCache {
+	t.Helper()
+	addr := os.Getenv("TEST_REDIS_ADDR")
+	if addr == "" {
+		t.Skip("TEST_REDIS_ADDR not set; skipping Redis test")
+	}
+	c, err := NewRedisCache(addr, time.Second)
+	if err != nil {
+		t.Fatalf("NewRedisCache() error = %v", err)
+	}
+	c.Clear()
+	t.Cleanup(func() {
+		c.Clear()
+		c.Close()
+	})
+	return c
+}
+
+func TestRedisCache_GetSetClear(t *testing.T) {
+	c := newTestRedisCache(t)
+
+	// Unrelated keys in the same database survive Clear
+	ctx := context.Background()
+	if err := c.client.Set(ctx, "other:key", "keep", 0).Err(); err != nil {
+		t.Fatalf("Set unrelated key: %v", err)
+	}
+	defer c.client.Del(ctx, "other:key")
+
+	key := GenerateCacheKey(251, []int{250, 500})
+	if _, _, found := c.Get(key); found {
+		t.Fatal("Get() on empty cache: found")
+	}
+	c.Set(key, map[int]int{500: 1}, 500, time.Minute)
+	packs, total, found := c.Get(key)
+	if !found || total != 500 || packs[500] != 1 {
+		t.Errorf("Get() = %v, %d, %v; want {500:1}, 500, true", packs, total, found)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.HitRatio != 0.5 {
+		t.Errorf("Stats() = %+v, want 1 hit and 1 miss", stats)
+	}
+
+	c.Clear()
+	if _, _, found := c.Get(key); found {
+		t.Error("Get() after Clear: found")
+	}
+	if val, err := c.client.Get(ctx, "other:key").Result(); err != nil || val != "keep" {
+		t.Errorf("Unrelated key after Clear = %q, %v; want keep", val, err)
+	}
+}
+
+func TestRedisCache_InvalidatePackSet(t *testing.T) {
+	c := newTestRedisCache(t)
+	sizes := []int{250, 500}
+	packs := map[int]int{500: 1}
+
+	keys := []string{
+		GenerateCacheKey(251, sizes),
+		GenerateCacheKey(251, sizes, ModeExact),
+		GenerateNegativeCacheKey(251, sizes),
+	}
+	for _, key := range keys {
+		c.Set(key, packs, 500, time.Minute)
+	}
+	kept := []string{
+		GenerateCacheKey(251, []int{250, 500, 1000}),
+		NamespacedKey("acme", GenerateCacheKey(251, sizes)),
+	}
+	for _, key := range kept {
+		c.Set(key, packs, 500, time.Minute)
+	}
+
+	if removed := c.InvalidatePackSet("", sizes); removed != len(keys) {
+		t.Errorf("InvalidatePackSet() removed %d, want %d", removed, len(keys))
+	}
+	for _, key := range keys {
+		if _, _, found := c.Get(key); found {
+			t.Errorf("%s survived invalidation", key)
+		}
+	}
+	for _, key := range kept {
+		if _, _, found := c.Get(key); !found {
+			t.Errorf("%s was invalidated", key)
+		}
+	}
+	if removed := c.InvalidatePackSet("acme", sizes); removed != 1 {
+		t.Errorf("InvalidatePackSet(acme) removed %d, want 1", removed)
+	}
+}
+
+func TestRedisIndexKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		index string
+		ok    bool
+	}{
+		{GenerateCacheKey(251, []int{250, 500}), "calc:index::250,500", true},
+		{GenerateCacheKey(251, []int{250, 500}, ModeExact), "calc:index::250,500", true},
+		{GenerateNegativeCacheKey(251, []int{250}), "calc:index::250", true},
+		{NamespacedKey("acme", GenerateCacheKey(251, []int{250})), "calc:index:acme:250", true},
+		{"unrelated", "", false},
+	}
+	for _, tt := range tests {
+		index, ok := redisIndexKey(tt.key)
+		if index != tt.index || ok != tt.ok {
+			t.Errorf("redisIndexKey(%q) = %q, %v; want %q, %v", tt.key, index, ok, tt.index, tt.ok)
+		}
+	}
+}
+
+func TestNewRedisCache_Unreachable(t *testing.T) {
+	// Nothing listens on the discard port, so the initial ping fails fast
+	if _, err := NewRedisCache("127.0.0.1:9", 200*time.Millisecond); err == nil {
+		t.Error("NewRedisCache() with no server: expected error")
+	}
+}
@@ -0,0 +1,94 @@
+package cache
+
+import "time"
+
+// CacheEventType identifies a change to a MemoryCache
+type CacheEventType string
+
+// Change log event types
+const (
+	CacheEventSet    CacheEventType = "set"    // Entry added or replaced
+	CacheEventAccess CacheEventType = "access" // Entry read by Get, which affects eviction order
+	CacheEventEvict  CacheEventType = "evict"  // Entry removed by LRU eviction or invalidation
+	CacheEventClear  CacheEventType = "clear"  // All entries removed
+)
+
+// CacheEvent is one change log record. Set events carry the entry's absolute
+// expiration so a mirror expires it at the same moment as the primary.
+type CacheEvent struct {
+	Type       CacheEventType
+	Key        string
+	Packs      map[int]int
+	Total      int
+	Expiration time.Time
+}
+
+// changeLogBuffer is how many events a subscriber may fall behind before events are dropped
+const changeLogBuffer = 1024
+
+// Subscribe returns a channel receiving every subsequent change to the cache, so a
+// standby can keep a warm mirror by passing each event to its own Apply. Cache hits
+// are published as access events, so the mirror evicts in the same order.
+// Events are sent without blocking: a subscriber more than changeLogBuffer events
+// behind misses events, and its mirror may then diverge until the next Clear.
+// Call Unsubscribe once the events are no longer read.
+func (c *MemoryCache) Subscribe() <-chan CacheEvent {
+	ch := make(chan CacheEvent, changeLogBuffer)
+	c.mu.Lock()
+	c.subscribers = append(c.subscribers, ch)
+	c.mu.Unlock()
+	return ch
+}
+
+// Unsubscribe stops publishing to a channel returned by Subscribe and closes it.
+// Channels that are not subscribed are ignored.
+func (c *MemoryCache) Unsubscribe(events <-chan CacheEvent) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, ch := range c.subscribers {
+		if ch == events {
+			c.subscribers = append(c.subscribers[:i], c.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// emit publishes an event to all subscribers; callers must hold c.mu
+func (c *MemoryCache) emit(event CacheEvent) {
+	for _, ch := range c.subscribers {
+		select {
+		case ch <- event:
+		default: // Subscriber is too far behind; drop rather than stall the cache
+		}
+	}
+}
+
+// Apply replays a change log event from another cache. Set events that have
+// already expired are ignored.
+func (c *MemoryCache) Apply(event CacheEvent) {
+	switch event.Type {
+	case CacheEventSet:
+		if time.Now().After(event.Expiration) {
+			return
+		}
+		c.mu.Lock()
+		c.setLocked(event.Key, event.Packs, event.Total, event.Expiration)
+		c.mu.Unlock()
+	case CacheEventAccess:
+		c.mu.Lock()
+		if _, exists := c.items[event.Key]; exists {
+			c.touchLocked(event.Key)
+		}
+		c.mu.Unlock()
+	case CacheEventEvict:
+		c.mu.Lock()
+		if item, exists := c.items[event.Key]; exists {
+			c.removeLocked(event.Key, item)
+		}
+		c.mu.Unlock()
+	case CacheEventClear:
+		c.Clear()
+	}
+}
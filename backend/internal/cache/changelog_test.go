@@ -0,0 +1,118 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// liveEntries snapshots the non-expired entries of a cache
+func liveEntries(c *MemoryCache) map[string]int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	now := time.Now()
+	entries := make(map[string]int)
+	for key, item := range c.items {
+		if now.Before(item.expiration) {
+			entries[key] = item.total
+		}
+	}
+	return entries
+}
+
+// drain applies every queued event to the mirror
+func drain(events <-chan CacheEvent, mirror *MemoryCache) {
+	for len(events) > 0 {
+		mirror.Apply(<-events)
+	}
+}
+
+func TestMemoryCache_ChangeLogMirror(t *testing.T) {
+	primary := NewMemoryCache(5)
+	standby := NewMemoryCache(5)
+	events := primary.Subscribe()
+
+	// Enough sets to force LRU evictions, plus an update and a short-lived entry
+	for i := 0; i < 8; i++ {
+		primary.Set(fmt.Sprintf("calc:%d:250,500", i), map[int]int{250: i}, 250*i, time.Hour)
+	}
+	primary.Set("calc:7:250,500", map[int]int{500: 1}, 500, time.Hour)
+	primary.Set("calc:99:250", map[int]int{250: 1}, 250, time.Millisecond)
+	primary.InvalidatePackSet("", []int{23})
+	primary.Set("calc:1:23", map[int]int{23: 1}, 23, time.Hour)
+	primary.InvalidatePackSet("", []int{23})
+
+	time.Sleep(5 * time.Millisecond) // Let the short-lived entry expire
+	drain(events, standby)
+
+	want := liveEntries(primary)
+	if got := liveEntries(standby); !reflect.DeepEqual(got, want) {
+		t.Errorf("Standby entries = %v, want %v", got, want)
+	}
+	if len(want) != 3 {
+		t.Errorf("Primary has %d live entries, want 3", len(want))
+	}
+
+	// Clear propagates too
+	primary.Clear()
+	drain(events, standby)
+	if got := liveEntries(standby); len(got) != 0 {
+		t.Errorf("Standby after Clear = %v, want empty", got)
+	}
+}
+
+func TestMemoryCache_ChangeLogMirrorsEvictionOrder(t *testing.T) {
+	primary := NewMemoryCache(3)
+	standby := NewMemoryCache(3)
+	events := primary.Subscribe()
+
+	for _, key := range []string{"a", "b", "c"} {
+		primary.Set(key, map[int]int{1: 1}, 1, time.Hour)
+	}
+	primary.Get("a") // Now b is the least recently used
+	primary.Set("d", map[int]int{1: 1}, 1, time.Hour)
+	drain(events, standby)
+
+	// A further set on the standby alone must evict the same entry as on the primary
+	primary.Set("e", map[int]int{1: 1}, 1, time.Hour)
+	standby.Set("e", map[int]int{1: 1}, 1, time.Hour)
+	if got, want := liveEntries(standby), liveEntries(primary); !reflect.DeepEqual(got, want) {
+		t.Errorf("Standby entries = %v, want %v", got, want)
+	}
+}
+
+func TestMemoryCache_Unsubscribe(t *testing.T) {
+	c := NewMemoryCache(10)
+	events := c.Subscribe()
+	c.Unsubscribe(events)
+	c.Unsubscribe(events) // Already gone: ignored
+
+	c.Set("k", map[int]int{1: 1}, 1, time.Hour)
+	if _, open := <-events; open {
+		t.Error("Channel still open after Unsubscribe")
+	}
+	if len(c.subscribers) != 0 {
+		t.Errorf("%d subscribers left, want 0", len(c.subscribers))
+	}
+}
+
+func TestMemoryCache_SubscribeDoesNotBlock(t *testing.T) {
+	c := NewMemoryCache(10)
+	c.Subscribe() // Never read
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < changeLogBuffer*2; i++ {
+			c.Set(fmt.Sprintf("k%d", i%10), map[int]int{1: 1}, 1, time.Hour)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set blocked on a full subscriber")
+	}
+}
@@ -0,0 +1,285 @@
+package cache
+
+// EvictionPolicy chooses which entry a MemoryCache drops when it is full. The cache
+// calls it under its write lock: Added for a new key, Accessed when a key is read or
+// replaced, Removed when a key leaves the cache for any reason, and Reset when the
+// cache is cleared. Victim returns the key to evict next, passing over keys for which
+// skip reports true, such as pinned entries. A policy holds per-key state, so each
+// cache needs its own.
+type EvictionPolicy interface {
+	Added(key string)
+	Accessed(key string)
+	Removed(key string)
+	Victim(skip func(key string) bool) (string, bool)
+	Reset()
+}
+
+// Eviction policy names, as configured with CACHE_EVICTION
+const (
+	// EvictLRU drops the least recently used entry
+	EvictLRU = "lru"
+	// EvictLFU drops the least frequently used entry, the least recently used among ties.
+	// Popular entries survive scans of many one-off keys.
+	EvictLFU = "lfu"
+)
+
+// NewEvictionPolicy returns a new policy by name. Unknown names fall back to EvictLRU.
+func NewEvictionPolicy(name string) EvictionPolicy {
+	if name == EvictLFU {
+		return NewLFUPolicy()
+	}
+	return NewLRUPolicy()
+}
+
+// lruPolicy keeps keys in a recency list, most recently used first
+type lruPolicy struct {
+	nodes map[string]*lruNode
+	head  *lruNode // Most recently used
+	tail  *lruNode // Least recently used
+}
+
+type lruNode struct {
+	key  string
+	prev *lruNode
+	next *lruNode
+}
+
+// NewLRUPolicy returns a policy evicting the least recently used entry. Every
+// operation is O(1), except that Victim walks past skipped keys at the tail.
+func NewLRUPolicy() EvictionPolicy {
+	return &lruPolicy{nodes: make(map[string]*lruNode)}
+}
+
+func (p *lruPolicy) Added(key string) {
+	node := &lruNode{key: key}
+	p.nodes[key] = node
+	p.addToFront(node)
+}
+
+func (p *lruPolicy) Accessed(key string) {
+	if node, exists := p.nodes[key]; exists && node != p.head {
+		p.unlink(node)
+		p.addToFront(node)
+	}
+}
+
+func (p *lruPolicy) Removed(key string) {
+	if node, exists := p.nodes[key]; exists {
+		p.unlink(node)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *lruPolicy) Victim(skip func(key string) bool) (string, bool) {
+	for node := p.tail; node != nil; node = node.prev {
+		if !skip(node.key) {
+			return node.key, true
+		}
+	}
+	return "", false
+}
+
+func (p *lruPolicy) Reset() {
+	p.nodes = make(map[string]*lruNode)
+	p.head, p.tail = nil, nil
+}
+
+// addToFront adds a node to the front (most recently used)
+func (p *lruPolicy) addToFront(node *lruNode) {
+	node.prev, node.next = nil, p.head
+	if p.head != nil {
+		p.head.prev = node
+	}
+	p.head = node
+	if p.tail == nil {
+		p.tail = node
+	}
+}
+
+// unlink removes a node from the recency list
+func (p *lruPolicy) unlink(node *lruNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		p.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		p.tail = node.prev
+	}
+}
+
+// lfuAgingFactor is how many accesses per tracked key an LFU policy counts before it
+// halves every frequency, so entries that stop being read can be evicted in time
+const lfuAgingFactor = 8
+
+// lfuPolicy keeps keys in buckets of equal frequency, in ascending order, each
+// holding its keys most recently used first. A key moves to the next bucket when it
+// is accessed and the victim is the tail of the lowest bucket, so both are O(1).
+type lfuPolicy struct {
+	entries  map[string]*lfuEntry
+	lowest   *lfuBucket // Least frequently used bucket
+	accesses int        // Since frequencies were last halved
+}
+
+type lfuEntry struct {
+	key    string
+	bucket *lfuBucket
+	prev   *lfuEntry
+	next   *lfuEntry
+}
+
+type lfuBucket struct {
+	freq int
+	head *lfuEntry // Most recently used
+	tail *lfuEntry // Least recently used
+	prev *lfuBucket
+	next *lfuBucket
+}
+
+// NewLFUPolicy returns a policy evicting the least frequently used entry, the least
+// recently used among ties. Every operation is O(1), except that Victim walks past
+// skipped keys. Frequencies are halved after lfuAgingFactor accesses per key, an
+// amortized O(1) cost, so formerly popular entries eventually become evictable.
+func NewLFUPolicy() EvictionPolicy {
+	return &lfuPolicy{entries: make(map[string]*lfuEntry)}
+}
+
+func (p *lfuPolicy) Added(key string) {
+	bucket := p.lowest
+	if bucket == nil || bucket.freq != 1 {
+		bucket = &lfuBucket{freq: 1, next: p.lowest}
+		if p.lowest != nil {
+			p.lowest.prev = bucket
+		}
+		p.lowest = bucket
+	}
+	entry := &lfuEntry{key: key}
+	p.entries[key] = entry
+	bucket.push(entry)
+}
+
+func (p *lfuPolicy) Accessed(key string) {
+	entry, exists := p.entries[key]
+	if !exists {
+		return
+	}
+
+	current := entry.bucket
+	next := current.next
+	if next == nil || next.freq != current.freq+1 {
+		next = &lfuBucket{freq: current.freq + 1, prev: current, next: current.next}
+		if current.next != nil {
+			current.next.prev = next
+		}
+		current.next = next
+	}
+	p.detach(entry)
+	next.push(entry)
+
+	p.accesses++
+	if p.accesses >= lfuAgingFactor*len(p.entries) {
+		p.age()
+	}
+}
+
+func (p *lfuPolicy) Removed(key string) {
+	if entry, exists := p.entries[key]; exists {
+		p.detach(entry)
+		delete(p.entries, key)
+	}
+}
+
+func (p *lfuPolicy) Victim(skip func(key string) bool) (string, bool) {
+	for bucket := p.lowest; bucket != nil; bucket = bucket.next {
+		for entry := bucket.tail; entry != nil; entry = entry.prev {
+			if !skip(entry.key) {
+				return entry.key, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *lfuPolicy) Reset() {
+	p.entries = make(map[string]*lfuEntry)
+	p.lowest = nil
+	p.accesses = 0
+}
+
+// detach removes an entry from its bucket, dropping the bucket once it is empty
+func (p *lfuPolicy) detach(entry *lfuEntry) {
+	bucket := entry.bucket
+	if entry.prev != nil {
+		entry.prev.next = entry.next
+	} else {
+		bucket.head = entry.next
+	}
+	if entry.next != nil {
+		entry.next.prev = entry.prev
+	} else {
+		bucket.tail = entry.prev
+	}
+	entry.prev, entry.next, entry.bucket = nil, nil, nil
+
+	if bucket.head != nil {
+		return
+	}
+	if bucket.prev != nil {
+		bucket.prev.next = bucket.next
+	} else {
+		p.lowest = bucket.next
+	}
+	if bucket.next != nil {
+		bucket.next.prev = bucket.prev
+	}
+}
+
+// age halves every frequency, keeping it at least 1. Buckets that end up with the
+// same frequency are merged, the formerly more frequent keys counting as more recent.
+func (p *lfuPolicy) age() {
+	p.accesses = 0
+
+	var kept *lfuBucket
+	for bucket := p.lowest; bucket != nil; {
+		next := bucket.next
+		freq := bucket.freq / 2
+		if freq < 1 {
+			freq = 1
+		}
+
+		if kept == nil || kept.freq != freq {
+			bucket.freq = freq
+			kept = bucket
+			bucket = next
+			continue
+		}
+
+		// Merge into kept, ahead of its keys
+		for entry := bucket.head; entry != nil; entry = entry.next {
+			entry.bucket = kept
+		}
+		bucket.tail.next = kept.head
+		kept.head.prev = bucket.tail
+		kept.head = bucket.head
+		kept.next = next
+		if next != nil {
+			next.prev = kept
+		}
+		bucket = next
+	}
+}
+
+// push adds an entry to the front of the bucket
+func (b *lfuBucket) push(entry *lfuEntry) {
+	entry.bucket = b
+	entry.prev, entry.next = nil, b.head
+	if b.head != nil {
+		b.head.prev = entry
+	}
+	b.head = entry
+	if b.tail == nil {
+		b.tail = entry
+	}
+}
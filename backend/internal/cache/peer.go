@@ -0,0 +1,293 @@
+package cache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// PeerPath is the route each node serves for peers to read and write the keys it owns
+const PeerPath = "/_peercache"
+
+// Headers authenticating peer requests: an HMAC-SHA256, keyed by the shared peer secret,
+// over the method, key, timestamp and body (see signPeerRequest)
+const (
+	PeerSignatureHeader = "X-Peer-Signature"
+	PeerTimestampHeader = "X-Peer-Timestamp"
+)
+
+// peerMaxSkew bounds how old a signed peer request may be, limiting replays
+const peerMaxSkew = 30 * time.Second
+
+// maxPeerEntryBytes bounds the body of a peer write
+const maxPeerEntryBytes = 1 << 20
+
+// defaultVirtualNodes spreads each peer over the ring to even out key ownership
+const defaultVirtualNodes = 100
+
+// HashRing maps keys to peers with consistent hashing, so adding or removing a
+// peer only moves the keys adjacent to its points on the ring
+type HashRing struct {
+	points []uint32
+	owners map[uint32]string
+}
+
+// NewHashRing builds a ring with virtualNodes points per peer
+func NewHashRing(peers []string, virtualNodes int) *HashRing {
+	if virtualNodes < 1 {
+		virtualNodes = defaultVirtualNodes
+	}
+	r := &HashRing{owners: make(map[uint32]string, len(peers)*virtualNodes)}
+	for _, peer := range peers {
+		for i := 0; i < virtualNodes; i++ {
+			point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + peer))
+			if _, taken := r.owners[point]; taken {
+				continue // Rare collision; the first peer keeps the point
+			}
+			r.owners[point] = peer
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Owner returns the peer owning key: the first ring point at or after the key's hash
+func (r *HashRing) Owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	idx := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if idx == len(r.points) {
+		idx = 0 // Wrap around
+	}
+	return r.owners[r.points[idx]]
+}
+
+// PeerCache is a Cache shared across instances without Redis: each node stores the
+// slice of the keyspace it owns on the hash ring and forwards other keys to their
+// owner over HTTP. Unreachable peers are treated as cache misses.
+type PeerCache struct {
+	self   string
+	secret []byte // Shared by every node; requests without a valid signature are refused
+	ring   *HashRing
+	local  *MemoryCache
+	client *http.Client
+	hits   int64
+	misses int64
+}
+
+// peerEntry is the wire format between peers
+type peerEntry struct {
+	Packs map[int]int   `json:"packs"`
+	Total int           `json:"total"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+}
+
+// NewPeerCache creates a peer cache for the node reachable at self (a base URL such as
+// "http://10.0.0.1:8080"). peers lists every node's base URL; self is added if missing.
+// Every node must share secret, which signs peer requests; with an empty secret the
+// node refuses all peer requests.
+func NewPeerCache(self string, peers []string, secret string, local *MemoryCache, timeout time.Duration) *PeerCache {
+	self = strings.TrimRight(self, "/")
+	nodes := []string{self}
+	for _, peer := range peers {
+		if peer = strings.TrimRight(peer, "/"); peer != "" && peer != self {
+			nodes = append(nodes, peer)
+		}
+	}
+	return &PeerCache{
+		self:   self,
+		secret: []byte(secret),
+		ring:   NewHashRing(nodes, defaultVirtualNodes),
+		local:  local,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Get returns the entry from the local store or from the owning peer
+func (c *PeerCache) Get(key string) (map[int]int, int, bool) {
+	var packs map[int]int
+	var total int
+	var found bool
+	if owner := c.ring.Owner(key); owner == c.self {
+		packs, total, found = c.local.Get(key)
+	} else {
+		packs, total, found = c.fetch(owner, key)
+	}
+
+	if found {
+		atomic.AddInt64(&c.hits, 1)
+	} else {
+		atomic.AddInt64(&c.misses, 1)
+	}
+	return packs, total, found
+}
+
+// Set stores the entry on the owning node
+func (c *PeerCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
+	if owner := c.ring.Owner(key); owner != c.self {
+		c.store(owner, key, peerEntry{Packs: packs, Total: total, TTL: ttl})
+		return
+	}
+	c.local.Set(key, packs, total, ttl)
+}
+
+// Clear empties this node's slice of the keyspace; peers clear their own
+func (c *PeerCache) Clear() {
+	c.local.Clear()
+}
+
+// InvalidatePackSet drops this node's entries for a pack set; peers drop their own
+func (c *PeerCache) InvalidatePackSet(namespace string, packSizes []int) int {
+	return c.local.InvalidatePackSet(namespace, packSizes)
+}
+
+// Stats reports hits and misses seen through this node and the size of its local slice
+func (c *PeerCache) Stats() CacheStats {
+	stats := c.local.Stats()
+	stats.Hits = atomic.LoadInt64(&c.hits)
+	stats.Misses = atomic.LoadInt64(&c.misses)
+	stats.HitRatio = 0
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+// fetch reads a key from its owner; any failure counts as a miss
+func (c *PeerCache) fetch(owner, key string) (map[int]int, int, bool) {
+	req, err := http.NewRequest(http.MethodGet, owner+PeerPath+"?key="+url.QueryEscape(key), nil)
+	if err != nil {
+		return nil, 0, false
+	}
+	c.sign(req, key, nil)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, 0, false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, false
+	}
+
+	var entry peerEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+		return nil, 0, false
+	}
+	return entry.Packs, entry.Total, true
+}
+
+// store writes a key to its owner; failures are dropped like any other cache write
+func (c *PeerCache) store(owner, key string, entry peerEntry) {
+	body, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPut, owner+PeerPath+"?key="+url.QueryEscape(key), bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	c.sign(req, key, body)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		log.Printf("Peer cache write to %s failed: %v", owner, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// sign adds the timestamp and signature headers to a peer request
+func (c *PeerCache) sign(req *http.Request, key string, body []byte) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(PeerTimestampHeader, timestamp)
+	req.Header.Set(PeerSignatureHeader, signPeerRequest(c.secret, req.Method, key, timestamp, body))
+}
+
+// verify reports whether a peer request is signed with the shared secret and recent
+func (c *PeerCache) verify(r *http.Request, key string, body []byte) bool {
+	if len(c.secret) == 0 {
+		return false
+	}
+	timestamp := r.Header.Get(PeerTimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := time.Since(time.Unix(unix, 0)); skew > peerMaxSkew || skew < -peerMaxSkew {
+		return false
+	}
+	want := signPeerRequest(c.secret, r.Method, key, timestamp, body)
+	return hmac.Equal([]byte(r.Header.Get(PeerSignatureHeader)), []byte(want))
+}
+
+// signPeerRequest returns the hex HMAC-SHA256 of a peer request's method, key,
+// timestamp and body, each separated by a newline
+func signPeerRequest(secret []byte, method, key, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, method+"\n"+key+"\n"+timestamp+"\n")
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ServeHTTP serves peer requests against the local store only, so requests never hop twice.
+// GET ?key= returns the entry or 404; PUT ?key= stores the JSON entry in the body.
+// Requests not signed with the shared secret are refused with 403, so only peers can
+// read or write the cache.
+func (c *PeerCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+
+	var body []byte
+	if r.Method == http.MethodPut {
+		var err error
+		if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, maxPeerEntryBytes)); err != nil {
+			http.Error(w, "invalid entry", http.StatusBadRequest)
+			return
+		}
+	}
+	if !c.verify(r, key, body) {
+		http.Error(w, "invalid peer signature", http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		packs, total, found := c.local.Get(key)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(peerEntry{Packs: packs, Total: total})
+	case http.MethodPut:
+		var entry peerEntry
+		if err := json.Unmarshal(body, &entry); err != nil {
+			http.Error(w, "invalid entry", http.StatusBadRequest)
+			return
+		}
+		c.local.Set(key, entry.Packs, entry.Total, entry.TTL)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startPeers runs n in-process nodes that all know each other
+func startPeers(t *testing.T, n int) []*PeerCache {
+	t.Helper()
+
+	servers := make([]*httptest.Server, n)
+	handlers := make([]http.Handler, n)
+	urls := make([]string, n)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(servers[i].Close)
+		urls[i] = servers[i].URL
+	}
+
+	caches := make([]*PeerCache, n)
+	for i := range caches {
+		caches[i] = NewPeerCache(urls[i], urls, "peer-secret", NewMemoryCache(100), time.Second)
+		handlers[i] = caches[i]
+	}
+	return caches
+}
+
+func TestPeerCache_SetOnOneGetOnOther(t *testing.T) {
+	peers := startPeers(t, 2)
+	a, b := peers[0], peers[1]
+
+	// Use enough keys that both nodes own some of them
+	ownedBy := map[string]int{}
+	for i := 0; i < 20; i++ {
+		key := GenerateCacheKey(1000+i, []int{250, 500})
+		ownedBy[a.ring.Owner(key)]++
+
+		a.Set(key, map[int]int{500: i + 1}, 500*(i+1), time.Hour)
+
+		packs, total, found := b.Get(key)
+		if !found {
+			t.Fatalf("Key %s set on A not found via B", key)
+		}
+		if total != 500*(i+1) || packs[500] != i+1 {
+			t.Errorf("Get(%s) via B = %v/%d, want %d packs of 500", key, packs, total, i+1)
+		}
+	}
+	if len(ownedBy) != 2 {
+		t.Errorf("Keys owned by %v, want both nodes to own some", ownedBy)
+	}
+
+	// Each entry lives only on its owner
+	if sa, sb := a.local.Stats().Size, b.local.Stats().Size; sa+sb != 20 {
+		t.Errorf("Local sizes %d + %d, want 20 entries stored once", sa, sb)
+	}
+	if stats := b.Stats(); stats.Hits != 20 || stats.Misses != 0 {
+		t.Errorf("B stats = %+v, want 20 hits", stats)
+	}
+}
+
+func TestPeerCache_RejectsUnsignedRequests(t *testing.T) {
+	c := NewPeerCache("http://self.invalid", nil, "peer-secret", NewMemoryCache(10), time.Second)
+	c.local.Set("k", map[int]int{250: 1}, 250, time.Hour)
+	forged := `{"packs":{"1":1},"total":1,"ttl":3600000000000}`
+
+	do := func(method, body string, sign func(*http.Request, []byte)) int {
+		req := httptest.NewRequest(method, PeerPath+"?key=k", strings.NewReader(body))
+		if sign != nil {
+			sign(req, []byte(body))
+		}
+		rec := httptest.NewRecorder()
+		c.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	signWith := func(secret string) func(*http.Request, []byte) {
+		return func(req *http.Request, body []byte) {
+			(&PeerCache{secret: []byte(secret)}).sign(req, "k", body)
+		}
+	}
+
+	if code := do(http.MethodPut, forged, nil); code != http.StatusForbidden {
+		t.Errorf("Unsigned PUT = %d, want 403", code)
+	}
+	if code := do(http.MethodPut, forged, signWith("wrong")); code != http.StatusForbidden {
+		t.Errorf("PUT signed with another secret = %d, want 403", code)
+	}
+	if code := do(http.MethodGet, "", nil); code != http.StatusForbidden {
+		t.Errorf("Unsigned GET = %d, want 403", code)
+	}
+	stale := func(req *http.Request, body []byte) {
+		timestamp := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+		req.Header.Set(PeerTimestampHeader, timestamp)
+		req.Header.Set(PeerSignatureHeader, signPeerRequest([]byte("peer-secret"), req.Method, "k", timestamp, body))
+	}
+	if code := do(http.MethodGet, "", stale); code != http.StatusForbidden {
+		t.Errorf("Replayed GET = %d, want 403", code)
+	}
+	if _, total, _ := c.local.Get("k"); total != 250 {
+		t.Errorf("Entry total = %d after forged writes, want 250", total)
+	}
+
+	if code := do(http.MethodGet, "", signWith("peer-secret")); code != http.StatusOK {
+		t.Errorf("Signed GET = %d, want 200", code)
+	}
+
+	// A node without a secret refuses everything, even requests signed with no secret
+	c.secret = nil
+	if code := do(http.MethodGet, "", signWith("")); code != http.StatusForbidden {
+		t.Errorf("GET on a node without a secret = %d, want 403", code)
+	}
+}
+
+func TestPeerCache_UnreachableOwnerIsMiss(t *testing.T) {
+	self := "http://self.invalid"
+	c := NewPeerCache(self, []string{self, "http://127.0.0.1:1"}, "peer-secret", NewMemoryCache(10), 200*time.Millisecond)
+
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if c.ring.Owner(key) == self {
+			continue
+		}
+		c.Set(key, map[int]int{250: 1}, 250, time.Hour)
+		if _, _, found := c.Get(key); found {
+			t.Errorf("Get(%s) found with an unreachable owner", key)
+		}
+		return
+	}
+	t.Fatal("No key owned by the remote peer")
+}
+
+func TestHashRing_StableWhenPeerAdded(t *testing.T) {
+	before := NewHashRing([]string{"a", "b", "c"}, 100)
+	after := NewHashRing([]string{"a", "b", "c", "d"}, 100)
+
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if o := after.Owner(key); o != before.Owner(key) {
+			moved++
+			if o != "d" {
+				t.Fatalf("Key %s moved between existing peers", key)
+			}
+		}
+	}
+	// Roughly a quarter of keys should move to the new peer
+	if moved < 100 || moved > 450 {
+		t.Errorf("%d of 1000 keys moved, want roughly 250", moved)
+	}
+}
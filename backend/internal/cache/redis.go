@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	json "github.com/goccy/go-json"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisKeyPrefix namespaces every entry RedisCache stores, so Clear can remove the
+// cache's keys without touching unrelated data in the same database
+const RedisKeyPrefix = "calc:"
+
+// RedisBlobPrefix namespaces the values stored by SetBlob, outside RedisKeyPrefix so
+// Clear leaves them alone
+const RedisBlobPrefix = "blob:"
+
+// redisIndexPrefix namespaces the sets indexing each pack set's keys for
+// InvalidatePackSet. It is inside RedisKeyPrefix, so Clear removes the indexes too,
+// and no cache key starts with "index:".
+const redisIndexPrefix = RedisKeyPrefix + "index:"
+
+// redisScanBatch is how many keys Clear and InvalidatePackSet handle per round trip
+const redisScanBatch = 500
+
+// redisSetIndexed stores an entry (KEYS[1]) and adds it to its pack set's index
+// (KEYS[2]) in one step. The index lives as long as its longest-lived entry: a zero
+// ttl (ARGV[2], in milliseconds) persists it, and a longer one extends it.
+var redisSetIndexed = redis.NewScript(`
+local ttl = tonumber(ARGV[2])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+end
+local existed = redis.call('EXISTS', KEYS[2])
+redis.call('SADD', KEYS[2], KEYS[1])
+if ttl == 0 then
+	redis.call('PERSIST', KEYS[2])
+	return 1
+end
+local current = redis.call('PTTL', KEYS[2])
+if existed == 0 or (current >= 0 and current < ttl) then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return 1
+`)
+
+// redisEntry is the JSON stored for each cached result
+type redisEntry struct {
+	Packs map[int]int `json:"packs"`
+	Total int         `json:"total"`
+}
+
+// RedisCache implements Cache on a Redis server, so results are shared by every
+// instance pointed at it. Operations that fail are treated as misses: the cache is
+// an optimization and must never fail a calculation.
+type RedisCache struct {
+	client  *redis.Client
+	timeout time.Duration // Deadline for each Redis round trip
+	hits    int64
+	misses  int64
+}
+
+// NewRedisCache connects to the Redis server at addr and checks it responds within
+// timeout, which also bounds every later operation
+func NewRedisCache(addr string, timeout time.Duration) (*RedisCache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		DialTimeout:  timeout,
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return &RedisCache{client: client, timeout: timeout}, nil
+}
+
+// Get retrieves a cached result; Redis errors and undecodable entries count as misses
+func (c *RedisCache) Get(key string) (map[int]int, int, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	val, err := c.client.Get(ctx, RedisKeyPrefix+key).Bytes()
+	if err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, 0, false
+	}
+
+	var entry redisEntry
+	if err := json.Unmarshal(val, &entry); err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, 0, false
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+	return entry.Packs, entry.Total, true
+}
+
+// Set stores a result that Redis expires after ttl; a zero ttl keeps it until cleared.
+// Results keyed by pack set are also added to the set's index for InvalidatePackSet.
+func (c *RedisCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
+	val, err := json.Marshal(redisEntry{Packs: packs, Total: total})
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	index, ok := redisIndexKey(key)
+	if !ok {
+		c.client.Set(ctx, RedisKeyPrefix+key, val, ttl)
+		return
+	}
+	redisSetIndexed.Run(ctx, c.client, []string{RedisKeyPrefix + key, index}, val, ttl.Milliseconds())
+}
+
+// redisIndexKey returns the index holding key if it was generated for a pack set
+func redisIndexKey(key string) (string, bool) {
+	namespace, base := keyNamespace(key)
+	i := strings.LastIndexByte(base, ':')
+	if i < 0 || !isPackSetKey(base, base[i:]) {
+		return "", false
+	}
+	return redisPackSetIndex(namespace, base[i:]), true
+}
+
+// redisPackSetIndex returns the index of the keys for the pack set whose writePackSet
+// suffix is suffix in namespace
+func redisPackSetIndex(namespace, suffix string) string {
+	return redisIndexPrefix + namespace + suffix
+}
+
+// InvalidatePackSet removes the entries, positive or negative, generated for packSizes
+// (in the same order) in exactly namespace, and returns how many were removed. Only the
+// set's index is read, so the cost does not depend on the rest of the keyspace. Keys are
+// popped from the index in batches, so entries added meanwhile are either removed or
+// stay indexed for the next invalidation. Errors are logged and end the invalidation.
+func (c *RedisCache) InvalidatePackSet(namespace string, packSizes []int) int {
+	var b strings.Builder
+	writePackSet(&b, packSizes)
+	index := redisPackSetIndex(namespace, b.String())
+
+	removed := 0
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		keys, err := c.client.SPopN(ctx, index, redisScanBatch).Result()
+		if err == nil && len(keys) > 0 {
+			var n int64
+			n, err = c.client.Unlink(ctx, keys...).Result()
+			removed += int(n)
+		}
+		cancel()
+		if err != nil {
+			log.Printf("Redis cache invalidation of %s failed: %v", index, err)
+			return removed
+		}
+		if len(keys) < redisScanBatch {
+			return removed
+		}
+	}
+}
+
+// Clear removes every key under RedisKeyPrefix, scanning in batches rather than
+// flushing the database. Each round trip is bounded by the cache's timeout; errors
+// are logged and end the scan, leaving the remaining keys to expire.
+func (c *RedisCache) Clear() {
+	var cursor uint64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+		keys, next, err := c.client.Scan(ctx, cursor, RedisKeyPrefix+"*", redisScanBatch).Result()
+		if err == nil && len(keys) > 0 {
+			err = c.client.Unlink(ctx, keys...).Err()
+		}
+		cancel()
+		if err != nil {
+			log.Printf("Redis cache clear failed: %v", err)
+			return
+		}
+		if next == 0 {
+			return
+		}
+		cursor = next
+	}
+}
+
+// GetBlob retrieves a value stored by SetBlob; Redis errors count as misses
+func (c *RedisCache) GetBlob(key string) ([]byte, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	val, err := c.client.Get(ctx, RedisBlobPrefix+key).Bytes()
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// SetBlob stores value under key, shared by every instance, until Redis expires it after ttl
+func (c *RedisCache) SetBlob(key string, value []byte, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	c.client.Set(ctx, RedisBlobPrefix+key, value, ttl)
+}
+
+// AddBlob stores value under key unless the key exists, atomically across instances.
+// A Redis error reports the value as stored, so an unreachable cache never blocks a
+// request that is merely being deduplicated.
+func (c *RedisCache) AddBlob(key string, value []byte, ttl time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	added, err := c.client.SetNX(ctx, RedisBlobPrefix+key, value, ttl).Result()
+	return added || err != nil
+}
+
+// DeleteBlob removes the value stored under key, if any
+func (c *RedisCache) DeleteBlob(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	c.client.Del(ctx, RedisBlobPrefix+key)
+}
+
+// Stats returns this instance's hit and miss counts. Size is not tracked, since
+// counting shared keys would need a scan of the whole database.
+func (c *RedisCache) Stats() CacheStats {
+	hits := atomic.LoadInt64(&c.hits)
+	misses := atomic.LoadInt64(&c.misses)
+
+	var ratio float64
+	if total := hits + misses; total > 0 {
+		ratio = float64(hits) / float64(total)
+	}
+	return CacheStats{Hits: hits, Misses: misses, HitRatio: ratio}
+}
+
+// Ping checks the Redis server responds
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+// Close releases the connection pool
+func (c *RedisCache) Close() error {
+	return c.client.Close()
+}
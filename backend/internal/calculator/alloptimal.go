@@ -0,0 +1,130 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"math"
+)
+
+// MaxOptimalSolutions caps how many solutions AllOptimalSolutions returns
+const MaxOptimalSolutions = 64
+
+// AllOptimalSolutions returns every distinct pack combination that reaches the optimal
+// total with the optimal pack count, up to MaxOptimalSolutions, along with that total.
+// Solutions are ordered by comparing their packs largest first, so combinations using
+// larger packs come first.
+func (c *Calculator) AllOptimalSolutions(amount int) ([]map[int]int, int, error) {
+	return c.AllOptimalSolutionsContext(context.Background(), amount)
+}
+
+// AllOptimalSolutionsContext is AllOptimalSolutions with cancellation. Besides the two
+// DP tables it allocates a third, minPacks, which the memory budget accounts for.
+func (c *Calculator) AllOptimalSolutionsContext(ctx context.Context, amount int) ([]map[int]int, int, error) {
+	if c.moq != nil || c.stock != nil {
+		return nil, 0, errors.New("enumerating solutions is not supported with MOQ or stock constraints")
+	}
+	if len(c.packSizes) > 0 {
+		if err := c.checkMemoryBudgetTables(amount+c.packSizes[len(c.packSizes)-1], 3); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	_, bestTotal, err := c.solve(ctx, amount, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// minPacks[i] is the fewest packs totalling exactly i. Every optimal solution for
+	// bestTotal stays optimal for what remains after removing any of its packs, so the
+	// search only follows sizes s where minPacks[rem-s] == minPacks[rem]-1.
+	minPacks := make([]int, bestTotal+1)
+	for i := 1; i <= bestTotal; i++ {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		minPacks[i] = math.MaxInt32
+		for _, size := range c.packSizes {
+			if size <= i && minPacks[i-size] != math.MaxInt32 && minPacks[i-size]+1 < minPacks[i] {
+				minPacks[i] = minPacks[i-size] + 1
+			}
+		}
+	}
+
+	var solutions []map[int]int
+	counts := make(map[int]int)
+	// Sizes are taken in non-increasing order (from index maxIdx down) so each
+	// combination is produced once rather than once per ordering
+	var search func(rem, maxIdx int)
+	search = func(rem, maxIdx int) {
+		if len(solutions) >= MaxOptimalSolutions {
+			return
+		}
+		if rem == 0 {
+			solution := make(map[int]int, len(counts))
+			for size, count := range counts {
+				solution[size] = count
+			}
+			solutions = append(solutions, solution)
+			return
+		}
+		for j := maxIdx; j >= 0; j-- {
+			size := c.packSizes[j]
+			if j < maxIdx && c.packSizes[j+1] == size {
+				continue // Duplicate size, already tried
+			}
+			if size > rem || minPacks[rem-size] != minPacks[rem]-1 {
+				continue
+			}
+			counts[size]++
+			search(rem-size, j)
+			if counts[size]--; counts[size] == 0 {
+				delete(counts, size)
+			}
+		}
+	}
+	search(bestTotal, len(c.packSizes)-1)
+
+	return solutions, bestTotal, nil
+}
+
+// Solution is one optimal pack combination
+type Solution struct {
+	Packs      map[int]int `json:"packs"`
+	TotalItems int         `json:"total_items"`
+	TotalPacks int         `json:"total_packs"`
+}
+
+// CalculateAll returns every combination tied for optimal, fewest items and then fewest
+// packs, in the order of AllOptimalSolutions. Duplicate pack sizes never produce
+// duplicate combinations.
+func (c *Calculator) CalculateAll(amount int) ([]Solution, error) {
+	return c.CalculateAllContext(context.Background(), amount)
+}
+
+// CalculateAllContext is CalculateAll with cancellation
+func (c *Calculator) CalculateAllContext(ctx context.Context, amount int) ([]Solution, error) {
+	combinations, total, err := c.AllOptimalSolutionsContext(ctx, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	solutions := make([]Solution, len(combinations))
+	for i, packs := range combinations {
+		solutions[i] = Solution{Packs: packs, TotalItems: total}
+		for _, count := range packs {
+			solutions[i].TotalPacks += count
+		}
+	}
+	return solutions, nil
+}
+
+// EstimateAllCost approximates the work of AllOptimalSolutions for amount, like
+// EstimateCost: it runs the DP over the whole amount, without pre-assigning largest
+// packs, and a second pass to fill minPacks
+func (c *Calculator) EstimateAllCost(amount int) int64 {
+	if amount <= 0 || len(c.packSizes) == 0 {
+		return 0
+	}
+	maxTarget := amount + c.packSizes[len(c.packSizes)-1]
+	return 2 * int64(maxTarget+1) * int64(len(c.packSizes))
+}
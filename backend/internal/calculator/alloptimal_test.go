@@ -0,0 +1,143 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestCalculator_AllOptimalSolutions(t *testing.T) {
+	// 10 is both 5+5 and 3+7; 13 only 3+5+5 or 3+3+7 at three packs
+	calc := NewCalculator([]int{3, 5, 7})
+
+	tests := []struct {
+		amount    int
+		wantTotal int
+		want      []map[int]int
+	}{
+		{10, 10, []map[int]int{{7: 1, 3: 1}, {5: 2}}},
+		{13, 13, []map[int]int{{7: 1, 3: 2}, {5: 2, 3: 1}}},
+		{14, 14, []map[int]int{{7: 2}}},
+		{1, 3, []map[int]int{{3: 1}}},
+	}
+	for _, tt := range tests {
+		solutions, total, err := calc.AllOptimalSolutions(tt.amount)
+		if err != nil {
+			t.Fatalf("AllOptimalSolutions(%d) error = %v", tt.amount, err)
+		}
+		if total != tt.wantTotal || !reflect.DeepEqual(solutions, tt.want) {
+			t.Errorf("AllOptimalSolutions(%d) = %v (%d items), want %v (%d items)", tt.amount, solutions, total, tt.want, tt.wantTotal)
+		}
+	}
+}
+
+func TestCalculator_AllOptimalSolutionsAreOptimal(t *testing.T) {
+	for _, sizes := range [][]int{{3, 5, 7}, {4, 6, 9, 10}, {250, 500, 1000, 2000, 5000}, {5, 5, 8}} {
+		calc := NewCalculator(sizes)
+		for _, amount := range []int{1, 12, 24, 31, 251, 1001, 12001} {
+			_, wantTotal, wantPacks, err := calc.CalculateWithDetails(amount)
+			if err != nil {
+				t.Fatalf("CalculateWithDetails(%d) error = %v", amount, err)
+			}
+			solutions, total, err := calc.AllOptimalSolutions(amount)
+			if err != nil {
+				t.Fatalf("AllOptimalSolutions(%d) error = %v", amount, err)
+			}
+			if total != wantTotal || len(solutions) == 0 {
+				t.Fatalf("%v: AllOptimalSolutions(%d) = %d items, %d solutions; want %d items", sizes, amount, total, len(solutions), wantTotal)
+			}
+
+			seen := make(map[string]bool)
+			for _, solution := range solutions {
+				items, packs := 0, 0
+				for size, count := range solution {
+					items += size * count
+					packs += count
+				}
+				if items != wantTotal || packs != wantPacks {
+					t.Errorf("%v: solution %v for %d has %d items in %d packs, want %d in %d", sizes, solution, amount, items, packs, wantTotal, wantPacks)
+				}
+				key := fmt.Sprint(solution)
+				if seen[key] {
+					t.Errorf("%v: duplicate solution %v for %d", sizes, solution, amount)
+				}
+				seen[key] = true
+			}
+		}
+	}
+}
+
+func TestCalculator_AllOptimalSolutionsCapped(t *testing.T) {
+	// Four packs from 10..30 summing to 100 can be chosen in well over the cap's ways
+	sizes := make([]int, 0, 21)
+	for size := 10; size <= 30; size++ {
+		sizes = append(sizes, size)
+	}
+	solutions, total, err := NewCalculator(sizes).AllOptimalSolutions(100)
+	if err != nil {
+		t.Fatalf("AllOptimalSolutions(100) error = %v", err)
+	}
+	if total != 100 || len(solutions) != MaxOptimalSolutions {
+		t.Errorf("AllOptimalSolutions(100) = %d solutions for %d items, want %d for 100", len(solutions), total, MaxOptimalSolutions)
+	}
+}
+
+func TestCalculator_CalculateAll(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  []int
+		amount int
+		want   []Solution
+	}{
+		// 2x250 also reaches 500 but uses more packs, so only 1x500 is optimal
+		{"fewer packs wins", []int{250, 500}, 500, []Solution{{Packs: map[int]int{500: 1}, TotalItems: 500, TotalPacks: 1}}},
+		// A size listed twice must not yield the same combination twice
+		{"duplicate sizes", []int{250, 250, 500}, 750, []Solution{{Packs: map[int]int{500: 1, 250: 1}, TotalItems: 750, TotalPacks: 2}}},
+		{"tied", []int{3, 5, 7}, 10, []Solution{
+			{Packs: map[int]int{7: 1, 3: 1}, TotalItems: 10, TotalPacks: 2},
+			{Packs: map[int]int{5: 2}, TotalItems: 10, TotalPacks: 2},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewCalculator(tt.sizes)
+			solutions, err := calc.CalculateAll(tt.amount)
+			if err != nil {
+				t.Fatalf("CalculateAll(%d) error = %v", tt.amount, err)
+			}
+			if !reflect.DeepEqual(solutions, tt.want) {
+				t.Errorf("CalculateAll(%d) = %+v, want %+v", tt.amount, solutions, tt.want)
+			}
+
+			// Calculate returns one of them
+			packs, _, _ := calc.Calculate(tt.amount)
+			found := false
+			for _, solution := range solutions {
+				found = found || reflect.DeepEqual(packs, solution.Packs)
+			}
+			if !found {
+				t.Errorf("Calculate(%d) = %v, not among %+v", tt.amount, packs, solutions)
+			}
+		})
+	}
+}
+
+func TestCalculator_AllOptimalSolutionsBudgetAndContext(t *testing.T) {
+	// Room for the two DP tables of Calculate but not the third minPacks table
+	calc := NewCalculator([]int{3, 5})
+	calc.SetMemoryBudget(int64(1000+5+1) * 8 * 2)
+	if _, _, err := calc.Calculate(1000); err != nil {
+		t.Fatalf("Calculate() error = %v, want it within budget", err)
+	}
+	if _, _, err := calc.AllOptimalSolutions(1000); !errors.Is(err, ErrMemoryBudgetExceeded) {
+		t.Errorf("AllOptimalSolutions() error = %v, want ErrMemoryBudgetExceeded", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewCalculator([]int{3, 5}).CalculateAllContext(ctx, 100000); !errors.Is(err, context.Canceled) {
+		t.Errorf("CalculateAllContext() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
@@ -1,14 +1,47 @@
 package calculator
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"math"
 	"sort"
 )
 
+// Validation errors returned for invalid amounts
+var (
+	ErrAmountZero     = errors.New("amount must be greater than zero")
+	ErrAmountNegative = errors.New("amount cannot be negative")
+)
+
+// ErrMemoryBudgetExceeded is returned, before anything is allocated, when a calculation's
+// DP tables would exceed the calculator's memory budget
+var ErrMemoryBudgetExceeded = errors.New("calculation exceeds the memory budget")
+
+// ErrMaxPacksExceeded is returned when an amount cannot be covered within the
+// calculator's maximum number of packs
+var ErrMaxPacksExceeded = errors.New("amount cannot be packed within the maximum number of packs")
+
+// ValidateAmount returns ErrAmountZero or ErrAmountNegative for non-positive amounts
+func ValidateAmount(amount int) error {
+	if amount == 0 {
+		return ErrAmountZero
+	}
+	if amount < 0 {
+		return ErrAmountNegative
+	}
+	return nil
+}
+
 // Calculator handles pack size calculations using dynamic programming
 type Calculator struct {
 	packSizes []int
+	moq       map[int]int // Minimum order quantity per size; nil when unconstrained
+	stock     map[int]int // Maximum count per size; nil when unbounded
+
+	preferTolerance int   // Extra items CalculatePreferring may send beyond the optimum
+	memoryBudget    int64 // Largest DP allocation in bytes; 0 means unlimited
+	maxPacks        int   // Most packs a Calculate result may use; 0 means unlimited
 }
 
 // NewCalculator creates a new calculator with given pack sizes
@@ -20,13 +53,314 @@ func NewCalculator(packSizes []int) *Calculator {
 	return &Calculator{packSizes: sorted}
 }
 
+// SetMemoryBudget caps the bytes a calculation's DP tables may take. Calculations that
+// would need more fail with ErrMemoryBudgetExceeded instead of allocating. Zero or a
+// negative value removes the cap.
+func (c *Calculator) SetMemoryBudget(bytes int64) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	c.memoryBudget = bytes
+}
+
+// SetMaxPacks caps the packs in Calculate results (and its Context, Details and Stats
+// variants): the result is then the fewest items, then fewest packs, among combinations
+// of at most max packs. Zero or a negative value removes the cap. Calculators with
+// minimum order quantities or stock limits do not support a cap.
+//
+// The cap needs no pack-count dimension in the DP: the table already holds the fewest
+// packs reaching each total exactly, so the capped optimum is the smallest total at or
+// above the amount whose entry is within the cap.
+func (c *Calculator) SetMaxPacks(max int) {
+	if max < 0 {
+		max = 0
+	}
+	c.maxPacks = max
+}
+
+// checkMemoryBudget estimates the DP allocation for totals up to maxTarget as two tables
+// of 8-byte entries and returns ErrMemoryBudgetExceeded if it is over budget
+func (c *Calculator) checkMemoryBudget(maxTarget int) error {
+	return c.checkMemoryBudgetTables(maxTarget, 2)
+}
+
+// checkMemoryBudgetTables is checkMemoryBudget for a calculation holding tables tables
+func (c *Calculator) checkMemoryBudgetTables(maxTarget, tables int) error {
+	if c.memoryBudget == 0 {
+		return nil
+	}
+	if need := int64(maxTarget+1) * 8 * int64(tables); need > c.memoryBudget {
+		return fmt.Errorf("%w: %d bytes needed, budget is %d", ErrMemoryBudgetExceeded, need, c.memoryBudget)
+	}
+	return nil
+}
+
 // Calculate finds the optimal pack combination for a given amount
 // Rule 1: Only whole packs (no breaking)
 // Rule 2: Minimize total items sent (takes precedence)
 // Rule 3: Among solutions with same item count, minimize number of packs
 func (c *Calculator) Calculate(amount int) (map[int]int, int, error) {
-	if amount <= 0 {
-		return nil, 0, errors.New("amount must be positive")
+	return c.CalculateContext(context.Background(), amount)
+}
+
+// CalculateContext is Calculate with cancellation: the DP stops early and returns
+// ctx.Err() once the context is done
+func (c *Calculator) CalculateContext(ctx context.Context, amount int) (map[int]int, int, error) {
+	return c.calculate(ctx, amount, nil)
+}
+
+// DPStats describes the work a calculation's DP did, for diagnosing slow requests
+type DPStats struct {
+	MaxTarget  int   `json:"dp_max_target"` // Largest total the DP table covered
+	Iterations int64 `json:"dp_iterations"` // DP transitions evaluated
+}
+
+// CalculateWithStatsContext is CalculateWithDetailsContext that also reports the DP size
+func (c *Calculator) CalculateWithStatsContext(ctx context.Context, amount int) (map[int]int, int, int, DPStats, error) {
+	var stats DPStats
+	packs, totalItems, err := c.calculate(ctx, amount, &stats)
+	if err != nil {
+		return nil, 0, 0, stats, err
+	}
+
+	totalPacks := 0
+	for _, count := range packs {
+		totalPacks += count
+	}
+	return packs, totalItems, totalPacks, stats, nil
+}
+
+// calculate dispatches to the DP for the calculator's constraints, recording into stats if non-nil
+func (c *Calculator) calculate(ctx context.Context, amount int, stats *DPStats) (map[int]int, int, error) {
+	if c.maxPacks > 0 && (c.moq != nil || c.stock != nil) {
+		return nil, 0, errors.New("a maximum number of packs cannot be combined with minimum order quantities or stock limits")
+	}
+	if c.moq != nil {
+		return c.calculateMOQ(ctx, amount, stats)
+	}
+	if c.stock != nil {
+		return c.calculateBounded(ctx, amount, stats)
+	}
+
+	if err := ValidateAmount(amount); err != nil {
+		return nil, 0, err
+	}
+
+	// Within a cap, the fewest packs possible is the amount in largest packs, rounded up.
+	// Whenever that fits, its total lies within one largest pack of the amount, inside
+	// the range the DP searches.
+	if c.maxPacks > 0 && len(c.packSizes) > 0 {
+		largest := c.packSizes[len(c.packSizes)-1]
+		if need := (amount + largest - 1) / largest; need > c.maxPacks {
+			return nil, 0, fmt.Errorf("%w: %d items need at least %d packs, the maximum is %d", ErrMaxPacksExceeded, amount, need, c.maxPacks)
+		}
+	}
+
+	// Large amounts pre-assign most of their largest packs and run the DP on the rest.
+	// Every fewest-packs combination for a candidate total contains those packs, so the
+	// cap carries over to the remainder less the packs pre-assigned.
+	largest := 0
+	preassigned := c.preassignedLargest(amount)
+	if preassigned > 0 {
+		largest = c.packSizes[len(c.packSizes)-1]
+		amount -= preassigned * largest
+	}
+	maxPacks := 0
+	if c.maxPacks > 0 {
+		maxPacks = c.maxPacks - preassigned
+	}
+
+	parent, bestTotal, err := c.solveCapped(ctx, amount, maxPacks, stats)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	packs := backtrack(parent, bestTotal)
+	if preassigned > 0 {
+		packs[largest] += preassigned
+		bestTotal += preassigned * largest
+	}
+	return packs, bestTotal, nil
+}
+
+// preassignedLargest returns how many largest packs every optimal solution for amount
+// is guaranteed to contain, so the DP only has to cover the remainder.
+//
+// In a fewest-packs combination, fewer than L/gcd(s, L) packs of each smaller size s are
+// used, where L is the largest size: that many packs of s sum to a multiple of L and could
+// be swapped for fewer largest packs. By the same argument over prefix sums modulo L, fewer
+// than L smaller packs are used in total. Either way the smaller packs sum to at most a
+// bound B, so any total t is reached with at least (t-B)/L largest packs. Taking those out
+// of every candidate maps solutions for amount one-to-one onto solutions for the remainder,
+// preserving both item and pack counts, so the optimum is unchanged.
+func (c *Calculator) preassignedLargest(amount int) int {
+	if len(c.packSizes) < 2 {
+		return 0
+	}
+	largest := c.packSizes[len(c.packSizes)-1]
+
+	// Keep the remainder above the bound and at least one item
+	bound := c.smallerPacksBound()
+	if amount <= bound+1 {
+		return 0
+	}
+	return (amount - bound - 1) / largest
+}
+
+// smallerPacksBound returns the bound B from preassignedLargest on the items the smaller
+// sizes contribute to a fewest-packs combination. It needs at least two sizes.
+func (c *Calculator) smallerPacksBound() int {
+	largest := c.packSizes[len(c.packSizes)-1]
+
+	perSize := 0
+	for _, size := range c.packSizes {
+		if size < largest {
+			perSize += (largest/gcd(size, largest) - 1) * size
+		}
+	}
+	bound := perSize
+	if total := (largest - 1) * c.packSizes[len(c.packSizes)-2]; total < bound {
+		bound = total
+	}
+	return bound
+}
+
+// CheckWorstCase returns ErrMemoryBudgetExceeded if Calculate for some amount up to
+// maxAmount would exceed the memory budget, so a pack set can be vetted before it is
+// used. With two or more sizes the remainder left by preassignedLargest is at most
+// B + L, which bounds the DP table for every amount without running it.
+func (c *Calculator) CheckWorstCase(maxAmount int) error {
+	if len(c.packSizes) == 0 || maxAmount <= 0 {
+		return nil
+	}
+	largest := c.packSizes[len(c.packSizes)-1]
+	remainder := maxAmount
+	if len(c.packSizes) >= 2 {
+		if bound := c.smallerPacksBound() + largest; bound < remainder {
+			remainder = bound
+		}
+	}
+	return c.checkMemoryBudget(remainder + largest)
+}
+
+// EstimateCost approximates the DP transitions a calculation for amount will evaluate:
+// the totals the table covers times the number of pack sizes. It allocates nothing, so
+// callers can use it to schedule work before running it. Invalid amounts cost zero.
+func (c *Calculator) EstimateCost(amount int) int64 {
+	if amount <= 0 || len(c.packSizes) == 0 {
+		return 0
+	}
+	largest := c.packSizes[len(c.packSizes)-1]
+	maxTarget := amount + largest
+	switch {
+	case c.moq != nil:
+		maxTarget = amount + c.minQuantity(largest)*largest
+	case c.stock == nil:
+		maxTarget -= c.preassignedLargest(amount) * largest
+	}
+	return int64(maxTarget+1) * int64(len(c.packSizes))
+}
+
+// gcd returns the greatest common divisor of two positive integers
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// backtrack follows the DP parent table from total down to zero to find which packs were used
+func backtrack(parent []int, total int) map[int]int {
+	packs := make(map[int]int)
+	for current := total; current > 0; current -= parent[current] {
+		packs[parent[current]]++
+	}
+	return packs
+}
+
+// Bounds are the smallest and largest totals reachable for an amount within one largest
+// pack of it, with a fewest-packs combination for each
+type Bounds struct {
+	MinTotal int         `json:"min_total"`
+	MinPacks map[int]int `json:"min_packs"`
+	MaxTotal int         `json:"max_total"`
+	MaxPacks map[int]int `json:"max_packs"`
+}
+
+// CalculateBounds returns the optimal total (as Calculate) and the largest total reachable
+// in [amount, amount+largest pack], read from the same DP pass
+func (c *Calculator) CalculateBounds(amount int) (Bounds, error) {
+	if c.moq != nil || c.stock != nil {
+		return Bounds{}, errors.New("bounds are not supported with MOQ or stock constraints")
+	}
+
+	parent, minTotal, err := c.solve(context.Background(), amount, nil)
+	if err != nil {
+		return Bounds{}, err
+	}
+
+	// Every reachable state above zero has a parent; minTotal itself guarantees a hit
+	maxTotal := minTotal
+	for i := len(parent) - 1; i > minTotal; i-- {
+		if parent[i] != 0 {
+			maxTotal = i
+			break
+		}
+	}
+
+	return Bounds{
+		MinTotal: minTotal,
+		MinPacks: backtrack(parent, minTotal),
+		MaxTotal: maxTotal,
+		MaxPacks: backtrack(parent, maxTotal),
+	}, nil
+}
+
+// CalculateSteps returns the optimal packs as an ordered list of pack sizes,
+// largest first (e.g. [5000, 5000, 2000, 250]), for step-by-step rendering
+func (c *Calculator) CalculateSteps(amount int) ([]int, int, error) {
+	var steps []int
+	var bestTotal int
+	if c.moq != nil || c.stock != nil {
+		packs, total, err := c.CalculateContext(context.Background(), amount)
+		if err != nil {
+			return nil, 0, err
+		}
+		for size, count := range packs {
+			for i := 0; i < count; i++ {
+				steps = append(steps, size)
+			}
+		}
+		bestTotal = total
+	} else {
+		parent, total, err := c.solve(context.Background(), amount, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		for current := total; current > 0; current -= parent[current] {
+			steps = append(steps, parent[current])
+		}
+		bestTotal = total
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(steps)))
+
+	return steps, bestTotal, nil
+}
+
+// cancelCheckInterval is how many DP states are processed between context checks
+const cancelCheckInterval = 1 << 12
+
+// solve runs the DP and returns the parent table and the optimal total items.
+// stats, if non-nil, receives the table bound and the transitions evaluated.
+func (c *Calculator) solve(ctx context.Context, amount int, stats *DPStats) ([]int, int, error) {
+	return c.solveCapped(ctx, amount, 0, stats)
+}
+
+// solveCapped is solve choosing only totals reachable with at most maxPacks packs;
+// zero means no cap
+func (c *Calculator) solveCapped(ctx context.Context, amount, maxPacks int, stats *DPStats) ([]int, int, error) {
+	if err := ValidateAmount(amount); err != nil {
+		return nil, 0, err
 	}
 	if len(c.packSizes) == 0 {
 		return nil, 0, errors.New("no pack sizes available")
@@ -36,6 +370,9 @@ func (c *Calculator) Calculate(amount int) (map[int]int, int, error) {
 	// We need to find the smallest combination that meets or exceeds 'amount'
 	// The worst case is using all smallest packs, but we limit search space
 	maxTarget := amount + c.packSizes[len(c.packSizes)-1]
+	if err := c.checkMemoryBudget(maxTarget); err != nil {
+		return nil, 0, err
+	}
 
 	// dp[i] stores the minimum number of packs to achieve exactly i items
 	// Initialize with max value (impossible state)
@@ -49,52 +386,241 @@ func (c *Calculator) Calculate(amount int) (map[int]int, int, error) {
 	parent := make([]int, maxTarget+1)
 
 	// Dynamic programming: build up solutions for all amounts up to maxTarget
+	var iterations int64
 	for i := 0; i <= maxTarget; i++ {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
 		if dp[i] == math.MaxInt32 {
 			continue // Can't reach this state
 		}
+		iterations += int64(len(c.packSizes))
 
 		// Try adding each pack size
 		for _, packSize := range c.packSizes {
 			next := i + packSize
 			if next <= maxTarget {
-				// Update if this gives fewer packs for the same total
+				// Update if this gives fewer packs for the same total.
+				// On a tie, record the larger pack so backtracking favours large packs
+				// regardless of iteration order.
 				if dp[next] > dp[i]+1 {
 					dp[next] = dp[i] + 1
 					parent[next] = packSize
+				} else if dp[next] == dp[i]+1 && packSize > parent[next] {
+					parent[next] = packSize
 				}
 			}
 		}
 	}
 
-	// Find the minimum total items >= amount with a valid solution
+	if stats != nil {
+		stats.MaxTarget = maxTarget
+		stats.Iterations = iterations
+	}
+
+	// Find the minimum total items >= amount with a valid solution within the cap
 	bestTotal := -1
 	for i := amount; i <= maxTarget; i++ {
-		if dp[i] != math.MaxInt32 {
+		if dp[i] != math.MaxInt32 && (maxPacks == 0 || dp[i] <= maxPacks) {
 			bestTotal = i
 			break
 		}
 	}
 
+	if bestTotal == -1 && maxPacks > 0 {
+		return nil, 0, fmt.Errorf("%w: %d items within %d packs", ErrMaxPacksExceeded, amount, maxPacks)
+	}
 	if bestTotal == -1 {
 		return nil, 0, errors.New("no valid pack combination found")
 	}
 
-	// Backtrack to find which packs were used
-	packs := make(map[int]int)
-	current := bestTotal
-	for current > 0 {
-		packUsed := parent[current]
-		packs[packUsed]++
-		current -= packUsed
+	return parent, bestTotal, nil
+}
+
+// RangeResult is the optimal packing of one amount from CalculateRange
+type RangeResult struct {
+	Amount     int
+	Packs      map[int]int
+	TotalItems int
+	TotalPacks int
+}
+
+// CalculateRange returns the optimal packs for every amount from lo to hi, in order, from
+// a single DP pass. See SolveRange for the constraints it supports.
+func (c *Calculator) CalculateRange(ctx context.Context, lo, hi int) ([]RangeResult, error) {
+	table, err := c.SolveRange(ctx, lo, hi)
+	if err != nil {
+		return nil, err
 	}
 
-	return packs, bestTotal, nil
+	results := make([]RangeResult, 0, hi-lo+1)
+	for amount := lo; amount <= hi; amount++ {
+		if len(results)%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		results = append(results, table.Result(amount))
+	}
+	return results, nil
+}
+
+// RangeTable is the solved DP for a range of amounts, from which each amount's packs are
+// backtracked on demand by Result
+type RangeTable struct {
+	parent      []int
+	lo, hi      int
+	largest     int
+	preassigned int
+}
+
+// SolveRange runs the single DP pass that covers every amount from lo to hi. Every amount
+// in the range contains at least as many largest packs as preassignedLargest guarantees
+// for lo, so those are taken out once and the table only spans the range plus one
+// largest pack. Calculators with minimum order quantities, stock limits or a pack cap
+// are not supported.
+func (c *Calculator) SolveRange(ctx context.Context, lo, hi int) (*RangeTable, error) {
+	if c.moq != nil || c.stock != nil || c.maxPacks > 0 {
+		return nil, errors.New("ranges are not supported with MOQ, stock or pack cap constraints")
+	}
+	if err := ValidateAmount(lo); err != nil {
+		return nil, err
+	}
+	if hi < lo {
+		return nil, errors.New("hi must be greater than or equal to lo")
+	}
+	if len(c.packSizes) == 0 {
+		return nil, errors.New("no pack sizes available")
+	}
+
+	largest := c.packSizes[len(c.packSizes)-1]
+	preassigned := c.preassignedLargest(lo)
+
+	// solve for the top of the range fills the table for every total up to hi + largest
+	parent, _, err := c.solve(ctx, hi-preassigned*largest, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RangeTable{parent: parent, lo: lo, hi: hi, largest: largest, preassigned: preassigned}, nil
+}
+
+// Result backtracks the optimal packs for amount, which must lie within the solved range
+func (t *RangeTable) Result(amount int) RangeResult {
+	if amount < t.lo || amount > t.hi {
+		panic(fmt.Sprintf("calculator: amount %d outside solved range %d-%d", amount, t.lo, t.hi))
+	}
+	offset := t.preassigned * t.largest
+
+	// Totals above zero are reachable exactly when they have a parent, and one is always
+	// reachable within a largest pack of the amount
+	total := amount - offset
+	for t.parent[total] == 0 {
+		total++
+	}
+	packs := backtrack(t.parent, total)
+	if t.preassigned > 0 {
+		packs[t.largest] += t.preassigned
+	}
+	totalPacks := 0
+	for _, count := range packs {
+		totalPacks += count
+	}
+	return RangeResult{Amount: amount, Packs: packs, TotalItems: total + offset, TotalPacks: totalPacks}
+}
+
+// EstimateRangeCost approximates the DP transitions CalculateRange evaluates for lo to
+// hi, like EstimateCost does for a single amount
+func (c *Calculator) EstimateRangeCost(lo, hi int) int64 {
+	if lo <= 0 || hi < lo || len(c.packSizes) == 0 {
+		return 0
+	}
+	largest := c.packSizes[len(c.packSizes)-1]
+	maxTarget := hi + largest - c.preassignedLargest(lo)*largest
+	return int64(maxTarget+1) * int64(len(c.packSizes))
+}
+
+// Feasibility describes whether an amount can be packed exactly and the minimal overshoot otherwise
+type Feasibility struct {
+	Amount               int  `json:"amount"`
+	RepresentableExactly bool `json:"representable_exactly"`
+	MinOvershoot         int  `json:"min_overshoot"`
+}
+
+// CheckFeasibility reports exact representability and minimal overshoot for each amount,
+// using a single reachability pass up to the largest amount plus the largest pack
+func (c *Calculator) CheckFeasibility(amounts []int) ([]Feasibility, error) {
+	return c.CheckFeasibilityContext(context.Background(), amounts)
+}
+
+// EstimateFeasibilityCost approximates the reachability pass CheckFeasibility runs for
+// amounts, like EstimateCost does for a single amount
+func (c *Calculator) EstimateFeasibilityCost(amounts []int) int64 {
+	if len(c.packSizes) == 0 {
+		return 0
+	}
+	maxAmount := 0
+	for _, amount := range amounts {
+		maxAmount = max(maxAmount, amount)
+	}
+	return int64(maxAmount+c.packSizes[len(c.packSizes)-1]+1) * int64(len(c.packSizes))
+}
+
+// CheckFeasibilityContext is CheckFeasibility with cancellation: it returns ctx.Err()
+// once the context is done
+func (c *Calculator) CheckFeasibilityContext(ctx context.Context, amounts []int) ([]Feasibility, error) {
+	if len(c.packSizes) == 0 {
+		return nil, errors.New("no pack sizes available")
+	}
+
+	maxAmount := 0
+	for _, amount := range amounts {
+		if err := ValidateAmount(amount); err != nil {
+			return nil, err
+		}
+		if amount > maxAmount {
+			maxAmount = amount
+		}
+	}
+
+	maxTarget := maxAmount + c.packSizes[len(c.packSizes)-1]
+	reachable := make([]bool, maxTarget+1)
+	reachable[0] = true
+	for i := 0; i <= maxTarget; i++ {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !reachable[i] {
+			continue
+		}
+		for _, packSize := range c.packSizes {
+			if next := i + packSize; next <= maxTarget {
+				reachable[next] = true
+			}
+		}
+	}
+
+	results := make([]Feasibility, len(amounts))
+	for idx, amount := range amounts {
+		results[idx] = Feasibility{Amount: amount}
+		// A reachable total always exists within one largest pack of the amount
+		for total := amount; total <= maxTarget; total++ {
+			if reachable[total] {
+				results[idx].RepresentableExactly = total == amount
+				results[idx].MinOvershoot = total - amount
+				break
+			}
+		}
+	}
+
+	return results, nil
 }
 
 // CalculateWithDetails returns detailed results including total packs
 func (c *Calculator) CalculateWithDetails(amount int) (map[int]int, int, int, error) {
-	packs, totalItems, err := c.Calculate(amount)
+	return c.CalculateWithDetailsContext(context.Background(), amount)
+}
+
+// CalculateWithDetailsContext is CalculateWithDetails with cancellation
+func (c *Calculator) CalculateWithDetailsContext(ctx context.Context, amount int) (map[int]int, int, int, error) {
+	packs, totalItems, err := c.CalculateContext(ctx, amount)
 	if err != nil {
 		return nil, 0, 0, err
 	}
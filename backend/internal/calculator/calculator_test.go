@@ -1,6 +1,9 @@
 package calculator
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
 )
 
@@ -163,6 +166,45 @@ func TestCalculator_MinimizePacks(t *testing.T) {
 	}
 }
 
+func TestCalculator_TieBreakPrefersLargerPacks(t *testing.T) {
+	tests := []struct {
+		name          string
+		packSizes     []int
+		amount        int
+		expectedPacks map[int]int
+	}{
+		// 750+250 and 500+500 both use two packs for 1000 items
+		{"Two-pack tie", []int{250, 500, 750}, 1000, map[int]int{750: 1, 250: 1}},
+		// 4+2 and 3+3 both use two packs for 6 items
+		{"Small sizes tie", []int{2, 3, 4}, 6, map[int]int{4: 1, 2: 1}},
+		// Not a tie: one 500 beats two 250s outright
+		{"Fewer packs wins", []int{250, 500}, 500, map[int]int{500: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Input order must not affect the result
+			for _, sizes := range [][]int{tt.packSizes, reversed(tt.packSizes)} {
+				packs, _, err := NewCalculator(sizes).Calculate(tt.amount)
+				if err != nil {
+					t.Fatalf("Calculate() error = %v", err)
+				}
+				if !mapsEqual(packs, tt.expectedPacks) {
+					t.Errorf("Packs = %v, want %v (sizes %v)", packs, tt.expectedPacks, sizes)
+				}
+			}
+		})
+	}
+}
+
+func reversed(a []int) []int {
+	out := make([]int, len(a))
+	for i, v := range a {
+		out[len(a)-1-i] = v
+	}
+	return out
+}
+
 func TestCalculator_ErrorCases(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -202,6 +244,57 @@ func TestCalculator_ErrorCases(t *testing.T) {
 	}
 }
 
+func TestCalculator_AmountValidationErrors(t *testing.T) {
+	calc := NewCalculator([]int{250, 500})
+
+	if _, _, err := calc.Calculate(0); !errors.Is(err, ErrAmountZero) {
+		t.Errorf("Calculate(0) error = %v, want ErrAmountZero", err)
+	}
+	if _, _, err := calc.Calculate(-5); !errors.Is(err, ErrAmountNegative) {
+		t.Errorf("Calculate(-5) error = %v, want ErrAmountNegative", err)
+	}
+	if err := ValidateAmount(1); err != nil {
+		t.Errorf("ValidateAmount(1) error = %v, want nil", err)
+	}
+}
+
+func TestCalculator_CheckFeasibility(t *testing.T) {
+	calc := NewCalculator([]int{23, 31, 53})
+
+	results, err := calc.CheckFeasibility([]int{23, 24, 46, 1, 500000})
+	if err != nil {
+		t.Fatalf("CheckFeasibility() error = %v", err)
+	}
+
+	want := []Feasibility{
+		{Amount: 23, RepresentableExactly: true, MinOvershoot: 0},
+		{Amount: 24, RepresentableExactly: false, MinOvershoot: 7},
+		{Amount: 46, RepresentableExactly: true, MinOvershoot: 0},
+		{Amount: 1, RepresentableExactly: false, MinOvershoot: 22},
+		{Amount: 500000, RepresentableExactly: true, MinOvershoot: 0},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Got %d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("Result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+
+	// Overshoot must agree with the full calculation
+	for _, r := range results {
+		_, total, _ := calc.Calculate(r.Amount)
+		if total-r.Amount != r.MinOvershoot {
+			t.Errorf("Amount %d: overshoot %d disagrees with Calculate total %d", r.Amount, r.MinOvershoot, total)
+		}
+	}
+
+	if _, err := calc.CheckFeasibility([]int{10, 0}); !errors.Is(err, ErrAmountZero) {
+		t.Errorf("CheckFeasibility with zero error = %v, want ErrAmountZero", err)
+	}
+}
+
 func TestCalculator_LargeNumbers(t *testing.T) {
 	packSizes := []int{1000, 5000, 10000}
 	amount := 1000000
@@ -231,6 +324,66 @@ func TestCalculator_LargeNumbers(t *testing.T) {
 	t.Logf("Large number test passed: amount=%d, total=%d, packs=%v", amount, total, packs)
 }
 
+func TestCalculator_CalculateSteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		packSizes []int
+		amount    int
+		wantSteps []int
+	}{
+		{"12001 items", []int{250, 500, 1000, 2000, 5000}, 12001, []int{5000, 5000, 2000, 250}},
+		{"Single pack", []int{250, 500, 1000, 2000, 5000}, 1, []int{250}},
+		{"Edge case", []int{23, 31, 53}, 500000, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewCalculator(tt.packSizes)
+			steps, total, err := calc.CalculateSteps(tt.amount)
+			if err != nil {
+				t.Fatalf("CalculateSteps() error = %v", err)
+			}
+
+			packs, wantTotal, _ := calc.Calculate(tt.amount)
+			if total != wantTotal {
+				t.Errorf("Total = %d, want %d", total, wantTotal)
+			}
+
+			sum := 0
+			aggregated := make(map[int]int)
+			for i, step := range steps {
+				if i > 0 && step > steps[i-1] {
+					t.Fatalf("Steps not in descending order at index %d: %v", i, steps[:i+1])
+				}
+				sum += step
+				aggregated[step]++
+			}
+
+			if sum != total {
+				t.Errorf("Sum of steps = %d, want %d", sum, total)
+			}
+			if !mapsEqual(aggregated, packs) {
+				t.Errorf("Aggregated steps = %v, want %v", aggregated, packs)
+			}
+			if tt.wantSteps != nil && !slicesEqual(steps, tt.wantSteps) {
+				t.Errorf("Steps = %v, want %v", steps, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func slicesEqual(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Helper function to compare maps
 func mapsEqual(a, b map[int]int) bool {
 	if len(a) != len(b) {
@@ -243,3 +396,439 @@ func mapsEqual(a, b map[int]int) bool {
 	}
 	return true
 }
+
+func TestCalculator_CheckFeasibilityContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calc := NewCalculator([]int{23, 31, 53})
+	if _, err := calc.CheckFeasibilityContext(ctx, []int{500000}); !errors.Is(err, context.Canceled) {
+		t.Errorf("CheckFeasibilityContext() error = %v, want context.Canceled", err)
+	}
+	if got, want := calc.EstimateFeasibilityCost([]int{100, 500000}), int64(500000+53+1)*3; got != want {
+		t.Errorf("EstimateFeasibilityCost() = %d, want %d", got, want)
+	}
+}
+
+func TestCalculator_CalculateContextCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for name, calc := range map[string]*Calculator{
+		"plain": NewCalculator([]int{23, 31, 53}),
+		"moq":   NewCalculatorWithMOQ([]int{23, 31, 53}, map[int]int{53: 2}),
+	} {
+		if _, _, err := calc.CalculateContext(ctx, 500000); !errors.Is(err, context.Canceled) {
+			t.Errorf("%s: CalculateContext() error = %v, want context.Canceled", name, err)
+		}
+		if _, total, err := calc.CalculateContext(context.Background(), 500000); err != nil || total < 500000 {
+			t.Errorf("%s: CalculateContext() = %d, %v with a live context", name, total, err)
+		}
+	}
+}
+
+func TestCalculator_CalculateBounds(t *testing.T) {
+	calc := NewCalculator([]int{250, 500, 1000, 2000, 5000})
+
+	bounds, err := calc.CalculateBounds(251)
+	if err != nil {
+		t.Fatalf("CalculateBounds() error = %v", err)
+	}
+	if bounds.MinTotal != 500 || !mapsEqual(bounds.MinPacks, map[int]int{500: 1}) {
+		t.Errorf("Min = %d %v, want 500 {500:1}", bounds.MinTotal, bounds.MinPacks)
+	}
+	// The window is [251, 5251]; 5250 is the largest multiple of 250 inside it
+	if bounds.MaxTotal != 5250 || !mapsEqual(bounds.MaxPacks, map[int]int{5000: 1, 250: 1}) {
+		t.Errorf("Max = %d %v, want 5250 {5000:1 250:1}", bounds.MaxTotal, bounds.MaxPacks)
+	}
+}
+
+func TestCalculator_CalculateBoundsMatchesReachability(t *testing.T) {
+	sizes := []int{23, 31, 53}
+	calc := NewCalculator(sizes)
+
+	for amount := 1; amount <= 300; amount++ {
+		bounds, err := calc.CalculateBounds(amount)
+		if err != nil {
+			t.Fatalf("CalculateBounds(%d) error = %v", amount, err)
+		}
+
+		_, optimum, _ := calc.Calculate(amount)
+		if bounds.MinTotal != optimum {
+			t.Errorf("CalculateBounds(%d).MinTotal = %d, want %d", amount, bounds.MinTotal, optimum)
+		}
+
+		// Largest total in the window reachable by some combination
+		window := amount + 53
+		reachable := make([]bool, window+1)
+		reachable[0] = true
+		for i := 0; i <= window; i++ {
+			for _, size := range sizes {
+				if reachable[i] && i+size <= window {
+					reachable[i+size] = true
+				}
+			}
+		}
+		wantMax := 0
+		for i := window; i >= amount; i-- {
+			if reachable[i] {
+				wantMax = i
+				break
+			}
+		}
+		if bounds.MaxTotal != wantMax {
+			t.Errorf("CalculateBounds(%d).MaxTotal = %d, want %d", amount, bounds.MaxTotal, wantMax)
+		}
+
+		for _, check := range []struct {
+			total int
+			packs map[int]int
+		}{{bounds.MinTotal, bounds.MinPacks}, {bounds.MaxTotal, bounds.MaxPacks}} {
+			sum := 0
+			for size, count := range check.packs {
+				sum += size * count
+			}
+			if sum != check.total {
+				t.Errorf("CalculateBounds(%d) packs %v sum to %d, want %d", amount, check.packs, sum, check.total)
+			}
+		}
+	}
+}
+
+func TestCalculator_DPStats(t *testing.T) {
+	ctx := context.Background()
+
+	// Unconstrained: the table spans amount + largest pack; only multiples of 250
+	// (0..6000, 25 states) are reachable and each tries all 5 sizes
+	_, _, _, stats, err := NewCalculator([]int{250, 500, 1000, 2000, 5000}).CalculateWithStatsContext(ctx, 1000)
+	if err != nil {
+		t.Fatalf("CalculateWithStatsContext() error = %v", err)
+	}
+	if stats.MaxTarget != 6000 || stats.Iterations != 125 {
+		t.Errorf("Stats = %+v, want max target 6000 and 125 iterations", stats)
+	}
+
+	// A tiny size makes nearly every state reachable, so iterations approach the table size
+	sizes := []int{23, 31, 53}
+	_, _, _, stats, err = NewCalculator(sizes).CalculateWithStatsContext(ctx, 500000)
+	if err != nil {
+		t.Fatalf("CalculateWithStatsContext() error = %v", err)
+	}
+	// Only the remainder after pre-assigning 9403 packs of 53 is covered by the DP:
+	// 500000 - 9403*53 = 1641, plus one largest pack
+	const wantMaxTarget = 1641 + 53
+	reachable := make([]bool, wantMaxTarget+1)
+	reachable[0] = true
+	var wantIterations int64
+	for i := range reachable {
+		if !reachable[i] {
+			continue
+		}
+		wantIterations += int64(len(sizes))
+		for _, size := range sizes {
+			if i+size < len(reachable) {
+				reachable[i+size] = true
+			}
+		}
+	}
+	if stats.MaxTarget != wantMaxTarget || stats.Iterations != wantIterations {
+		t.Errorf("Stats = %+v, want max target %d and %d iterations", stats, wantMaxTarget, wantIterations)
+	}
+
+	// Layered variants cover every total once per size
+	_, _, _, stats, _ = NewCalculatorWithMOQ([]int{3, 5}, map[int]int{5: 2}).CalculateWithStatsContext(ctx, 8)
+	if stats.MaxTarget != 18 || stats.Iterations != 2*19 {
+		t.Errorf("MOQ stats = %+v, want max target 18 and 38 iterations", stats)
+	}
+	_, _, _, stats, _ = NewCalculatorWithStock([]int{250, 500}, map[int]int{500: 1}).CalculateWithStatsContext(ctx, 1000)
+	if stats.MaxTarget != 1500 || stats.Iterations != 2*1501 {
+		t.Errorf("Stock stats = %+v, want max target 1500 and 3002 iterations", stats)
+	}
+}
+
+func TestCalculator_PreassignedLargestMatchesFullDP(t *testing.T) {
+	sizeSets := [][]int{
+		{250, 500, 1000, 2000, 5000},
+		{23, 31, 53},
+		{6, 9, 20},
+		{4, 6},
+		{7, 7, 10},
+		{1000},
+	}
+	for _, sizes := range sizeSets {
+		calc := NewCalculator(sizes)
+		for _, amount := range []int{1, 263, 4999, 5001, 12345, 25001, 99999, 250001} {
+			packs, total, err := calc.Calculate(amount)
+			if err != nil {
+				t.Fatalf("%v, %d: Calculate() error = %v", sizes, amount, err)
+			}
+
+			parent, wantTotal, err := calc.solve(context.Background(), amount, nil)
+			if err != nil {
+				t.Fatalf("%v, %d: solve() error = %v", sizes, amount, err)
+			}
+			wantPacks := 0
+			for _, count := range backtrack(parent, wantTotal) {
+				wantPacks += count
+			}
+
+			gotItems, gotPacks := 0, 0
+			for size, count := range packs {
+				gotItems += size * count
+				gotPacks += count
+			}
+			if total != wantTotal || gotItems != total || gotPacks != wantPacks {
+				t.Errorf("%v, %d: total %d in %d packs (%v), full DP gives %d in %d packs",
+					sizes, amount, total, gotPacks, packs, wantTotal, wantPacks)
+			}
+		}
+	}
+}
+
+func TestCalculator_MaxPacksForcesLargerPacks(t *testing.T) {
+	tests := []struct {
+		sizes     []int
+		amount    int
+		maxPacks  int
+		wantTotal int
+		wantPacks map[int]int
+	}{
+		{[]int{250, 500, 1000}, 1750, 0, 1750, map[int]int{1000: 1, 500: 1, 250: 1}},
+		{[]int{250, 500, 1000}, 1750, 3, 1750, map[int]int{1000: 1, 500: 1, 250: 1}},
+		{[]int{250, 500, 1000}, 1750, 2, 2000, map[int]int{1000: 2}},
+		{[]int{1, 10}, 19, 2, 20, map[int]int{10: 2}},
+		{[]int{3, 5}, 14, 3, 15, map[int]int{5: 3}},
+		{[]int{250, 500, 1000, 2000, 5000}, 12001, 3, 15000, map[int]int{5000: 3}},
+	}
+	for _, tt := range tests {
+		calc := NewCalculator(tt.sizes)
+		calc.SetMaxPacks(tt.maxPacks)
+		packs, total, err := calc.Calculate(tt.amount)
+		if err != nil {
+			t.Fatalf("%v, %d within %d: Calculate() error = %v", tt.sizes, tt.amount, tt.maxPacks, err)
+		}
+		if total != tt.wantTotal || !reflect.DeepEqual(packs, tt.wantPacks) {
+			t.Errorf("%v, %d within %d packs = %d %v, want %d %v", tt.sizes, tt.amount, tt.maxPacks, total, packs, tt.wantTotal, tt.wantPacks)
+		}
+	}
+
+	calc := NewCalculator([]int{250, 500, 1000})
+	calc.SetMaxPacks(2)
+	if _, _, err := calc.Calculate(3000); !errors.Is(err, ErrMaxPacksExceeded) {
+		t.Errorf("3000 within 2 packs of at most 1000: error = %v, want ErrMaxPacksExceeded", err)
+	}
+
+	moq := NewCalculatorWithStock([]int{250, 500}, map[int]int{250: 1})
+	moq.SetMaxPacks(2)
+	if _, _, err := moq.Calculate(500); err == nil {
+		t.Error("Max packs with stock limits: expected error")
+	}
+}
+
+func TestCalculator_MaxPacksMatchesBruteForce(t *testing.T) {
+	for _, sizes := range [][]int{{3, 5, 7}, {4, 6}, {6, 9, 20}, {23, 31, 53}} {
+		largest := sizes[len(sizes)-1]
+		for amount := 1; amount <= 300; amount++ {
+			minPacks := (amount + largest - 1) / largest
+			for maxPacks := minPacks; maxPacks <= minPacks+4; maxPacks++ {
+				calc := NewCalculator(sizes)
+				calc.SetMaxPacks(maxPacks)
+				packs, total, err := calc.Calculate(amount)
+				if err != nil {
+					t.Fatalf("%v, %d within %d: Calculate() error = %v", sizes, amount, maxPacks, err)
+				}
+				gotItems, gotPacks := 0, 0
+				for size, count := range packs {
+					gotItems += size * count
+					gotPacks += count
+				}
+
+				wantTotal, wantPacks := bruteForceMaxPacks(sizes, amount, maxPacks)
+				if total != wantTotal || gotItems != total || gotPacks != wantPacks {
+					t.Fatalf("%v, %d within %d: %d items in %d packs (%v), want %d in %d",
+						sizes, amount, maxPacks, total, gotPacks, packs, wantTotal, wantPacks)
+				}
+			}
+		}
+	}
+}
+
+// bruteForceMaxPacks finds the fewest items, then fewest packs, covering amount with at
+// most maxPacks packs by tracking pack count as an explicit DP dimension
+func bruteForceMaxPacks(sizes []int, amount, maxPacks int) (int, int) {
+	limit := amount + sizes[len(sizes)-1]
+	reachable := make([][]bool, maxPacks+1) // [packs][total]
+	for k := range reachable {
+		reachable[k] = make([]bool, limit+1)
+	}
+	reachable[0][0] = true
+	for k := 1; k <= maxPacks; k++ {
+		for total := 0; total <= limit; total++ {
+			for _, size := range sizes {
+				if total >= size && reachable[k-1][total-size] {
+					reachable[k][total] = true
+				}
+			}
+		}
+	}
+	for total := amount; total <= limit; total++ {
+		for k := 1; k <= maxPacks; k++ {
+			if reachable[k][total] {
+				return total, k
+			}
+		}
+	}
+	return -1, -1
+}
+
+func TestCalculator_MemoryBudget(t *testing.T) {
+	// 1000 in packs of 250 needs totals up to 1250: 1251 * 8 * 2 = 20016 bytes
+	calc := NewCalculator([]int{250})
+	calc.SetMemoryBudget(20016)
+	if _, total, err := calc.Calculate(1000); err != nil || total != 1000 {
+		t.Errorf("Within budget: total = %d, err = %v, want 1000", total, err)
+	}
+
+	calc.SetMemoryBudget(20015)
+	if _, _, err := calc.Calculate(1000); !errors.Is(err, ErrMemoryBudgetExceeded) {
+		t.Errorf("Over budget: err = %v, want ErrMemoryBudgetExceeded", err)
+	}
+
+	// Coprime large sizes defeat pre-assignment, so a huge amount needs the full table
+	calc = NewCalculator([]int{9967, 9973})
+	calc.SetMemoryBudget(1 << 20)
+	if _, _, err := calc.Calculate(10_000_000); !errors.Is(err, ErrMemoryBudgetExceeded) {
+		t.Errorf("Large custom sizes: err = %v, want ErrMemoryBudgetExceeded", err)
+	}
+
+	// Constrained variants are bounded too
+	stocked := NewCalculatorWithStock([]int{250, 500}, map[int]int{500: 1})
+	stocked.SetMemoryBudget(1024)
+	if _, _, err := stocked.Calculate(1000); !errors.Is(err, ErrMemoryBudgetExceeded) {
+		t.Errorf("Stock calculator over budget: err = %v, want ErrMemoryBudgetExceeded", err)
+	}
+
+	calc.SetMemoryBudget(0)
+	if _, _, err := calc.Calculate(100_000); err != nil {
+		t.Errorf("No budget: err = %v", err)
+	}
+}
+
+// BenchmarkCalculate_LargeAmount compares a 5,000,000 item calculation with the default
+// sizes against the full DP table it replaces; run with -benchmem to see the allocations
+func BenchmarkCalculate_LargeAmount(b *testing.B) {
+	calc := NewCalculator([]int{250, 500, 1000, 2000, 5000})
+	const amount = 5_000_000
+
+	b.Run("Preassigned", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			calc.Calculate(amount)
+		}
+	})
+	b.Run("FullDP", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			parent, total, _ := calc.solve(context.Background(), amount, nil)
+			backtrack(parent, total)
+		}
+	})
+}
+
+func TestCalculator_EstimateCost(t *testing.T) {
+	ctx := context.Background()
+	calculators := map[string]*Calculator{
+		"unconstrained": NewCalculator([]int{23, 31, 53}),
+		"moq":           NewCalculatorWithMOQ([]int{3, 5}, map[int]int{5: 2}),
+		"stock":         NewCalculatorWithStock([]int{250, 500}, map[int]int{500: 1}),
+	}
+	amounts := map[string]int{"unconstrained": 500000, "moq": 8, "stock": 1000}
+
+	// The estimate is the table the DP will cover times the sizes tried per total
+	for name, calc := range calculators {
+		_, _, _, stats, err := calc.CalculateWithStatsContext(ctx, amounts[name])
+		if err != nil {
+			t.Fatalf("%s: CalculateWithStatsContext() error = %v", name, err)
+		}
+		want := int64(stats.MaxTarget+1) * int64(len(calc.packSizes))
+		if got := calc.EstimateCost(amounts[name]); got != want {
+			t.Errorf("%s: EstimateCost() = %d, want %d", name, got, want)
+		}
+	}
+
+	// Pre-assignment keeps large amounts cheap; invalid amounts cost nothing
+	calc := NewCalculator([]int{23, 31, 53})
+	if small, large := calc.EstimateCost(500000), calc.EstimateCost(50000000); large > 2*small {
+		t.Errorf("EstimateCost(50000000) = %d, want about %d as for 500000", large, small)
+	}
+	if got := calc.EstimateCost(0); got != 0 {
+		t.Errorf("EstimateCost(0) = %d, want 0", got)
+	}
+}
+
+func TestCalculator_CheckWorstCaseBoundsEveryAmount(t *testing.T) {
+	for _, sizes := range [][]int{{250, 500, 1000}, {23, 31, 53}, {1, 997}, {7}} {
+		const maxAmount = 5000
+		worst := 0
+		for amount := 1; amount <= maxAmount; amount++ {
+			_, _, _, stats, err := NewCalculator(sizes).CalculateWithStatsContext(context.Background(), amount)
+			if err != nil {
+				t.Fatalf("%v, %d: error = %v", sizes, amount, err)
+			}
+			if stats.MaxTarget > worst {
+				worst = stats.MaxTarget
+			}
+		}
+
+		// A budget of exactly the largest table passes; one entry less fails
+		calc := NewCalculator(sizes)
+		calc.SetMemoryBudget(int64(worst+1) * 16)
+		if err := calc.CheckWorstCase(maxAmount); err != nil {
+			t.Errorf("%v: CheckWorstCase() at the observed worst table = %v, want nil", sizes, err)
+		}
+		calc.SetMemoryBudget(int64(worst) * 16)
+		if err := calc.CheckWorstCase(maxAmount); !errors.Is(err, ErrMemoryBudgetExceeded) {
+			t.Errorf("%v: CheckWorstCase() below the observed worst table = %v, want ErrMemoryBudgetExceeded", sizes, err)
+		}
+	}
+}
+
+func TestCalculator_CalculateRangeMatchesCalculate(t *testing.T) {
+	tests := []struct {
+		packSizes []int
+		lo, hi    int
+	}{
+		{[]int{250, 500, 1000, 2000, 5000}, 1, 1200},
+		{[]int{23, 31, 53}, 1, 600},
+		{[]int{23, 31, 53}, 500000, 500300}, // Pre-assigned largest packs
+		{[]int{7}, 95, 120},
+	}
+
+	for _, tt := range tests {
+		calc := NewCalculator(tt.packSizes)
+		results, err := calc.CalculateRange(context.Background(), tt.lo, tt.hi)
+		if err != nil {
+			t.Fatalf("%v: CalculateRange(%d, %d) error = %v", tt.packSizes, tt.lo, tt.hi, err)
+		}
+		if len(results) != tt.hi-tt.lo+1 {
+			t.Fatalf("%v: %d results, want %d", tt.packSizes, len(results), tt.hi-tt.lo+1)
+		}
+		for i, got := range results {
+			_, wantItems, wantPacks, err := calc.CalculateWithDetails(tt.lo + i)
+			if err != nil {
+				t.Fatalf("Calculate(%d) error = %v", tt.lo+i, err)
+			}
+			sum := 0
+			for size, count := range got.Packs {
+				sum += size * count
+			}
+			if got.Amount != tt.lo+i || got.TotalItems != wantItems || got.TotalPacks != wantPacks || sum != got.TotalItems {
+				t.Fatalf("%v: range result %+v, want amount %d with %d items in %d packs", tt.packSizes, got, tt.lo+i, wantItems, wantPacks)
+			}
+		}
+	}
+
+	if _, err := NewCalculator([]int{250}).CalculateRange(context.Background(), 10, 5); err == nil {
+		t.Error("CalculateRange with hi < lo: expected error")
+	}
+}
@@ -0,0 +1,64 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+)
+
+// CalculateGreedy is a fast approximation of Calculate for latency-critical callers.
+// It fills the amount with the largest size, then packs what is left with the exact DP,
+// also trying one fewer large pack as a correction. The DP only spans a few multiples
+// of the largest size, so the cost does not grow with the amount. The total always
+// meets the amount and exceeds the optimum by less than the largest pack size.
+func (c *Calculator) CalculateGreedy(amount int) (map[int]int, int, error) {
+	return c.CalculateGreedyContext(context.Background(), amount)
+}
+
+// CalculateGreedyContext is CalculateGreedy with cancellation
+func (c *Calculator) CalculateGreedyContext(ctx context.Context, amount int) (map[int]int, int, error) {
+	if c.moq != nil || c.stock != nil {
+		return nil, 0, errors.New("greedy calculation is not supported with MOQ or stock constraints")
+	}
+	if err := ValidateAmount(amount); err != nil {
+		return nil, 0, err
+	}
+	if len(c.packSizes) == 0 {
+		return nil, 0, errors.New("no pack sizes available")
+	}
+
+	largest := c.packSizes[len(c.packSizes)-1]
+	full := amount / largest
+
+	var bestPacks map[int]int
+	bestTotal, bestCount := 0, 0
+	for _, large := range []int{full, full - 1} {
+		if large < 0 {
+			continue
+		}
+
+		packs := make(map[int]int)
+		total := large * largest
+		if rest := amount - total; rest > 0 {
+			parent, restTotal, err := c.solve(ctx, rest, nil)
+			if err != nil {
+				return nil, 0, err
+			}
+			packs = backtrack(parent, restTotal)
+			total += restTotal
+		}
+		packs[largest] += large
+		if packs[largest] == 0 {
+			delete(packs, largest)
+		}
+
+		count := 0
+		for _, n := range packs {
+			count += n
+		}
+		if bestPacks == nil || total < bestTotal || (total == bestTotal && count < bestCount) {
+			bestPacks, bestTotal, bestCount = packs, total, count
+		}
+	}
+
+	return bestPacks, bestTotal, nil
+}
@@ -0,0 +1,59 @@
+package calculator
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCalculator_GreedyWithinBoundOfOptimal(t *testing.T) {
+	sizeSets := [][]int{
+		{250, 500, 1000, 2000, 5000},
+		{23, 31, 53},
+		{7, 12, 40},
+	}
+	amounts := []int{1, 12, 251, 501, 1001, 12001, 263, 500000, 99991}
+
+	for _, sizes := range sizeSets {
+		calc := NewCalculator(sizes)
+		largest := sizes[len(sizes)-1]
+		for _, amount := range amounts {
+			_, optimal, err := calc.Calculate(amount)
+			if err != nil {
+				t.Fatalf("Calculate(%d) error = %v", amount, err)
+			}
+			packs, total, err := calc.CalculateGreedy(amount)
+			if err != nil {
+				t.Fatalf("CalculateGreedy(%d) error = %v", amount, err)
+			}
+
+			sum := 0
+			for size, count := range packs {
+				sum += size * count
+			}
+			if sum != total || total < amount {
+				t.Errorf("%v: CalculateGreedy(%d) = %v totalling %d (reported %d), must meet the amount", sizes, amount, packs, sum, total)
+			}
+			if total >= optimal+largest {
+				t.Errorf("%v: CalculateGreedy(%d) total %d not within one largest pack of optimal %d", sizes, amount, total, optimal)
+			}
+		}
+	}
+}
+
+func TestCalculator_GreedyHugeAmount(t *testing.T) {
+	calc := NewCalculator([]int{23, 31, 53})
+	const amount = min(5_000_000_000, math.MaxInt/2) // Fits int on 32-bit builds
+
+	start := time.Now()
+	packs, total, err := calc.CalculateGreedy(amount)
+	if err != nil {
+		t.Fatalf("CalculateGreedy() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("CalculateGreedy took %s, want it independent of the amount", elapsed)
+	}
+	if total < amount || total >= amount+53 || packs[53] == 0 {
+		t.Errorf("CalculateGreedy() = %v/%d, want mostly 53s just above the amount", packs, total)
+	}
+}
@@ -0,0 +1,60 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+)
+
+// CalculateWithLoose meets the amount with whole packs plus up to looseMax loose items,
+// minimizing total items sent (packed plus loose). Among equal totals it prefers fewer
+// loose items. With looseMax 0 it matches Calculate.
+func (c *Calculator) CalculateWithLoose(amount, looseMax int) (map[int]int, int, int, error) {
+	return c.CalculateWithLooseContext(context.Background(), amount, looseMax)
+}
+
+// CalculateWithLooseContext is CalculateWithLoose with cancellation. It returns the
+// packs, the loose item count and the total items.
+func (c *Calculator) CalculateWithLooseContext(ctx context.Context, amount, looseMax int) (map[int]int, int, int, error) {
+	if c.moq != nil || c.stock != nil {
+		return nil, 0, 0, errors.New("loose items are not supported with MOQ or stock constraints")
+	}
+	if looseMax < 0 {
+		return nil, 0, 0, errors.New("loose maximum cannot be negative")
+	}
+
+	parent, _, err := c.solve(ctx, amount, nil)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// A packed total T serves the amount with max(0, amount-T) loose items, so only
+	// totals from amount-looseMax upward qualify; zero packs is reachable by definition
+	start := amount - looseMax
+	if start < 0 {
+		start = 0
+	}
+	bestPacked, bestLoose := -1, 0
+	for packed := start; packed < len(parent); packed++ {
+		if packed > 0 && parent[packed] == 0 {
+			continue // Not reachable with whole packs
+		}
+		loose := amount - packed
+		if loose < 0 {
+			loose = 0
+		}
+		if bestPacked == -1 {
+			bestPacked, bestLoose = packed, loose
+			continue
+		}
+		// Below the amount every candidate ships exactly amount items, so a larger packed
+		// total only trades loose items for packs; at or above it, the first one wins
+		if packed+loose > bestPacked+bestLoose {
+			break
+		}
+		if loose < bestLoose {
+			bestPacked, bestLoose = packed, loose
+		}
+	}
+
+	return backtrack(parent, bestPacked), bestLoose, bestPacked + bestLoose, nil
+}
@@ -0,0 +1,79 @@
+package calculator
+
+import "testing"
+
+func TestCalculator_LooseReducesOvershoot(t *testing.T) {
+	calc := NewCalculator([]int{250, 500, 1000, 2000, 5000})
+
+	tests := []struct {
+		amount, looseMax   int
+		wantPacks          map[int]int
+		wantLoose, wantAll int
+	}{
+		// Without loose items 251 needs a 500 pack; one loose item avoids 249 spare
+		{251, 0, map[int]int{500: 1}, 0, 500},
+		{251, 1, map[int]int{250: 1}, 1, 251},
+		{251, 10, map[int]int{250: 1}, 1, 251},
+		{12001, 0, map[int]int{5000: 2, 2000: 1, 250: 1}, 0, 12250},
+		{12001, 5, map[int]int{5000: 2, 2000: 1}, 1, 12001},
+		// Exact amounts never need loose items
+		{750, 100, map[int]int{500: 1, 250: 1}, 0, 750},
+		// Small amounts can ship entirely loose
+		{3, 5, map[int]int{}, 3, 3},
+	}
+
+	for _, tt := range tests {
+		packs, loose, total, err := calc.CalculateWithLoose(tt.amount, tt.looseMax)
+		if err != nil {
+			t.Fatalf("CalculateWithLoose(%d, %d) error = %v", tt.amount, tt.looseMax, err)
+		}
+		if loose != tt.wantLoose || total != tt.wantAll || len(packs) != len(tt.wantPacks) {
+			t.Errorf("CalculateWithLoose(%d, %d) = %v + %d loose (%d total), want %v + %d loose (%d total)",
+				tt.amount, tt.looseMax, packs, loose, total, tt.wantPacks, tt.wantLoose, tt.wantAll)
+			continue
+		}
+		for size, count := range tt.wantPacks {
+			if packs[size] != count {
+				t.Errorf("CalculateWithLoose(%d, %d) packs = %v, want %v", tt.amount, tt.looseMax, packs, tt.wantPacks)
+				break
+			}
+		}
+	}
+}
+
+func TestCalculator_LooseNeverWorseThanWhole(t *testing.T) {
+	calc := NewCalculator([]int{23, 31, 53})
+	for amount := 1; amount <= 500; amount++ {
+		_, whole, err := calc.Calculate(amount)
+		if err != nil {
+			t.Fatalf("Calculate(%d) error = %v", amount, err)
+		}
+		for _, looseMax := range []int{0, 3, 10} {
+			packs, loose, total, err := calc.CalculateWithLoose(amount, looseMax)
+			if err != nil {
+				t.Fatalf("CalculateWithLoose(%d, %d) error = %v", amount, looseMax, err)
+			}
+			packed := 0
+			for size, count := range packs {
+				packed += size * count
+			}
+			if loose > looseMax || packed+loose != total || total < amount || total > whole {
+				t.Errorf("CalculateWithLoose(%d, %d) = %v + %d loose (%d total); whole-pack total %d",
+					amount, looseMax, packs, loose, total, whole)
+			}
+			if looseMax == 0 && total != whole {
+				t.Errorf("CalculateWithLoose(%d, 0) total %d, want %d", amount, total, whole)
+			}
+		}
+	}
+}
+
+func TestCalculator_LooseRejectsConstraints(t *testing.T) {
+	if _, _, _, err := NewCalculator([]int{250}).CalculateWithLoose(100, -1); err == nil {
+		t.Error("expected error for negative loose maximum")
+	}
+	calc := NewCalculatorWithStock([]int{250, 500}, map[int]int{500: 1})
+	if _, _, _, err := calc.CalculateWithLoose(100, 10); err == nil {
+		t.Error("expected error for stock-constrained calculator")
+	}
+}
@@ -0,0 +1,125 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// NewCalculatorWithMOQ creates a calculator where each pack size in moq, if used at all,
+// must be used at least that many times. Sizes without an entry (or with MOQ <= 1) are unconstrained.
+func NewCalculatorWithMOQ(packSizes []int, moq map[int]int) *Calculator {
+	c := NewCalculator(packSizes)
+	for _, size := range c.packSizes {
+		if m := moq[size]; m > 1 {
+			if c.moq == nil {
+				c.moq = make(map[int]int)
+			}
+			c.moq[size] = m
+		}
+	}
+	return c
+}
+
+// minQuantity returns the smallest non-zero count allowed for a pack size
+func (c *Calculator) minQuantity(size int) int {
+	if m := c.moq[size]; m > 1 {
+		return m
+	}
+	return 1
+}
+
+// calculateMOQ solves the "0 or >= MOQ" variant with a per-size layered DP.
+// Each layer adds one pack size: a state either skips the size or takes at least
+// its MOQ, reached by shifting the previous layer by MOQ packs and then extending
+// one pack at a time. Sizes are layered largest first and ties keep the earlier
+// layer, so equal solutions favour larger packs as in Calculate.
+func (c *Calculator) calculateMOQ(ctx context.Context, amount int, stats *DPStats) (map[int]int, int, error) {
+	if err := ValidateAmount(amount); err != nil {
+		return nil, 0, err
+	}
+	if len(c.packSizes) == 0 {
+		return nil, 0, errors.New("no pack sizes available")
+	}
+
+	// Using only the largest size, max(MOQ, ceil(amount/size)) packs always covers
+	// the amount within MOQ*size of it, so the optimum lies below this bound
+	largest := c.packSizes[len(c.packSizes)-1]
+	maxTarget := amount + c.minQuantity(largest)*largest
+	if err := c.checkMemoryBudget(maxTarget); err != nil {
+		return nil, 0, err
+	}
+
+	sizes := make([]int, len(c.packSizes))
+	copy(sizes, c.packSizes)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	// dp[i] is the minimum number of packs totalling exactly i using the layers so far;
+	// counts[layer][i] records how many packs of that layer's size state i used
+	dp := make([]int, maxTarget+1)
+	for i := range dp {
+		dp[i] = math.MaxInt32
+	}
+	dp[0] = 0
+	counts := make([][]int, len(sizes))
+
+	with := make([]int, maxTarget+1)
+	withCount := make([]int, maxTarget+1)
+	for layer, size := range sizes {
+		m := c.minQuantity(size)
+		for i := range with {
+			if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+				return nil, 0, ctx.Err()
+			}
+			with[i] = math.MaxInt32
+			withCount[i] = 0
+			// Take exactly the MOQ on top of a previous-layer state
+			if prev := i - m*size; prev >= 0 && dp[prev] != math.MaxInt32 {
+				with[i] = dp[prev] + m
+				withCount[i] = m
+			}
+			// Or extend a state that already meets the MOQ by one more pack
+			if prev := i - size; prev >= 0 && with[prev] != math.MaxInt32 && with[prev]+1 < with[i] {
+				with[i] = with[prev] + 1
+				withCount[i] = withCount[prev] + 1
+			}
+		}
+
+		counts[layer] = make([]int, maxTarget+1)
+		for i := range dp {
+			if with[i] < dp[i] {
+				dp[i] = with[i]
+				counts[layer][i] = withCount[i]
+			}
+		}
+	}
+
+	if stats != nil {
+		stats.MaxTarget = maxTarget
+		stats.Iterations = int64(len(sizes)) * int64(maxTarget+1)
+	}
+
+	bestTotal := -1
+	for i := amount; i <= maxTarget; i++ {
+		if dp[i] != math.MaxInt32 {
+			bestTotal = i
+			break
+		}
+	}
+	if bestTotal == -1 {
+		return nil, 0, errors.New("no valid pack combination found")
+	}
+
+	// Walk the layers back from the last, peeling off each size's count
+	packs := make(map[int]int)
+	current := bestTotal
+	for layer := len(sizes) - 1; layer >= 0; layer-- {
+		if n := counts[layer][current]; n > 0 {
+			packs[sizes[layer]] = n
+			current -= n * sizes[layer]
+		}
+	}
+
+	return packs, bestTotal, nil
+}
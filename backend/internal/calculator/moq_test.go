@@ -0,0 +1,99 @@
+package calculator
+
+import "testing"
+
+func TestCalculator_MOQChangesOptimum(t *testing.T) {
+	sizes := []int{3, 5}
+	moq := map[int]int{5: 2}
+
+	tests := []struct {
+		amount             int
+		unconstrained      map[int]int
+		withMOQ            map[int]int
+		withMOQTotal       int
+		unconstrainedTotal int
+	}{
+		// A single 5 is no longer allowed: fall back to two 3s
+		{5, map[int]int{5: 1}, map[int]int{3: 2}, 6, 5},
+		// 3+5 needs one 5; three 3s is the closest valid total
+		{8, map[int]int{3: 1, 5: 1}, map[int]int{3: 3}, 9, 8},
+		// Two 5s satisfy the MOQ, so nothing changes
+		{10, map[int]int{5: 2}, map[int]int{5: 2}, 10, 10},
+	}
+
+	for _, tt := range tests {
+		packs, total, err := NewCalculator(sizes).Calculate(tt.amount)
+		if err != nil || total != tt.unconstrainedTotal || !mapsEqual(packs, tt.unconstrained) {
+			t.Errorf("Unconstrained(%d) = %v/%d (%v), want %v/%d", tt.amount, packs, total, err, tt.unconstrained, tt.unconstrainedTotal)
+		}
+
+		packs, total, err = NewCalculatorWithMOQ(sizes, moq).Calculate(tt.amount)
+		if err != nil || total != tt.withMOQTotal || !mapsEqual(packs, tt.withMOQ) {
+			t.Errorf("WithMOQ(%d) = %v/%d (%v), want %v/%d", tt.amount, packs, total, err, tt.withMOQ, tt.withMOQTotal)
+		}
+	}
+}
+
+func TestCalculator_MOQMatchesBruteForce(t *testing.T) {
+	sizes := []int{4, 7, 10}
+	moq := map[int]int{7: 2, 10: 3}
+	calc := NewCalculatorWithMOQ(sizes, moq)
+
+	for amount := 1; amount <= 80; amount++ {
+		packs, total, err := calc.Calculate(amount)
+		if err != nil {
+			t.Fatalf("Calculate(%d) error = %v", amount, err)
+		}
+
+		sum, count := 0, 0
+		for size, n := range packs {
+			if m := moq[size]; n > 0 && n < m {
+				t.Errorf("Calculate(%d) uses %d of size %d, below MOQ %d", amount, n, size, m)
+			}
+			sum += size * n
+			count += n
+		}
+		if sum != total {
+			t.Errorf("Calculate(%d) packs sum to %d, reported %d", amount, sum, total)
+		}
+
+		wantTotal, wantCount := bruteForceMOQ(sizes, moq, amount)
+		if total != wantTotal || count != wantCount {
+			t.Errorf("Calculate(%d) = %d items in %d packs, want %d in %d", amount, total, count, wantTotal, wantCount)
+		}
+	}
+}
+
+// bruteForceMOQ enumerates counts up to a generous bound and returns the best total and pack count
+func bruteForceMOQ(sizes []int, moq map[int]int, amount int) (int, int) {
+	bestTotal, bestCount := -1, 0
+	var search func(idx, total, count int)
+	search = func(idx, total, count int) {
+		if idx == len(sizes) {
+			if total >= amount && (bestTotal == -1 || total < bestTotal || (total == bestTotal && count < bestCount)) {
+				bestTotal, bestCount = total, count
+			}
+			return
+		}
+		size := sizes[idx]
+		for n := 0; n*size <= amount+size*moq[size]+size; n++ {
+			if n > 0 && n < moq[size] {
+				continue
+			}
+			search(idx+1, total+n*size, count+n)
+		}
+	}
+	search(0, 0, 0)
+	return bestTotal, bestCount
+}
+
+func TestCalculator_MOQSteps(t *testing.T) {
+	steps, total, err := NewCalculatorWithMOQ([]int{3, 5}, map[int]int{5: 2}).CalculateSteps(13)
+	if err != nil {
+		t.Fatalf("CalculateSteps() error = %v", err)
+	}
+	// 13 = 5+5+3
+	if total != 13 || !slicesEqual(steps, []int{5, 5, 3}) {
+		t.Errorf("CalculateSteps(13) = %v/%d, want [5 5 3]/13", steps, total)
+	}
+}
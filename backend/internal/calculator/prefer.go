@@ -0,0 +1,101 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrPreferenceUnsupported is returned by CalculatePreferring on calculators with MOQ or stock constraints
+var ErrPreferenceUnsupported = errors.New("preferred size is not supported with MOQ or stock constraints")
+
+// SetPreferenceTolerance sets how many items above the optimum CalculatePreferring may
+// send to use more of the preferred size. Zero (the default) only chooses among
+// solutions with the optimal item count; negative values are treated as zero.
+func (c *Calculator) SetPreferenceTolerance(items int) {
+	if items < 0 {
+		items = 0
+	}
+	c.preferTolerance = items
+}
+
+// CalculatePreferring returns packs that use as many preferredSize packs as possible
+// among solutions sending at most the preference tolerance more items than Calculate.
+// Ties are broken by fewer items, then fewer packs, then larger packs as in Calculate.
+func (c *Calculator) CalculatePreferring(amount, preferredSize int) (map[int]int, int, error) {
+	return c.CalculatePreferringContext(context.Background(), amount, preferredSize)
+}
+
+// CalculatePreferringContext is CalculatePreferring with cancellation
+func (c *Calculator) CalculatePreferringContext(ctx context.Context, amount, preferredSize int) (map[int]int, int, error) {
+	if c.moq != nil || c.stock != nil {
+		return nil, 0, ErrPreferenceUnsupported
+	}
+	var others []int
+	for _, size := range c.packSizes {
+		if size != preferredSize {
+			others = append(others, size)
+		}
+	}
+	if len(others) == len(c.packSizes) {
+		return nil, 0, fmt.Errorf("preferred size %d is not one of the pack sizes", preferredSize)
+	}
+
+	_, optimum, err := c.solve(ctx, amount, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	maxTotal := optimum + c.preferTolerance
+
+	// dp[i] is the minimum number of non-preferred packs summing to exactly i
+	dp := make([]int, maxTotal+1)
+	parent := make([]int, maxTotal+1)
+	for i := 1; i <= maxTotal; i++ {
+		dp[i] = math.MaxInt32
+	}
+	for i := 0; i <= maxTotal; i++ {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+		if dp[i] == math.MaxInt32 {
+			continue
+		}
+		for _, packSize := range others {
+			next := i + packSize
+			if next > maxTotal {
+				break // others is sorted ascending
+			}
+			if dp[next] > dp[i]+1 || (dp[next] == dp[i]+1 && packSize > parent[next]) {
+				dp[next] = dp[i] + 1
+				parent[next] = packSize
+			}
+		}
+	}
+
+	// For each allowed total, the most preferred packs is the largest k whose
+	// remainder the other sizes can fill; keep the best across totals
+	bestPreferred, bestTotal := -1, 0
+	for total := amount; total <= maxTotal; total++ {
+		for k := total / preferredSize; k >= 0; k-- {
+			rest := total - k*preferredSize
+			if dp[rest] == math.MaxInt32 {
+				continue
+			}
+			if k > bestPreferred { // Totals ascend, so equal counts keep the fewer items
+				bestPreferred, bestTotal = k, total
+			}
+			break
+		}
+	}
+
+	packs := make(map[int]int)
+	if bestPreferred > 0 {
+		packs[preferredSize] = bestPreferred
+	}
+	for current := bestTotal - bestPreferred*preferredSize; current > 0; current -= parent[current] {
+		packs[parent[current]]++
+	}
+
+	return packs, bestTotal, nil
+}
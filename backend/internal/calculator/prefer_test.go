@@ -0,0 +1,101 @@
+package calculator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculator_PreferringShiftsTowardPreferredSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		sizes     []int
+		amount    int
+		preferred int
+		tolerance int
+		want      map[int]int
+		wantTotal int
+	}{
+		// Same item count, more packs of the preferred size
+		{"equal items", []int{250, 500, 1000, 2000, 5000}, 4000, 1000, 0, map[int]int{1000: 4}, 4000},
+		{"already preferred", []int{250, 500, 1000, 2000, 5000}, 4000, 2000, 0, map[int]int{2000: 2}, 4000},
+		// A single 5 is optimal; no 3s fit without overshooting
+		{"no tolerance", []int{3, 5}, 5, 3, 0, map[int]int{5: 1}, 5},
+		{"one item of slack", []int{3, 5}, 5, 3, 1, map[int]int{3: 2}, 6},
+		{"wide slack", []int{3, 5}, 5, 3, 4, map[int]int{3: 3}, 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calc := NewCalculator(tt.sizes)
+			calc.SetPreferenceTolerance(tt.tolerance)
+
+			_, optimum, err := calc.Calculate(tt.amount)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			packs, total, err := calc.CalculatePreferring(tt.amount, tt.preferred)
+			if err != nil {
+				t.Fatalf("CalculatePreferring() error = %v", err)
+			}
+			if total != tt.wantTotal || !mapsEqual(packs, tt.want) {
+				t.Errorf("CalculatePreferring(%d, %d) = %v/%d, want %v/%d", tt.amount, tt.preferred, packs, total, tt.want, tt.wantTotal)
+			}
+			if total > optimum+tt.tolerance {
+				t.Errorf("Total %d exceeds optimum %d plus tolerance %d", total, optimum, tt.tolerance)
+			}
+		})
+	}
+}
+
+func TestCalculator_PreferringMatchesBruteForce(t *testing.T) {
+	sizes := []int{4, 7, 9}
+	const preferred, tolerance = 4, 3
+
+	calc := NewCalculator(sizes)
+	calc.SetPreferenceTolerance(tolerance)
+
+	for amount := 1; amount <= 60; amount++ {
+		_, optimum, _ := calc.Calculate(amount)
+
+		// Most 4s over all combinations within the tolerance, then fewest items
+		bestFours, bestTotal := -1, 0
+		for a := 0; a*4 <= optimum+tolerance; a++ {
+			for b := 0; a*4+b*7 <= optimum+tolerance; b++ {
+				for c := 0; a*4+b*7+c*9 <= optimum+tolerance; c++ {
+					total := a*4 + b*7 + c*9
+					if total < amount {
+						continue
+					}
+					if a > bestFours || (a == bestFours && total < bestTotal) {
+						bestFours, bestTotal = a, total
+					}
+				}
+			}
+		}
+
+		packs, total, err := calc.CalculatePreferring(amount, preferred)
+		if err != nil {
+			t.Fatalf("CalculatePreferring(%d) error = %v", amount, err)
+		}
+		if packs[preferred] != bestFours || total != bestTotal {
+			t.Errorf("CalculatePreferring(%d) = %v/%d, want %d fours and total %d", amount, packs, total, bestFours, bestTotal)
+		}
+		sum := 0
+		for size, count := range packs {
+			sum += size * count
+		}
+		if sum != total {
+			t.Errorf("CalculatePreferring(%d) packs %v sum to %d, want %d", amount, packs, sum, total)
+		}
+	}
+}
+
+func TestCalculator_PreferringErrors(t *testing.T) {
+	if _, _, err := NewCalculator([]int{3, 5}).CalculatePreferring(10, 4); err == nil {
+		t.Error("Expected error for a preferred size outside the pack set")
+	}
+	stocked := NewCalculatorWithStock([]int{3, 5}, map[int]int{5: 1})
+	if _, _, err := stocked.CalculatePreferring(10, 3); !errors.Is(err, ErrPreferenceUnsupported) {
+		t.Errorf("Error = %v, want ErrPreferenceUnsupported", err)
+	}
+}
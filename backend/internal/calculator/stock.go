@@ -0,0 +1,142 @@
+package calculator
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+)
+
+// ErrInsufficientStock is returned when the available stock cannot cover the amount
+var ErrInsufficientStock = errors.New("insufficient stock to fulfil amount")
+
+// NewCalculatorWithStock creates a calculator that uses at most stock[size] packs of each
+// size. Sizes without an entry are unlimited; negative stock is treated as zero.
+func NewCalculatorWithStock(packSizes []int, stock map[int]int) *Calculator {
+	c := NewCalculator(packSizes)
+	c.stock = make(map[int]int)
+	for _, size := range c.packSizes {
+		if available, tracked := stock[size]; tracked {
+			if available < 0 {
+				available = 0
+			}
+			c.stock[size] = available
+		}
+	}
+	return c
+}
+
+// CalculateWithInventory is Calculate using at most available[size] packs of each size,
+// as NewCalculatorWithStock. Sizes without an entry are unlimited, so a nil map gives the
+// same result as Calculate. Returns ErrInsufficientStock if the inventory cannot cover
+// the amount.
+func (c *Calculator) CalculateWithInventory(amount int, available map[int]int) (map[int]int, int, error) {
+	if available == nil {
+		return c.Calculate(amount)
+	}
+	bounded := NewCalculatorWithStock(c.packSizes, available)
+	bounded.memoryBudget = c.memoryBudget
+	return bounded.Calculate(amount)
+}
+
+// calculateBounded solves the bounded variant where each size has a maximum count.
+// Like calculateMOQ it adds one size per layer, largest first. Within a layer the best
+// count for each total is a sliding-window minimum over totals with the same remainder
+// modulo the size, kept in a monotonic deque, so each layer costs O(maxTarget).
+func (c *Calculator) calculateBounded(ctx context.Context, amount int, stats *DPStats) (map[int]int, int, error) {
+	if err := ValidateAmount(amount); err != nil {
+		return nil, 0, err
+	}
+	if len(c.packSizes) == 0 {
+		return nil, 0, errors.New("no pack sizes available")
+	}
+
+	// An optimal total never exceeds amount by a full pack: removing any pack from
+	// such a solution would still cover the amount with fewer items
+	largest := c.packSizes[len(c.packSizes)-1]
+	maxTarget := amount + largest
+	if err := c.checkMemoryBudget(maxTarget); err != nil {
+		return nil, 0, err
+	}
+
+	sizes := make([]int, len(c.packSizes))
+	copy(sizes, c.packSizes)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	dp := make([]int, maxTarget+1)
+	for i := range dp {
+		dp[i] = math.MaxInt32
+	}
+	dp[0] = 0
+	// counts[layer][i] is how many packs of that layer's size state i used
+	counts := make([][]int32, len(sizes))
+
+	next := make([]int, maxTarget+1)
+	deque := make([]int, 0, maxTarget/sizes[len(sizes)-1]+1)
+	for layer, size := range sizes {
+		if ctx.Err() != nil {
+			return nil, 0, ctx.Err()
+		}
+
+		limit, tracked := c.stock[size]
+		if !tracked {
+			limit = maxTarget / size
+		}
+
+		counts[layer] = make([]int32, maxTarget+1)
+		for r := 0; r < size && r <= maxTarget; r++ {
+			// Over totals r, r+size, r+2*size, ... with step index t, taking k packs
+			// moves from step t-k; minimising dp[t-k]+k equals minimising dp[t']-t' + t
+			value := func(t int) int { return dp[r+t*size] - t }
+			deque = deque[:0]
+			head := 0
+			for t, i := 0, r; i <= maxTarget; t, i = t+1, i+size {
+				if dp[i] != math.MaxInt32 {
+					// Prefer the newest index on ties, i.e. fewer packs of this size
+					for len(deque) > head && value(deque[len(deque)-1]) >= value(t) {
+						deque = deque[:len(deque)-1]
+					}
+					deque = append(deque, t)
+				}
+				for len(deque) > head && deque[head] < t-limit {
+					head++
+				}
+
+				next[i] = math.MaxInt32
+				if len(deque) > head {
+					from := deque[head]
+					next[i] = value(from) + t
+					counts[layer][i] = int32(t - from)
+				}
+			}
+		}
+		dp, next = next, dp
+	}
+
+	if stats != nil {
+		stats.MaxTarget = maxTarget
+		stats.Iterations = int64(len(sizes)) * int64(maxTarget+1)
+	}
+
+	bestTotal := -1
+	for i := amount; i <= maxTarget; i++ {
+		if dp[i] != math.MaxInt32 {
+			bestTotal = i
+			break
+		}
+	}
+	if bestTotal == -1 {
+		return nil, 0, ErrInsufficientStock
+	}
+
+	packs := make(map[int]int)
+	current := bestTotal
+	for layer := len(sizes) - 1; layer >= 0; layer-- {
+		if n := int(counts[layer][current]); n > 0 {
+			packs[sizes[layer]] = n
+			current -= n * sizes[layer]
+		}
+	}
+
+	return packs, bestTotal, nil
+}
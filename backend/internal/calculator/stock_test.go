@@ -0,0 +1,122 @@
+package calculator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculator_StockLimits(t *testing.T) {
+	sizes := []int{250, 500, 1000, 2000, 5000}
+
+	tests := []struct {
+		name      string
+		stock     map[int]int
+		amount    int
+		wantPacks map[int]int
+		wantTotal int
+	}{
+		{"unbounded matches Calculate", map[int]int{}, 12001, map[int]int{5000: 2, 2000: 1, 250: 1}, 12250},
+		{"only one 5000 left", map[int]int{5000: 1}, 12001, map[int]int{5000: 1, 2000: 3, 1000: 1, 250: 1}, 12250},
+		{"no 250s overshoots with 500", map[int]int{250: 0}, 251, map[int]int{500: 1}, 500},
+		{"limited 250s", map[int]int{250: 1, 500: 0}, 501, map[int]int{1000: 1}, 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packs, total, err := NewCalculatorWithStock(sizes, tt.stock).Calculate(tt.amount)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			if total != tt.wantTotal || !mapsEqual(packs, tt.wantPacks) {
+				t.Errorf("Calculate(%d) = %v/%d, want %v/%d", tt.amount, packs, total, tt.wantPacks, tt.wantTotal)
+			}
+			for size, n := range packs {
+				if limit, ok := tt.stock[size]; ok && n > limit {
+					t.Errorf("Used %d of size %d, stock is %d", n, size, limit)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculator_StockMatchesBruteForce(t *testing.T) {
+	sizes := []int{3, 7, 11}
+	stock := map[int]int{3: 2, 11: 1} // 7 is unlimited
+	calc := NewCalculatorWithStock(sizes, stock)
+
+	for amount := 1; amount <= 60; amount++ {
+		packs, total, err := calc.Calculate(amount)
+		if err != nil {
+			t.Fatalf("Calculate(%d) error = %v", amount, err)
+		}
+		count := 0
+		for _, n := range packs {
+			count += n
+		}
+
+		bestTotal, bestCount := -1, 0
+		for a := 0; a <= stock[3]; a++ {
+			for c := 0; c <= stock[11]; c++ {
+				for b := 0; b*7 <= amount+11; b++ {
+					tot, cnt := a*3+b*7+c*11, a+b+c
+					if tot >= amount && (bestTotal == -1 || tot < bestTotal || (tot == bestTotal && cnt < bestCount)) {
+						bestTotal, bestCount = tot, cnt
+					}
+				}
+			}
+		}
+		if total != bestTotal || count != bestCount {
+			t.Errorf("Calculate(%d) = %d items in %d packs (%v), want %d in %d", amount, total, count, packs, bestTotal, bestCount)
+		}
+	}
+}
+
+func TestCalculator_InsufficientStock(t *testing.T) {
+	calc := NewCalculatorWithStock([]int{250, 500}, map[int]int{250: 1, 500: 1})
+	if _, _, err := calc.Calculate(751); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("Calculate(751) error = %v, want ErrInsufficientStock", err)
+	}
+	if _, total, err := calc.Calculate(750); err != nil || total != 750 {
+		t.Errorf("Calculate(750) = %d, %v; want 750", total, err)
+	}
+}
+
+func TestCalculator_CalculateWithInventory(t *testing.T) {
+	calc := NewCalculator([]int{250, 500, 1000, 2000, 5000})
+
+	// Scarce large packs push the rest of the amount onto smaller sizes
+	packs, total, err := calc.CalculateWithInventory(12001, map[int]int{5000: 1, 2000: 1})
+	if err != nil {
+		t.Fatalf("CalculateWithInventory() error = %v", err)
+	}
+	if want := map[int]int{5000: 1, 2000: 1, 1000: 5, 250: 1}; total != 12250 || !mapsEqual(packs, want) {
+		t.Errorf("CalculateWithInventory(12001) = %v/%d, want %v/12250", packs, total, want)
+	}
+
+	// Out of the largest size entirely
+	packs, total, err = calc.CalculateWithInventory(5000, map[int]int{5000: 0})
+	if err != nil {
+		t.Fatalf("CalculateWithInventory() error = %v", err)
+	}
+	if want := map[int]int{2000: 2, 1000: 1}; total != 5000 || !mapsEqual(packs, want) {
+		t.Errorf("CalculateWithInventory(5000) = %v/%d, want %v/5000", packs, total, want)
+	}
+
+	// Every size capped and too few packs in total
+	limited := map[int]int{250: 1, 500: 1, 1000: 0, 2000: 0, 5000: 0}
+	if _, _, err := calc.CalculateWithInventory(751, limited); !errors.Is(err, ErrInsufficientStock) {
+		t.Errorf("CalculateWithInventory(751) error = %v, want ErrInsufficientStock", err)
+	}
+
+	// Unlimited inventory is Calculate
+	for _, amount := range []int{1, 251, 501, 12001, 500000} {
+		wantPacks, wantTotal, _ := calc.Calculate(amount)
+		packs, total, err := calc.CalculateWithInventory(amount, nil)
+		if err != nil || total != wantTotal || !mapsEqual(packs, wantPacks) {
+			t.Errorf("CalculateWithInventory(%d, nil) = %v/%d, %v; want %v/%d", amount, packs, total, err, wantPacks, wantTotal)
+		}
+		if _, total, err := calc.CalculateWithInventory(amount, map[int]int{}); err != nil || total != wantTotal {
+			t.Errorf("CalculateWithInventory(%d, {}) total = %d, %v; want %d", amount, total, err, wantTotal)
+		}
+	}
+}
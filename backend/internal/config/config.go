@@ -0,0 +1,487 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redacted replaces secret values in Sanitized output
+const Redacted = "[REDACTED]"
+
+// Duration is a time.Duration that marshals as a human-readable string (e.g. "1h0m0s")
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(time.Duration(d).String())), nil
+}
+
+// Config is the resolved server configuration, loaded once at startup from the environment
+type Config struct {
+	Port      string          `json:"port"`
+	Database  DatabaseConfig  `json:"database"`
+	Server    ServerConfig    `json:"server"`
+	Cache     CacheConfig     `json:"cache"`
+	RateLimit RateLimitConfig `json:"rate_limit"`
+	Pool      PoolConfig      `json:"calculation_pool"`
+	Calc      CalcConfig      `json:"calculation"`
+	Stats     StatsConfig     `json:"stats"`
+	Orders    OrdersConfig    `json:"orders"`
+
+	CustomSizes CustomSizesConfig `json:"custom_sizes"`
+
+	APIKey               string         `json:"api_key"` // Comma-separated; empty disables auth
+	TenantAPIKeys        []TenantAPIKey `json:"tenant_api_keys,omitempty"`
+	AllowQueryAPIKey     bool           `json:"allow_query_api_key"` // Also accept ?api_key=; header only by default
+	MaxPackSizes         int            `json:"max_pack_sizes"`
+	MaxPackSize          int            `json:"max_pack_size"`       // Largest pack size that may be configured
+	EfficiencyDecimals   int            `json:"efficiency_decimals"` // Rounding of efficiency and overshoot_percent
+	CompressPacksJSON    bool           `json:"compress_packs_json"`
+	CompressionMinLength int            `json:"compression_min_length"`
+	WebhookURLs          []string       `json:"webhook_urls"`
+	ReconcileDefaults    bool           `json:"reconcile_defaults"`
+	MigrateTimestamps    bool           `json:"migrate_timestamps"`   // Convert legacy TIMESTAMP columns at startup
+	LegacyTimeZone       string         `json:"legacy_time_zone"`     // Zone legacy order and pack size times were written in
+	StrictExact          bool           `json:"strict_exact"`         // Calculate rejects overshoot unless a request allows it
+	IdempotencyWindow    Duration       `json:"idempotency_window"`   // How long Idempotency-Key responses are replayed
+	IdempotencyMaxKeys   int            `json:"idempotency_max_keys"` // Keys kept in memory without the Redis backend
+	MaxBatchAmounts      int            `json:"max_batch_amounts"`    // Largest /api/calculate/batch request
+
+	// Endpoints lists the registered route patterns; filled in by main as routes are added
+	Endpoints []string `json:"endpoints"`
+}
+
+// TenantAPIKey binds an API key to the one tenant its requests are scoped to
+type TenantAPIKey struct {
+	Tenant string `json:"tenant"`
+	Key    string `json:"key"`
+}
+
+// DatabaseConfig holds Postgres connection settings
+type DatabaseConfig struct {
+	Host            string   `json:"host"`
+	Port            string   `json:"port"`
+	User            string   `json:"user"`
+	Password        string   `json:"password"`
+	Name            string   `json:"name"`
+	MaxOpenConns    int      `json:"max_open_conns"`
+	MaxIdleConns    int      `json:"max_idle_conns"`
+	ConnMaxLifetime Duration `json:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `json:"conn_max_idle_time"`
+}
+
+// ServerConfig holds HTTP server timeouts
+type ServerConfig struct {
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"` // How long in-flight requests may drain on SIGTERM
+}
+
+// CacheConfig holds result cache settings
+type CacheConfig struct {
+	Size     int      `json:"size"`
+	TTL      Duration `json:"ttl"`
+	Eviction string   `json:"eviction"` // CacheEvictionLRU or CacheEvictionLFU, for the memory cache
+
+	// How often the memory cache removes expired entries; 0 leaves them to Get and eviction
+	SweepInterval Duration `json:"sweep_interval"`
+
+	Backend   string `json:"backend"`              // CacheBackendMemory or CacheBackendRedis
+	RedisAddr string `json:"redis_addr,omitempty"` // host:port of the Redis server
+
+	// Peer cache ring (optional): Self is this node's base URL, Peers every node's, and
+	// PeerSecret the secret shared by all nodes to sign peer requests
+	Self       string   `json:"self,omitempty"`
+	Peers      []string `json:"peers,omitempty"`
+	PeerSecret string   `json:"peer_secret,omitempty"`
+}
+
+// Cache backends selectable with CACHE_BACKEND
+const (
+	CacheBackendMemory = "memory"
+	CacheBackendRedis  = "redis"
+)
+
+// Memory cache eviction policies selectable with CACHE_EVICTION
+const (
+	CacheEvictionLRU = "lru"
+	CacheEvictionLFU = "lfu"
+)
+
+// RateLimitConfig holds token bucket settings
+type RateLimitConfig struct {
+	Enabled  bool     `json:"enabled"`  // False skips the rate limit middleware entirely
+	Interval Duration `json:"interval"` // Time to refill one token
+	Burst    int      `json:"burst"`
+	ByAPIKey bool     `json:"by_api_key"`
+
+	TrustedProxies []string `json:"trusted_proxies"` // CIDRs allowed to set X-Forwarded-For
+}
+
+// PoolConfig holds the optional calculation worker pool size (Workers 0 = disabled)
+type PoolConfig struct {
+	Workers int `json:"workers"`
+	Queue   int `json:"queue"`
+}
+
+// CalcConfig holds calculation deadlines
+type CalcConfig struct {
+	Timeout   Duration `json:"timeout"`    // Default per-calculation deadline
+	MaxBudget Duration `json:"max_budget"` // Cap on X-Calc-Budget
+
+	MemoryBudget int64 `json:"memory_budget"` // Bytes a single calculation's DP tables may take
+}
+
+// DefaultCalcMemoryBudget is the default cap on a single calculation's DP tables
+const DefaultCalcMemoryBudget = 256 << 20
+
+// DefaultMaxBatchAmounts is the default cap on amounts per batch calculation
+const DefaultMaxBatchAmounts = 1000
+
+// DefaultIdempotencyMaxKeys is the default cap on idempotency keys kept in memory
+const DefaultIdempotencyMaxKeys = 10000
+
+// DefaultCompressionMinLength is the default body size below which responses are sent uncompressed
+const DefaultCompressionMinLength = 1024
+
+// DefaultMaxPackSize is the default largest pack size that may be configured
+const DefaultMaxPackSize = 1000000
+
+// StatsConfig holds the periodic stats snapshot settings
+type StatsConfig struct {
+	SnapshotInterval Duration `json:"snapshot_interval"` // Zero disables snapshots
+	Retention        Duration `json:"retention"`         // Snapshots older than this are pruned
+}
+
+// OrdersConfig holds the order retention policy
+type OrdersConfig struct {
+	Retention    Duration `json:"retention"`     // Orders older than this are deleted; zero keeps them all
+	ReapInterval Duration `json:"reap_interval"` // How often expired orders are deleted
+	SampleRate   float64  `json:"sample_rate"`   // Fraction of calculated orders persisted, 0 to 1
+}
+
+// CustomSizesConfig bounds the pack sizes clients may supply in place of the configured set
+type CustomSizesConfig struct {
+	MinSize int   `json:"min_size"`          // Smallest custom size accepted
+	Allowed []int `json:"allowed,omitempty"` // If set, only these sizes are accepted
+}
+
+// Load reads the configuration from environment variables, applying defaults.
+// Malformed values for optional tunables fall back to their defaults, except the
+// worker pool settings and the custom size allow-list, which are rejected so a typo
+// does not silently disable the pool or lift the size policy.
+func Load() (*Config, error) {
+	cfg := &Config{
+		Port: getEnv("PORT", "8080"),
+		Database: DatabaseConfig{
+			Host:            getEnv("DB_HOST", "localhost"),
+			Port:            getEnv("DB_PORT", "5432"),
+			User:            getEnv("DB_USER", "postgres"),
+			Password:        getEnv("DB_PASSWORD", "postgres"),
+			Name:            getEnv("DB_NAME", "packcalculator"),
+			MaxOpenConns:    50,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: Duration(1 * time.Minute),
+			ConnMaxIdleTime: Duration(30 * time.Second),
+		},
+		Server: ServerConfig{
+			ReadTimeout:     Duration(30 * time.Second),
+			WriteTimeout:    Duration(30 * time.Second),
+			IdleTimeout:     Duration(120 * time.Second),
+			ShutdownTimeout: Duration(15 * time.Second),
+		},
+		Cache: CacheConfig{
+			Size:     1000,
+			TTL:      Duration(1 * time.Hour),
+			Eviction: getEnv("CACHE_EVICTION", CacheEvictionLRU),
+			Self:     getEnv("CACHE_SELF", ""),
+			Peers:    splitList(getEnv("CACHE_PEERS", "")),
+
+			PeerSecret: getEnv("CACHE_PEER_SECRET", ""),
+
+			SweepInterval: Duration(1 * time.Minute),
+
+			Backend:   getEnv("CACHE_BACKEND", CacheBackendMemory),
+			RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
+		},
+		RateLimit: RateLimitConfig{
+			Enabled:  true,
+			Interval: Duration(100 * time.Millisecond), // 100 requests per 10 seconds
+			Burst:    20,
+			ByAPIKey: getEnv("RATE_LIMIT_BY_API_KEY", "") == "true",
+		},
+		Calc: CalcConfig{
+			Timeout:      Duration(10 * time.Second),
+			MaxBudget:    Duration(30 * time.Second),
+			MemoryBudget: DefaultCalcMemoryBudget,
+		},
+		Stats: StatsConfig{
+			SnapshotInterval: Duration(1 * time.Minute),
+			Retention:        Duration(7 * 24 * time.Hour),
+		},
+		Orders: OrdersConfig{
+			ReapInterval: Duration(1 * time.Hour),
+			SampleRate:   1,
+		},
+		APIKey:               getEnv("API_KEY", ""),
+		AllowQueryAPIKey:     getEnv("ALLOW_QUERY_API_KEY", "") == "true",
+		CompressPacksJSON:    getEnv("COMPRESS_PACKS_JSON", "") == "true",
+		CompressionMinLength: DefaultCompressionMinLength,
+		EfficiencyDecimals:   4,
+		WebhookURLs:          splitList(getEnv("WEBHOOK_URLS", "")),
+		ReconcileDefaults:    getEnv("RECONCILE_DEFAULTS", "") == "1",
+		MigrateTimestamps:    getEnv("MIGRATE_TIMESTAMPS", "") == "1",
+		LegacyTimeZone:       getEnv("LEGACY_TIME_ZONE", getEnv("TZ", "UTC")),
+		StrictExact:          getEnv("STRICT_EXACT", "") == "1",
+		IdempotencyWindow:    Duration(24 * time.Hour),
+		IdempotencyMaxKeys:   DefaultIdempotencyMaxKeys,
+		MaxBatchAmounts:      DefaultMaxBatchAmounts,
+		MaxPackSize:          DefaultMaxPackSize,
+		CustomSizes:          CustomSizesConfig{MinSize: 1},
+	}
+
+	if size, err := strconv.Atoi(getEnv("CACHE_SIZE", "")); err == nil {
+		cfg.Cache.Size = size
+	}
+	if d, err := time.ParseDuration(getEnv("CACHE_TTL", "")); err == nil && d > 0 {
+		cfg.Cache.TTL = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("CACHE_SWEEP_INTERVAL", "")); err == nil && d >= 0 {
+		cfg.Cache.SweepInterval = Duration(d)
+	}
+	if max, err := strconv.Atoi(getEnv("MAX_PACK_SIZES", "")); err == nil && max >= 0 {
+		cfg.MaxPackSizes = max
+	}
+	if max, err := strconv.Atoi(getEnv("MAX_PACK_SIZE", "")); err == nil && max >= 1 {
+		cfg.MaxPackSize = max
+	}
+	if n, err := strconv.Atoi(getEnv("EFFICIENCY_DECIMALS", "")); err == nil && n >= 1 && n <= 10 {
+		cfg.EfficiencyDecimals = n
+	}
+	if n, err := strconv.Atoi(getEnv("COMPRESSION_MIN_LENGTH", "")); err == nil && n >= 0 {
+		cfg.CompressionMinLength = n
+	}
+
+	if n, err := strconv.Atoi(getEnv("MAX_BATCH_AMOUNTS", "")); err == nil && n >= 1 {
+		cfg.MaxBatchAmounts = n
+	}
+
+	if n, err := strconv.Atoi(getEnv("CUSTOM_SIZE_MIN", "")); err == nil && n >= 1 {
+		cfg.CustomSizes.MinSize = n
+	}
+
+	if d, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "")); err == nil && d > 0 {
+		cfg.Server.ShutdownTimeout = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("CALC_TIMEOUT", "")); err == nil && d > 0 {
+		cfg.Calc.Timeout = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("CALC_MAX_BUDGET", "")); err == nil && d > 0 {
+		cfg.Calc.MaxBudget = Duration(d)
+	}
+	if n, err := strconv.ParseInt(getEnv("CALC_MEMORY_BUDGET", ""), 10, 64); err == nil && n > 0 {
+		cfg.Calc.MemoryBudget = n
+	}
+	if d, err := time.ParseDuration(getEnv("STATS_SNAPSHOT_INTERVAL", "")); err == nil && d >= 0 {
+		cfg.Stats.SnapshotInterval = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("STATS_RETENTION", "")); err == nil && d > 0 {
+		cfg.Stats.Retention = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("ORDERS_RETENTION", "")); err == nil && d >= 0 {
+		cfg.Orders.Retention = Duration(d)
+	}
+	if d, err := time.ParseDuration(getEnv("ORDERS_REAP_INTERVAL", "")); err == nil && d > 0 {
+		cfg.Orders.ReapInterval = Duration(d)
+	}
+	if rate, err := strconv.ParseFloat(getEnv("ORDER_SAMPLE_RATE", ""), 64); err == nil && rate >= 0 && rate <= 1 {
+		cfg.Orders.SampleRate = rate
+	}
+	if d, err := time.ParseDuration(getEnv("IDEMPOTENCY_WINDOW", "")); err == nil && d > 0 {
+		cfg.IdempotencyWindow = Duration(d)
+	}
+	if n, err := strconv.Atoi(getEnv("IDEMPOTENCY_MAX_KEYS", "")); err == nil && n >= 1 {
+		cfg.IdempotencyMaxKeys = n
+	}
+
+	// Also handed to Postgres, which knows IANA names but not Go's "Local"
+	if _, err := time.LoadLocation(cfg.LegacyTimeZone); err != nil || cfg.LegacyTimeZone == "" || cfg.LegacyTimeZone == "Local" {
+		return nil, fmt.Errorf("invalid LEGACY_TIME_ZONE %q: must be an IANA time zone name such as UTC or Europe/Amsterdam", cfg.LegacyTimeZone)
+	}
+
+	if len(cfg.Cache.Peers) > 0 && cfg.Cache.Self == "" {
+		return nil, fmt.Errorf("CACHE_PEERS requires CACHE_SELF, this node's base URL")
+	}
+	if len(cfg.Cache.Peers) > 0 && cfg.Cache.PeerSecret == "" {
+		return nil, fmt.Errorf("CACHE_PEERS requires CACHE_PEER_SECRET, shared by every node to authenticate peer requests")
+	}
+	switch cfg.Cache.Backend {
+	case CacheBackendMemory:
+		cfg.Cache.RedisAddr = ""
+	case CacheBackendRedis:
+		if len(cfg.Cache.Peers) > 0 {
+			return nil, fmt.Errorf("CACHE_PEERS cannot be combined with CACHE_BACKEND=redis, which is already shared")
+		}
+	default:
+		return nil, fmt.Errorf("invalid CACHE_BACKEND %q: must be %q or %q", cfg.Cache.Backend, CacheBackendMemory, CacheBackendRedis)
+	}
+
+	if cfg.Cache.Eviction != CacheEvictionLRU && cfg.Cache.Eviction != CacheEvictionLFU {
+		return nil, fmt.Errorf("invalid CACHE_EVICTION %q: must be %q or %q", cfg.Cache.Eviction, CacheEvictionLRU, CacheEvictionLFU)
+	}
+
+	// TENANT_API_KEYS is a comma-separated list of tenant:key pairs
+	if tenantKeysStr := getEnv("TENANT_API_KEYS", ""); tenantKeysStr != "" {
+		for _, field := range strings.Split(tenantKeysStr, ",") {
+			tenant, key, ok := strings.Cut(strings.TrimSpace(field), ":")
+			if !ok || tenant == "" || key == "" {
+				return nil, fmt.Errorf("invalid TENANT_API_KEYS entry %q: must be tenant:key", field)
+			}
+			cfg.TenantAPIKeys = append(cfg.TenantAPIKeys, TenantAPIKey{Tenant: tenant, Key: key})
+		}
+	}
+
+	if allowedStr := getEnv("CUSTOM_SIZES_ALLOWED", ""); allowedStr != "" {
+		for _, field := range strings.Split(allowedStr, ",") {
+			size, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || size < 1 {
+				return nil, fmt.Errorf("invalid CUSTOM_SIZES_ALLOWED entry %q: must be a positive integer", field)
+			}
+			cfg.CustomSizes.Allowed = append(cfg.CustomSizes.Allowed, size)
+		}
+	}
+
+	if enabledStr := getEnv("RATE_LIMIT_ENABLED", ""); enabledStr != "" {
+		enabled, err := strconv.ParseBool(enabledStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_ENABLED %q: must be true or false", enabledStr)
+		}
+		cfg.RateLimit.Enabled = enabled
+	}
+	if rateStr := getEnv("RATE_LIMIT_RATE", ""); rateStr != "" {
+		d, err := time.ParseDuration(rateStr)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_RATE %q: must be a positive duration such as 100ms", rateStr)
+		}
+		cfg.RateLimit.Interval = Duration(d)
+	}
+	if burstStr := getEnv("RATE_LIMIT_BURST", ""); burstStr != "" {
+		burst, err := strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_BURST %q: must be a positive integer", burstStr)
+		}
+		cfg.RateLimit.Burst = burst
+	}
+
+	if proxiesStr := getEnv("TRUSTED_PROXIES", ""); proxiesStr != "" {
+		for _, field := range strings.Split(proxiesStr, ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				cfg.RateLimit.TrustedProxies = append(cfg.RateLimit.TrustedProxies, field)
+			}
+		}
+		if _, err := ParseTrustedProxies(cfg.RateLimit.TrustedProxies); err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+		}
+	}
+
+	if workersStr := getEnv("CALC_WORKERS", ""); workersStr != "" {
+		workers, err := strconv.Atoi(workersStr)
+		if err != nil || workers < 1 {
+			return nil, fmt.Errorf("invalid CALC_WORKERS %q: must be a positive integer", workersStr)
+		}
+		cfg.Pool.Workers = workers
+		cfg.Pool.Queue = workers * 4
+		if queueStr := getEnv("CALC_QUEUE", ""); queueStr != "" {
+			queue, err := strconv.Atoi(queueStr)
+			if err != nil || queue < 0 {
+				return nil, fmt.Errorf("invalid CALC_QUEUE %q: must be a non-negative integer", queueStr)
+			}
+			cfg.Pool.Queue = queue
+		}
+	}
+
+	return cfg, nil
+}
+
+// Sanitized returns a copy safe to expose over the API, with API keys and other secrets redacted
+func (c *Config) Sanitized() Config {
+	out := *c
+	if out.APIKey != "" {
+		out.APIKey = Redacted
+	}
+	if out.Database.Password != "" {
+		out.Database.Password = Redacted
+	}
+	if out.Cache.PeerSecret != "" {
+		out.Cache.PeerSecret = Redacted
+	}
+	out.TenantAPIKeys = nil
+	for _, tk := range c.TenantAPIKeys {
+		out.TenantAPIKeys = append(out.TenantAPIKeys, TenantAPIKey{Tenant: tk.Tenant, Key: Redacted})
+	}
+	// Webhook URLs may embed tokens, and peer addresses map the internal network
+	out.WebhookURLs = redactAll(c.WebhookURLs)
+	out.Cache.Peers = redactAll(c.Cache.Peers)
+	if out.Cache.Self != "" {
+		out.Cache.Self = Redacted
+	}
+	out.Endpoints = append([]string(nil), c.Endpoints...)
+	out.CustomSizes.Allowed = append([]int(nil), c.CustomSizes.Allowed...)
+	return out
+}
+
+// redactAll returns one Redacted entry per value, so the count stays visible
+func redactAll(values []string) []string {
+	out := make([]string, 0, len(values))
+	for range values {
+		out = append(out, Redacted)
+	}
+	return out
+}
+
+// ParseTrustedProxies parses CIDRs such as "10.0.0.0/8"; a bare IP is taken as a
+// single-address network
+func ParseTrustedProxies(cidrs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if ip := net.ParseIP(cidr); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: must be an IP or CIDR", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// splitList splits a comma-separated value such as WEBHOOK_URLS, ignoring blanks
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,310 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Port != "8080" || cfg.Cache.Size != 1000 || time.Duration(cfg.Cache.TTL) != time.Hour {
+		t.Errorf("Unexpected defaults: %+v", cfg)
+	}
+	if cfg.Pool.Workers != 0 {
+		t.Errorf("Pool.Workers = %d, want 0 (disabled)", cfg.Pool.Workers)
+	}
+	if cfg.Orders.SampleRate != 1 {
+		t.Errorf("Orders.SampleRate = %v, want 1 (persist all)", cfg.Orders.SampleRate)
+	}
+	if cfg.MaxBatchAmounts != DefaultMaxBatchAmounts {
+		t.Errorf("MaxBatchAmounts = %d, want %d", cfg.MaxBatchAmounts, DefaultMaxBatchAmounts)
+	}
+	if cfg.Calc.MemoryBudget != DefaultCalcMemoryBudget {
+		t.Errorf("Calc.MemoryBudget = %d, want %d", cfg.Calc.MemoryBudget, DefaultCalcMemoryBudget)
+	}
+	if time.Duration(cfg.Server.ShutdownTimeout) != 15*time.Second {
+		t.Errorf("Server.ShutdownTimeout = %s, want 15s", time.Duration(cfg.Server.ShutdownTimeout))
+	}
+	if time.Duration(cfg.Cache.SweepInterval) != time.Minute {
+		t.Errorf("Cache.SweepInterval = %s, want 1m", time.Duration(cfg.Cache.SweepInterval))
+	}
+
+	t.Setenv("ORDER_SAMPLE_RATE", "1.5")
+	if cfg, _ := Load(); cfg.Orders.SampleRate != 1 {
+		t.Errorf("Out-of-range ORDER_SAMPLE_RATE: SampleRate = %v, want fallback 1", cfg.Orders.SampleRate)
+	}
+}
+
+func TestLoad_Environment(t *testing.T) {
+	t.Setenv("CACHE_SIZE", "250")
+	t.Setenv("CALC_WORKERS", "3")
+	t.Setenv("MAX_PACK_SIZES", "not-a-number")
+	t.Setenv("WEBHOOK_URLS", "http://a.example, http://b.example")
+	t.Setenv("ORDERS_RETENTION", "720h")
+	t.Setenv("ORDER_SAMPLE_RATE", "0.1")
+	t.Setenv("CALC_MEMORY_BUDGET", "1048576")
+	t.Setenv("SHUTDOWN_TIMEOUT", "45s")
+	t.Setenv("CACHE_TTL", "10m")
+	t.Setenv("CACHE_SWEEP_INTERVAL", "0")
+	t.Setenv("MAX_PACK_SIZE", "50000")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Cache.Size != 250 || time.Duration(cfg.Cache.TTL) != 10*time.Minute {
+		t.Errorf("Cache = %+v, want size 250 and TTL 10m", cfg.Cache)
+	}
+	if cfg.Cache.SweepInterval != 0 {
+		t.Errorf("Cache.SweepInterval = %s, want 0 (disabled)", time.Duration(cfg.Cache.SweepInterval))
+	}
+	if cfg.Pool.Workers != 3 || cfg.Pool.Queue != 12 {
+		t.Errorf("Pool = %+v, want 3 workers and queue 12", cfg.Pool)
+	}
+	if cfg.MaxPackSizes != 0 {
+		t.Errorf("MaxPackSizes = %d, want fallback 0", cfg.MaxPackSizes)
+	}
+	if cfg.MaxPackSize != 50000 {
+		t.Errorf("MaxPackSize = %d, want 50000", cfg.MaxPackSize)
+	}
+	if len(cfg.WebhookURLs) != 2 {
+		t.Errorf("WebhookURLs = %v, want 2", cfg.WebhookURLs)
+	}
+	if time.Duration(cfg.Orders.Retention) != 720*time.Hour || time.Duration(cfg.Orders.ReapInterval) != time.Hour {
+		t.Errorf("Orders = %+v, want 720h retention reaped hourly", cfg.Orders)
+	}
+	if cfg.Orders.SampleRate != 0.1 {
+		t.Errorf("Orders.SampleRate = %v, want 0.1", cfg.Orders.SampleRate)
+	}
+	if cfg.Calc.MemoryBudget != 1<<20 {
+		t.Errorf("Calc.MemoryBudget = %d, want 1048576", cfg.Calc.MemoryBudget)
+	}
+	if time.Duration(cfg.Server.ShutdownTimeout) != 45*time.Second {
+		t.Errorf("Server.ShutdownTimeout = %s, want 45s", time.Duration(cfg.Server.ShutdownTimeout))
+	}
+
+	t.Setenv("CALC_WORKERS", "zero")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with invalid CALC_WORKERS: expected error")
+	}
+}
+
+func TestLoad_CustomSizes(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.CustomSizes.MinSize != 1 || cfg.CustomSizes.Allowed != nil {
+		t.Errorf("CustomSizes = %+v, want min 1 and no allow-list", cfg.CustomSizes)
+	}
+
+	t.Setenv("CUSTOM_SIZE_MIN", "50")
+	t.Setenv("CUSTOM_SIZES_ALLOWED", "100, 250,500")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.CustomSizes.MinSize != 50 || len(cfg.CustomSizes.Allowed) != 3 || cfg.CustomSizes.Allowed[1] != 250 {
+		t.Errorf("CustomSizes = %+v, want min 50 and [100 250 500]", cfg.CustomSizes)
+	}
+
+	t.Setenv("CUSTOM_SIZES_ALLOWED", "100,abc")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with invalid CUSTOM_SIZES_ALLOWED: expected error")
+	}
+}
+
+func TestLoad_CacheBackend(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Cache.Backend != CacheBackendMemory || cfg.Cache.RedisAddr != "" {
+		t.Errorf("Cache = %+v, want memory backend and no Redis address", cfg.Cache)
+	}
+
+	t.Setenv("CACHE_BACKEND", "redis")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Cache.Backend != CacheBackendRedis || cfg.Cache.RedisAddr != "localhost:6379" {
+		t.Errorf("Cache = %+v, want redis backend at localhost:6379", cfg.Cache)
+	}
+	t.Setenv("REDIS_ADDR", "cache.internal:6380")
+	if cfg, _ = Load(); cfg.Cache.RedisAddr != "cache.internal:6380" {
+		t.Errorf("Cache.RedisAddr = %q, want cache.internal:6380", cfg.Cache.RedisAddr)
+	}
+
+	if cfg.Cache.Eviction != CacheEvictionLRU {
+		t.Errorf("Cache.Eviction = %q, want lru by default", cfg.Cache.Eviction)
+	}
+	t.Setenv("CACHE_EVICTION", "lfu")
+	if cfg, _ = Load(); cfg.Cache.Eviction != CacheEvictionLFU {
+		t.Errorf("Cache.Eviction = %q, want lfu", cfg.Cache.Eviction)
+	}
+	t.Setenv("CACHE_EVICTION", "fifo")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with unknown CACHE_EVICTION: expected error")
+	}
+	t.Setenv("CACHE_EVICTION", "")
+
+	t.Setenv("CACHE_BACKEND", "memory")
+	t.Setenv("CACHE_SELF", "http://10.0.0.1:8080")
+	t.Setenv("CACHE_PEERS", "http://10.0.0.1:8080,http://10.0.0.2:8080")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with CACHE_PEERS but no CACHE_PEER_SECRET: expected error")
+	}
+	t.Setenv("CACHE_PEERS", "")
+
+	t.Setenv("CACHE_BACKEND", "memcached")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with unknown CACHE_BACKEND: expected error")
+	}
+}
+
+func TestLoad_LegacyTimeZone(t *testing.T) {
+	t.Setenv("TZ", "")
+	t.Setenv("LEGACY_TIME_ZONE", "")
+	if cfg, err := Load(); err != nil || cfg.LegacyTimeZone != "UTC" || cfg.MigrateTimestamps {
+		t.Errorf("Load() = %q, migrate %v, %v; want UTC without migrating by default", cfg.LegacyTimeZone, cfg.MigrateTimestamps, err)
+	}
+
+	t.Setenv("TZ", "Europe/Amsterdam")
+	if cfg, err := Load(); err != nil || cfg.LegacyTimeZone != "Europe/Amsterdam" {
+		t.Errorf("Load() = %q, %v; want the TZ zone", cfg.LegacyTimeZone, err)
+	}
+
+	for _, zone := range []string{"Local", "Mars/Olympus"} {
+		t.Setenv("LEGACY_TIME_ZONE", zone)
+		if _, err := Load(); err == nil {
+			t.Errorf("Load() with LEGACY_TIME_ZONE %q: expected error", zone)
+		}
+	}
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RateLimit.TrustedProxies != nil {
+		t.Errorf("RateLimit.TrustedProxies = %v, want none", cfg.RateLimit.TrustedProxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, fd00::/8,,192.168.1.1")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cfg.RateLimit.TrustedProxies) != 3 || cfg.RateLimit.TrustedProxies[1] != "fd00::/8" {
+		t.Errorf("RateLimit.TrustedProxies = %v, want [10.0.0.0/8 fd00::/8 192.168.1.1]", cfg.RateLimit.TrustedProxies)
+	}
+
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+	if _, err := Load(); err == nil {
+		t.Error("Load() with invalid TRUSTED_PROXIES: expected error")
+	}
+}
+
+func TestLoad_RateLimit(t *testing.T) {
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !cfg.RateLimit.Enabled || cfg.RateLimit.Interval != Duration(100*time.Millisecond) || cfg.RateLimit.Burst != 20 {
+		t.Errorf("RateLimit = %+v, want enabled, 100ms and burst 20", cfg.RateLimit)
+	}
+
+	t.Setenv("RATE_LIMIT_RATE", "250ms")
+	t.Setenv("RATE_LIMIT_BURST", "50")
+	t.Setenv("RATE_LIMIT_ENABLED", "false")
+	if cfg, err = Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.RateLimit.Enabled || cfg.RateLimit.Interval != Duration(250*time.Millisecond) || cfg.RateLimit.Burst != 50 {
+		t.Errorf("RateLimit = %+v, want disabled, 250ms and burst 50", cfg.RateLimit)
+	}
+
+	for name, value := range map[string]string{
+		"RATE_LIMIT_RATE":    "0s",
+		"RATE_LIMIT_BURST":   "0",
+		"RATE_LIMIT_ENABLED": "maybe",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := Load(); err == nil {
+				t.Errorf("Load() with %s=%q: expected error", name, value)
+			}
+		})
+	}
+}
+
+func TestSanitized(t *testing.T) {
+	t.Setenv("API_KEY", "super-secret-key")
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("CACHE_SELF", "http://10.0.0.1:8080")
+	t.Setenv("CACHE_PEERS", "http://10.0.0.1:8080,http://10.0.0.2:8080")
+	t.Setenv("CACHE_PEER_SECRET", "peer-secret")
+	t.Setenv("TENANT_API_KEYS", "acme:acme-secret")
+	t.Setenv("WEBHOOK_URLS", "https://hooks.example/notify?token=hook-token")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	data, err := json.Marshal(cfg.Sanitized())
+	if err != nil {
+		t.Fatalf("Marshal error = %v", err)
+	}
+	body := string(data)
+	for _, secret := range []string{"super-secret-key", "hunter2", "peer-secret", "acme-secret", "hook-token", "10.0.0.1", "10.0.0.2"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Sanitized config leaks %q: %s", secret, body)
+		}
+	}
+	if !strings.Contains(body, `"peers":["[REDACTED]","[REDACTED]"]`) {
+		t.Errorf("Peers should be redacted one by one: %s", body)
+	}
+	if !strings.Contains(body, `"ttl":"1h0m0s"`) {
+		t.Errorf("Durations should marshal as strings: %s", body)
+	}
+
+	// The original keeps its secrets for wiring
+	if cfg.APIKey != "super-secret-key" || cfg.Database.Password != "hunter2" || cfg.Cache.PeerSecret != "peer-secret" ||
+		cfg.TenantAPIKeys[0].Key != "acme-secret" {
+		t.Error("Sanitized() modified the original config")
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 2001:db8::1 ", "192.0.2.7"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies() error = %v", err)
+	}
+	want := []string{"10.0.0.0/8", "2001:db8::1/128", "192.0.2.7/32"}
+	for i, ipNet := range nets {
+		if ipNet.String() != want[i] {
+			t.Errorf("Network %d = %s, want %s", i, ipNet, want[i])
+		}
+	}
+
+	if _, err := ParseTrustedProxies([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("ParseTrustedProxies() with invalid CIDR: expected error")
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	items := splitList(" http://a.example/hook, ,http://b.example/hook ")
+	if len(items) != 2 || items[0] != "http://a.example/hook" || items[1] != "http://b.example/hook" {
+		t.Errorf("splitList() = %v", items)
+	}
+	if items := splitList(""); len(items) != 0 {
+		t.Errorf("splitList(\"\") = %v, want empty", items)
+	}
+}
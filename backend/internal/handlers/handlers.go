@@ -1,33 +1,382 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"mime"
 	"net/http"
+	"net/url"
 	"pack-calculator/internal/cache"
 	"pack-calculator/internal/calculator"
+	"pack-calculator/internal/config"
+	"pack-calculator/internal/middleware"
 	"pack-calculator/internal/models"
 	"pack-calculator/internal/repository"
+	"pack-calculator/internal/slip"
+	"pack-calculator/internal/webhook"
+	"pack-calculator/internal/workerpool"
+	"reflect"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	_ "time/tzdata" // Embedded zone database for ?tz= on minimal images
 
 	json "github.com/goccy/go-json"
 )
 
+// maxAmount is the largest amount accepted for calculation, to prevent memory exhaustion
+const maxAmount = 10000000 // 10 million items max
+
+// maxStreamRange caps how many amounts a single streaming range request may cover
+const maxStreamRange = 10000
+
+// maxFeasibilityAmounts caps how many amounts a single feasibility request may check
+const maxFeasibilityAmounts = 1000
+
+// maxFastAmount is the largest amount accepted by the greedy endpoint, whose cost does not grow with the amount.
+// On 32-bit builds it is half of int's range, leaving the rest for the overshoot.
+const maxFastAmount = min(1_000_000_000_000, math.MaxInt/2)
+
+// maxConsolidatedAmounts caps how many orders a single consolidated shipment may combine
+const maxConsolidatedAmounts = 100
+
+// maxCompareAmounts caps the sample of a pack set comparison, whether given or taken from orders
+const maxCompareAmounts = 1000
+
+// maxCompareCost caps the estimated DP transitions of a whole pack set comparison,
+// both sets over every sampled amount
+const maxCompareCost = 200_000_000
+
+// maxOrdersLimit caps the page size of GET /api/orders; larger limits are clamped to it
+const maxOrdersLimit = 1000
+
+// maxRequestCacheTTL caps the cache lifetime a request may ask for with cache_ttl_seconds
+const maxRequestCacheTTL = 24 * time.Hour
+
 // Handler manages HTTP requests
 type Handler struct {
-	repo  *repository.Repository
-	cache cache.Cache
+	repo            repository.Store
+	cache           cache.Cache
+	config          Config
+	notifier        PackSizeNotifier
+	pool            *workerpool.Pool
+	validator       ResultValidator
+	db              DatabasePinger // Checked by Ready; nil when not set
+	effectiveConfig *config.Config // Reported by GetConfig; nil when not set
+
+	calculations      atomic.Int64 // Successful calculator runs by CalculatePacks (cache misses)
+	calculationErrors atomic.Int64 // Failed or aborted calculator runs
+	warm              atomic.Bool  // Set once WarmUp has finished
+	dpSizes           dpHistogram  // DP table bounds of calculator runs
+}
+
+// dpBucketBounds are the upper bounds of the DP table size histogram buckets;
+// a final bucket counts larger tables
+var dpBucketBounds = []int{1_000, 10_000, 100_000, 1_000_000, 10_000_000}
+
+// dpHistogram counts calculator runs by DP table bound (maxTarget)
+type dpHistogram struct {
+	counts [6]atomic.Int64 // One per dpBucketBounds entry plus the overflow bucket
+	max    atomic.Int64
+}
+
+// observe records one calculator run's DP stats
+func (d *dpHistogram) observe(stats calculator.DPStats) {
+	bucket := len(dpBucketBounds)
+	for i, bound := range dpBucketBounds {
+		if stats.MaxTarget <= bound {
+			bucket = i
+			break
+		}
+	}
+	d.counts[bucket].Add(1)
+	for {
+		current := d.max.Load()
+		if int64(stats.MaxTarget) <= current || d.max.CompareAndSwap(current, int64(stats.MaxTarget)) {
+			return
+		}
+	}
+}
+
+// SetEffectiveConfig registers the resolved server configuration reported by GET /api/config
+func (h *Handler) SetEffectiveConfig(cfg *config.Config) {
+	h.effectiveConfig = cfg
+}
+
+// ResultValidator enforces deployment-specific rules on a calculation result before it
+// is returned; a non-nil error rejects the result with a 422 carrying the error's message
+type ResultValidator func(req models.PackCalculationRequest, result models.PackCalculationResult) error
+
+// SetResultValidator registers a validator run on every /api/calculate result, and on
+// each result of /api/calculate/batch
+func (h *Handler) SetResultValidator(validator ResultValidator) {
+	h.validator = validator
+}
+
+// validateResult runs the validator, if any, writing a 422 and returning false on rejection
+func (h *Handler) validateResult(w http.ResponseWriter, req models.PackCalculationRequest, result models.PackCalculationResult) bool {
+	if h.validator == nil {
+		return true
+	}
+	if err := h.validator(req, result); err != nil {
+		respondError(w, http.StatusUnprocessableEntity, codeResultRejected, err.Error())
+		return false
+	}
+	return true
+}
+
+// SetCalculationPool bounds concurrent calculations with the given worker pool
+func (h *Handler) SetCalculationPool(pool *workerpool.Pool) {
+	h.pool = pool
+}
+
+// newCalculator returns a calculator for packSizes bounded by CalcMemoryBudget
+func (h *Handler) newCalculator(packSizes []int) *calculator.Calculator {
+	calc := calculator.NewCalculator(packSizes)
+	calc.SetMemoryBudget(h.config.CalcMemoryBudget)
+	return calc
+}
+
+// runCalculation runs fn on the calculation pool when one is configured, queued by its
+// estimated cost so cheap calculations are not stuck behind expensive ones
+func (h *Handler) runCalculation(ctx context.Context, cost int64, fn func()) error {
+	if h.pool == nil {
+		fn()
+		return nil
+	}
+	return h.pool.DoCost(ctx, cost, fn)
+}
+
+// requestCacheTTL returns how long a request's result is cached: CacheTTL when seconds
+// is nil, otherwise seconds capped at maxRequestCacheTTL, with zero meaning not at all
+func (h *Handler) requestCacheTTL(seconds *int) (time.Duration, error) {
+	if seconds == nil {
+		return h.config.CacheTTL, nil
+	}
+	if *seconds < 0 {
+		return 0, fmt.Errorf("cache_ttl_seconds cannot be negative")
+	}
+	if *seconds > int(maxRequestCacheTTL/time.Second) {
+		return maxRequestCacheTTL, nil
+	}
+	return time.Duration(*seconds) * time.Second, nil
+}
+
+// calculationBudget returns the calculation deadline for a request: the X-Calc-Budget
+// header (a Go duration such as "500ms") capped at MaxCalcBudget, or CalcTimeout when absent
+func (h *Handler) calculationBudget(r *http.Request) (time.Duration, error) {
+	header := r.Header.Get("X-Calc-Budget")
+	if header == "" {
+		return h.config.CalcTimeout, nil
+	}
+	budget, err := time.ParseDuration(header)
+	if err != nil || budget <= 0 {
+		return 0, errors.New("X-Calc-Budget must be a positive duration such as 500ms or 2s")
+	}
+	if budget > h.config.MaxCalcBudget {
+		budget = h.config.MaxCalcBudget
+	}
+	return budget, nil
+}
+
+// respondCalculationError maps a failed or aborted calculation to a response
+func (h *Handler) respondCalculationError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, workerpool.ErrSaturated):
+		h.respondOverloaded(w)
+	case errors.Is(err, calculator.ErrInsufficientStock):
+		respondError(w, http.StatusConflict, codeInsufficientStock, "Not enough stock to fulfil the amount")
+	case errors.Is(err, calculator.ErrMemoryBudgetExceeded):
+		respondError(w, http.StatusUnprocessableEntity, codeMemoryBudget, "Calculation would exceed the memory budget; use larger pack sizes or a smaller amount")
+	case errors.Is(err, calculator.ErrMaxPacksExceeded):
+		respondError(w, http.StatusUnprocessableEntity, codeMaxPacks, "Amount cannot be packed within max_packs packs")
+	case errors.Is(err, context.DeadlineExceeded):
+		respondError(w, http.StatusGatewayTimeout, codeTimeout, "Calculation exceeded its time budget")
+	case errors.Is(err, context.Canceled):
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "Request cancelled"})
+	default:
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
+// respondOverloaded writes a 503 with a Retry-After estimate from the pool's load
+func (h *Handler) respondOverloaded(w http.ResponseWriter) {
+	retryAfter := int(h.pool.RetryAfter() / time.Second)
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	respondJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+		"error":               "Server is busy, please retry later",
+		"code":                codeOverloaded,
+		"retry_after_seconds": retryAfter,
+	})
+}
+
+// invalidatePackSet drops the request tenant's cached results computed for the pack set
+// that was just replaced. Keys embed the full sorted set, so results for other sets (e.g.
+// other profiles) remain valid, and other tenants' entries for the same set are kept.
+// Caches without scoped invalidation are cleared.
+func (h *Handler) invalidatePackSet(ctx context.Context, oldSizes []int) {
+	if invalidator, ok := h.cache.(cache.PackSetInvalidator); ok {
+		invalidator.InvalidatePackSet(h.cacheNamespace(ctx), sortedCopy(oldSizes))
+		return
+	}
+	h.cache.Clear()
+}
+
+// store returns the repository scoped to the request's tenant (see middleware.APIKeyAuth.TenantMiddleware)
+func (h *Handler) store(ctx context.Context) repository.Store {
+	if tenant := middleware.TenantFromContext(ctx); tenant != middleware.DefaultTenant {
+		return h.repo.ForTenant(tenant)
+	}
+	return h.repo
+}
+
+// cacheNamespace returns the cache namespace of the request's tenant. The default
+// tenant has none and keeps unprefixed keys, so entries cached before tenants existed
+// remain valid.
+func (h *Handler) cacheNamespace(ctx context.Context) string {
+	if tenant := middleware.TenantFromContext(ctx); tenant != middleware.DefaultTenant {
+		return tenant
+	}
+	return ""
+}
+
+// cacheKey scopes a generated cache key to the request's tenant
+func (h *Handler) cacheKey(ctx context.Context, key string) string {
+	if namespace := h.cacheNamespace(ctx); namespace != "" {
+		return cache.NamespacedKey(namespace, key)
+	}
+	return key
+}
+
+// profileCacheKey scopes a generated cache key to a named profile and then to the
+// tenant. The default profile keeps the tenant's keys. Profile namespaces contain a
+// '.', which tenant IDs cannot, so the two never collide.
+func (h *Handler) profileCacheKey(ctx context.Context, profile, key string) string {
+	if profile != "" && profile != repository.DefaultProfile {
+		key = cache.NamespacedKey("profile."+profile, key)
+	}
+	return h.cacheKey(ctx, key)
+}
+
+// PackSizeNotifier is informed after a pack size mutation succeeds
+type PackSizeNotifier interface {
+	NotifyPackSizeChange(eventType string, size, oldSize int)
+}
+
+// DatabasePinger checks the database connection, as *sql.DB does
+type DatabasePinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// SetDatabase registers the database connection checked by Ready
+func (h *Handler) SetDatabase(db DatabasePinger) {
+	h.db = db
+}
+
+// SetNotifier registers a notifier for pack size changes
+func (h *Handler) SetNotifier(notifier PackSizeNotifier) {
+	h.notifier = notifier
+}
+
+// notifyPackSizeChange forwards a pack size change to the notifier, if any
+func (h *Handler) notifyPackSizeChange(eventType string, size, oldSize int) {
+	if h.notifier != nil {
+		h.notifier.NotifyPackSizeChange(eventType, size, oldSize)
+	}
+}
+
+// Config holds tunable handler behavior
+type Config struct {
+	// MaxPackSizes caps how many distinct pack sizes may be configured (0 = unlimited)
+	MaxPackSizes int
+	// CacheTTL is how long calculation results stay cached
+	CacheTTL time.Duration
+	// CalcTimeout is the default deadline for a single calculation
+	CalcTimeout time.Duration
+	// MaxCalcBudget caps the deadline a client may request via X-Calc-Budget
+	MaxCalcBudget time.Duration
+	// EfficiencyDecimals is how many decimals efficiency and overshoot_percent are rounded to
+	EfficiencyDecimals int
+	// CustomSizeMin is the smallest pack size a client may supply in a request (0 = 1)
+	CustomSizeMin int
+	// CustomSizesAllowed, if non-empty, are the only pack sizes a client may supply
+	CustomSizesAllowed []int
+	// OrderSampleRate is the fraction of calculated orders persisted: 1 saves all, 0 none
+	OrderSampleRate float64
+	// StrictExact makes CalculatePacks reject overshoot unless a request allows it
+	StrictExact bool
+	// CalcMemoryBudget caps the bytes a single calculation's DP tables may take
+	CalcMemoryBudget int64
+	// MaxBatchAmounts caps how many amounts one batch calculation may contain
+	MaxBatchAmounts int
+	// MaxPackSize is the largest pack size that may be configured
+	MaxPackSize int
+}
+
+// DefaultConfig returns the handler configuration used by NewHandler
+func DefaultConfig() Config {
+	return Config{
+		CacheTTL:           1 * time.Hour,
+		CalcTimeout:        10 * time.Second,
+		MaxCalcBudget:      30 * time.Second,
+		EfficiencyDecimals: 4,
+		OrderSampleRate:    1,
+		CalcMemoryBudget:   config.DefaultCalcMemoryBudget,
+		MaxBatchAmounts:    config.DefaultMaxBatchAmounts,
+		MaxPackSize:        config.DefaultMaxPackSize,
+	}
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(repo *repository.Repository, cacheImpl cache.Cache) *Handler {
+func NewHandler(repo repository.Store, cacheImpl cache.Cache) *Handler {
+	return NewHandlerWithConfig(repo, cacheImpl, DefaultConfig())
+}
+
+// NewHandlerWithConfig creates a new handler instance with the given configuration
+func NewHandlerWithConfig(repo repository.Store, cacheImpl cache.Cache, config Config) *Handler {
 	if cacheImpl == nil {
 		cacheImpl = &cache.NoOpCache{} // Default to no cache
 	}
+	defaults := DefaultConfig()
+	if config.CacheTTL <= 0 {
+		config.CacheTTL = defaults.CacheTTL
+	}
+	if config.CalcTimeout <= 0 {
+		config.CalcTimeout = defaults.CalcTimeout
+	}
+	if config.MaxCalcBudget <= 0 {
+		config.MaxCalcBudget = defaults.MaxCalcBudget
+	}
+	if config.EfficiencyDecimals <= 0 {
+		config.EfficiencyDecimals = defaults.EfficiencyDecimals
+	}
+	if config.CalcMemoryBudget <= 0 {
+		config.CalcMemoryBudget = defaults.CalcMemoryBudget
+	}
+	if config.MaxBatchAmounts <= 0 {
+		config.MaxBatchAmounts = defaults.MaxBatchAmounts
+	}
+	if config.MaxPackSize <= 0 {
+		config.MaxPackSize = defaults.MaxPackSize
+	}
 	return &Handler{
-		repo:  repo,
-		cache: cacheImpl,
+		repo:   repo,
+		cache:  cacheImpl,
+		config: config,
 	}
 }
 
@@ -35,8 +384,9 @@ func NewHandler(repo *repository.Repository, cacheImpl cache.Cache) *Handler {
 func EnableCORS(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, X-Calc-Budget, Idempotency-Key, X-Tenant-ID, If-None-Match")
+		w.Header().Set("Timing-Allow-Origin", "*") // Lets cross-origin devtools read Server-Timing
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -47,219 +397,2469 @@ func EnableCORS(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// CalculatePacks handles POST /api/calculate
+// CalculatePacks handles POST /api/calculate, and GET /api/calculate?amount=N for
+// clients and CDNs that cache by URL
 func (h *Handler) CalculatePacks(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
 		return
 	}
 
-	// Parse request
-	var req models.PackCalculationRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	view, err := parseResultView(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Validate amount
-	if req.Amount < 1 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Amount must be at least 1"})
+	// ?dryrun=1 exercises only the calculator: no cache read/write and no order persistence
+	dryRun, err := parseFlag(r.URL.Query(), "dryrun")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Set reasonable upper limit to prevent memory exhaustion
-	const maxAmount = 10000000 // 10 million items max
-	if req.Amount > maxAmount {
-		respondJSON(w, http.StatusBadRequest, map[string]string{
-			"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
-		})
+	// ?respect_stock=1 limits each size to its tracked stock; results depend on live
+	// stock levels, so they bypass the cache
+	respectStock, err := parseFlag(r.URL.Query(), "respect_stock")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Get pack sizes from database
-	packSizes, err := h.repo.GetPackSizesAsSlice()
+	// ?exact=1 rejects amounts that cannot be packed without overshoot. Exact results are
+	// cached under their own mode key, and rejections are negative-cached.
+	exact, err := parseFlag(r.URL.Query(), "exact")
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	if len(packSizes) == 0 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+	// Under StrictExact every request is exact unless it opts back in with ?allow_overshoot=1
+	allowOvershoot, err := parseFlag(r.URL.Query(), "allow_overshoot")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
+	if h.config.StrictExact && !allowOvershoot {
+		exact = true
+	}
 
-	// Check cache first
-	cacheKey := cache.GenerateCacheKey(req.Amount, packSizes)
-	if cachedPacks, cachedTotal, found := h.cache.Get(cacheKey); found {
-		// Calculate total packs from cached data
-		totalPacks := 0
-		for _, count := range cachedPacks {
-			totalPacks += count
-		}
-
-		result := models.PackCalculationResult{
-			Amount:     req.Amount,
-			TotalItems: cachedTotal,
-			TotalPacks: totalPacks,
-			Packs:      cachedPacks,
-		}
-		respondJSON(w, http.StatusOK, result)
+	debug, err := parseFlag(r.URL.Query(), "debug")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Calculate optimal packs
-	calc := calculator.NewCalculator(packSizes)
-	packs, totalItems, totalPacks, err := calc.CalculateWithDetails(req.Amount)
+	// ?next_exact=1 suggests the nearest amount that would ship with no overshoot
+	suggestNext, err := parseFlag(r.URL.Query(), "next_exact")
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	// Cache the result (TTL: 1 hour)
-	h.cache.Set(cacheKey, packs, totalItems, 1*time.Hour)
-
-	// Create result
-	result := models.PackCalculationResult{
-		Amount:     req.Amount,
-		TotalItems: totalItems,
-		TotalPacks: totalPacks,
-		Packs:      packs,
+	// ?variants=true lists the other combinations tied with the result; they are always
+	// computed, as the cache only holds one combination per amount
+	variants, err := parseFlag(r.URL.Query(), "variants")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
 	}
-
-	// Save order to database
-	order := &models.Order{
-		Amount:     req.Amount,
-		TotalItems: totalItems,
-		TotalPacks: totalPacks,
-		Packs:      packs,
+	if variants && respectStock {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "variants cannot be combined with respect_stock"})
+		return
 	}
 
-	if err := h.repo.SaveOrder(order); err != nil {
-		// Log error but don't fail the request
-		// The calculation is still valid even if we can't save it
+	// ?save=false skips recording the calculation as an order; absent means save, except
+	// on GET, which is a read and only saves with ?save=true
+	save := r.Method == http.MethodPost
+	if r.URL.Query().Has("save") {
+		if save, err = parseFlag(r.URL.Query(), "save"); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
 	}
 
-	respondJSON(w, http.StatusOK, result)
-}
+	// ?tier=name packs using only that tier's sizes
+	tier := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("tier")))
 
-// GetPackSizes handles GET /api/packs
-func (h *Handler) GetPackSizes(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	// ?profile=name packs with a named profile's sizes instead of the tenant's own
+	profile := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("profile")))
+	if profile == repository.DefaultProfile {
+		profile = ""
+	}
+	if profile != "" {
+		if err := models.ValidateProfileName(profile); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
 	}
 
-	packSizes, err := h.repo.GetAllPackSizes()
+	// The calculation deadline defaults to CalcTimeout; X-Calc-Budget may override it
+	budget, err := h.calculationBudget(r)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
 
-	respondJSON(w, http.StatusOK, packSizes)
-}
-
-// AddPackSize handles POST /api/packs
-func (h *Handler) AddPackSize(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Parse request: JSON by default, or just the amount from a form body or ?amount=
+	req, err := decodeCalculationRequest(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	var req struct {
-		Size int `json:"size"`
+	// Derive the amount from weights when requested
+	if req.TargetWeight != 0 || req.ItemWeight != 0 {
+		if req.Amount != 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Specify either amount or target_weight/item_weight, not both"})
+			return
+		}
+		amount, err := amountFromWeight(req.TargetWeight, req.ItemWeight)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		req.Amount = amount
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	// Validate amount, distinguishing zero from negative for clients
+	if err := calculator.ValidateAmount(req.Amount); err != nil {
+		respondAmountError(w, err)
 		return
 	}
 
-	if req.Size < 1 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Size must be at least 1"})
+	// max_packs trades overshoot for fewer packs. Stock-limited results and tied
+	// alternatives are computed without a cap, so neither combines with it.
+	if req.MaxPacks < 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "max_packs must not be negative"})
+		return
+	}
+	if req.MaxPacks > 0 && (respectStock || variants) {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "max_packs cannot be combined with respect_stock or variants"})
 		return
 	}
 
-	// Check if pack size already exists
-	exists, err := h.repo.PackSizeExists(req.Size)
+	// cache_ttl_seconds overrides CacheTTL for this result, up to maxRequestCacheTTL;
+	// 0 leaves it uncached
+	cacheTTL, err := h.requestCacheTTL(req.CacheTTLSeconds)
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to check pack size"})
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 		return
 	}
 
-	if exists {
-		respondJSON(w, http.StatusConflict, map[string]string{"error": "Pack size already exists"})
-		return
+	// Optionally round the amount up to a granularity before packing
+	packAmount := req.Amount
+	roundedAmount := 0
+	if req.RoundTo != nil {
+		if *req.RoundTo <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "round_to must be greater than zero"})
+			return
+		}
+		rounded, ok := roundUp(req.Amount, *req.RoundTo)
+		if !ok {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+			})
+			return
+		}
+		packAmount = rounded
+		roundedAmount = packAmount
 	}
 
-	if err := h.repo.AddPackSize(req.Size); err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add pack size"})
+	// Set reasonable upper limit to prevent memory exhaustion
+	if packAmount > maxAmount {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+		})
 		return
 	}
 
-	// Clear cache when pack sizes change
-	h.cache.Clear()
+	// Get pack sizes from database, restricted to one tier with ?tier=, unless the request
+	// supplies its own or selects a profile. Custom and profile sizes have no tiers or
+	// stock, and custom sizes never touch the configuration.
+	store := h.store(r.Context())
+	var records []models.PackSize
+	var tiered bool
+	var packSizes []int
+	var stock map[int]int
+	var readPackSizes func(repository.Store) ([]int, error) // Nil for custom sizes
+	if len(req.PackSizes) > 0 {
+		if tier != "" || respectStock || profile != "" {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "pack_sizes cannot be combined with tier, respect_stock or profile"})
+			return
+		}
+		if packSizes, err = h.requestPackSizes(req.PackSizes); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	} else if profile != "" {
+		if tier != "" || respectStock {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "profile cannot be combined with tier or respect_stock"})
+			return
+		}
+		packSizes, err = store.GetPackSizesAsSlice(profile)
+		if errors.Is(err, repository.ErrProfileNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("Profile %q not found", profile)})
+			return
+		}
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+			return
+		}
+		if len(packSizes) == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("No pack sizes in profile %q", profile)})
+			return
+		}
+		packSizes = sortedCopy(packSizes)
+		readPackSizes = packSetReader(profile, "")
+	} else {
+		records, err = store.GetAllPackSizes()
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+			return
+		}
+		tiered = hasTiers(records)
+		if tier != "" {
+			records = filterTier(records, tier)
+			if len(records) == 0 {
+				respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("No pack sizes in tier %q", tier)})
+				return
+			}
+		}
 
-	respondJSON(w, http.StatusCreated, map[string]string{"message": "Pack size added successfully"})
-}
+		if len(records) == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+			return
+		}
 
-// DeletePackSize handles DELETE /api/packs/{size}
-func (h *Handler) DeletePackSize(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+		// Sorted, distinct sizes so the cache key does not depend on repository order
+		packSizes, stock = sizesAndStock(records)
+		readPackSizes = packSetReader(repository.DefaultProfile, tier)
 	}
 
-	// Extract size from URL path
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid URL"})
-		return
+	// packs_list repeats the packs largest size first; tiered deployments also get them keyed
+	// by (size, tier). The per-size map is unchanged.
+	// With ?debug=1 the trace of how the result was produced is included.
+	trace := &models.CalculationTrace{CacheHit: true}
+	timing := &serverTiming{}
+	var etag string
+	respond := func(result models.PackCalculationResult) {
+		if suggestNext {
+			next, err := h.nextExactFor(ctx, packSizes, req.MaxPacks, result, packAmount)
+			if err != nil {
+				h.respondCalculationError(w, err)
+				return
+			}
+			result.NextExact = next
+		}
+		if variants {
+			alternatives, err := h.alternatives(ctx, packSizes, packAmount, result.Packs)
+			if err != nil {
+				h.respondCalculationError(w, err)
+				return
+			}
+			result.Alternatives = alternatives
+		}
+		result = view.apply(h.withEfficiency(result))
+		result.PacksList = packLines(result.Packs, records)
+		if tiered {
+			result.PackLines = result.PacksList
+		}
+		if debug {
+			result.Trace = trace
+		}
+		if etag != "" {
+			setCalculationCacheHeaders(w, etag)
+		}
+		respondJSONTimed(w, http.StatusOK, result, timing)
 	}
 
-	sizeStr := parts[len(parts)-1]
-	size, err := strconv.Atoi(sizeStr)
-	if err != nil {
-		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid size"})
-		return
+	// Check cache first
+	useCache := !dryRun && !respectStock
+	cacheStart := time.Now()
+	var modes []string
+	if exact {
+		modes = append(modes, cache.ModeExact)
 	}
-
-	if err := h.repo.DeletePackSize(size); err != nil {
-		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
-		return
+	if req.MaxPacks > 0 {
+		modes = append(modes, cache.MaxPacksMode(req.MaxPacks))
 	}
+	cacheKey := h.profileCacheKey(ctx, profile, cache.GenerateCacheKey(packAmount, packSizes, modes...))
 
-	// Clear cache when pack sizes change
-	h.cache.Clear()
-
-	respondJSON(w, http.StatusOK, map[string]string{"message": "Pack size deleted successfully"})
-}
-
-// GetOrders handles GET /api/orders
-func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	// A GET result is fixed by its URL and cache key, so it carries an ETag derived from
+	// the key and a matching If-None-Match is answered before calculating. Saving an
+	// order, live stock levels and debug traces make a response unrepeatable.
+	if r.Method == http.MethodGet {
+		if useCache && !save && !debug {
+			etag = calculationETag(cacheKey)
+			if etagMatches(r.Header.Get("If-None-Match"), etag) {
+				setCalculationCacheHeaders(w, etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+	}
+	var cachedPacks map[int]int
+	var cachedTotal int
+	var found bool
+	negativeKey := h.profileCacheKey(ctx, profile, cache.GenerateNegativeCacheKey(packAmount, packSizes))
+	// The negative cache holds the uncapped closest total, so capped requests skip it
+	useNegativeCache := exact && useCache && req.MaxPacks == 0
+	if useNegativeCache {
+		if _, closest, infeasible := h.cache.Get(negativeKey); infeasible {
+			respondNotExact(w, packAmount, closest)
+			return
+		}
 	}
+	if useCache {
+		cachedPacks, cachedTotal, found = h.cache.Get(cacheKey)
+		timing.add("cache", time.Since(cacheStart))
+	}
+	if found {
+		// Calculate total packs from cached data
+		totalPacks := 0
+		for _, count := range cachedPacks {
+			totalPacks += count
+		}
 
-	// Get limit from query param, default to 100
-	limitStr := r.URL.Query().Get("limit")
-	limit := 100
-	if limitStr != "" {
-		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
-			limit = l
+		result := models.PackCalculationResult{
+			Amount:        req.Amount,
+			TotalItems:    cachedTotal,
+			TotalPacks:    totalPacks,
+			Packs:         cachedPacks,
+			TargetWeight:  req.TargetWeight,
+			ItemWeight:    req.ItemWeight,
+			RoundedAmount: roundedAmount,
 		}
+		if !h.validateResult(w, req, result) {
+			return
+		}
+		// A cache hit is still a real request, so it is recorded like a calculated one
+		if save {
+			h.saveOrder(r.Context(), store, readPackSizes, result, packSizes, timing)
+		}
+		respond(result)
+		return
 	}
 
-	orders, err := h.repo.GetAllOrders(limit)
+	// Calculate optimal packs
+	calc := h.newCalculator(packSizes)
+	if respectStock {
+		calc = calculator.NewCalculatorWithStock(packSizes, stock)
+		calc.SetMemoryBudget(h.config.CalcMemoryBudget)
+	}
+	calc.SetMaxPacks(req.MaxPacks)
+	var packs map[int]int
+	var totalItems, totalPacks int
+	var stats calculator.DPStats
+	calcStart := time.Now()
+	if poolErr := h.runCalculation(ctx, calc.EstimateCost(packAmount), func() {
+		packs, totalItems, totalPacks, stats, err = calc.CalculateWithStatsContext(ctx, packAmount)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	timing.add("calc", time.Since(calcStart))
 	if err != nil {
-		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get orders"})
+		h.calculationErrors.Add(1)
+		h.respondCalculationError(w, err)
 		return
 	}
+	h.calculations.Add(1)
+	h.dpSizes.observe(stats)
+	trace = &models.CalculationTrace{DPMaxTarget: stats.MaxTarget, DPIterations: stats.Iterations}
 
+	if exact && totalItems != packAmount {
+		if useNegativeCache && cacheTTL > 0 {
+			h.cache.Set(negativeKey, nil, totalItems, cacheTTL)
+		}
+		respondNotExact(w, packAmount, totalItems)
+		return
+	}
+
+	// Create result
+	result := models.PackCalculationResult{
+		Amount:        req.Amount,
+		TotalItems:    totalItems,
+		TotalPacks:    totalPacks,
+		Packs:         packs,
+		TargetWeight:  req.TargetWeight,
+		ItemWeight:    req.ItemWeight,
+		RoundedAmount: roundedAmount,
+	}
+	if !h.validateResult(w, req, result) {
+		return
+	}
+
+	if dryRun {
+		respond(result)
+		return
+	}
+
+	// Cache the result
+	if useCache && cacheTTL > 0 {
+		h.cache.Set(cacheKey, packs, totalItems, cacheTTL)
+	}
+
+	if save {
+		h.saveOrder(r.Context(), store, readPackSizes, result, packSizes, timing)
+	}
+	respond(result)
+}
+
+// saveOrder records a calculation as an order, subject to OrderSampleRate. Failures
+// are not reported to the client: the calculation is still valid without its record.
+// Orders packed from a changed set are skipped, as described at saveWithPackSet.
+func (h *Handler) saveOrder(ctx context.Context, store repository.Store, readPackSizes func(repository.Store) ([]int, error), result models.PackCalculationResult, packSizes []int, timing *serverTiming) {
+	if !h.sampleOrder() {
+		return
+	}
+
+	order := &models.Order{
+		Amount:     result.Amount,
+		TotalItems: result.TotalItems,
+		TotalPacks: result.TotalPacks,
+		Packs:      result.Packs,
+		PackSizes:  packSizes,
+	}
+
+	saveStart := time.Now()
+	err := saveWithPackSet(store, readPackSizes, packSizes, func(tx repository.Store) error {
+		return tx.SaveOrder(order)
+	})
+	if errors.Is(err, errPackSetChanged) {
+		logRequest(ctx, slog.LevelWarn, "Skipped order for amount %d: %v", order.Amount, err)
+	} else if err != nil {
+		logRequest(ctx, slog.LevelError, "Failed to save order for amount %d: %v", order.Amount, err)
+	}
+	timing.add("db", time.Since(saveStart))
+}
+
+// errPackSetChanged means the pack sizes an order was packed from changed before it was saved
+var errPackSetChanged = errors.New("pack sizes changed during the calculation")
+
+// saveWithPackSet runs save in a transaction after reading the pack set again with
+// readPackSizes, so an order is never recorded against a set that changed while it
+// was calculated; errPackSetChanged is returned instead. The repository locks the
+// sizes it reads until the transaction ends. A nil readPackSizes, for one-off sizes,
+// skips the check.
+func saveWithPackSet(store repository.Store, readPackSizes func(repository.Store) ([]int, error), packSizes []int, save func(tx repository.Store) error) error {
+	return store.WithTx(func(tx repository.Store) error {
+		if readPackSizes != nil {
+			current, err := readPackSizes(tx)
+			if err != nil {
+				return err
+			}
+			if !slices.Equal(current, packSizes) {
+				return errPackSetChanged
+			}
+		}
+		return save(tx)
+	})
+}
+
+// packSetReader returns a function reading a calculation's pack set: the sizes of
+// profile, or the tenant's pack sizes in tier if one is given
+func packSetReader(profile, tier string) func(repository.Store) ([]int, error) {
+	return func(store repository.Store) ([]int, error) {
+		if tier == "" {
+			return store.GetPackSizesAsSlice(profile)
+		}
+		records, err := store.GetAllPackSizes()
+		if err != nil {
+			return nil, err
+		}
+		sizes, _ := sizesAndStock(filterTier(records, tier))
+		return sizes, nil
+	}
+}
+
+// requestPackSizes validates the pack sizes supplied with a calculation request and
+// returns them sorted. Duplicates are rejected rather than merged, as they are most
+// likely a client mistake, and the custom size policy applies.
+func (h *Handler) requestPackSizes(sizes []int) ([]int, error) {
+	if h.config.MaxPackSizes > 0 && len(sizes) > h.config.MaxPackSizes {
+		return nil, fmt.Errorf("Too many pack sizes. Maximum allowed: %d pack sizes", h.config.MaxPackSizes)
+	}
+	sorted := make([]int, len(sizes))
+	copy(sorted, sizes)
+	sort.Ints(sorted)
+	for i, size := range sorted {
+		if size < 1 {
+			return nil, fmt.Errorf("Pack size must be at least 1, got %d", size)
+		}
+		if i > 0 && sorted[i-1] == size {
+			return nil, fmt.Errorf("Duplicate pack size %d", size)
+		}
+	}
+	if err := h.checkCustomSizes(sorted); err != nil {
+		return nil, err
+	}
+	return sorted, nil
+}
+
+// sampleOrder reports whether the current order should be persisted under OrderSampleRate.
+// The top-level math/rand source is lock-free when unseeded, so this is cheap per request.
+func (h *Handler) sampleOrder() bool {
+	rate := h.config.OrderSampleRate
+	if rate >= 1 {
+		return true
+	}
+	if rate <= 0 {
+		return false
+	}
+	return rand.Float64() < rate
+}
+
+// sizesAndStock returns the sorted distinct sizes of records and the stock of each tracked
+// size. A size listed in several tiers has the tracked stock of all of them.
+func sizesAndStock(records []models.PackSize) ([]int, map[int]int) {
+	seen := make(map[int]bool, len(records))
+	sizes := make([]int, 0, len(records))
+	stock := make(map[int]int)
+	for _, ps := range records {
+		if !seen[ps.Size] {
+			seen[ps.Size] = true
+			sizes = append(sizes, ps.Size)
+		}
+		if ps.Stock != nil {
+			stock[ps.Size] += *ps.Stock
+		}
+	}
+	sort.Ints(sizes)
+	return sizes, stock
+}
+
+// hasTiers reports whether any pack size is assigned to a tier
+func hasTiers(records []models.PackSize) bool {
+	for _, ps := range records {
+		if ps.Tier != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// filterTier returns the pack sizes belonging to tier
+func filterTier(records []models.PackSize, tier string) []models.PackSize {
+	var filtered []models.PackSize
+	for _, ps := range records {
+		if ps.Tier == tier {
+			filtered = append(filtered, ps)
+		}
+	}
+	return filtered
+}
+
+// packLines attributes each size in packs to a tier, largest size first. When a size is
+// listed in several tiers, the alphabetically first tier is used so results are stable.
+func packLines(packs map[int]int, records []models.PackSize) []models.PackLine {
+	if len(packs) == 0 {
+		return nil
+	}
+
+	tierOf := make(map[int]string, len(records))
+	for _, ps := range records {
+		if current, ok := tierOf[ps.Size]; !ok || ps.Tier < current {
+			tierOf[ps.Size] = ps.Tier
+		}
+	}
+
+	lines := make([]models.PackLine, 0, len(packs))
+	for size, count := range packs {
+		lines = append(lines, models.PackLine{Size: size, Tier: tierOf[size], Count: count})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].Size > lines[j].Size })
+	return lines
+}
+
+// withEfficiency fills in efficiency and overshoot, rounded to EfficiencyDecimals
+func (h *Handler) withEfficiency(result models.PackCalculationResult) models.PackCalculationResult {
+	if result.Amount <= 0 || result.TotalItems <= 0 {
+		return result
+	}
+	scale := math.Pow10(h.config.EfficiencyDecimals)
+	round := func(v float64) float64 { return math.Round(v*scale) / scale }
+
+	result.Overshoot = result.TotalItems - result.Amount
+	result.Efficiency = round(float64(result.Amount) / float64(result.TotalItems))
+	result.OvershootPercent = round(float64(result.Overshoot) / float64(result.Amount) * 100)
+	return result
+}
+
+// nextExact returns the zero-waste suggestion for result, or nil if it has no overshoot.
+// The optimal total is by construction the smallest reachable total at or above the
+// amount, so it is itself the next exactly packable amount, with the same packs.
+func nextExact(result models.PackCalculationResult) *models.NextExact {
+	if result.TotalItems <= result.Amount {
+		return nil
+	}
+	return &models.NextExact{
+		Amount: result.TotalItems,
+		Total:  result.TotalItems,
+		Extra:  result.TotalItems - result.Amount,
+		Packs:  result.Packs,
+	}
+}
+
+// nextExactFor returns the zero-waste suggestion for result, which was packed for
+// packAmount. With round_to that is the rounded amount, whose total can lie well past
+// the smallest reachable total at or above the requested amount, so the suggestion is
+// calculated from the requested amount instead.
+func (h *Handler) nextExactFor(ctx context.Context, packSizes []int, maxPacks int, result models.PackCalculationResult, packAmount int) (*models.NextExact, error) {
+	if packAmount == result.Amount {
+		return nextExact(result), nil
+	}
+
+	calc := h.newCalculator(packSizes)
+	calc.SetMaxPacks(maxPacks)
+	var packs map[int]int
+	var total int
+	var err error
+	if poolErr := h.runCalculation(ctx, calc.EstimateCost(result.Amount), func() {
+		packs, total, err = calc.CalculateContext(ctx, result.Amount)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nextExact(models.PackCalculationResult{Amount: result.Amount, TotalItems: total, Packs: packs}), nil
+}
+
+// alternatives returns the optimal combinations for amount other than packs. Enumerating
+// them runs its own DP, so like any calculation it honours ctx's deadline and the pool.
+func (h *Handler) alternatives(ctx context.Context, packSizes []int, amount int, packs map[int]int) ([]models.PackAlternative, error) {
+	calc := h.newCalculator(packSizes)
+	var solutions []calculator.Solution
+	var err error
+	if poolErr := h.runCalculation(ctx, calc.EstimateAllCost(amount), func() {
+		solutions, err = calc.CalculateAllContext(ctx, amount)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	alternatives := make([]models.PackAlternative, 0, len(solutions))
+	for _, solution := range solutions {
+		if reflect.DeepEqual(solution.Packs, packs) {
+			continue
+		}
+		alternatives = append(alternatives, models.PackAlternative{
+			Packs:      solution.Packs,
+			TotalItems: solution.TotalItems,
+			TotalPacks: solution.TotalPacks,
+		})
+	}
+	return alternatives, nil
+}
+
+// roundUp returns the smallest multiple of step that is >= amount. ok is false when
+// that multiple does not fit in an int.
+func roundUp(amount, step int) (rounded int, ok bool) {
+	multiples := amount / step
+	if amount%step != 0 {
+		if multiples >= math.MaxInt/step {
+			return 0, false
+		}
+		multiples++
+	}
+	return multiples * step, true
+}
+
+// resultView controls how much of a calculation result is returned.
+// Totals are always reported in full; only the per-size breakdown is reduced.
+type resultView struct {
+	summaryOnly bool // Omit the per-size packs map
+	maxLines    int  // Keep at most this many pack sizes, largest first (0 = all)
+}
+
+// parseResultView reads ?summary_only=1 and ?max_lines=N
+func parseResultView(r *http.Request) (resultView, error) {
+	var view resultView
+	query := r.URL.Query()
+
+	summaryOnly, err := parseFlag(query, "summary_only")
+	if err != nil {
+		return view, err
+	}
+	view.summaryOnly = summaryOnly
+
+	if maxStr := query.Get("max_lines"); maxStr != "" {
+		n, err := strconv.Atoi(maxStr)
+		if err != nil || n < 1 {
+			return view, errors.New("max_lines must be a positive integer")
+		}
+		view.maxLines = n
+	}
+
+	return view, nil
+}
+
+// decodeCalculationRequest reads a calculation request. Clients that cannot send JSON
+// may instead give only the amount, in an application/x-www-form-urlencoded body or,
+// with no body, as ?amount=. Any other request is decoded as JSON.
+func decodeCalculationRequest(r *http.Request) (models.PackCalculationRequest, error) {
+	var req models.PackCalculationRequest
+	if r.Method == http.MethodGet {
+		amount, err := formAmount(r.URL.Query())
+		req.Amount = amount
+		return req, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/x-www-form-urlencoded" {
+		if err := r.ParseForm(); err != nil {
+			return req, errors.New("Invalid form body")
+		}
+		if r.URL.Query().Has("amount") {
+			return req, errors.New("Specify amount in the query string or the body, not both")
+		}
+		amount, err := formAmount(r.PostForm)
+		req.Amount = amount
+		return req, err
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if r.URL.Query().Has("amount") {
+		if err != io.EOF {
+			return models.PackCalculationRequest{}, errors.New("Specify amount in the query string or the body, not both")
+		}
+		amount, err := formAmount(r.URL.Query())
+		req.Amount = amount
+		return req, err
+	}
+	if err != nil {
+		return req, errors.New("Invalid request body")
+	}
+	return req, nil
+}
+
+// calculationMaxAge is how long clients and CDNs may reuse a GET calculation without
+// revalidating. It bounds how stale a result can be after the pack sizes change;
+// revalidating with the ETag costs no calculation.
+const calculationMaxAge = time.Minute
+
+// calculationETag derives a weak ETag from a calculation's cache key. It is weak as
+// the compression middleware may change the encoding of the same result.
+func calculationETag(cacheKey string) string {
+	sum := sha256.Sum256([]byte(cacheKey))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, using the weak
+// comparison RFC 9110 requires for If-None-Match
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// setCalculationCacheHeaders marks a GET calculation cacheable by URL. Results differ
+// per tenant, which the API key selects, so shared caches must key on the tenant and
+// API key headers too.
+func setCalculationCacheHeaders(w http.ResponseWriter, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(calculationMaxAge.Seconds())))
+	w.Header().Add("Vary", middleware.TenantHeader)
+	w.Header().Add("Vary", "X-API-Key")
+}
+
+// formAmount reads the integer amount from form or query values
+func formAmount(values url.Values) (int, error) {
+	if !values.Has("amount") {
+		return 0, errors.New("amount is required")
+	}
+	amount, err := strconv.Atoi(strings.TrimSpace(values.Get("amount")))
+	if err != nil {
+		return 0, errors.New("amount must be an integer")
+	}
+	return amount, nil
+}
+
+// parseFlag reads a boolean query parameter accepting 1/0 or true/false; absent means false
+func parseFlag(query url.Values, name string) (bool, error) {
+	switch query.Get(name) {
+	case "", "0", "false":
+		return false, nil
+	case "1", "true":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s must be 1 or 0", name)
+	}
+}
+
+// apply returns a copy of result reduced according to the view, without mutating cached maps
+func (v resultView) apply(result models.PackCalculationResult) models.PackCalculationResult {
+	if v.summaryOnly {
+		result.Packs = nil
+		result.Alternatives = nil // Identical totals; only the breakdown differs
+		if result.NextExact != nil {
+			next := *result.NextExact
+			next.Packs = nil
+			result.NextExact = &next
+		}
+		return result
+	}
+	if v.maxLines == 0 || len(result.Packs) <= v.maxLines {
+		return result
+	}
+
+	sizes := make([]int, 0, len(result.Packs))
+	for size := range result.Packs {
+		sizes = append(sizes, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	packs := make(map[int]int, v.maxLines)
+	for _, size := range sizes[:v.maxLines] {
+		packs[size] = result.Packs[size]
+	}
+	result.OmittedLines = len(sizes) - v.maxLines
+	result.Packs = packs
+	return result
+}
+
+// CheckFeasibility handles POST /api/calculate/feasibility. Like the other calculations
+// it runs on the calculation pool within the X-Calc-Budget or CalcTimeout deadline.
+func (h *Handler) CheckFeasibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	budget, err := h.calculationBudget(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+
+	var req struct {
+		Amounts []int `json:"amounts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Amounts) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one amount is required"})
+		return
+	}
+	if len(req.Amounts) > maxFeasibilityAmounts {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many amounts. Maximum allowed: %d", maxFeasibilityAmounts),
+		})
+		return
+	}
+	for _, amount := range req.Amounts {
+		if err := calculator.ValidateAmount(amount); err != nil {
+			respondAmountError(w, err)
+			return
+		}
+		if amount > maxAmount {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+			})
+			return
+		}
+	}
+
+	packSizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+
+	// Queued on the calculation pool and bounded by the budget like any other DP
+	calc := h.newCalculator(packSizes)
+	var results []calculator.Feasibility
+	if poolErr := h.runCalculation(ctx, calc.EstimateFeasibilityCost(req.Amounts), func() {
+		results, err = calc.CheckFeasibilityContext(ctx, req.Amounts)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	if err != nil {
+		h.calculationErrors.Add(1)
+		h.respondCalculationError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+// CalculateFast handles POST /api/calculate/fast. It uses the approximate greedy
+// calculation: the total meets the amount and is less than one largest pack above the
+// optimum, but may send more items or packs than /api/calculate. Results are flagged
+// approximate and are neither cached nor saved as orders.
+func (h *Handler) CalculateFast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.PackCalculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if err := calculator.ValidateAmount(req.Amount); err != nil {
+		respondAmountError(w, err)
+		return
+	}
+	if req.Amount > maxFastAmount {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxFastAmount),
+		})
+		return
+	}
+
+	packSizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.CalcTimeout)
+	defer cancel()
+	packs, totalItems, err := h.newCalculator(packSizes).CalculateGreedyContext(ctx, req.Amount)
+	if err != nil {
+		h.respondCalculationError(w, err)
+		return
+	}
+
+	totalPacks := 0
+	for _, count := range packs {
+		totalPacks += count
+	}
+	respondJSON(w, http.StatusOK, h.withEfficiency(models.PackCalculationResult{
+		Amount:      req.Amount,
+		TotalItems:  totalItems,
+		TotalPacks:  totalPacks,
+		Packs:       packs,
+		Approximate: true,
+	}))
+}
+
+// CalculateConsolidated handles POST /api/calculate/consolidated. It packs each order
+// amount separately and also packs their sum, reporting the items and packs saved by
+// shipping the orders together. Results are cached but no orders are saved.
+func (h *Handler) CalculateConsolidated(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	budget, err := h.calculationBudget(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+
+	var req models.ConsolidatedRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Amounts) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one amount is required"})
+		return
+	}
+	if len(req.Amounts) > maxConsolidatedAmounts {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many amounts. Maximum allowed: %d", maxConsolidatedAmounts),
+		})
+		return
+	}
+	sum := 0
+	for _, amount := range req.Amounts {
+		if err := calculator.ValidateAmount(amount); err != nil {
+			respondAmountError(w, err)
+			return
+		}
+		sum += amount
+		if amount > maxAmount || sum > maxAmount {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items in total", maxAmount),
+			})
+			return
+		}
+	}
+
+	packSizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+	packSizes = sortedCopy(packSizes)
+	calc := h.newCalculator(packSizes)
+
+	response := models.ConsolidatedResult{Orders: make([]models.PackCalculationResult, 0, len(req.Amounts))}
+	for _, amount := range req.Amounts {
+		result, err := h.calculateCached(ctx, calc, packSizes, amount)
+		if err != nil {
+			h.respondCalculationError(w, err)
+			return
+		}
+		response.Orders = append(response.Orders, result)
+		response.SeparateTotalItems += result.TotalItems
+		response.SeparateTotalPacks += result.TotalPacks
+	}
+
+	response.Consolidated, err = h.calculateCached(ctx, calc, packSizes, sum)
+	if err != nil {
+		h.respondCalculationError(w, err)
+		return
+	}
+	response.ItemsSaved = response.SeparateTotalItems - response.Consolidated.TotalItems
+	response.PacksSaved = response.SeparateTotalPacks - response.Consolidated.TotalPacks
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// CalculateBatch handles POST /api/calculate/batch, packing each amount independently
+// and returning the results in request order. One calculator serves the whole batch and
+// each amount is looked up in the cache first. An amount that is invalid or over the
+// memory budget gets a result carrying only its error; other failures, such as the time
+// budget running out, fail the batch. As for single calculations, StrictExact rejects
+// amounts that overshoot unless ?allow_overshoot=1 is given, and the result validator
+// runs on each result; rejected amounts also get an error result and are not saved.
+// Orders are saved in one transaction, unless the pack sizes changed meanwhile.
+func (h *Handler) CalculateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	budget, err := h.calculationBudget(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+
+	allowOvershoot, err := parseFlag(r.URL.Query(), "allow_overshoot")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	exact := h.config.StrictExact && !allowOvershoot
+
+	var req models.BatchCalculationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Amounts) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one amount is required"})
+		return
+	}
+	if len(req.Amounts) > h.config.MaxBatchAmounts {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many amounts. Maximum allowed: %d", h.config.MaxBatchAmounts),
+		})
+		return
+	}
+
+	store := h.store(r.Context())
+	packSizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+	packSizes = sortedCopy(packSizes)
+	calc := h.newCalculator(packSizes)
+
+	results := make([]models.PackCalculationResult, len(req.Amounts))
+	var orders []*models.Order
+	for i, amount := range req.Amounts {
+		if err := calculator.ValidateAmount(amount); err != nil {
+			results[i] = models.PackCalculationResult{Amount: amount, Error: err.Error()}
+			continue
+		}
+		if amount > maxAmount {
+			results[i] = models.PackCalculationResult{Amount: amount, Error: fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount)}
+			continue
+		}
+
+		result, err := h.calculateCached(ctx, calc, packSizes, amount)
+		if errors.Is(err, calculator.ErrMemoryBudgetExceeded) {
+			results[i] = models.PackCalculationResult{Amount: amount, Error: err.Error()}
+			continue
+		}
+		if err != nil {
+			h.respondCalculationError(w, err)
+			return
+		}
+		if exact && result.TotalItems != amount {
+			results[i] = models.PackCalculationResult{Amount: amount, Error: notExactMessage(amount, result.TotalItems)}
+			continue
+		}
+		if h.validator != nil {
+			if err := h.validator(models.PackCalculationRequest{Amount: amount}, result); err != nil {
+				results[i] = models.PackCalculationResult{Amount: amount, Error: err.Error()}
+				continue
+			}
+		}
+		results[i] = result
+
+		if h.sampleOrder() {
+			orders = append(orders, &models.Order{
+				Amount:     amount,
+				TotalItems: result.TotalItems,
+				TotalPacks: result.TotalPacks,
+				Packs:      result.Packs,
+				PackSizes:  packSizes,
+			})
+		}
+	}
+
+	// As for single calculations, a failed save does not fail the request
+	if len(orders) == 0 {
+		respondJSON(w, http.StatusOK, results)
+		return
+	}
+	err = saveWithPackSet(store, packSetReader(repository.DefaultProfile, ""), packSizes, func(tx repository.Store) error {
+		return tx.SaveOrdersContext(r.Context(), orders)
+	})
+	if errors.Is(err, errPackSetChanged) {
+		logRequest(r.Context(), slog.LevelWarn, "Skipped %d batch orders: %v", len(orders), err)
+	} else if err != nil {
+		logRequest(r.Context(), slog.LevelError, "Failed to save %d batch orders: %v", len(orders), err)
+	}
+
+	respondJSON(w, http.StatusOK, results)
+}
+
+// ComparePackSets handles POST /api/packs/compare. It packs a sample of amounts with the
+// current pack sizes and with a proposed set, reporting aggregate overshoot, pack counts
+// and efficiency for each. The sample is the request's amounts, or else the amounts of
+// the most recent orders. Comparisons estimated above maxCompareCost are rejected. Only
+// the current set's results are cached, and no orders are saved.
+func (h *Handler) ComparePackSets(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	budget, err := h.calculationBudget(r)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), budget)
+	defer cancel()
+
+	var req models.PackSetCompareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	if len(req.Proposed) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "At least one proposed pack size is required"})
+		return
+	}
+	if err := h.checkCustomSizes(req.Proposed); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	seen := make(map[int]bool, len(req.Proposed))
+	proposed := make([]int, 0, len(req.Proposed))
+	for _, size := range req.Proposed {
+		if !seen[size] {
+			seen[size] = true
+			proposed = append(proposed, size)
+		}
+	}
+	if h.config.MaxPackSizes > 0 && len(proposed) > h.config.MaxPackSizes {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many proposed pack sizes. Maximum allowed: %d pack sizes", h.config.MaxPackSizes),
+		})
+		return
+	}
+
+	if len(req.Amounts) > maxCompareAmounts {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many amounts. Maximum allowed: %d", maxCompareAmounts),
+		})
+		return
+	}
+	for _, amount := range req.Amounts {
+		if err := calculator.ValidateAmount(amount); err != nil {
+			respondAmountError(w, err)
+			return
+		}
+		if amount > maxAmount {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+			})
+			return
+		}
+	}
+
+	store := h.store(r.Context())
+	response := models.PackSetComparison{SampleSource: "request"}
+	amounts := req.Amounts
+	if len(amounts) == 0 {
+		response.SampleSource = "orders"
+		orders, err := store.GetAllOrders(maxCompareAmounts)
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get orders"})
+			return
+		}
+		// Repeated amounts are kept so the sample reflects how often each is ordered
+		for _, order := range orders {
+			if order.Amount > 0 && order.Amount <= maxAmount {
+				amounts = append(amounts, order.Amount)
+			}
+		}
+		if len(amounts) == 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No amounts given and no orders to sample"})
+			return
+		}
+	}
+	response.SampleSize = len(amounts)
+
+	current, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(current) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+
+	// Both sets are packed for every amount, so the whole comparison is bounded up front
+	current, proposed = sortedCopy(current), sortedCopy(proposed)
+	currentCalc, proposedCalc := h.newCalculator(current), h.newCalculator(proposed)
+	var cost int64
+	for _, amount := range amounts {
+		cost += currentCalc.EstimateCost(amount) + proposedCalc.EstimateCost(amount)
+	}
+	if cost > maxCompareCost {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Comparison too expensive. Use fewer or smaller amounts, or larger pack sizes",
+		})
+		return
+	}
+
+	if response.Current, err = h.packSetMetrics(ctx, currentCalc, current, amounts, true); err != nil {
+		h.respondCalculationError(w, err)
+		return
+	}
+	// Proposed sets are arbitrary client input, so their results stay out of the shared cache
+	if response.Proposed, err = h.packSetMetrics(ctx, proposedCalc, proposed, amounts, false); err != nil {
+		h.respondCalculationError(w, err)
+		return
+	}
+
+	respondJSON(w, http.StatusOK, response)
+}
+
+// checkCustomSizes enforces the custom size policy on client-supplied pack sizes:
+// each must be at least CustomSizeMin and, if an allow-list is configured, on it.
+// Tiny sizes are what make a what-if calculation expensive, so they are bounded here.
+func (h *Handler) checkCustomSizes(sizes []int) error {
+	minSize := h.config.CustomSizeMin
+	if minSize < 1 {
+		minSize = 1
+	}
+	for _, size := range sizes {
+		if size < minSize {
+			return fmt.Errorf("Size %d is below the minimum custom pack size of %d", size, minSize)
+		}
+		if len(h.config.CustomSizesAllowed) == 0 {
+			continue
+		}
+		allowed := false
+		for _, a := range h.config.CustomSizesAllowed {
+			if a == size {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("Size %d is not an allowed custom pack size", size)
+		}
+	}
+	return nil
+}
+
+// packSetMetrics packs every amount with packSizes (sorted, matching calc) and aggregates
+// the results, rounding averages and efficiency to EfficiencyDecimals. Results are read
+// from and written to the cache only if useCache is set.
+func (h *Handler) packSetMetrics(ctx context.Context, calc *calculator.Calculator, packSizes []int, amounts []int, useCache bool) (models.PackSetMetrics, error) {
+	metrics := models.PackSetMetrics{PackSizes: packSizes}
+
+	amountSum := 0
+	for _, amount := range amounts {
+		var result models.PackCalculationResult
+		var err error
+		if useCache {
+			result, err = h.calculateCached(ctx, calc, packSizes, amount)
+		} else {
+			result, err = h.calculate(ctx, calc, amount)
+		}
+		if err != nil {
+			return models.PackSetMetrics{}, err
+		}
+		amountSum += amount
+		metrics.TotalItems += result.TotalItems
+		metrics.TotalPacks += result.TotalPacks
+		if result.TotalItems == amount {
+			metrics.ExactCount++
+		}
+	}
+
+	scale := math.Pow10(h.config.EfficiencyDecimals)
+	round := func(v float64) float64 { return math.Round(v*scale) / scale }
+	n := float64(len(amounts))
+	metrics.AverageOvershoot = round(float64(metrics.TotalItems-amountSum) / n)
+	metrics.AveragePacks = round(float64(metrics.TotalPacks) / n)
+	metrics.Efficiency = round(float64(amountSum) / float64(metrics.TotalItems))
+	return metrics, nil
+}
+
+// calculateCached returns the result for amount from the cache, or computes and caches it.
+// packSizes must be sorted and match calc.
+func (h *Handler) calculateCached(ctx context.Context, calc *calculator.Calculator, packSizes []int, amount int) (models.PackCalculationResult, error) {
+	cacheKey := h.cacheKey(ctx, cache.GenerateCacheKey(amount, packSizes))
+	if packs, total, found := h.cache.Get(cacheKey); found {
+		totalPacks := 0
+		for _, count := range packs {
+			totalPacks += count
+		}
+		return h.withEfficiency(models.PackCalculationResult{Amount: amount, TotalItems: total, TotalPacks: totalPacks, Packs: packs}), nil
+	}
+
+	result, err := h.calculate(ctx, calc, amount)
+	if err != nil {
+		return models.PackCalculationResult{}, err
+	}
+	h.cache.Set(cacheKey, result.Packs, result.TotalItems, h.config.CacheTTL)
+	return result, nil
+}
+
+// calculate computes the result for amount on the calculation pool, bypassing the cache
+func (h *Handler) calculate(ctx context.Context, calc *calculator.Calculator, amount int) (models.PackCalculationResult, error) {
+	var packs map[int]int
+	var totalItems, totalPacks int
+	var stats calculator.DPStats
+	var err error
+	if poolErr := h.runCalculation(ctx, calc.EstimateCost(amount), func() {
+		packs, totalItems, totalPacks, stats, err = calc.CalculateWithStatsContext(ctx, amount)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	if err != nil {
+		h.calculationErrors.Add(1)
+		return models.PackCalculationResult{}, err
+	}
+	h.calculations.Add(1)
+	h.dpSizes.observe(stats)
+
+	return h.withEfficiency(models.PackCalculationResult{Amount: amount, TotalItems: totalItems, TotalPacks: totalPacks, Packs: packs}), nil
+}
+
+// StreamCalculationRange handles GET /api/calculate/range/stream?lo=&hi=
+// It solves the whole range in one DP pass, then backtracks and emits one Server-Sent
+// Event per amount as it goes, followed by a "done" event.
+func (h *Handler) StreamCalculationRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	lo, errLo := strconv.Atoi(r.URL.Query().Get("lo"))
+	hi, errHi := strconv.Atoi(r.URL.Query().Get("hi"))
+	if errLo != nil || errHi != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "lo and hi must be integers"})
+		return
+	}
+	if err := calculator.ValidateAmount(lo); err != nil {
+		respondAmountError(w, err)
+		return
+	}
+	if hi < lo {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "hi must be greater than or equal to lo"})
+		return
+	}
+	if hi > maxAmount {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+		})
+		return
+	}
+	if hi-lo+1 > maxStreamRange {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Range too large. Maximum allowed: %d amounts", maxStreamRange),
+		})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Streaming not supported"})
+		return
+	}
+
+	packSizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+
+	// One DP covers the whole range; it runs on the pool like any other calculation and
+	// before the stream starts, so failures are still reported as JSON errors
+	calc := h.newCalculator(packSizes)
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.CalcTimeout)
+	defer cancel()
+	var table *calculator.RangeTable
+	if poolErr := h.runCalculation(ctx, calc.EstimateRangeCost(lo, hi), func() {
+		table, err = calc.SolveRange(ctx, lo, hi)
+	}); poolErr != nil {
+		err = poolErr
+	}
+	if err != nil {
+		h.calculationErrors.Add(1)
+		h.respondCalculationError(w, err)
+		return
+	}
+	h.calculations.Add(1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for amount := lo; amount <= hi; amount++ {
+		// Stop backtracking and writing as soon as the client goes away
+		select {
+		case <-r.Context().Done():
+			return
+		default:
+		}
+		result := table.Result(amount)
+		writeSSE(w, "", models.PackCalculationResult{
+			Amount:     result.Amount,
+			TotalItems: result.TotalItems,
+			TotalPacks: result.TotalPacks,
+			Packs:      result.Packs,
+		})
+		flusher.Flush()
+	}
+
+	writeSSE(w, "done", map[string]int{"count": hi - lo + 1})
+	flusher.Flush()
+}
+
+// CalculationSlip handles GET /api/calculate/slip?amount=N and returns a printable packing slip PDF.
+// The calculation goes through the cache like /api/calculate but is not saved as an order.
+func (h *Handler) CalculationSlip(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	amount, err := strconv.Atoi(r.URL.Query().Get("amount"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "amount must be an integer"})
+		return
+	}
+	if err := calculator.ValidateAmount(amount); err != nil {
+		respondAmountError(w, err)
+		return
+	}
+	if amount > maxAmount {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Amount too large. Maximum allowed: %d items", maxAmount),
+		})
+		return
+	}
+
+	packSizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	if len(packSizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "No pack sizes configured"})
+		return
+	}
+	packSizes = sortedCopy(packSizes)
+
+	cacheKey := h.cacheKey(r.Context(), cache.GenerateCacheKey(amount, packSizes))
+	packs, totalItems, found := h.cache.Get(cacheKey)
+	if !found {
+		calc := h.newCalculator(packSizes)
+		ctx, cancel := context.WithTimeout(r.Context(), h.config.CalcTimeout)
+		defer cancel()
+		if poolErr := h.runCalculation(ctx, calc.EstimateCost(amount), func() {
+			packs, totalItems, err = calc.CalculateContext(ctx, amount)
+		}); poolErr != nil {
+			err = poolErr
+		}
+		if err != nil {
+			h.respondCalculationError(w, err)
+			return
+		}
+		h.cache.Set(cacheKey, packs, totalItems, h.config.CacheTTL)
+	}
+
+	totalPacks := 0
+	for _, count := range packs {
+		totalPacks += count
+	}
+
+	pdf := slip.Render(models.PackCalculationResult{
+		Amount:     amount,
+		TotalItems: totalItems,
+		TotalPacks: totalPacks,
+		Packs:      packs,
+	})
+
+	w.Header().Set("Content-Type", slip.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="packing-slip-%d.pdf"`, amount))
+	w.Header().Set("Content-Length", strconv.Itoa(len(pdf)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(pdf)
+}
+
+// writeSSE writes a single Server-Sent Event with a JSON data payload.
+// An empty event name produces a default "message" event.
+func writeSSE(w http.ResponseWriter, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		fmt.Printf("Error encoding event: %v\n", err)
+		return
+	}
+	if event != "" {
+		fmt.Fprintf(w, "event: %s\n", event)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", payload)
+}
+
+// GetPackSizes handles GET /api/packs
+func (h *Handler) GetPackSizes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// ?include_deleted=true also lists soft-deleted sizes, with deleted_at set; cmd/api
+	// only lets admins through with it
+	includeDeleted, err := parseFlag(r.URL.Query(), "include_deleted")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// ?include=usage annotates each size with when an order last used it
+	switch include := r.URL.Query().Get("include"); include {
+	case "":
+	case "usage":
+		if includeDeleted {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "include=usage cannot be combined with include_deleted"})
+			return
+		}
+		usage, err := h.store(r.Context()).GetPackSizesWithUsage()
+		if err != nil {
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+			return
+		}
+		h.setPackSizeHeaders(w, len(usage))
+		respondJSON(w, http.StatusOK, usage)
+		return
+	default:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown include %q; supported: usage", include)})
+		return
+	}
+
+	var packSizes []models.PackSize
+	if includeDeleted {
+		packSizes, err = h.store(r.Context()).GetAllPackSizesIncludingDeleted()
+	} else {
+		packSizes, err = h.store(r.Context()).GetAllPackSizes()
+	}
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	h.setPackSizeHeaders(w, len(packSizes))
+	respondJSON(w, http.StatusOK, packSizes)
+}
+
+// setPackSizeHeaders reports the count and limit as headers to keep the body a plain array
+func (h *Handler) setPackSizeHeaders(w http.ResponseWriter, count int) {
+	w.Header().Set("X-Pack-Size-Count", strconv.Itoa(count))
+	if h.config.MaxPackSizes > 0 {
+		w.Header().Set("X-Pack-Size-Limit", strconv.Itoa(h.config.MaxPackSizes))
+	}
+}
+
+// AddPackSize handles POST /api/packs
+func (h *Handler) AddPackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.AddPackSizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	req.Normalize()
+	if err := req.Validate(); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	// The current set is needed both for the limit and to scope cache invalidation. It is
+	// read under the pack size lock, so the limit still holds when the insert commits.
+	var sizes []int
+	var created models.PackSize
+	var invalid error
+	err := h.store(r.Context()).WithTx(func(tx repository.Store) error {
+		if err := tx.LockPackSizes(); err != nil {
+			return err
+		}
+		var err error
+		if sizes, err = tx.GetPackSizesAsSlice(repository.DefaultProfile); err != nil {
+			return err
+		}
+		if h.config.MaxPackSizes > 0 && len(sizes) >= h.config.MaxPackSizes {
+			return errPackSizeLimit
+		}
+		if invalid = h.checkPackSize(sizes, req.Size); invalid != nil {
+			return invalid
+		}
+
+		// Rely on the unique constraint rather than a pre-check to avoid a check-then-insert race
+		created, err = tx.AddPackSizeWithDetails(req)
+		return err
+	})
+	switch {
+	case invalid != nil:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": invalid.Error()})
+		return
+	case errors.Is(err, errPackSizeLimit):
+		respondJSON(w, http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Pack size limit reached. Maximum allowed: %d pack sizes", h.config.MaxPackSizes),
+		})
+		return
+	case errors.Is(err, repository.ErrPackSizeExists):
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Pack size already exists"})
+		return
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add pack size"})
+		return
+	}
+
+	h.invalidatePackSet(r.Context(), sizes)
+	h.notifyPackSizeChange(webhook.EventPackSizeAdded, req.Size, 0)
+
+	w.Header().Set("Location", "/api/packs/"+strconv.Itoa(created.Size))
+	respondJSON(w, http.StatusCreated, created)
+}
+
+// GetPackSize handles GET /api/packs/{size}, the Location of a created pack size.
+// Deleted sizes are not found.
+func (h *Handler) GetPackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	size, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/packs/"))
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid size"})
+		return
+	}
+
+	packSizes, err := h.store(r.Context()).GetAllPackSizes()
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+	for _, ps := range packSizes {
+		if ps.Size == size {
+			respondJSON(w, http.StatusOK, ps)
+			return
+		}
+	}
+	respondJSON(w, http.StatusNotFound, map[string]string{"error": "Pack size not found"})
+}
+
+// errPackSizeLimit rolls back an addition that would take the pack sizes past MaxPackSizes
+var errPackSizeLimit = errors.New("pack size limit reached")
+
+// maxBulkPackSizes caps the sizes in one POST /api/packs/bulk request
+const maxBulkPackSizes = 1000
+
+// BulkAddPackSizes handles POST /api/packs/bulk with {"sizes": [...]}. Sizes that are
+// already configured, or repeated in the request, are skipped; the rest are added in a
+// single transaction, so an invalid size or a failed insert adds none of them. The
+// cache is invalidated once for the whole batch.
+func (h *Handler) BulkAddPackSizes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.BulkAddPackSizesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Sizes) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "sizes must not be empty"})
+		return
+	}
+	if len(req.Sizes) > maxBulkPackSizes {
+		respondJSON(w, http.StatusBadRequest, map[string]string{
+			"error": fmt.Sprintf("Too many sizes. Maximum allowed: %d per request", maxBulkPackSizes),
+		})
+		return
+	}
+
+	for _, size := range req.Sizes {
+		if size < 1 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Pack size must be at least 1, got %d", size)})
+			return
+		}
+	}
+
+	// Like AddPackSize, the limit is checked on a set read under the pack size lock
+	var sizes []int
+	var summary models.BulkAddPackSizesSummary
+	var invalid error
+	err := h.store(r.Context()).WithTx(func(tx repository.Store) error {
+		if err := tx.LockPackSizes(); err != nil {
+			return err
+		}
+		var err error
+		if sizes, err = tx.GetPackSizesAsSlice(repository.DefaultProfile); err != nil {
+			return err
+		}
+
+		summary = models.BulkAddPackSizesSummary{Added: []int{}, Skipped: []int{}}
+		current := append([]int(nil), sizes...)
+		for _, size := range req.Sizes {
+			if containsSize(current, size) {
+				summary.Skipped = append(summary.Skipped, size)
+				continue
+			}
+			if invalid = h.checkPackSize(current, size); invalid != nil {
+				return invalid
+			}
+			current = append(current, size)
+			summary.Added = append(summary.Added, size)
+		}
+		if h.config.MaxPackSizes > 0 && len(current) > h.config.MaxPackSizes {
+			return errPackSizeLimit
+		}
+		return tx.AddPackSizes(summary.Added)
+	})
+	switch {
+	case invalid != nil:
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": invalid.Error()})
+		return
+	case errors.Is(err, errPackSizeLimit):
+		respondJSON(w, http.StatusConflict, map[string]string{
+			"error": fmt.Sprintf("Pack size limit reached. Maximum allowed: %d pack sizes", h.config.MaxPackSizes),
+		})
+		return
+	case errors.Is(err, repository.ErrPackSizeExists):
+		// Added since the sizes were read without the lock, e.g. by an import; retrying skips it
+		respondJSON(w, http.StatusConflict, map[string]string{"error": "Pack sizes changed concurrently, please retry"})
+		return
+	case err != nil:
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to add pack sizes"})
+		return
+	}
+
+	if len(summary.Added) > 0 {
+		h.invalidatePackSet(r.Context(), sizes)
+		for _, size := range summary.Added {
+			h.notifyPackSizeChange(webhook.EventPackSizeAdded, size, 0)
+		}
+	}
+
+	respondJSON(w, http.StatusOK, summary)
+}
+
+// checkPackSize returns a user-facing error if size may not join sizes: it is over
+// MaxPackSize, or the resulting set would let a calculation for some amount up to
+// maxAmount exceed CalcMemoryBudget
+func (h *Handler) checkPackSize(sizes []int, size int) error {
+	if size > h.config.MaxPackSize {
+		return fmt.Errorf("Pack size too large. Maximum allowed: %d", h.config.MaxPackSize)
+	}
+
+	proposed := []int{size}
+	for _, existing := range sizes {
+		if existing != size {
+			proposed = append(proposed, existing)
+		}
+	}
+	if err := h.newCalculator(proposed).CheckWorstCase(maxAmount); err != nil {
+		return fmt.Errorf("Pack size %d would let calculations for amounts up to %d exceed the memory budget of %d bytes; choose sizes with a larger common divisor",
+			size, maxAmount, h.config.CalcMemoryBudget)
+	}
+	return nil
+}
+
+// ValidatePackSize handles POST /api/packs/validate, a dry run of AddPackSize. It
+// reports whether the size would be accepted, and why not, without adding it.
+func (h *Handler) ValidatePackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.AddPackSizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	sizes, err := h.store(r.Context()).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	result := models.PackSizeValidation{Size: req.Size}
+	req.Normalize()
+	if err := req.Validate(); err != nil {
+		result.Error = err.Error()
+	} else if containsSize(sizes, req.Size) {
+		result.Error = "Pack size already exists"
+	} else if h.config.MaxPackSizes > 0 && len(sizes) >= h.config.MaxPackSizes {
+		result.Error = fmt.Sprintf("Pack size limit reached. Maximum allowed: %d pack sizes", h.config.MaxPackSizes)
+	} else if err := h.checkPackSize(sizes, req.Size); err != nil {
+		result.Error = err.Error()
+	}
+	result.Valid = result.Error == ""
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// DeletePackSize handles DELETE /api/packs/{size}
+func (h *Handler) DeletePackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// Extract size from URL path
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid URL"})
+		return
+	}
+
+	sizeStr := parts[len(parts)-1]
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid size"})
+		return
+	}
+
+	store := h.store(r.Context())
+	sizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	if err := store.DeletePackSize(size); err != nil {
+		respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+
+	h.invalidatePackSet(r.Context(), sizes)
+	h.notifyPackSizeChange(webhook.EventPackSizeDeleted, size, 0)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pack size deleted successfully"})
+}
+
+// RestorePackSize handles POST /api/packs/{size}/restore, undeleting the size's most
+// recently deleted record with its original details and created_at
+func (h *Handler) RestorePackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// Extract size from URL path
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 5 || parts[len(parts)-1] != "restore" {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid URL"})
+		return
+	}
+
+	size, err := strconv.Atoi(parts[len(parts)-2])
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid size"})
+		return
+	}
+
+	store := h.store(r.Context())
+	sizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	if err := store.RestorePackSize(size); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPackSizeExists):
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "Pack size already exists"})
+		case errors.Is(err, repository.ErrPackSizeNotFound):
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("no deleted pack size %d", size)})
+		default:
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to restore pack size"})
+		}
+		return
+	}
+
+	h.invalidatePackSet(r.Context(), sizes)
+	h.notifyPackSizeChange(webhook.EventPackSizeRestored, size, 0)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pack size restored successfully"})
+}
+
+// UpdatePackSize handles PUT /api/packs/{size} with a {"size": newSize} body, changing
+// the size in place so it keeps its details and created_at
+func (h *Handler) UpdatePackSize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// Extract size from URL path
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid URL"})
+		return
+	}
+
+	oldSize, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid size"})
+		return
+	}
+
+	var req models.UpdatePackSizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if req.Size < 1 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Size must be at least 1"})
+		return
+	}
+
+	store := h.store(r.Context())
+	sizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	others := make([]int, 0, len(sizes))
+	for _, size := range sizes {
+		if size != oldSize {
+			others = append(others, size)
+		}
+	}
+	if err := h.checkPackSize(others, req.Size); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := store.UpdatePackSize(oldSize, req.Size); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrPackSizeExists):
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "Pack size already exists"})
+		case errors.Is(err, repository.ErrPackSizeNotFound):
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("pack size %d not found", oldSize)})
+		default:
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update pack size"})
+		}
+		return
+	}
+
+	h.invalidatePackSet(r.Context(), sizes)
+	h.notifyPackSizeChange(webhook.EventPackSizeUpdated, req.Size, oldSize)
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Pack size updated successfully"})
+}
+
+// ImportPackSizes handles POST /api/packs/import with a CSV body of size[,label[,tier]]
+// rows and an optional "size" header. Invalid rows are reported with their line number
+// and raw value while valid rows are imported. With ?strict=true the import is all or
+// nothing: it stops at the first row that fails to parse or insert, and the rows are
+// added in one transaction, so none are kept and no webhooks are sent.
+func (h *Handler) ImportPackSizes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	strict, err := parseFlag(r.URL.Query(), "strict")
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	summary := models.PackImportSummary{Imported: []int{}, Errors: []models.PackImportRowError{}}
+	rows, err := parsePackImport(r.Body, &summary)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid CSV: " + err.Error()})
+		return
+	}
+	if strict && len(summary.Errors) > 0 {
+		summary.Errors = summary.Errors[:1]
+		summary.Aborted = true
+		respondJSON(w, http.StatusBadRequest, summary)
+		return
+	}
+
+	store := h.store(r.Context())
+	sizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get pack sizes"})
+		return
+	}
+
+	// Duplicates are found in one round-trip; the insert still reports any that race in
+	requested := make([]int, len(rows))
+	for i, row := range rows {
+		requested[i] = row.req.Size
+	}
+	existing, err := store.PackSizesExist(requested)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to check pack sizes"})
+		return
+	}
+
+	importRows := func(tx repository.Store) error {
+		current := append([]int(nil), sizes...)
+		for _, row := range rows {
+			var rowErr string
+			if existing[row.req.Size] {
+				rowErr = "Pack size already exists"
+			} else if h.config.MaxPackSizes > 0 && len(current) >= h.config.MaxPackSizes {
+				rowErr = fmt.Sprintf("Pack size limit reached. Maximum allowed: %d pack sizes", h.config.MaxPackSizes)
+			} else if err := h.checkPackSize(current, row.req.Size); err != nil {
+				rowErr = err.Error()
+			} else if _, err := tx.AddPackSizeWithDetails(row.req); err != nil {
+				rowErr = "Failed to add pack size"
+				if errors.Is(err, repository.ErrPackSizeExists) {
+					rowErr = "Pack size already exists"
+				}
+			}
+
+			if rowErr != "" {
+				summary.Errors = append(summary.Errors, models.PackImportRowError{Line: row.line, Value: row.raw, Error: rowErr})
+				if strict {
+					summary.Aborted = true
+					return errImportAborted
+				}
+				continue
+			}
+			current = append(current, row.req.Size)
+			summary.Imported = append(summary.Imported, row.req.Size)
+		}
+		return nil
+	}
+
+	// Strict mode adds every row in one transaction, so a row failing partway leaves
+	// none of the rows before it in place
+	if strict {
+		err = store.WithTx(importRows)
+	} else {
+		err = importRows(store)
+	}
+	if summary.Aborted {
+		summary.Imported = []int{}
+	} else if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to import pack sizes"})
+		return
+	}
+
+	if len(summary.Imported) > 0 {
+		h.invalidatePackSet(r.Context(), sizes)
+		for _, size := range summary.Imported {
+			h.notifyPackSizeChange(webhook.EventPackSizeAdded, size, 0)
+		}
+	}
+
+	status := http.StatusOK
+	if summary.Aborted {
+		status = http.StatusConflict
+	}
+	respondJSON(w, status, summary)
+}
+
+// errImportAborted rolls back a strict import when a row fails
+var errImportAborted = errors.New("import aborted")
+
+// packImportRow is a parsed, valid import row
+type packImportRow struct {
+	line int
+	raw  string
+	req  models.AddPackSizeRequest
+}
+
+// parsePackImport reads CSV import rows, appending rows that fail to parse or validate to
+// summary.Errors. It only returns an error when the CSV itself is unreadable.
+func parsePackImport(body io.Reader, summary *models.PackImportSummary) ([]packImportRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []packImportRow
+	for first := true; ; first = false {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		raw := strings.Join(record, ",")
+
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "size") {
+			continue // Header row
+		}
+		if len(record) > 3 {
+			summary.Errors = append(summary.Errors, models.PackImportRowError{Line: line, Value: raw, Error: "Expected at most 3 columns: size, label, tier"})
+			continue
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(record[0]))
+		if err != nil {
+			summary.Errors = append(summary.Errors, models.PackImportRowError{Line: line, Value: raw, Error: fmt.Sprintf("Invalid size %q: must be a whole number", record[0])})
+			continue
+		}
+		req := models.AddPackSizeRequest{Size: size}
+		if len(record) > 1 {
+			req.Label = record[1]
+		}
+		if len(record) > 2 {
+			req.Tier = record[2]
+		}
+		req.Normalize()
+		if err := req.Validate(); err != nil {
+			summary.Errors = append(summary.Errors, models.PackImportRowError{Line: line, Value: raw, Error: err.Error()})
+			continue
+		}
+		rows = append(rows, packImportRow{line: line, raw: raw, req: req})
+	}
+}
+
+// CreateProfile handles POST /api/profiles, creating a named pack size profile that
+// calculations select with ?profile=. Sizes are held to the same limits as pack sizes
+// added to the default profile, and the profile is created with its sizes or not at all.
+func (h *Handler) CreateProfile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.CreateProfileRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+
+	req.Normalize()
+	if err := req.Validate(); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	sizes, err := h.requestPackSizes(req.PackSizes)
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	// Checking the largest size against the others covers MaxPackSize for every size
+	// and the memory budget for the whole set
+	if err := h.checkPackSize(sizes, sizes[len(sizes)-1]); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := h.store(r.Context()).CreateProfile(req.Name, sizes); err != nil {
+		if errors.Is(err, repository.ErrProfileExists) {
+			respondJSON(w, http.StatusConflict, map[string]string{"error": "Profile already exists"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create profile"})
+		return
+	}
+
+	respondJSON(w, http.StatusCreated, map[string]string{"message": "Profile created successfully"})
+}
+
+// SetStock handles PUT /api/stock, setting a pack size's stock level (null stops tracking)
+func (h *Handler) SetStock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.SetStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if req.Stock != nil && *req.Stock < 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Stock cannot be negative"})
+		return
+	}
+
+	if err := h.store(r.Context()).SetStock(req.Size, req.Stock); err != nil {
+		if errors.Is(err, repository.ErrPackSizeNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": "Pack size not found"})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to set stock"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Stock updated successfully"})
+}
+
+// ReserveStock handles POST /api/stock/reserve, decrementing stock for a confirmed order.
+// The reservation is all-or-nothing.
+func (h *Handler) ReserveStock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	var req models.ReserveStockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+		return
+	}
+	if len(req.Packs) == 0 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "packs must not be empty"})
+		return
+	}
+	for size, count := range req.Packs {
+		if count <= 0 {
+			respondJSON(w, http.StatusBadRequest, map[string]string{
+				"error": fmt.Sprintf("Quantity for pack size %d must be greater than zero", size),
+			})
+			return
+		}
+	}
+
+	if err := h.store(r.Context()).ReserveStock(req.Packs); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrInsufficientStock):
+			respondError(w, http.StatusConflict, codeInsufficientStock, err.Error())
+		case errors.Is(err, repository.ErrPackSizeNotFound):
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		default:
+			respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to reserve stock"})
+		}
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Stock reserved successfully"})
+}
+
+// GetOrders handles GET /api/orders
+func (h *Handler) GetOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// Get limit from query param, default to 100
+	limitStr := r.URL.Query().Get("limit")
+	limit := 100
+	if limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = min(l, maxOrdersLimit)
+		}
+	}
+
+	filter := repository.OrderFilter{Limit: limit}
+	for param, dest := range map[string]**int{
+		"amount":     &filter.Amount,
+		"min_amount": &filter.MinAmount,
+		"max_amount": &filter.MaxAmount,
+	} {
+		value := r.URL.Query().Get(param)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s must be an integer", param)})
+			return
+		}
+		*dest = &n
+	}
+	if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "min_amount must not exceed max_amount"})
+		return
+	}
+
+	// Timestamps are stored in UTC; ?tz= converts them to an IANA zone for display
+	loc := time.UTC
+	if tz := r.URL.Query().Get("tz"); tz != "" {
+		var err error
+		if loc, err = time.LoadLocation(tz); err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Unknown time zone: %s", tz)})
+			return
+		}
+	}
+
+	// ?cursor= pages through the history: an empty cursor starts at the newest order and
+	// each page's next_cursor continues it. Paged responses are wrapped in an envelope.
+	paged := r.URL.Query().Has("cursor")
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		cursor, err := repository.ParseOrderCursor(token)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid cursor"})
+			return
+		}
+		filter.After = &cursor
+	}
+	if paged {
+		filter.Limit++ // One extra row tells whether another page follows
+	}
+
+	orders, err := h.store(r.Context()).QueryOrders(filter)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get orders"})
+		return
+	}
+
+	var page models.OrderPage
+	if paged && len(orders) == limit+1 {
+		orders = orders[:limit]
+		page.NextCursor = repository.CursorAfter(orders[limit-1]).Encode()
+	}
+	for i := range orders {
+		orders[i].CreatedAt = orders[i].CreatedAt.In(loc)
+		orders[i].UpdatedAt = orders[i].UpdatedAt.In(loc)
+	}
+
+	if paged {
+		page.Orders = orders
+		respondJSON(w, http.StatusOK, page)
+		return
+	}
 	respondJSON(w, http.StatusOK, orders)
 }
 
-// HealthCheck handles GET /health
+// DeleteOrder handles DELETE /api/orders/{id}
+func (h *Handler) DeleteOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	// Extract id from URL path
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid URL"})
+		return
+	}
+
+	id, err := strconv.Atoi(parts[len(parts)-1])
+	if err != nil {
+		respondJSON(w, http.StatusBadRequest, map[string]string{"error": "Invalid order id"})
+		return
+	}
+
+	if err := h.store(r.Context()).DeleteOrder(id); err != nil {
+		if errors.Is(err, repository.ErrOrderNotFound) {
+			respondJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to delete order"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Order deleted successfully"})
+}
+
+// recomputeBatchSize is how many orders RecomputeOrders corrects per transaction
+const recomputeBatchSize = 500
+
+// RecomputeOrders handles POST /api/orders/recompute, rewriting order totals that
+// disagree with their stored packs and reporting how many were corrected
+func (h *Handler) RecomputeOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	result, err := h.repo.RecomputeOrderTotals(recomputeBatchSize)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]interface{}{
+			"error":  "Failed to recompute order totals",
+			"result": result, // Batches committed before the failure stay corrected
+		})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, result)
+}
+
+// HealthCheck handles GET /health, the liveness probe: it answers 200 whenever the
+// process is serving, without checking dependencies (see Ready)
 func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	stats := h.cache.Stats()
 	respondJSON(w, http.StatusOK, map[string]interface{}{
@@ -269,10 +2869,449 @@ func (h *Handler) HealthCheck(w http.ResponseWriter, r *http.Request) {
 			"misses":    stats.Misses,
 			"hit_ratio": stats.HitRatio,
 			"size":      stats.Size,
+			"pinned":    stats.Pinned,
+		},
+	})
+}
+
+// GetDPStats handles GET /api/stats/dp, a histogram of DP table bounds across calculator
+// runs. Buckets are not cumulative; the last bucket (le null) counts tables above 10M.
+func (h *Handler) GetDPStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	type bucket struct {
+		UpperBound *int  `json:"le"`
+		Count      int64 `json:"count"`
+	}
+	buckets := make([]bucket, len(h.dpSizes.counts))
+	var total int64
+	for i := range buckets {
+		if i < len(dpBucketBounds) {
+			buckets[i].UpperBound = &dpBucketBounds[i]
+		}
+		buckets[i].Count = h.dpSizes.counts[i].Load()
+		total += buckets[i].Count
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"buckets":       buckets,
+		"count":         total,
+		"max_dp_target": h.dpSizes.max.Load(),
+	})
+}
+
+// maxStatsHistory caps how many snapshots a single history request returns
+const maxStatsHistory = 1000
+
+// snapshotStats stores the current cache stats and calculation counters
+func (h *Handler) snapshotStats() error {
+	stats := h.cache.Stats()
+	return h.repo.SaveStatsSnapshot(&models.StatsSnapshot{
+		CacheHits:         stats.Hits,
+		CacheMisses:       stats.Misses,
+		CacheHitRatio:     stats.HitRatio,
+		CacheSize:         stats.Size,
+		Calculations:      h.calculations.Load(),
+		CalculationErrors: h.calculationErrors.Load(),
+	})
+}
+
+// StartStatsSnapshots stores a stats snapshot every interval and prunes snapshots older
+// than retention (zero keeps them all) until the returned stop function is called
+func (h *Handler) StartStatsSnapshots(interval, retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				if err := h.snapshotStats(); err != nil {
+					log.Printf("Stats snapshot failed: %v", err)
+				}
+				if retention > 0 {
+					if _, err := h.repo.PruneStatsSnapshots(time.Now().Add(-retention)); err != nil {
+						log.Printf("Stats snapshot pruning failed: %v", err)
+					}
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// orderReapBatchSize is how many expired orders one delete statement removes
+const orderReapBatchSize = 1000
+
+// ReapOrders deletes orders older than retention and returns how many were removed
+func (h *Handler) ReapOrders(retention time.Duration) (int64, error) {
+	return h.repo.PruneOrders(time.Now().Add(-retention), orderReapBatchSize)
+}
+
+// StartOrderReaper deletes orders older than retention every interval, logging how many
+// were pruned, until the returned stop function is called
+func (h *Handler) StartOrderReaper(interval, retention time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				pruned, err := h.ReapOrders(retention)
+				if err != nil {
+					log.Printf("Order reaping failed after %d orders: %v", pruned, err)
+				} else if pruned > 0 {
+					log.Printf("Reaped %d orders older than %s", pruned, retention)
+				}
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// GetStatsHistory handles GET /api/stats/history?since=RFC3339, returning snapshots
+// oldest first. Without since it returns the last 24 hours.
+func (h *Handler) GetStatsHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if sinceStr := r.URL.Query().Get("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			respondJSON(w, http.StatusBadRequest, map[string]string{"error": "since must be an RFC 3339 timestamp such as 2024-01-02T15:04:05Z"})
+			return
+		}
+		since = parsed
+	}
+
+	snapshots, err := h.repo.GetStatsSnapshots(since, maxStatsHistory)
+	if err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to get stats history"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{"snapshots": snapshots})
+}
+
+// WarmUp precomputes results for the distinct amounts of the most recent orders
+// (up to limit orders) into the cache, then marks the handler ready for traffic.
+// Readiness is set even if warm-up fails so an instance is never held out forever;
+// the error is returned for logging.
+func (h *Handler) WarmUp(ctx context.Context, limit int) error {
+	defer h.warm.Store(true)
+
+	orders, err := h.store(ctx).GetAllOrders(limit)
+	if err != nil {
+		return fmt.Errorf("failed to load recent orders: %w", err)
+	}
+	packSizes, err := h.store(ctx).GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		return fmt.Errorf("failed to get pack sizes: %w", err)
+	}
+	if len(packSizes) == 0 {
+		return nil
+	}
+	packSizes = sortedCopy(packSizes)
+	calc := h.newCalculator(packSizes)
+
+	seen := make(map[int]bool, len(orders))
+	for _, order := range orders {
+		if seen[order.Amount] || order.Amount <= 0 || order.Amount > maxAmount {
+			continue
+		}
+		seen[order.Amount] = true
+		if _, err := h.calculateCached(ctx, calc, packSizes, order.Amount); err != nil {
+			return fmt.Errorf("failed to warm amount %d: %w", order.Amount, err)
+		}
+	}
+	return nil
+}
+
+// readinessTimeout bounds each dependency check made by Ready, so a hung database
+// fails the probe instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// Ready handles GET /ready, the readiness probe: it pings the database and, for caches
+// backed by a server, the cache, reporting each as "up" or "down". Any dependency down
+// gives 503 so load balancers stop routing here; /health stays 200 for liveness.
+func (h *Handler) Ready(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status := http.StatusOK
+	check := func(ping func(context.Context) error) string {
+		if err := ping(ctx); err != nil {
+			status = http.StatusServiceUnavailable
+			return "down"
+		}
+		return "up"
+	}
+
+	response := map[string]string{"database": "up", "cache": "up"}
+	if h.db != nil {
+		response["database"] = check(h.db.PingContext)
+	}
+	if pinger, ok := h.cache.(cache.Pinger); ok {
+		response["cache"] = check(pinger.Ping)
+	}
+	response["status"] = "ready"
+	if status != http.StatusOK {
+		response["status"] = "unavailable"
+	}
+	respondJSON(w, status, response)
+}
+
+// ReadyForTraffic handles GET /api/ready-for-traffic: 503 until WarmUp has finished, then 200
+func (h *Handler) ReadyForTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	if !h.warm.Load() {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "warming_up"})
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// memoryReporter is implemented by caches that can estimate their memory usage
+type memoryReporter interface {
+	MemoryReport(topN int) cache.MemoryReport
+}
+
+// GetCacheMemory handles GET /api/cache/memory
+func (h *Handler) GetCacheMemory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	reporter, ok := h.cache.(memoryReporter)
+	if !ok {
+		respondJSON(w, http.StatusNotImplemented, map[string]string{"error": "Cache backend does not support memory reporting"})
+		return
+	}
+
+	// Get top from query param, default to 10
+	top := 10
+	if topStr := r.URL.Query().Get("top"); topStr != "" {
+		if n, err := strconv.Atoi(topStr); err == nil && n >= 0 {
+			top = n
+		}
+	}
+
+	respondJSON(w, http.StatusOK, reporter.MemoryReport(top))
+}
+
+// GetConfig handles GET /api/config, reporting the sanitized effective configuration
+// together with the built-in request limits
+func (h *Handler) GetConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+	if h.effectiveConfig == nil {
+		respondJSON(w, http.StatusNotImplemented, map[string]string{"error": "Configuration not available"})
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"config": h.effectiveConfig.Sanitized(),
+		"limits": map[string]int{
+			"max_amount":              maxAmount,
+			"max_stream_range":        maxStreamRange,
+			"max_feasibility_amounts": maxFeasibilityAmounts,
 		},
 	})
 }
 
+// GetFeatures handles GET /api/features, reporting which optional capabilities this
+// server has enabled so a client can adapt to it. Deployment-level flags are only
+// reported as enabled when the effective configuration is known.
+func (h *Handler) GetFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		MethodNotAllowed(w, r)
+		return
+	}
+
+	features := map[string]bool{
+		"exact":        true,
+		"strict_exact": h.config.StrictExact,
+		"custom_sizes": true,
+		"variants":     true,
+		"next_exact":   true,
+		"batch":        true,
+		"profiles":     true,
+		"max_packs":    true,
+		"tenants":      true,
+		"worker_pool":  h.pool != nil,
+		"orders":       h.config.OrderSampleRate > 0,
+	}
+	cfg := h.effectiveConfig
+	features["auth"] = cfg != nil && cfg.APIKey != ""
+	features["peer_cache"] = cfg != nil && len(cfg.Cache.Peers) > 0
+	features["webhooks"] = cfg != nil && len(cfg.WebhookURLs) > 0
+	features["order_retention"] = cfg != nil && cfg.Orders.Retention > 0
+	features["stats_history"] = cfg != nil && cfg.Stats.SnapshotInterval > 0
+
+	respondJSON(w, http.StatusOK, features)
+}
+
+// Error codes returned alongside validation errors
+const (
+	codeAmountZero        = "AMOUNT_ZERO"
+	codeAmountNegative    = "AMOUNT_NEGATIVE"
+	codeInsufficientStock = "INSUFFICIENT_STOCK"
+	codeNotExact          = "NOT_EXACT"
+	codeResultRejected    = "RESULT_REJECTED"
+	codeMemoryBudget      = "MEMORY_BUDGET_EXCEEDED"
+	codeMaxPacks          = "MAX_PACKS_EXCEEDED"
+)
+
+// Error codes for routing errors
+const (
+	codeNotFound         = "NOT_FOUND"
+	codeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	codeOverloaded       = "OVERLOADED"
+	codeTimeout          = "TIMEOUT"
+)
+
+// respondNotExact writes a 422 for an exact-match request whose amount cannot be packed exactly
+func respondNotExact(w http.ResponseWriter, amount, closest int) {
+	respondError(w, http.StatusUnprocessableEntity, codeNotExact, notExactMessage(amount, closest))
+}
+
+// notExactMessage explains why amount was rejected in exact mode
+func notExactMessage(amount, closest int) string {
+	return fmt.Sprintf("Amount %d cannot be packed exactly; the closest total is %d", amount, closest)
+}
+
+// NotFoundHandler returns a JSON 404 for routes that do not exist
+func NotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusNotFound, codeNotFound, fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+}
+
+// MethodNotAllowed returns a JSON 405 for a known route hit with an unsupported method
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+}
+
+// respondError writes a JSON error with a stable machine-readable code
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	respondJSON(w, status, map[string]string{"error": message, "code": code})
+}
+
+// respondAmountError maps calculator amount validation errors to a 400 with a specific code
+func respondAmountError(w http.ResponseWriter, err error) {
+	code := codeAmountNegative
+	if errors.Is(err, calculator.ErrAmountZero) {
+		code = codeAmountZero
+	}
+	respondError(w, http.StatusBadRequest, code, err.Error())
+}
+
+// weightEpsilon absorbs floating-point error so e.g. 10 / 0.1 yields 100, not 101
+const weightEpsilon = 1e-9
+
+// amountFromWeight converts a target weight into the item count needed to reach it
+func amountFromWeight(targetWeight, itemWeight float64) (int, error) {
+	if itemWeight <= 0 || math.IsNaN(itemWeight) || math.IsInf(itemWeight, 0) {
+		return 0, errors.New("item_weight must be greater than zero")
+	}
+	if targetWeight <= 0 || math.IsNaN(targetWeight) || math.IsInf(targetWeight, 0) {
+		return 0, errors.New("target_weight must be greater than zero")
+	}
+
+	items := math.Ceil(targetWeight/itemWeight - weightEpsilon)
+	if items > maxAmount {
+		return 0, fmt.Errorf("Amount too large. Maximum allowed: %d items", maxAmount)
+	}
+	return int(items), nil
+}
+
+// containsSize reports whether size is one of sizes
+func containsSize(sizes []int, size int) bool {
+	for _, s := range sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedCopy returns an ascending copy of sizes without modifying the input
+func sortedCopy(sizes []int) []int {
+	sorted := make([]int, len(sizes))
+	copy(sorted, sizes)
+	sort.Ints(sorted)
+	return sorted
+}
+
+// serverTiming collects named request segments for a W3C Server-Timing header
+type serverTiming struct {
+	names     []string
+	durations []time.Duration
+}
+
+// add records a segment of duration d
+func (t *serverTiming) add(name string, d time.Duration) {
+	t.names = append(t.names, name)
+	t.durations = append(t.durations, d)
+}
+
+// String formats the segments as "name;dur=milliseconds", comma-separated
+func (t *serverTiming) String() string {
+	parts := make([]string, len(t.names))
+	for i, name := range t.names {
+		parts[i] = fmt.Sprintf("%s;dur=%.3f", name, float64(t.durations[i].Microseconds())/1000)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// respondJSONTimed is respondJSON that also reports serialization time: the body is
+// encoded before the headers are written so the Server-Timing header can include it
+func respondJSONTimed(w http.ResponseWriter, status int, data interface{}, timing *serverTiming) {
+	start := time.Now()
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(data); err != nil {
+		respondJSON(w, http.StatusInternalServerError, map[string]string{"error": "Failed to encode response"})
+		return
+	}
+	timing.add("serialize", time.Since(start))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Server-Timing", timing.String())
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// logRequest logs a handler message through slog.Default with the request ID that
+// LoggingMiddleware put in ctx, so it can be matched with the request's own log line
+func logRequest(ctx context.Context, level slog.Level, format string, args ...interface{}) {
+	slog.Log(ctx, level, fmt.Sprintf(format, args...), slog.String("request_id", middleware.RequestIDFromContext(ctx)))
+}
+
 // respondJSON writes a buffered JSON response for better performance
 func respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,3874 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"pack-calculator/internal/cache"
+	"pack-calculator/internal/config"
+	"pack-calculator/internal/middleware"
+	"pack-calculator/internal/models"
+	"pack-calculator/internal/repository"
+	"pack-calculator/internal/webhook"
+	"pack-calculator/internal/workerpool"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// fakeStore is a minimal in-memory repository.Store for handler tests
+type fakeStore struct {
+	mu        sync.Mutex
+	txMu      sync.Mutex // Serializes WithTx calls
+	sizes     map[int]models.PackSize
+	deleted   []models.PackSize // Soft-deleted records, oldest first
+	orders    []models.Order
+	lastOrder int // ID of the most recently saved order
+	snapshots []models.StatsSnapshot
+	profiles  map[string][]int      // Named profiles; the default profile is sizes
+	tenants   map[string]*fakeStore // Created empty on first use
+}
+
+func newFakeStore(sizes ...int) *fakeStore {
+	s := &fakeStore{sizes: make(map[int]models.PackSize)}
+	for _, size := range sizes {
+		s.sizes[size] = models.PackSize{ID: size, Size: size, CreatedAt: time.Now()}
+	}
+	return s
+}
+
+func (s *fakeStore) ForTenant(tenant string) repository.Store {
+	if tenant == repository.DefaultTenant {
+		return s
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tenants == nil {
+		s.tenants = make(map[string]*fakeStore)
+	}
+	if s.tenants[tenant] == nil {
+		s.tenants[tenant] = newFakeStore()
+	}
+	return s.tenants[tenant]
+}
+
+// WithTx restores the store's sizes, profiles and orders if fn fails
+func (s *fakeStore) WithTx(fn func(tx repository.Store) error) error {
+	s.txMu.Lock()
+	defer s.txMu.Unlock()
+
+	s.mu.Lock()
+	sizes := make(map[int]models.PackSize, len(s.sizes))
+	for size, ps := range s.sizes {
+		sizes[size] = ps
+	}
+	profiles := make(map[string][]int, len(s.profiles))
+	for name, members := range s.profiles {
+		profiles[name] = append([]int(nil), members...)
+	}
+	deleted, orders, lastOrder := append([]models.PackSize(nil), s.deleted...), append([]models.Order(nil), s.orders...), s.lastOrder
+	s.mu.Unlock()
+
+	if err := fn(s); err != nil {
+		s.mu.Lock()
+		s.sizes, s.profiles, s.deleted, s.orders, s.lastOrder = sizes, profiles, deleted, orders, lastOrder
+		s.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (s *fakeStore) GetAllPackSizes() ([]models.PackSize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	packSizes := make([]models.PackSize, 0, len(s.sizes))
+	for _, ps := range s.sizes {
+		packSizes = append(packSizes, ps)
+	}
+	sort.Slice(packSizes, func(i, j int) bool { return packSizes[i].Size < packSizes[j].Size })
+	return packSizes, nil
+}
+
+func (s *fakeStore) GetPackSizesAsSlice(profile string) ([]int, error) {
+	if profile != "" && profile != repository.DefaultProfile {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		sizes, exists := s.profiles[profile]
+		if !exists {
+			return nil, fmt.Errorf("%w: %q", repository.ErrProfileNotFound, profile)
+		}
+		return append([]int{}, sizes...), nil
+	}
+
+	packSizes, _ := s.GetAllPackSizes()
+	sizes := make([]int, len(packSizes))
+	for i, ps := range packSizes {
+		sizes[i] = ps.Size
+	}
+	return sizes, nil
+}
+
+func (s *fakeStore) CreateProfile(name string, sizes []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.profiles[name]; exists || name == repository.DefaultProfile {
+		return fmt.Errorf("failed to create profile %q: %w", name, repository.ErrProfileExists)
+	}
+	if s.profiles == nil {
+		s.profiles = make(map[string][]int)
+	}
+	members := append([]int{}, sizes...)
+	sort.Ints(members)
+	s.profiles[name] = members
+	return nil
+}
+
+func (s *fakeStore) AddProfilePackSizes(name string, sizes []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, exists := s.profiles[name]
+	if !exists {
+		return fmt.Errorf("%w: %q", repository.ErrProfileNotFound, name)
+	}
+	for _, size := range sizes {
+		if !containsInt(existing, size) {
+			existing = append(existing, size)
+		}
+	}
+	sort.Ints(existing)
+	s.profiles[name] = existing
+	return nil
+}
+
+func containsInt(values []int, v int) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *fakeStore) GetPackSizesWithUsage() ([]models.PackSizeUsage, error) {
+	packSizes, _ := s.GetAllPackSizes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make([]models.PackSizeUsage, len(packSizes))
+	for i, ps := range packSizes {
+		usage[i].PackSize = ps
+		for _, order := range s.orders {
+			if order.Packs[ps.Size] > 0 && (usage[i].LastUsedAt == nil || order.CreatedAt.After(*usage[i].LastUsedAt)) {
+				usedAt := order.CreatedAt
+				usage[i].LastUsedAt = &usedAt
+			}
+		}
+	}
+	return usage, nil
+}
+
+func (s *fakeStore) AddPackSize(size int) (models.PackSize, error) {
+	return s.AddPackSizeWithDetails(models.AddPackSizeRequest{Size: size})
+}
+
+func (s *fakeStore) AddPackSizeWithDetails(req models.AddPackSizeRequest) (models.PackSize, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.sizes[req.Size]; exists {
+		return models.PackSize{}, fmt.Errorf("failed to add pack size %d: %w", req.Size, repository.ErrPackSizeExists)
+	}
+	ps := models.PackSize{ID: req.Size, Size: req.Size, Label: req.Label, Tier: req.Tier, CreatedAt: time.Now().UTC()}
+	s.sizes[req.Size] = ps
+	return ps, nil
+}
+
+func (s *fakeStore) AddPackSizes(sizes []int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, size := range sizes {
+		if _, exists := s.sizes[size]; exists {
+			return fmt.Errorf("failed to add pack size %d: %w", size, repository.ErrPackSizeExists)
+		}
+	}
+	for _, size := range sizes {
+		s.sizes[size] = models.PackSize{ID: size, Size: size, CreatedAt: time.Now()}
+	}
+	return nil
+}
+
+func (s *fakeStore) GetAllPackSizesIncludingDeleted() ([]models.PackSize, error) {
+	packSizes, _ := s.GetAllPackSizes()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.deleted) - 1; i >= 0; i-- {
+		packSizes = append(packSizes, s.deleted[i])
+	}
+	sort.SliceStable(packSizes, func(i, j int) bool { return packSizes[i].Size < packSizes[j].Size })
+	return packSizes, nil
+}
+
+func (s *fakeStore) DeletePackSize(size int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.sizes[size]
+	if !exists {
+		return fmt.Errorf("pack size %d not found", size)
+	}
+	deletedAt := time.Now()
+	record.DeletedAt = &deletedAt
+	s.deleted = append(s.deleted, record)
+	delete(s.sizes, size)
+	return nil
+}
+
+func (s *fakeStore) RestorePackSize(size int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.deleted) - 1; i >= 0; i-- {
+		if s.deleted[i].Size != size {
+			continue
+		}
+		if _, exists := s.sizes[size]; exists {
+			return fmt.Errorf("%w: %d", repository.ErrPackSizeExists, size)
+		}
+		record := s.deleted[i]
+		record.DeletedAt = nil
+		s.sizes[size] = record
+		s.deleted = append(s.deleted[:i], s.deleted[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("%w: %d", repository.ErrPackSizeNotFound, size)
+}
+
+func (s *fakeStore) UpdatePackSize(oldSize, newSize int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, exists := s.sizes[oldSize]
+	if !exists {
+		return fmt.Errorf("%w: %d", repository.ErrPackSizeNotFound, oldSize)
+	}
+	if _, taken := s.sizes[newSize]; taken && newSize != oldSize {
+		return fmt.Errorf("%w: %d", repository.ErrPackSizeExists, newSize)
+	}
+	delete(s.sizes, oldSize)
+	record.Size = newSize
+	s.sizes[newSize] = record
+	return nil
+}
+
+func (s *fakeStore) PackSizeExists(size int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, exists := s.sizes[size]
+	return exists, nil
+}
+
+// LockPackSizes is a no-op, as WithTx already serializes transactions
+func (s *fakeStore) LockPackSizes() error {
+	return nil
+}
+
+func (s *fakeStore) PackSizesExist(sizes []int) (map[int]bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing := make(map[int]bool)
+	for _, size := range sizes {
+		if _, exists := s.sizes[size]; exists {
+			existing[size] = true
+		}
+	}
+	return existing, nil
+}
+
+func (s *fakeStore) SetStock(size int, stock *int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ps, ok := s.sizes[size]
+	if !ok {
+		return repository.ErrPackSizeNotFound
+	}
+	ps.Stock = stock
+	s.sizes[size] = ps
+	return nil
+}
+
+func (s *fakeStore) ReserveStock(packs map[int]int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for size, count := range packs {
+		ps, ok := s.sizes[size]
+		if !ok {
+			return repository.ErrPackSizeNotFound
+		}
+		if ps.Stock != nil && *ps.Stock < count {
+			return repository.ErrInsufficientStock
+		}
+	}
+	for size, count := range packs {
+		if ps := s.sizes[size]; ps.Stock != nil {
+			remaining := *ps.Stock - count
+			ps.Stock = &remaining
+			s.sizes[size] = ps
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) SaveStatsSnapshot(snapshot *models.StatsSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot.ID = len(s.snapshots) + 1
+	snapshot.CreatedAt = time.Now().UTC()
+	s.snapshots = append(s.snapshots, *snapshot)
+	return nil
+}
+
+func (s *fakeStore) GetStatsSnapshots(since time.Time, limit int) ([]models.StatsSnapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshots := []models.StatsSnapshot{}
+	for _, snapshot := range s.snapshots {
+		if !snapshot.CreatedAt.Before(since) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	if len(snapshots) > limit {
+		snapshots = snapshots[len(snapshots)-limit:]
+	}
+	return snapshots, nil
+}
+
+func (s *fakeStore) PruneStatsSnapshots(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.snapshots[:0]
+	for _, snapshot := range s.snapshots {
+		if !snapshot.CreatedAt.Before(before) {
+			kept = append(kept, snapshot)
+		}
+	}
+	pruned := int64(len(s.snapshots) - len(kept))
+	s.snapshots = kept
+	return pruned, nil
+}
+
+func (s *fakeStore) PruneOrders(before time.Time, batchSize int) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.orders[:0]
+	for _, order := range s.orders {
+		if !order.CreatedAt.Before(before) {
+			kept = append(kept, order)
+		}
+	}
+	pruned := int64(len(s.orders) - len(kept))
+	s.orders = kept
+	return pruned, nil
+}
+
+func (s *fakeStore) DeleteOrder(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, order := range s.orders {
+		if order.ID == id {
+			s.orders = append(s.orders[:i], s.orders[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", repository.ErrOrderNotFound, id)
+}
+
+func (s *fakeStore) RecomputeOrderTotals(batchSize int) (repository.RecomputeResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result repository.RecomputeResult
+	for i := range s.orders {
+		result.Scanned++
+		totalItems, totalPacks := 0, 0
+		for size, count := range s.orders[i].Packs {
+			totalItems += size * count
+			totalPacks += count
+		}
+		if totalItems != s.orders[i].TotalItems || totalPacks != s.orders[i].TotalPacks {
+			s.orders[i].TotalItems, s.orders[i].TotalPacks = totalItems, totalPacks
+			result.Corrected++
+		}
+	}
+	return result, nil
+}
+
+func (s *fakeStore) SaveOrder(order *models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastOrder++
+	order.ID = s.lastOrder
+	order.CreatedAt = time.Now().UTC()
+	order.UpdatedAt = order.CreatedAt
+	s.orders = append(s.orders, *order)
+	return nil
+}
+
+func (s *fakeStore) SaveOrdersContext(ctx context.Context, orders []*models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, order := range orders {
+		s.lastOrder++
+		order.ID = s.lastOrder
+		order.CreatedAt = time.Now().UTC()
+		order.UpdatedAt = order.CreatedAt
+		s.orders = append(s.orders, *order)
+	}
+	return nil
+}
+
+func (s *fakeStore) GetAllOrders(limit int) ([]models.Order, error) {
+	return s.QueryOrders(repository.OrderFilter{Limit: limit})
+}
+
+func (s *fakeStore) QueryOrders(filter repository.OrderFilter) ([]models.Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	orders := make([]models.Order, 0)
+	for i := len(s.orders) - 1; i >= 0 && len(orders) < filter.Limit; i-- {
+		o := s.orders[i]
+		if filter.Amount != nil && o.Amount != *filter.Amount ||
+			filter.MinAmount != nil && o.Amount < *filter.MinAmount ||
+			filter.MaxAmount != nil && o.Amount > *filter.MaxAmount ||
+			filter.After != nil && !filter.After.Precedes(o.CreatedAt, o.ID) {
+			continue
+		}
+		orders = append(orders, o)
+	}
+	return orders, nil
+}
+
+func TestAddPackSize_ConcurrentDuplicates(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+
+	const attempts = 2
+	codes := make([]int, attempts)
+
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			req := httptest.NewRequest(http.MethodPost, "/api/packs", strings.NewReader(`{"size": 750}`))
+			rec := httptest.NewRecorder()
+			h.AddPackSize(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created, conflicts := 0, 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+			conflicts++
+		default:
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+
+	if created != 1 || conflicts != 1 {
+		t.Errorf("Got %d created and %d conflicts, want exactly one of each", created, conflicts)
+	}
+}
+
+func addPackSize(h *Handler, size int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/packs", strings.NewReader(fmt.Sprintf(`{"size": %d}`, size)))
+	rec := httptest.NewRecorder()
+	h.AddPackSize(rec, req)
+	return rec
+}
+
+func TestAddPackSize_CreatedWithLocation(t *testing.T) {
+	h := NewHandler(newFakeStore(250), cache.NewMemoryCache(10))
+
+	rec := addPackSize(h, 750)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status = %d, want 201", rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/api/packs/750" {
+		t.Errorf("Location = %q, want /api/packs/750", loc)
+	}
+	var created models.PackSize
+	if err := json.Unmarshal(rec.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Body %s: %v", rec.Body.String(), err)
+	}
+	if created.ID == 0 || created.Size != 750 || created.CreatedAt.IsZero() {
+		t.Errorf("Body = %+v, want the created pack size with id and created_at", created)
+	}
+
+	if rec := addPackSize(h, 750); rec.Code != http.StatusConflict || rec.Header().Get("Location") != "" {
+		t.Errorf("Duplicate = %d with Location %q, want 409 without it", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// The Location resolves to the same pack size
+	get := httptest.NewRecorder()
+	h.GetPackSize(get, httptest.NewRequest(http.MethodGet, rec.Header().Get("Location"), nil))
+	var fetched models.PackSize
+	if err := json.Unmarshal(get.Body.Bytes(), &fetched); get.Code != http.StatusOK || err != nil || fetched.ID != created.ID {
+		t.Errorf("GET Location = %d, body = %s, want the created pack size", get.Code, get.Body.String())
+	}
+}
+
+func TestGetPackSize_NotFoundAndInvalid(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+
+	for path, want := range map[string]int{
+		"/api/packs/250":         http.StatusOK,
+		"/api/packs/500":         http.StatusNotFound,
+		"/api/packs/abc":         http.StatusBadRequest,
+		"/api/packs/250/restore": http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		h.GetPackSize(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func deletePackSize(h *Handler, size int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/api/packs/%d", size), nil)
+	rec := httptest.NewRecorder()
+	h.DeletePackSize(rec, req)
+	return rec
+}
+
+func updatePackSize(h *Handler, size int, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/packs/%d", size), strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.UpdatePackSize(rec, req)
+	return rec
+}
+
+func TestSoftDeleteAndRestorePackSize(t *testing.T) {
+	store := newFakeStore(250, 500)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+	restore := func(path string) int {
+		rec := httptest.NewRecorder()
+		h.RestorePackSize(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Code
+	}
+	list := func(query string) (int, []models.PackSize) {
+		rec := httptest.NewRecorder()
+		h.GetPackSizes(rec, httptest.NewRequest(http.MethodGet, "/api/packs"+query, nil))
+		var packSizes []models.PackSize
+		json.Unmarshal(rec.Body.Bytes(), &packSizes)
+		return rec.Code, packSizes
+	}
+
+	created := store.sizes[250].CreatedAt
+	if rec := deletePackSize(h, 250); rec.Code != http.StatusOK {
+		t.Fatalf("Delete status = %d", rec.Code)
+	}
+	if _, packSizes := list(""); len(packSizes) != 1 || packSizes[0].Size != 500 {
+		t.Errorf("Listed sizes after delete = %+v, want only 500", packSizes)
+	}
+	code, packSizes := list("?include_deleted=true")
+	if code != http.StatusOK || len(packSizes) != 2 || packSizes[0].Size != 250 || packSizes[0].DeletedAt == nil || packSizes[1].DeletedAt != nil {
+		t.Errorf("include_deleted = %d %+v, want 250 marked deleted and live 500", code, packSizes)
+	}
+	for _, query := range []string{"?include_deleted=maybe", "?include_deleted=1&include=usage"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", query, code)
+		}
+	}
+
+	for path, want := range map[string]int{
+		"/api/packs/500/restore": http.StatusNotFound, // Never deleted
+		"/api/packs/abc/restore": http.StatusBadRequest,
+		"/api/packs/250":         http.StatusBadRequest,
+	} {
+		if code := restore(path); code != want {
+			t.Errorf("POST %s status = %d, want %d", path, code, want)
+		}
+	}
+
+	// Calculate with the remaining set, then restore: the cached set is invalidated
+	if rec := calculate(h, `{"amount": 251}`); rec.Code != http.StatusOK {
+		t.Fatalf("Calculate status = %d", rec.Code)
+	}
+	if code := restore("/api/packs/250/restore"); code != http.StatusOK {
+		t.Fatalf("Restore status = %d, want 200", code)
+	}
+	if record, ok := store.sizes[250]; !ok || record.DeletedAt != nil || !record.CreatedAt.Equal(created) {
+		t.Errorf("Restored record = %+v, want live 250 keeping created_at", record)
+	}
+	if memCache.Stats().Size != 0 {
+		t.Errorf("Cache size after restore = %d, want 0", memCache.Stats().Size)
+	}
+	if code := restore("/api/packs/250/restore"); code != http.StatusNotFound {
+		t.Errorf("Second restore status = %d, want 404", code)
+	}
+
+	// A size added again after deletion cannot be restored over
+	deletePackSize(h, 500)
+	if _, err := store.AddPackSize(500); err != nil {
+		t.Fatalf("AddPackSize(500) error = %v", err)
+	}
+	if code := restore("/api/packs/500/restore"); code != http.StatusConflict {
+		t.Errorf("Restore over a re-added size status = %d, want 409", code)
+	}
+}
+
+func TestUpdatePackSize(t *testing.T) {
+	store := newFakeStore(250, 500)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+	if rec := calculate(h, `{"amount": 251}`); rec.Code != http.StatusOK {
+		t.Fatalf("Calculate status = %d", rec.Code)
+	}
+
+	tests := []struct {
+		name string
+		size int
+		body string
+		want int
+	}{
+		{"conflict", 250, `{"size": 500}`, http.StatusConflict},
+		{"not found", 300, `{"size": 350}`, http.StatusNotFound},
+		{"invalid size", 250, `{"size": 0}`, http.StatusBadRequest},
+		{"invalid body", 250, `not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := updatePackSize(h, tt.size, tt.body); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if memCache.Stats().Size != 1 {
+		t.Errorf("Cache size after failed updates = %d, want 1", memCache.Stats().Size)
+	}
+
+	created := store.sizes[250].CreatedAt
+	if rec := updatePackSize(h, 250, `{"size": 300}`); rec.Code != http.StatusOK {
+		t.Fatalf("Update status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if record, ok := store.sizes[300]; !ok || !record.CreatedAt.Equal(created) {
+		t.Errorf("Updated record = %+v, want size 300 keeping created_at", record)
+	}
+	if memCache.Stats().Size != 0 {
+		t.Errorf("Cache size after update = %d, want 0", memCache.Stats().Size)
+	}
+
+	// The new set is used straight away
+	var result models.PackCalculationResult
+	if err := json.Unmarshal(calculate(h, `{"amount": 251}`).Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if result.TotalItems != 300 {
+		t.Errorf("TotalItems after update = %d, want 300", result.TotalItems)
+	}
+}
+
+func TestMemoryStore_CalculateAddDeleteOrders(t *testing.T) {
+	store := repository.NewMemoryStore()
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	if rec := calculate(h, `{"amount": 251}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Calculate with no sizes = %d, want 400", rec.Code)
+	}
+	for _, size := range []int{250, 500, 1000} {
+		if rec := addPackSize(h, size); rec.Code != http.StatusCreated {
+			t.Fatalf("Add %d = %d, body = %s", size, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := addPackSize(h, 500); rec.Code != http.StatusConflict {
+		t.Errorf("Duplicate add = %d, want 409", rec.Code)
+	}
+
+	decode := func(rec *httptest.ResponseRecorder) models.PackCalculationResult {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Calculate status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result
+	}
+	if result := decode(calculate(h, `{"amount": 1250}`)); result.TotalItems != 1250 || result.Packs[1000] != 1 || result.Packs[250] != 1 {
+		t.Errorf("Calculate 1250 = %+v, want 1x1000 + 1x250", result)
+	}
+
+	// Deleting 250 changes the answer, and the cached result is not served
+	if rec := deletePackSize(h, 250); rec.Code != http.StatusOK {
+		t.Fatalf("Delete 250 = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if rec := deletePackSize(h, 250); rec.Code != http.StatusNotFound {
+		t.Errorf("Repeat delete = %d, want 404", rec.Code)
+	}
+	if result := decode(calculate(h, `{"amount": 1250}`)); result.TotalItems != 1500 {
+		t.Errorf("Calculate 1250 after delete = %+v, want 1500 items", result)
+	}
+
+	rec := getOrders(h, "")
+	var orders []models.Order
+	if err := json.Unmarshal(rec.Body.Bytes(), &orders); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Orders status = %d, err = %v", rec.Code, err)
+	}
+	if len(orders) != 2 || orders[0].TotalItems != 1500 || orders[1].TotalItems != 1250 {
+		t.Errorf("Orders = %+v, want the two calculations newest first", orders)
+	}
+	if orders[0].Corrupted || !reflect.DeepEqual(orders[0].PackSizes, []int{500, 1000}) {
+		t.Errorf("Newest order = %+v, want intact with pack sizes [500 1000]", orders[0])
+	}
+}
+
+func TestAddPackSize_MaxPackSizes(t *testing.T) {
+	h := NewHandlerWithConfig(newFakeStore(250), nil, Config{MaxPackSizes: 3, MaxPackSize: 10000})
+
+	for _, size := range []int{500, 1000} {
+		if rec := addPackSize(h, size); rec.Code != http.StatusCreated {
+			t.Fatalf("Add %d status = %d, want 201", size, rec.Code)
+		}
+	}
+
+	if rec := addPackSize(h, 2000); rec.Code != http.StatusConflict {
+		t.Errorf("Add beyond limit status = %d, want 409", rec.Code)
+	}
+
+	// Deleting a size frees room for another
+	if rec := deletePackSize(h, 500); rec.Code != http.StatusOK {
+		t.Fatalf("Delete status = %d, want 200", rec.Code)
+	}
+	if rec := addPackSize(h, 2000); rec.Code != http.StatusCreated {
+		t.Errorf("Add after delete status = %d, want 201", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/packs", nil)
+	rec := httptest.NewRecorder()
+	h.GetPackSizes(rec, req)
+	if got := rec.Header().Get("X-Pack-Size-Count"); got != "3" {
+		t.Errorf("X-Pack-Size-Count = %q, want 3", got)
+	}
+	if got := rec.Header().Get("X-Pack-Size-Limit"); got != "3" {
+		t.Errorf("X-Pack-Size-Limit = %q, want 3", got)
+	}
+}
+
+func TestAddPackSize_MaxPackSizesConcurrent(t *testing.T) {
+	store := repository.NewMemoryStore()
+	h := NewHandlerWithConfig(store, nil, Config{MaxPackSizes: 3, MaxPackSize: 10000})
+
+	// Single and bulk additions race for the three slots
+	const attempts = 12
+	codes := make([]int, attempts)
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			size := 250 * (i + 1)
+			if i%2 == 0 {
+				codes[i] = addPackSize(h, size).Code
+				return
+			}
+			req := httptest.NewRequest(http.MethodPost, "/api/packs/bulk", strings.NewReader(fmt.Sprintf(`{"sizes": [%d]}`, size)))
+			rec := httptest.NewRecorder()
+			h.BulkAddPackSizes(rec, req)
+			codes[i] = rec.Code
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	added := 0
+	for i, code := range codes {
+		switch code {
+		case http.StatusCreated, http.StatusOK:
+			added++
+		case http.StatusConflict:
+		default:
+			t.Errorf("Attempt %d status = %d, want success or 409", i, code)
+		}
+	}
+	sizes, err := store.GetPackSizesAsSlice(repository.DefaultProfile)
+	if err != nil {
+		t.Fatalf("GetPackSizesAsSlice() error = %v", err)
+	}
+	if added != 3 || len(sizes) != 3 {
+		t.Errorf("Added %d, stored %v; want exactly 3 within the limit", added, sizes)
+	}
+}
+
+func TestAddPackSize_Feasibility(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandlerWithConfig(store, nil, Config{MaxPackSize: 100000, CalcMemoryBudget: 16 << 20})
+
+	// A prime size next to 500 leaves large amounts with a DP table of millions of totals
+	for size, want := range map[int]int{
+		200000: http.StatusBadRequest,
+		99991:  http.StatusBadRequest,
+		1:      http.StatusCreated,
+		1000:   http.StatusCreated,
+	} {
+		if rec := addPackSize(h, size); rec.Code != want {
+			t.Errorf("Add %d status = %d, want %d: %s", size, rec.Code, want, rec.Body.String())
+		}
+	}
+	if _, added := store.sizes[99991]; added {
+		t.Error("Infeasible size was added")
+	}
+
+	validate := func(body string) models.PackSizeValidation {
+		req := httptest.NewRequest(http.MethodPost, "/api/packs/validate", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ValidatePackSize(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Validate %s status = %d, want 200", body, rec.Code)
+		}
+		var result models.PackSizeValidation
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result
+	}
+	if result := validate(`{"size": 2000}`); !result.Valid || result.Error != "" {
+		t.Errorf("Validate 2000 = %+v, want valid", result)
+	}
+	for _, body := range []string{`{"size": 99991}`, `{"size": 200000}`, `{"size": 250}`, `{"size": 0}`} {
+		if result := validate(body); result.Valid || result.Error == "" {
+			t.Errorf("Validate %s = %+v, want invalid with a reason", body, result)
+		}
+	}
+	if len(store.sizes) != 4 {
+		t.Errorf("Store has %d sizes after validation, want 4", len(store.sizes))
+	}
+}
+
+func TestCreateProfile(t *testing.T) {
+	h := NewHandlerWithConfig(newFakeStore(250), nil, Config{MaxPackSizes: 3, MaxPackSize: 10000})
+	create := func(body string) int {
+		rec := httptest.NewRecorder()
+		h.CreateProfile(rec, httptest.NewRequest(http.MethodPost, "/api/profiles", strings.NewReader(body)))
+		return rec.Code
+	}
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"created", `{"name": " Retail ", "pack_sizes": [6, 12, 24]}`, http.StatusCreated},
+		{"duplicate", `{"name": "retail", "pack_sizes": [6]}`, http.StatusConflict},
+		{"default is reserved", `{"name": "default", "pack_sizes": [6]}`, http.StatusConflict},
+		{"invalid name", `{"name": "re/tail", "pack_sizes": [6]}`, http.StatusBadRequest},
+		{"no sizes", `{"name": "bulk"}`, http.StatusBadRequest},
+		{"invalid size", `{"name": "bulk", "pack_sizes": [0]}`, http.StatusBadRequest},
+		{"over the limit", `{"name": "bulk", "pack_sizes": [1, 2, 3, 4]}`, http.StatusBadRequest},
+		{"duplicate size", `{"name": "bulk", "pack_sizes": [6, 6]}`, http.StatusBadRequest},
+		{"size too large", `{"name": "bulk", "pack_sizes": [6, 20000]}`, http.StatusBadRequest},
+		{"invalid body", `not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if got := create(tt.body); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.CreateProfile(rec, httptest.NewRequest(http.MethodGet, "/api/profiles", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
+
+func TestCalculatePacks_SkipsOrderWhenPackSetChanges(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandler(store, nil)
+
+	// The validator runs between the calculation and the save, standing in for a
+	// concurrent pack size change
+	h.SetResultValidator(func(req models.PackCalculationRequest, result models.PackCalculationResult) error {
+		if req.Amount == 251 {
+			store.DeletePackSize(500)
+		}
+		return nil
+	})
+
+	if rec := calculate(h, `{"amount": 251}`); rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(store.orders) != 0 {
+		t.Errorf("Saved %d orders, want none against the changed pack set", len(store.orders))
+	}
+
+	// One-off sizes are not part of the catalog, so they are always saved
+	if rec := calculate(h, `{"amount": 251, "pack_sizes": [250, 500]}`); rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(store.orders) != 1 {
+		t.Errorf("Saved %d orders, want the custom size order", len(store.orders))
+	}
+}
+
+func TestCalculatePacks_Profile(t *testing.T) {
+	store := newFakeStore(250, 500)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+	// Same sizes as the default profile, so only the profile tells the entries apart
+	if err := store.CreateProfile("retail", []int{500, 250}); err != nil {
+		t.Fatalf("CreateProfile() error = %v", err)
+	}
+	if err := store.CreateProfile("bulk", []int{1000}); err != nil {
+		t.Fatalf("CreateProfile() error = %v", err)
+	}
+
+	calc := func(query string) (int, models.PackCalculationResult) {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate"+query, strings.NewReader(`{"amount": 251}`))
+		rec := httptest.NewRecorder()
+		h.CalculatePacks(rec, req)
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec.Code, result
+	}
+
+	if code, result := calc("?profile=bulk"); code != http.StatusOK || !reflect.DeepEqual(result.Packs, map[int]int{1000: 1}) {
+		t.Errorf("bulk profile = %d %v, want 200 with one 1000 pack", code, result.Packs)
+	}
+	for _, query := range []string{"", "?profile=default", "?profile=retail", "?profile=RETAIL"} {
+		if code, result := calc(query); code != http.StatusOK || !reflect.DeepEqual(result.Packs, map[int]int{500: 1}) {
+			t.Errorf("%q = %d %v, want 200 with one 500 pack", query, code, result.Packs)
+		}
+	}
+	// Default and retail each miss once; the explicit default and uppercase name hit
+	if h.calculations.Load() != 3 || memCache.Stats().Size != 3 {
+		t.Errorf("%d calculations, %d cache entries; want 3 of each, one per profile", h.calculations.Load(), memCache.Stats().Size)
+	}
+
+	for query, want := range map[string]int{
+		"?profile=missing":                http.StatusNotFound,
+		"?profile=bad/name":               http.StatusBadRequest,
+		"?profile=retail&tier=gold":       http.StatusBadRequest,
+		"?profile=retail&respect_stock=1": http.StatusBadRequest,
+	} {
+		if code, _ := calc(query); code != want {
+			t.Errorf("%q status = %d, want %d", query, code, want)
+		}
+	}
+}
+
+func TestCalculatePacks_MaxPacks(t *testing.T) {
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(newFakeStore(250, 500, 1000), memCache)
+
+	calc := func(body string) (int, string, models.PackCalculationResult) {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.CalculatePacks(rec, req)
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return rec.Code, rec.Body.String(), result
+	}
+
+	if code, _, result := calc(`{"amount": 1750}`); code != http.StatusOK || result.TotalPacks != 3 {
+		t.Errorf("Uncapped = %d with %d packs, want 200 with 3", code, result.TotalPacks)
+	}
+	// Two packs force the larger size, overshooting instead of using three packs
+	code, _, result := calc(`{"amount": 1750, "max_packs": 2}`)
+	if code != http.StatusOK || result.TotalItems != 2000 || !reflect.DeepEqual(result.Packs, map[int]int{1000: 2}) {
+		t.Errorf("max_packs 2 = %d %d %v, want 200 with two 1000 packs", code, result.TotalItems, result.Packs)
+	}
+	// Capped and uncapped results are cached separately
+	if memCache.Stats().Size != 2 {
+		t.Errorf("Cache size = %d, want 2", memCache.Stats().Size)
+	}
+
+	if code, body, _ := calc(`{"amount": 3000, "max_packs": 2}`); code != http.StatusUnprocessableEntity || !strings.Contains(body, codeMaxPacks) {
+		t.Errorf("Infeasible cap: status = %d, body = %s, want 422 %s", code, body, codeMaxPacks)
+	}
+	if code, _, _ := calc(`{"amount": 1750, "max_packs": -1}`); code != http.StatusBadRequest {
+		t.Errorf("Negative max_packs: status = %d, want 400", code)
+	}
+}
+
+func TestCalculatePacks_InputModes(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000), cache.NewMemoryCache(100))
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantStatus  int
+		wantError   string
+	}{
+		{"json", "/api/calculate", "application/json", `{"amount": 251}`, http.StatusOK, ""},
+		{"json without content type", "/api/calculate", "", `{"amount": 251}`, http.StatusOK, ""},
+		{"form", "/api/calculate", "application/x-www-form-urlencoded", "amount=251", http.StatusOK, ""},
+		{"form with charset", "/api/calculate", "application/x-www-form-urlencoded; charset=utf-8", "amount=+251+", http.StatusOK, ""},
+		{"query", "/api/calculate?amount=251", "", "", http.StatusOK, ""},
+		{"malformed form", "/api/calculate", "application/x-www-form-urlencoded", "amount=%zz", http.StatusBadRequest, "Invalid form body"},
+		{"form without amount", "/api/calculate", "application/x-www-form-urlencoded", "count=251", http.StatusBadRequest, "amount is required"},
+		{"form amount not an integer", "/api/calculate", "application/x-www-form-urlencoded", "amount=2.5", http.StatusBadRequest, "amount must be an integer"},
+		{"negative query amount", "/api/calculate?amount=-5", "", "", http.StatusBadRequest, codeAmountNegative},
+		{"zero form amount", "/api/calculate", "application/x-www-form-urlencoded", "amount=0", http.StatusBadRequest, codeAmountZero},
+		{"query and json body", "/api/calculate?amount=251", "application/json", `{"amount": 500}`, http.StatusBadRequest, "not both"},
+		{"query and form body", "/api/calculate?amount=251", "application/x-www-form-urlencoded", "amount=500", http.StatusBadRequest, "not both"},
+		{"malformed json", "/api/calculate", "application/json", "amount=251", http.StatusBadRequest, "Invalid request body"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+			h.CalculatePacks(rec, req)
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Fatalf("Status = %d, body = %s, want %d containing %q", rec.Code, rec.Body.String(), tt.wantStatus, tt.wantError)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var result models.PackCalculationResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			if result.Amount != 251 || !reflect.DeepEqual(result.Packs, map[int]int{500: 1}) {
+				t.Errorf("Result = %d %v, want amount 251 packed as one 500", result.Amount, result.Packs)
+			}
+		})
+	}
+}
+
+func TestCalculatePacks_GetCachedByURL(t *testing.T) {
+	store := newFakeStore(250, 500, 1000)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+
+	get := func(target, ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		h.CalculatePacks(rec, req)
+		return rec
+	}
+
+	rec := get("/api/calculate?amount=251", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(etag, `W/"`) || rec.Header().Get("Cache-Control") != "public, max-age=60" {
+		t.Fatalf("GET = %d with ETag %q and Cache-Control %q, want 200 cacheable", rec.Code, etag, rec.Header().Get("Cache-Control"))
+	}
+	if len(store.orders) != 0 {
+		t.Errorf("GET saved %d orders, want none", len(store.orders))
+	}
+
+	// A matching ETag is answered without touching the cache or calculating
+	before := memCache.Stats()
+	for _, header := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag} {
+		rec := get("/api/calculate?amount=251", header)
+		if rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get("ETag") != etag {
+			t.Errorf("If-None-Match %s: status = %d, body = %q, want 304 with the ETag", header, rec.Code, rec.Body.String())
+		}
+	}
+	if after := memCache.Stats(); after.Hits != before.Hits || after.Misses != before.Misses {
+		t.Errorf("Cache stats = %+v, want unchanged from %+v after 304s", after, before)
+	}
+	if rec := get("/api/calculate?amount=251", `W/"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("Stale If-None-Match: status = %d, want 200", rec.Code)
+	}
+
+	// Other amounts and pack sets have other ETags
+	if other := get("/api/calculate?amount=501", "").Header().Get("ETag"); other == etag {
+		t.Error("Different amounts share an ETag")
+	}
+	addPackSize(h, 300)
+	if rec := get("/api/calculate?amount=251", etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("After a pack size change: status = %d, ETag %q, want 200 with a new ETag", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	// Saving makes the request a write, so it is neither cacheable nor short-circuited
+	rec = get("/api/calculate?amount=251&save=true", "*")
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != "" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("GET with save = %d, ETag %q, Cache-Control %q, want 200 no-store", rec.Code, rec.Header().Get("ETag"), rec.Header().Get("Cache-Control"))
+	}
+	if len(store.orders) != 1 {
+		t.Errorf("GET with save saved %d orders, want 1", len(store.orders))
+	}
+
+	if rec := get("/api/calculate", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("GET without amount: status = %d, want 400", rec.Code)
+	}
+	if rec := calculate(h, `{"amount": 251}`); rec.Header().Get("ETag") != "" {
+		t.Errorf("POST ETag = %q, want none", rec.Header().Get("ETag"))
+	}
+}
+
+// reversedStore returns pack sizes in descending order to simulate a different DB ordering
+type reversedStore struct {
+	*fakeStore
+}
+
+func (s reversedStore) GetPackSizesAsSlice(profile string) ([]int, error) {
+	sizes, _ := s.fakeStore.GetPackSizesAsSlice(profile)
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+	return sizes, nil
+}
+
+func calculate(h *Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.CalculatePacks(rec, req)
+	return rec
+}
+
+func TestCalculatePacks_PacksListSorted(t *testing.T) {
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(reversedStore{newFakeStore(250, 500, 1000)}, memCache)
+
+	want := []models.PackLine{{Size: 1000, Count: 1}, {Size: 500, Count: 1}, {Size: 250, Count: 1}}
+	// The second call is served from the cache and must list packs in the same order
+	for i := 0; i < 2; i++ {
+		rec := calculate(h, `{"amount": 1750}`)
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if rec.Code != http.StatusOK || !reflect.DeepEqual(result.PacksList, want) {
+			t.Errorf("Call %d: status = %d, packs_list = %+v, want 200 with %+v", i+1, rec.Code, result.PacksList, want)
+		}
+	}
+	if memCache.Stats().Hits != 1 {
+		t.Errorf("Cache hits = %d, want 1", memCache.Stats().Hits)
+	}
+}
+
+func TestCalculatePacks_CacheKeyIndependentOfStoreOrder(t *testing.T) {
+	memCache := cache.NewMemoryCache(100)
+	sizes := []int{250, 500, 1000, 2000, 5000}
+
+	ascending := NewHandler(newFakeStore(sizes...), memCache)
+	descending := NewHandler(reversedStore{newFakeStore(sizes...)}, memCache)
+
+	if rec := calculate(ascending, `{"amount": 12001}`); rec.Code != http.StatusOK {
+		t.Fatalf("First calculate status = %d, want 200", rec.Code)
+	}
+	if rec := calculate(descending, `{"amount": 12001}`); rec.Code != http.StatusOK {
+		t.Fatalf("Second calculate status = %d, want 200", rec.Code)
+	}
+
+	if _, _, found := memCache.Get(cache.GenerateCacheKey(12001, sizes)); !found {
+		t.Error("Expected result cached under the sorted-size key")
+	}
+	if stats := memCache.Stats(); stats.Hits != 2 || stats.Size != 1 {
+		t.Errorf("Cache stats = %+v, want 2 hits (second request + lookup) and 1 entry", stats)
+	}
+}
+
+func TestCalculatePacks_AmountErrorCodes(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500), nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode string
+		wantMsg  string
+	}{
+		{"zero", `{"amount": 0}`, "AMOUNT_ZERO", "amount must be greater than zero"},
+		{"missing", `{}`, "AMOUNT_ZERO", "amount must be greater than zero"},
+		{"negative", `{"amount": -3}`, "AMOUNT_NEGATIVE", "amount cannot be negative"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := calculate(h, tt.body)
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("Status = %d, want 400", rec.Code)
+			}
+
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if body["code"] != tt.wantCode {
+				t.Errorf("code = %q, want %q", body["code"], tt.wantCode)
+			}
+			if body["error"] != tt.wantMsg {
+				t.Errorf("error = %q, want %q", body["error"], tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCheckFeasibility(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500), nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate/feasibility", strings.NewReader(`{"amounts": [500, 251, 750]}`))
+	rec := httptest.NewRecorder()
+	h.CheckFeasibility(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var results []struct {
+		Amount               int  `json:"amount"`
+		RepresentableExactly bool `json:"representable_exactly"`
+		MinOvershoot         int  `json:"min_overshoot"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Got %d results, want 3", len(results))
+	}
+	if !results[0].RepresentableExactly || results[0].MinOvershoot != 0 {
+		t.Errorf("500: got %+v, want exact", results[0])
+	}
+	if results[1].RepresentableExactly || results[1].MinOvershoot != 249 {
+		t.Errorf("251: got %+v, want overshoot 249", results[1])
+	}
+	if !results[2].RepresentableExactly {
+		t.Errorf("750: got %+v, want exact", results[2])
+	}
+}
+
+func TestCheckFeasibility_UsesCalculationPoolAndBudget(t *testing.T) {
+	pool := workerpool.NewPool(1, 0, 2*time.Second)
+	h := NewHandler(newFakeStore(9967, 9973), nil)
+	h.SetCalculationPool(pool)
+
+	do := func(budget string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"amounts": [%d]}`, maxAmount)
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate/feasibility", strings.NewReader(body))
+		req.Header.Set("X-Calc-Budget", budget)
+		rec := httptest.NewRecorder()
+		h.CheckFeasibility(rec, req)
+		return rec
+	}
+
+	rec := do("1us")
+	if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), `"code":"TIMEOUT"`) {
+		t.Fatalf("Tight budget = %d %s, want 504 TIMEOUT", rec.Code, rec.Body.String())
+	}
+
+	// Occupy the only worker
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	rec = do("30s")
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Saturated pool status = %d, want 503: %s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("Retry-After"); got == "" {
+		t.Error("Saturated pool response has no Retry-After header")
+	}
+}
+
+func TestAddPackSize_OptionalFields(t *testing.T) {
+	store := newFakeStore()
+	h := NewHandler(store, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/packs", strings.NewReader(`{"size": 750, "label": "  Medium  ", "tier": " Wholesale "}`))
+	rec := httptest.NewRecorder()
+	h.AddPackSize(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Status = %d, want 201: %s", rec.Code, rec.Body.String())
+	}
+
+	if got := store.sizes[750]; got.Label != "Medium" || got.Tier != "wholesale" {
+		t.Errorf("Stored pack size = %+v, want normalized label and tier", got)
+	}
+
+	if rec := addPackSize(h, 1000); rec.Code != http.StatusCreated {
+		t.Fatalf("Add without optional fields status = %d, want 201", rec.Code)
+	}
+	if got := store.sizes[1000]; got.Label != "" || got.Tier != "" {
+		t.Errorf("Pack size without optional fields = %+v, want empty label and tier", got)
+	}
+}
+
+func TestPackSizeWebhooks(t *testing.T) {
+	events := make(chan webhook.Event, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhook.Event
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Invalid webhook payload: %v", err)
+		}
+		events <- event
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	notifier := webhook.NewNotifier([]string{receiver.URL}, time.Second)
+	h := NewHandler(newFakeStore(250), nil)
+	h.SetNotifier(notifier)
+
+	if rec := addPackSize(h, 750); rec.Code != http.StatusCreated {
+		t.Fatalf("Add status = %d, want 201", rec.Code)
+	}
+	notifier.Wait()
+	if rec := deletePackSize(h, 750); rec.Code != http.StatusOK {
+		t.Fatalf("Delete status = %d, want 200", rec.Code)
+	}
+	notifier.Wait()
+	close(events)
+
+	var got []webhook.Event
+	for event := range events {
+		got = append(got, event)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Received %d events, want 2", len(got))
+	}
+	if got[0].Type != webhook.EventPackSizeAdded || got[0].Size != 750 {
+		t.Errorf("First event = %+v, want added 750", got[0])
+	}
+	if got[1].Type != webhook.EventPackSizeDeleted || got[1].Size != 750 {
+		t.Errorf("Second event = %+v, want deleted 750", got[1])
+	}
+	for _, event := range got {
+		if event.Timestamp.IsZero() {
+			t.Errorf("Event %+v has no timestamp", event)
+		}
+	}
+
+	// Failed mutations must not notify
+	if rec := deletePackSize(h, 999); rec.Code != http.StatusNotFound {
+		t.Fatalf("Delete missing status = %d, want 404", rec.Code)
+	}
+	notifier.Wait()
+}
+
+func TestStreamCalculationRange(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000), nil)
+	server := httptest.NewServer(http.HandlerFunc(h.StreamCalculationRange))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/calculate/range/stream?lo=249&hi=253")
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", ct)
+	}
+
+	var amounts []int
+	var sawDone bool
+	event := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			if event == "done" {
+				sawDone = true
+				break
+			}
+			if event != "" {
+				t.Fatalf("Unexpected %q event: %s", event, data)
+			}
+			var result models.PackCalculationResult
+			if err := json.Unmarshal(data, &result); err != nil {
+				t.Fatalf("Invalid event data %q: %v", data, err)
+			}
+			if result.TotalItems < result.Amount {
+				t.Errorf("Amount %d: total %d below amount", result.Amount, result.TotalItems)
+			}
+			amounts = append(amounts, result.Amount)
+		case line == "":
+			event = ""
+		}
+	}
+
+	want := []int{249, 250, 251, 252, 253}
+	if fmt.Sprint(amounts) != fmt.Sprint(want) {
+		t.Errorf("Streamed amounts = %v, want %v", amounts, want)
+	}
+	if !sawDone {
+		t.Error("Missing final done event")
+	}
+}
+
+// cancelOnFlush cancels the request context on the first Flush, as if the client
+// disconnected once the first event arrived
+type cancelOnFlush struct {
+	*httptest.ResponseRecorder
+	cancel context.CancelFunc
+}
+
+func (c cancelOnFlush) Flush() {
+	c.ResponseRecorder.Flush()
+	c.cancel()
+}
+
+func TestStreamCalculationRange_StopsWhenClientGoesAway(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/calculate/range/stream?lo=1&hi=1000", nil).WithContext(ctx)
+	rec := cancelOnFlush{httptest.NewRecorder(), cancel}
+	h.StreamCalculationRange(rec, req)
+
+	body := rec.Body.String()
+	if got := strings.Count(body, "data: "); got != 1 {
+		t.Errorf("Streamed %d events after the client went away, want 1: %s", got, body)
+	}
+	if strings.Contains(body, "event: done") {
+		t.Error("Sent done event to a client that went away")
+	}
+}
+
+func TestCalculatePacks_TargetWeight(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000, 2000, 5000), nil)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantAmount int
+		wantTotal  int
+	}{
+		// 30 kg at 0.12 kg per item needs exactly 250 items
+		{"exact division", `{"target_weight": 30, "item_weight": 0.12}`, 250, 250},
+		// 10 kg at 0.1 kg per item must not round up to 101 from float error
+		{"float division", `{"target_weight": 10, "item_weight": 0.1}`, 100, 250},
+		// 50.1 kg at 0.2 kg per item needs 251 items
+		{"rounds up", `{"target_weight": 50.1, "item_weight": 0.2}`, 251, 500},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := calculate(h, tt.body)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			var result models.PackCalculationResult
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if result.Amount != tt.wantAmount {
+				t.Errorf("Amount = %d, want %d", result.Amount, tt.wantAmount)
+			}
+			if result.TotalItems != tt.wantTotal {
+				t.Errorf("TotalItems = %d, want %d", result.TotalItems, tt.wantTotal)
+			}
+			if result.TargetWeight == 0 || result.ItemWeight == 0 {
+				t.Errorf("Weights not echoed in response: %+v", result)
+			}
+		})
+	}
+
+	for _, body := range []string{
+		`{"target_weight": 10, "item_weight": 0}`,
+		`{"target_weight": 10, "item_weight": -1}`,
+		`{"item_weight": 0.5}`,
+		`{"amount": 10, "target_weight": 10, "item_weight": 0.5}`,
+	} {
+		if rec := calculate(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestRouting_JSONErrors(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/calculate", h.CalculatePacks)
+	mux.HandleFunc("/api/orders", h.GetOrders)
+	mux.HandleFunc("/", NotFoundHandler)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unknown path", http.MethodGet, "/api/nope", http.StatusNotFound, "NOT_FOUND"},
+		{"root path", http.MethodGet, "/", http.StatusNotFound, "NOT_FOUND"},
+		{"wrong method", http.MethodDelete, "/api/calculate", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+		{"wrong method on orders", http.MethodPost, "/api/orders", http.StatusMethodNotAllowed, "METHOD_NOT_ALLOWED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", ct)
+			}
+			var body map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if body["code"] != tt.wantCode || body["error"] == "" {
+				t.Errorf("Body = %v, want code %s with a message", body, tt.wantCode)
+			}
+		})
+	}
+
+	// Real routes still match
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/orders", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("GET /api/orders status = %d, want 200", rec.Code)
+	}
+}
+
+func calculateWithQuery(h *Handler, query, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate"+query, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.CalculatePacks(rec, req)
+	return rec
+}
+
+func TestCalculatePacks_SummaryOnlyAndMaxLines(t *testing.T) {
+	h := NewHandler(newFakeStore(23, 31, 53), cache.NewMemoryCache(10))
+	body := `{"amount": 500000}`
+
+	decode := func(rec *httptest.ResponseRecorder) map[string]interface{} {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+		}
+		var out map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		return out
+	}
+
+	normal := decode(calculateWithQuery(h, "", body))
+	if packs := normal["packs"].(map[string]interface{}); len(packs) != 3 || packs["53"] != float64(9429) {
+		t.Errorf("Normal packs = %v, want 3 sizes with 9429 x 53", packs)
+	}
+
+	// Served from cache: the reduced views must not corrupt the cached map
+	summary := decode(calculateWithQuery(h, "?summary_only=1", body))
+	if _, ok := summary["packs"]; ok {
+		t.Errorf("Summary response includes packs: %v", summary)
+	}
+	if summary["total_items"] != float64(500000) || summary["total_packs"] != float64(9438) {
+		t.Errorf("Summary totals = %v/%v, want 500000/9438", summary["total_items"], summary["total_packs"])
+	}
+
+	capped := decode(calculateWithQuery(h, "?max_lines=1", body))
+	if packs := capped["packs"].(map[string]interface{}); len(packs) != 1 || packs["53"] != float64(9429) {
+		t.Errorf("Capped packs = %v, want only 53 x 9429", packs)
+	}
+	if capped["omitted_lines"] != float64(2) || capped["total_packs"] != float64(9438) {
+		t.Errorf("Capped omitted_lines/total_packs = %v/%v, want 2/9438", capped["omitted_lines"], capped["total_packs"])
+	}
+
+	again := decode(calculateWithQuery(h, "", body))
+	if packs := again["packs"].(map[string]interface{}); len(packs) != 3 {
+		t.Errorf("Cached packs after reduced views = %v, want 3 sizes", packs)
+	}
+
+	if rec := calculateWithQuery(h, "?max_lines=0", body); rec.Code != http.StatusBadRequest {
+		t.Errorf("max_lines=0 status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_OverloadedRetryAfter(t *testing.T) {
+	pool := workerpool.NewPool(1, 0, 2*time.Second)
+	h := NewHandler(newFakeStore(250, 500), nil)
+	h.SetCalculationPool(pool)
+
+	// Occupy the only worker
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	rec := calculate(h, `{"amount": 251}`)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Status = %d, want 503", rec.Code)
+	}
+
+	// One in-flight calculation on one worker at ~2s each
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if body["retry_after_seconds"] != float64(2) || body["code"] != "OVERLOADED" {
+		t.Errorf("Body = %v, want retry_after_seconds 2 and code OVERLOADED", body)
+	}
+}
+
+func TestCalculatePacks_CheapRequestsOvertakeExpensive(t *testing.T) {
+	pool := workerpool.NewPool(1, 10, time.Second)
+	pool.SetAgingRate(0)
+	h := NewHandler(newFakeStore(250, 500), nil)
+	h.SetCalculationPool(pool)
+
+	// Occupy the only worker so every request queues
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go pool.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	finished := make(chan string, 4)
+	send := func(name, body string) {
+		if rec := calculate(h, body); rec.Code != http.StatusOK {
+			t.Errorf("%s status = %d: %s", name, rec.Code, rec.Body.String())
+		}
+		finished <- name
+	}
+
+	go send("huge", `{"amount": 5000000, "pack_sizes": [7]}`)
+	for pool.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for _, amount := range []int{251, 501, 750} {
+		go send("tiny", fmt.Sprintf(`{"amount": %d}`, amount))
+	}
+	for pool.Stats().Queued != 4 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		if name := <-finished; name != "tiny" {
+			t.Fatalf("Completion %d = %s, want the tiny requests first", i+1, name)
+		}
+	}
+	<-finished
+}
+
+func getOrders(h *Handler, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/api/orders"+query, nil)
+	rec := httptest.NewRecorder()
+	h.GetOrders(rec, req)
+	return rec
+}
+
+func deleteOrder(h *Handler, id string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodDelete, "/api/orders/"+id, nil)
+	rec := httptest.NewRecorder()
+	h.DeleteOrder(rec, req)
+	return rec
+}
+
+func TestDeleteOrder(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandler(store, nil)
+	for _, amount := range []int{251, 501} {
+		if rec := calculate(h, fmt.Sprintf(`{"amount": %d}`, amount)); rec.Code != http.StatusOK {
+			t.Fatalf("Calculate %d status = %d", amount, rec.Code)
+		}
+	}
+
+	if rec := deleteOrder(h, "1"); rec.Code != http.StatusOK {
+		t.Fatalf("Delete status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var orders []models.Order
+	if err := json.Unmarshal(getOrders(h, "").Body.Bytes(), &orders); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if len(orders) != 1 || orders[0].Amount != 501 {
+		t.Errorf("Orders after delete = %+v, want only the 501 order", orders)
+	}
+
+	tests := []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"already deleted", "1", http.StatusNotFound},
+		{"unknown", "99", http.StatusNotFound},
+		{"non-numeric", "abc", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if rec := deleteOrder(h, tt.id); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/orders/2", nil)
+	rec := httptest.NewRecorder()
+	h.DeleteOrder(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET status = %d, want 405", rec.Code)
+	}
+}
+
+func TestGetOrders_CursorPaging(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandler(store, nil)
+	const seeded = 25
+	for amount := 1; amount <= seeded; amount++ {
+		if rec := calculate(h, fmt.Sprintf(`{"amount": %d}`, amount)); rec.Code != http.StatusOK {
+			t.Fatalf("Calculate %d status = %d", amount, rec.Code)
+		}
+	}
+	// Shared timestamps must not break page boundaries
+	sameTime := time.Now()
+	for i := range store.orders {
+		store.orders[i].CreatedAt = sameTime
+	}
+
+	seen := make(map[int]bool)
+	var pages int
+	cursor := ""
+	for {
+		rec := getOrders(h, "?limit=10&cursor="+cursor)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Page %d status = %d: %s", pages+1, rec.Code, rec.Body.String())
+		}
+		var page models.OrderPage
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		pages++
+		for _, order := range page.Orders {
+			if seen[order.ID] {
+				t.Errorf("Order %d returned twice", order.ID)
+			}
+			seen[order.ID] = true
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		if pages > seeded {
+			t.Fatal("Paging did not terminate")
+		}
+		cursor = page.NextCursor
+	}
+	if pages != 3 || len(seen) != seeded {
+		t.Errorf("Paged %d orders over %d pages, want %d over 3", len(seen), pages, seeded)
+	}
+
+	// Without a cursor the response stays a plain array
+	var orders []models.Order
+	if err := json.Unmarshal(getOrders(h, "?limit=5").Body.Bytes(), &orders); err != nil || len(orders) != 5 {
+		t.Errorf("Unpaged response = %d orders, %v; want a 5-element array", len(orders), err)
+	}
+	if rec := getOrders(h, "?cursor=not-a-cursor"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid cursor status = %d, want 400", rec.Code)
+	}
+}
+
+// limitRecordingStore records the limit each order query asks for
+type limitRecordingStore struct {
+	*fakeStore
+	limits *[]int
+}
+
+func (s limitRecordingStore) QueryOrders(filter repository.OrderFilter) ([]models.Order, error) {
+	*s.limits = append(*s.limits, filter.Limit)
+	return s.fakeStore.QueryOrders(filter)
+}
+
+func TestGetOrders_LimitIsClamped(t *testing.T) {
+	var limits []int
+	h := NewHandler(limitRecordingStore{newFakeStore(250), &limits}, nil)
+
+	for _, query := range []string{
+		"?limit=1001",
+		fmt.Sprintf("?limit=%d&cursor=", math.MaxInt),
+	} {
+		if rec := getOrders(h, query); rec.Code != http.StatusOK {
+			t.Fatalf("GET %s status = %d: %s", query, rec.Code, rec.Body.String())
+		}
+	}
+
+	// The paged query asks for one look-ahead row on top of the clamped limit
+	if want := []int{maxOrdersLimit, maxOrdersLimit + 1}; fmt.Sprint(limits) != fmt.Sprint(want) {
+		t.Errorf("Queried limits = %v, want %v", limits, want)
+	}
+}
+
+func TestGetOrders_AmountFilters(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandler(store, nil)
+	for _, amount := range []int{100, 250, 251, 500, 1000} {
+		if rec := calculate(h, fmt.Sprintf(`{"amount": %d}`, amount)); rec.Code != http.StatusOK {
+			t.Fatalf("Calculate %d status = %d", amount, rec.Code)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  []int
+	}{
+		{"exact", "?amount=251", []int{251}},
+		{"range", "?min_amount=250&max_amount=500", []int{500, 251, 250}},
+		{"lower bound only", "?min_amount=501", []int{1000}},
+		{"no match", "?amount=7", []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := getOrders(h, tt.query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want 200", rec.Code)
+			}
+			var orders []models.Order
+			if err := json.Unmarshal(rec.Body.Bytes(), &orders); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			got := make([]int, 0, len(orders))
+			for _, o := range orders {
+				got = append(got, o.Amount)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Amounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, query := range []string{"?amount=abc", "?min_amount=10&max_amount=5", "?max_amount=1%3BDROP"} {
+		if rec := getOrders(h, query); rec.Code != http.StatusBadRequest {
+			t.Errorf("Query %s status = %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+// countingCache records calls so tests can assert the cache was not touched
+type countingCache struct {
+	cache.Cache
+	gets, sets int
+	lastTTL    time.Duration // TTL passed to the latest Set
+}
+
+func (c *countingCache) Get(key string) (map[int]int, int, bool) {
+	c.gets++
+	return c.Cache.Get(key)
+}
+
+func (c *countingCache) Set(key string, packs map[int]int, total int, ttl time.Duration) {
+	c.sets++
+	c.lastTTL = ttl
+	c.Cache.Set(key, packs, total, ttl)
+}
+
+func TestCalculatePacks_CacheHitsSaveOrders(t *testing.T) {
+	store := newFakeStore(250, 500)
+	counting := &countingCache{Cache: cache.NewMemoryCache(100)}
+	h := NewHandler(store, counting)
+
+	for i := 0; i < 2; i++ {
+		if rec := calculate(h, `{"amount": 251}`); rec.Code != http.StatusOK {
+			t.Fatalf("Request %d status = %d", i+1, rec.Code)
+		}
+	}
+	if counting.sets != 1 || h.calculations.Load() != 1 {
+		t.Fatalf("%d cache sets, %d calculations; want the repeat served from cache", counting.sets, h.calculations.Load())
+	}
+	if len(store.orders) != 2 {
+		t.Fatalf("Saved %d orders, want 2", len(store.orders))
+	}
+	if first, second := store.orders[0], store.orders[1]; second.TotalItems != first.TotalItems || second.TotalPacks != first.TotalPacks || !reflect.DeepEqual(second.Packs, first.Packs) {
+		t.Errorf("Cached order = %+v, want the same result as %+v", second, first)
+	}
+
+	// ?save=false skips the order on hits and misses alike
+	for _, body := range []string{`{"amount": 251}`, `{"amount": 751}`} {
+		if rec := calculateWithQuery(h, "?save=false", body); rec.Code != http.StatusOK {
+			t.Fatalf("save=false status = %d", rec.Code)
+		}
+	}
+	if len(store.orders) != 2 {
+		t.Errorf("Saved %d orders with save=false, want still 2", len(store.orders))
+	}
+
+	if rec := calculateWithQuery(h, "?save=maybe", `{"amount": 251}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid save status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_DryRun(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	counting := &countingCache{Cache: cache.NewMemoryCache(100)}
+	h := NewHandler(store, counting)
+
+	rec := calculateWithQuery(h, "?dryrun=1", `{"amount": 12001}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	var result models.PackCalculationResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := map[int]int{5000: 2, 2000: 1, 250: 1}
+	if result.TotalItems != 12250 || result.TotalPacks != 4 || fmt.Sprint(result.Packs) != fmt.Sprint(want) {
+		t.Errorf("Result = %+v, want 12250 items in %v", result, want)
+	}
+
+	if counting.gets != 0 || counting.sets != 0 {
+		t.Errorf("Cache touched in dry run: %d gets, %d sets", counting.gets, counting.sets)
+	}
+	if len(store.orders) != 0 {
+		t.Errorf("Saved %d orders in dry run, want 0", len(store.orders))
+	}
+
+	// Without the flag both side effects happen
+	if rec := calculate(h, `{"amount": 12001}`); rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	if counting.gets != 1 || counting.sets != 1 || len(store.orders) != 1 {
+		t.Errorf("Normal request: %d gets, %d sets, %d orders; want 1 each", counting.gets, counting.sets, len(store.orders))
+	}
+
+	if rec := calculateWithQuery(h, "?dryrun=maybe", `{"amount": 1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid dryrun status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_CacheTTLSeconds(t *testing.T) {
+	counting := &countingCache{Cache: cache.NewMemoryCache(100)}
+	config := DefaultConfig()
+	config.CacheTTL = 5 * time.Minute
+	h := NewHandlerWithConfig(newFakeStore(250, 500), counting, config)
+
+	tests := []struct {
+		name     string
+		body     string
+		wantSets int
+		wantTTL  time.Duration
+	}{
+		{"configured default", `{"amount": 251}`, 1, 5 * time.Minute},
+		{"override", `{"amount": 501, "cache_ttl_seconds": 30}`, 2, 30 * time.Second},
+		{"clamped", `{"amount": 751, "cache_ttl_seconds": 10000000}`, 3, maxRequestCacheTTL},
+		{"zero skips caching", `{"amount": 1001, "cache_ttl_seconds": 0}`, 3, maxRequestCacheTTL},
+	}
+	for _, tt := range tests {
+		if rec := calculate(h, tt.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200", tt.name, rec.Code)
+		}
+		if counting.sets != tt.wantSets || counting.lastTTL != tt.wantTTL {
+			t.Errorf("%s: %d sets, last TTL %s; want %d and %s", tt.name, counting.sets, counting.lastTTL, tt.wantSets, tt.wantTTL)
+		}
+	}
+
+	if rec := calculate(h, `{"amount": 251, "cache_ttl_seconds": -1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Negative cache_ttl_seconds status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculationSlip(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/calculate/slip?amount=12001", nil)
+	rec := httptest.NewRecorder()
+	h.CalculationSlip(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/pdf" {
+		t.Errorf("Content-Type = %q, want application/pdf", ct)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); !strings.HasPrefix(cd, "attachment;") {
+		t.Errorf("Content-Disposition = %q, want attachment", cd)
+	}
+
+	pdf := rec.Body.String()
+	if !strings.HasPrefix(pdf, "%PDF-") {
+		t.Fatalf("Body is not a PDF: %q", pdf[:min(len(pdf), 16)])
+	}
+	for _, want := range []string{"(Total items: 12250)", "(Total packs: 4)"} {
+		if !strings.Contains(pdf, want) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+	if len(store.orders) != 0 {
+		t.Errorf("Slip saved %d orders, want 0", len(store.orders))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/calculate/slip?amount=abc", nil)
+	rec = httptest.NewRecorder()
+	h.CalculationSlip(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid amount status = %d, want 400", rec.Code)
+	}
+}
+
+func TestGetOrders_TimeZone(t *testing.T) {
+	store := newFakeStore(250)
+	stored := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	store.orders = []models.Order{{ID: 1, Amount: 250, TotalItems: 250, TotalPacks: 1, CreatedAt: stored, UpdatedAt: stored}}
+	h := NewHandler(store, nil)
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "2024-03-15T12:30:00Z"},
+		{"?tz=Asia/Tokyo", "2024-03-15T21:30:00+09:00"},
+		{"?tz=America/New_York", "2024-03-15T08:30:00-04:00"}, // EDT
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := getOrders(h, tt.query)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+			var orders []map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &orders); err != nil {
+				t.Fatalf("Invalid JSON response: %v", err)
+			}
+			if len(orders) != 1 || orders[0]["created_at"] != tt.want || orders[0]["updated_at"] != tt.want {
+				t.Errorf("created_at/updated_at = %v, want %s", orders, tt.want)
+			}
+		})
+	}
+
+	if rec := getOrders(h, "?tz=Mars/Olympus_Mons"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid zone status = %d, want 400", rec.Code)
+	}
+	if !store.orders[0].CreatedAt.Equal(stored) || store.orders[0].CreatedAt.Location() != time.UTC {
+		t.Error("Stored timestamp was modified")
+	}
+}
+
+func TestGetFeatures_MatchesConfig(t *testing.T) {
+	features := func(h *Handler) map[string]bool {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.GetFeatures(rec, httptest.NewRequest(http.MethodGet, "/api/features", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, want 200", rec.Code)
+		}
+		var got map[string]bool
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		return got
+	}
+
+	got := features(NewHandler(newFakeStore(250), nil))
+	for name, want := range map[string]bool{
+		"exact": true, "custom_sizes": true, "strict_exact": false, "batch": true,
+		"orders": true, "auth": false, "webhooks": false, "worker_pool": false, "profiles": true,
+	} {
+		if enabled, reported := got[name]; !reported || enabled != want {
+			t.Errorf("Default %q = %v (reported %v), want %v", name, enabled, reported, want)
+		}
+	}
+
+	t.Setenv("API_KEY", "key")
+	t.Setenv("WEBHOOK_URLS", "http://hooks.example")
+	t.Setenv("ORDERS_RETENTION", "720h")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	handlerConfig := DefaultConfig()
+	handlerConfig.StrictExact = true
+	handlerConfig.OrderSampleRate = 0
+	h := NewHandlerWithConfig(newFakeStore(250), nil, handlerConfig)
+	h.SetEffectiveConfig(cfg)
+	h.SetCalculationPool(workerpool.NewPool(1, 1, time.Second))
+
+	got = features(h)
+	for name, want := range map[string]bool{
+		"strict_exact": true, "orders": false, "auth": true, "webhooks": true,
+		"order_retention": true, "worker_pool": true, "peer_cache": false,
+	} {
+		if got[name] != want {
+			t.Errorf("Configured %q = %v, want %v", name, got[name], want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.GetFeatures(rec, httptest.NewRequest(http.MethodPost, "/api/features", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
+
+func TestGetConfig_RedactsSecrets(t *testing.T) {
+	t.Setenv("API_KEY", "super-secret-key")
+	t.Setenv("DB_PASSWORD", "hunter2")
+	t.Setenv("CACHE_SIZE", "500")
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	cfg.Endpoints = []string{"/api/calculate", "/api/config"}
+
+	h := NewHandler(newFakeStore(250), nil)
+	h.SetEffectiveConfig(cfg)
+
+	rec := httptest.NewRecorder()
+	h.GetConfig(rec, httptest.NewRequest(http.MethodGet, "/api/config", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+
+	body := rec.Body.String()
+	for _, secret := range []string{"super-secret-key", "hunter2"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("Response leaks %q", secret)
+		}
+	}
+
+	var resp struct {
+		Config struct {
+			APIKey   string `json:"api_key"`
+			Database struct {
+				Password string `json:"password"`
+			} `json:"database"`
+			Cache struct {
+				Size int    `json:"size"`
+				TTL  string `json:"ttl"`
+			} `json:"cache"`
+			RateLimit struct {
+				Burst int `json:"burst"`
+			} `json:"rate_limit"`
+			Endpoints []string `json:"endpoints"`
+		} `json:"config"`
+		Limits map[string]int `json:"limits"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	if resp.Config.APIKey != config.Redacted || resp.Config.Database.Password != config.Redacted {
+		t.Errorf("Secrets = %q / %q, want redacted", resp.Config.APIKey, resp.Config.Database.Password)
+	}
+	if resp.Config.Cache.Size != 500 || resp.Config.Cache.TTL != "1h0m0s" {
+		t.Errorf("Cache = %+v, want size 500 and ttl 1h0m0s", resp.Config.Cache)
+	}
+	if resp.Config.RateLimit.Burst != 20 {
+		t.Errorf("RateLimit.Burst = %d, want 20", resp.Config.RateLimit.Burst)
+	}
+	if len(resp.Config.Endpoints) != 2 {
+		t.Errorf("Endpoints = %v, want 2 entries", resp.Config.Endpoints)
+	}
+	if resp.Limits["max_amount"] != maxAmount {
+		t.Errorf("max_amount = %d, want %d", resp.Limits["max_amount"], maxAmount)
+	}
+}
+
+func TestCalculatePacks_CalcBudget(t *testing.T) {
+	// Two large coprime sizes keep the DP table at the full amount, so the run is slow
+	h := NewHandler(newFakeStore(9967, 9973), nil)
+	body := fmt.Sprintf(`{"amount": %d}`, maxAmount)
+
+	do := func(budget string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate?dryrun=1", strings.NewReader(body))
+		req.Header.Set("X-Calc-Budget", budget)
+		rec := httptest.NewRecorder()
+		h.CalculatePacks(rec, req)
+		return rec
+	}
+
+	rec := do("1us")
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("Tight budget status = %d, want 504: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"code":"TIMEOUT"`) {
+		t.Errorf("Tight budget body = %s, want TIMEOUT code", rec.Body.String())
+	}
+
+	rec = do("30s")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Generous budget status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var result models.PackCalculationResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.TotalItems < maxAmount {
+		t.Errorf("Generous budget result = %+v (%v)", result, err)
+	}
+
+	if rec := do("soon"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid budget status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_MemoryBudget(t *testing.T) {
+	h := NewHandlerWithConfig(newFakeStore(250, 500), nil, Config{CalcMemoryBudget: 1024})
+	rec := calculate(h, `{"amount": 1000}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), codeMemoryBudget) {
+		t.Errorf("Over budget: status = %d, body = %s, want 422 %s", rec.Code, rec.Body.String(), codeMemoryBudget)
+	}
+
+	h = NewHandler(newFakeStore(250, 500), nil)
+	if rec := calculate(h, `{"amount": 1000}`); rec.Code != http.StatusOK {
+		t.Errorf("Default budget: status = %d, want 200", rec.Code)
+	}
+}
+
+func TestCalculationBudget_CappedAtMax(t *testing.T) {
+	h := NewHandlerWithConfig(newFakeStore(250), nil, Config{CalcTimeout: time.Second, MaxCalcBudget: 5 * time.Second})
+
+	for header, want := range map[string]time.Duration{
+		"":     time.Second,
+		"2s":   2 * time.Second,
+		"1h":   5 * time.Second,
+		"10ms": 10 * time.Millisecond,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		if header != "" {
+			req.Header.Set("X-Calc-Budget", header)
+		}
+		if got, err := h.calculationBudget(req); err != nil || got != want {
+			t.Errorf("calculationBudget(%q) = %v, %v; want %v", header, got, err, want)
+		}
+	}
+}
+
+func TestPackSizeChange_ScopedInvalidation(t *testing.T) {
+	shared := cache.NewMemoryCache(100)
+	profileA := NewHandler(newFakeStore(250, 500, 1000), shared)
+	profileB := NewHandler(newFakeStore(23, 31, 53), shared)
+
+	for _, h := range []*Handler{profileA, profileB} {
+		if rec := calculate(h, `{"amount": 263}`); rec.Code != http.StatusOK {
+			t.Fatalf("Calculate status = %d", rec.Code)
+		}
+	}
+
+	if rec := addPackSize(profileA, 2000); rec.Code != http.StatusCreated {
+		t.Fatalf("Add status = %d", rec.Code)
+	}
+	if _, _, found := shared.Get(cache.GenerateCacheKey(263, []int{250, 500, 1000})); found {
+		t.Error("Profile A's result for its old pack set was not invalidated")
+	}
+	if _, _, found := shared.Get(cache.GenerateCacheKey(263, []int{23, 31, 53})); !found {
+		t.Error("Profile B's cached result was invalidated by a change in profile A")
+	}
+
+	if rec := calculate(profileA, `{"amount": 263}`); rec.Code != http.StatusOK {
+		t.Fatalf("Calculate status = %d", rec.Code)
+	}
+	if rec := deletePackSize(profileA, 2000); rec.Code != http.StatusOK {
+		t.Fatalf("Delete status = %d", rec.Code)
+	}
+	if _, _, found := shared.Get(cache.GenerateCacheKey(263, []int{250, 500, 1000, 2000})); found {
+		t.Error("Profile A's result was not invalidated on delete")
+	}
+	if _, _, found := shared.Get(cache.GenerateCacheKey(263, []int{23, 31, 53})); !found {
+		t.Error("Profile B's cached result was invalidated by a delete in profile A")
+	}
+}
+
+func TestCalculatePacks_RoundTo(t *testing.T) {
+	store := newFakeStore(100, 250)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	for _, attempt := range []string{"computed", "cached"} {
+		rec := calculate(h, `{"amount": 251, "round_to": 100}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want 200: %s", attempt, rec.Code, rec.Body.String())
+		}
+		var result models.PackCalculationResult
+		if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+			t.Fatalf("Invalid JSON response: %v", err)
+		}
+		// 300 is packed (3x100), not 251 (which would be 250+100 = 350)
+		if result.Amount != 251 || result.RoundedAmount != 300 || result.TotalItems != 300 {
+			t.Errorf("%s: result = %+v, want amount 251 rounded to 300 with 300 items", attempt, result)
+		}
+	}
+
+	if _, _, found := h.cache.Get(cache.GenerateCacheKey(300, []int{100, 250})); !found {
+		t.Error("Result not cached under the rounded amount")
+	}
+
+	overflow := fmt.Sprintf(`{"amount": %d, "round_to": 100}`, math.MaxInt-1)
+	for _, body := range []string{`{"amount": 251, "round_to": 0}`, `{"amount": 251, "round_to": -5}`, overflow} {
+		if rec := calculate(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s status = %d, want 400", body, rec.Code)
+		}
+	}
+
+	// Without round_to the rounded amount is omitted
+	if rec := calculate(h, `{"amount": 251}`); strings.Contains(rec.Body.String(), "rounded_amount") {
+		t.Errorf("Unrounded response includes rounded_amount: %s", rec.Body.String())
+	}
+}
+
+func TestRoundUp(t *testing.T) {
+	tests := []struct {
+		amount, step, want int
+		ok                 bool
+	}{
+		{251, 100, 300, true},
+		{300, 100, 300, true},
+		{1, 50, 50, true},
+		{7, 1, 7, true},
+		{5, math.MaxInt, math.MaxInt, true},
+		{math.MaxInt - 1, 100, 0, false},
+		{math.MaxInt, 2, 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := roundUp(tt.amount, tt.step); got != tt.want || ok != tt.ok {
+			t.Errorf("roundUp(%d, %d) = %d, %v, want %d, %v", tt.amount, tt.step, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func intPtr(n int) *int { return &n }
+
+func TestCalculatePacks_RespectStock(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	store.SetStock(5000, intPtr(1))
+	store.SetStock(250, intPtr(0))
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+
+	rec := calculateWithQuery(h, "?respect_stock=1", `{"amount": 12001}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	var result models.PackCalculationResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Invalid JSON response: %v", err)
+	}
+	// Unconstrained would be 2x5000 + 2000 + 250; with one 5000 and no 250s, 12500 is best
+	want := map[int]int{5000: 1, 2000: 3, 1000: 1, 500: 1}
+	if result.TotalItems != 12500 || fmt.Sprint(result.Packs) != fmt.Sprint(want) {
+		t.Errorf("Result = %+v, want 12500 items as %v", result, want)
+	}
+	if stats := memCache.Stats(); stats.Size != 0 {
+		t.Errorf("Stock-limited result was cached (%d entries)", stats.Size)
+	}
+
+	// Without the flag stock is ignored
+	rec = calculate(h, `{"amount": 12001}`)
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.TotalItems != 12250 {
+		t.Errorf("Unconstrained result = %+v (%v), want 12250 items", result, err)
+	}
+
+	// Running out of stock entirely is a conflict
+	for _, size := range []int{500, 1000, 2000} {
+		store.SetStock(size, intPtr(0))
+	}
+	rec = calculateWithQuery(h, "?respect_stock=1", `{"amount": 6000}`)
+	if rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "INSUFFICIENT_STOCK") {
+		t.Errorf("Out of stock = %d %s, want 409 INSUFFICIENT_STOCK", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReserveStock(t *testing.T) {
+	store := newFakeStore(250, 500)
+	store.SetStock(250, intPtr(3))
+	h := NewHandler(store, nil)
+
+	reserve := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/stock/reserve", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.ReserveStock(rec, req)
+		return rec
+	}
+
+	if rec := reserve(`{"packs": {"250": 2, "500": 10}}`); rec.Code != http.StatusOK {
+		t.Fatalf("Reserve status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+	if got := *store.sizes[250].Stock; got != 1 {
+		t.Errorf("Stock after reservation = %d, want 1", got)
+	}
+	if rec := reserve(`{"packs": {"250": 2}}`); rec.Code != http.StatusConflict {
+		t.Errorf("Overselling status = %d, want 409", rec.Code)
+	}
+	if rec := reserve(`{"packs": {"750": 1}}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Unknown size status = %d, want 404", rec.Code)
+	}
+	if rec := reserve(`{"packs": {"250": 0}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Zero quantity status = %d, want 400", rec.Code)
+	}
+}
+
+func importPackSizes(h *Handler, query, body string) (*httptest.ResponseRecorder, models.PackImportSummary) {
+	req := httptest.NewRequest(http.MethodPost, "/api/packs/import"+query, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ImportPackSizes(rec, req)
+
+	var summary models.PackImportSummary
+	json.Unmarshal(rec.Body.Bytes(), &summary)
+	return rec, summary
+}
+
+func TestBulkAddPackSizes(t *testing.T) {
+	store := newFakeStore(250)
+	memCache := cache.NewMemoryCache(10)
+	memCache.Set(cache.GenerateCacheKey(250, []int{250}), map[int]int{250: 1}, 250, time.Hour)
+	h := NewHandlerWithConfig(store, memCache, Config{MaxPackSizes: 4})
+
+	bulk := func(body string) (int, models.BulkAddPackSizesSummary) {
+		req := httptest.NewRequest(http.MethodPost, "/api/packs/bulk", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		h.BulkAddPackSizes(rec, req)
+		var summary models.BulkAddPackSizesSummary
+		json.Unmarshal(rec.Body.Bytes(), &summary)
+		return rec.Code, summary
+	}
+
+	code, summary := bulk(`{"sizes": [500, 250, 1000, 500]}`)
+	if code != http.StatusOK || !reflect.DeepEqual(summary.Added, []int{500, 1000}) || !reflect.DeepEqual(summary.Skipped, []int{250, 500}) {
+		t.Errorf("Bulk add = %d %+v, want 500 and 1000 added, 250 and 500 skipped", code, summary)
+	}
+	if len(store.sizes) != 3 {
+		t.Errorf("Store has %d sizes, want 3", len(store.sizes))
+	}
+	if memCache.Stats().Size != 0 {
+		t.Error("Cached result for the old pack set was not invalidated")
+	}
+
+	// Any invalid size, or going over the limit, adds nothing
+	for body, want := range map[string]int{
+		`{"sizes": []}`:                 http.StatusBadRequest,
+		`{"sizes": [2000, 0]}`:          http.StatusBadRequest,
+		`{"sizes": [2000, 5000, 7000]}`: http.StatusConflict,
+	} {
+		if code, _ := bulk(body); code != want {
+			t.Errorf("%s status = %d, want %d", body, code, want)
+		}
+	}
+	if len(store.sizes) != 3 {
+		t.Errorf("Store has %d sizes after rejected requests, want 3", len(store.sizes))
+	}
+}
+
+func TestImportPackSizes_Lenient(t *testing.T) {
+	store := newFakeStore(250)
+	h := NewHandler(store, nil)
+
+	body := "size,label\n500,Half\nabc\n1000\n-5\n250\n"
+	rec, summary := importPackSizes(h, "", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200: %s", rec.Code, rec.Body.String())
+	}
+
+	if fmt.Sprint(summary.Imported) != "[500 1000]" {
+		t.Errorf("Imported = %v, want [500 1000]", summary.Imported)
+	}
+	want := []models.PackImportRowError{
+		{Line: 3, Value: "abc", Error: `Invalid size "abc": must be a whole number`},
+		{Line: 5, Value: "-5", Error: "Size must be at least 1"},
+		{Line: 6, Value: "250", Error: "Pack size already exists"},
+	}
+	if len(summary.Errors) != len(want) {
+		t.Fatalf("Errors = %+v, want %+v", summary.Errors, want)
+	}
+	for i := range want {
+		if summary.Errors[i] != want[i] {
+			t.Errorf("Errors[%d] = %+v, want %+v", i, summary.Errors[i], want[i])
+		}
+	}
+	if _, ok := store.sizes[1000]; !ok {
+		t.Error("Valid row 1000 was not stored")
+	}
+}
+
+func TestImportPackSizes_Strict(t *testing.T) {
+	store := newFakeStore(250)
+	h := NewHandler(store, nil)
+
+	rec, summary := importPackSizes(h, "?strict=true", "500\nabc\n1000\nxyz\n")
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Status = %d, want 400", rec.Code)
+	}
+	if !summary.Aborted || len(summary.Errors) != 1 || summary.Errors[0].Line != 2 || summary.Errors[0].Value != "abc" {
+		t.Errorf("Summary = %+v, want aborted at line 2", summary)
+	}
+	if len(summary.Imported) != 0 || len(store.sizes) != 1 {
+		t.Errorf("Strict import stored rows despite a parse error: %v", summary.Imported)
+	}
+
+	// Rows that parse but fail to insert stop the import at that row and roll back
+	// the rows before it
+	rec, summary = importPackSizes(h, "?strict=true", "500\n250\n1000\n")
+	if rec.Code != http.StatusConflict || !summary.Aborted {
+		t.Fatalf("Status = %d, summary = %+v, want 409 aborted", rec.Code, summary)
+	}
+	if len(summary.Imported) != 0 || summary.Errors[0].Line != 2 {
+		t.Errorf("Summary = %+v, want nothing imported and line 2 failed", summary)
+	}
+	if _, ok := store.sizes[500]; ok {
+		t.Error("Strict import kept the row before the failing one")
+	}
+	if _, ok := store.sizes[1000]; ok {
+		t.Error("Strict import continued past the failing row")
+	}
+}
+
+// failingInsertStore fails to add one pack size as a database error would
+type failingInsertStore struct {
+	*fakeStore
+	failSize int
+}
+
+func (s failingInsertStore) AddPackSizeWithDetails(req models.AddPackSizeRequest) (models.PackSize, error) {
+	if req.Size == s.failSize {
+		return models.PackSize{}, errors.New("connection reset")
+	}
+	return s.fakeStore.AddPackSizeWithDetails(req)
+}
+
+func (s failingInsertStore) WithTx(fn func(tx repository.Store) error) error {
+	return s.fakeStore.WithTx(func(repository.Store) error { return fn(s) })
+}
+
+func TestImportPackSizes_StrictRollsBackOnStoreError(t *testing.T) {
+	store := newFakeStore(250)
+	h := NewHandler(failingInsertStore{fakeStore: store, failSize: 2000}, nil)
+
+	rec, summary := importPackSizes(h, "?strict=true", "500\n1000\n2000\n5000\n")
+	if rec.Code != http.StatusConflict || !summary.Aborted {
+		t.Fatalf("Status = %d, summary = %+v, want 409 aborted", rec.Code, summary)
+	}
+	if len(summary.Errors) != 1 || summary.Errors[0].Line != 3 || summary.Errors[0].Error != "Failed to add pack size" {
+		t.Errorf("Errors = %+v, want line 3 failed to add", summary.Errors)
+	}
+	if len(summary.Imported) != 0 || len(store.sizes) != 1 {
+		t.Errorf("Imported %v with sizes %v, want the first rows rolled back", summary.Imported, store.sizes)
+	}
+
+	// Without strict the other rows are kept
+	rec, summary = importPackSizes(h, "", "500\n1000\n2000\n5000\n")
+	if rec.Code != http.StatusOK || fmt.Sprint(summary.Imported) != "[500 1000 5000]" || len(store.sizes) != 4 {
+		t.Errorf("Lenient import = %d %+v, want 500, 1000 and 5000 imported", rec.Code, summary)
+	}
+}
+
+// failingSaveStore fails to save orders as a database error would
+type failingSaveStore struct {
+	*fakeStore
+}
+
+func (s failingSaveStore) SaveOrder(order *models.Order) error {
+	return errors.New("connection reset")
+}
+
+func (s failingSaveStore) WithTx(fn func(tx repository.Store) error) error {
+	return s.fakeStore.WithTx(func(repository.Store) error { return fn(s) })
+}
+
+func TestCalculatePacks_LogsSaveFailureWithRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&logs, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	h := NewHandler(failingSaveStore{newFakeStore(250, 500)}, nil)
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(`{"amount": 251}`))
+	req.Header.Set(middleware.RequestIDHeader, "req-123")
+	rec := httptest.NewRecorder()
+	middleware.LoggingMiddleware(h.CalculatePacks)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200 despite the failed save", rec.Code)
+	}
+
+	var entry struct {
+		Level     string `json:"level"`
+		Msg       string `json:"msg"`
+		RequestID string `json:"request_id"`
+	}
+	line, _, _ := strings.Cut(logs.String(), "\n") // The handler's line precedes the request's
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("Log %q: %v", logs.String(), err)
+	}
+	if entry.Level != "ERROR" || !strings.HasPrefix(entry.Msg, "Failed to save order for amount 251") || entry.RequestID != "req-123" {
+		t.Errorf("Log entry = %+v, want the failed save with request_id req-123", entry)
+	}
+}
+
+func TestStatsSnapshots_WrittenOnSchedule(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandler(store, cache.NewMemoryCache(10))
+
+	calculate(h, `{"amount": 251}`)
+	calculate(h, `{"amount": 251}`) // Cache hit
+
+	stop := h.StartStatsSnapshots(10*time.Millisecond, time.Hour)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.Lock()
+		n := len(store.snapshots)
+		store.mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d snapshots written within 2s", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/history?since="+time.Now().Add(-time.Minute).Format(time.RFC3339), nil)
+	rec := httptest.NewRecorder()
+	h.GetStatsHistory(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+
+	var body struct {
+		Snapshots []models.StatsSnapshot `json:"snapshots"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode history: %v", err)
+	}
+	if len(body.Snapshots) < 3 {
+		t.Fatalf("History has %d snapshots, want at least 3", len(body.Snapshots))
+	}
+	for i, snapshot := range body.Snapshots {
+		if i > 0 && snapshot.CreatedAt.Before(body.Snapshots[i-1].CreatedAt) {
+			t.Errorf("Snapshot %d is older than the one before it", i)
+		}
+		if snapshot.Calculations != 1 || snapshot.CacheHits != 1 || snapshot.CacheMisses != 1 {
+			t.Errorf("Snapshot %d = %+v, want 1 calculation, 1 hit and 1 miss", i, snapshot)
+		}
+	}
+}
+
+func TestStatsSnapshots_RetentionPrunes(t *testing.T) {
+	store := newFakeStore(250)
+	store.snapshots = []models.StatsSnapshot{{ID: 1, CreatedAt: time.Now().Add(-2 * time.Hour)}}
+	h := NewHandler(store, nil)
+
+	stop := h.StartStatsSnapshots(5*time.Millisecond, time.Hour)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for _, snapshot := range store.snapshots {
+		if snapshot.ID == 1 {
+			t.Error("Snapshot older than the retention window was not pruned")
+		}
+	}
+}
+
+func TestOrderReaper_PrunesOnlyOldOrders(t *testing.T) {
+	store := newFakeStore(250)
+	now := time.Now()
+	store.orders = []models.Order{
+		{ID: 1, Amount: 1, CreatedAt: now.Add(-72 * time.Hour)},
+		{ID: 2, Amount: 2, CreatedAt: now.Add(-25 * time.Hour)},
+		{ID: 3, Amount: 3, CreatedAt: now.Add(-time.Hour)},
+		{ID: 4, Amount: 4, CreatedAt: now},
+	}
+	h := NewHandler(store, nil)
+
+	stop := h.StartOrderReaper(5*time.Millisecond, 24*time.Hour)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		store.mu.Lock()
+		n := len(store.orders)
+		store.mu.Unlock()
+		if n <= 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d orders left after 2s, want 2", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	stop()
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if len(store.orders) != 2 || store.orders[0].ID != 3 || store.orders[1].ID != 4 {
+		t.Errorf("Orders left = %+v, want the two recent ones", store.orders)
+	}
+}
+
+func TestCalculatePacks_OrderSampleRate(t *testing.T) {
+	const runs = 2000
+	persisted := func(rate float64) int {
+		store := newFakeStore(250, 500)
+		cfg := DefaultConfig()
+		cfg.OrderSampleRate = rate
+		h := NewHandlerWithConfig(store, nil, cfg)
+		for i := 0; i < runs; i++ {
+			if rec := calculate(h, `{"amount": 750}`); rec.Code != http.StatusOK {
+				t.Fatalf("Rate %v: status = %d", rate, rec.Code)
+			}
+		}
+		return len(store.orders)
+	}
+
+	if n := persisted(1); n != runs {
+		t.Errorf("Rate 1: persisted %d of %d, want all", n, runs)
+	}
+	if n := persisted(0); n != 0 {
+		t.Errorf("Rate 0: persisted %d of %d, want none", n, runs)
+	}
+	// Binomial standard deviation at 0.25 over 2000 runs is about 19, so 0.05 is ~5 sigma
+	if n := persisted(0.25); math.Abs(float64(n)/runs-0.25) > 0.05 {
+		t.Errorf("Rate 0.25: persisted %d of %d, want about %d", n, runs, runs/4)
+	}
+}
+
+func TestGetStatsHistory_InvalidSince(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+	req := httptest.NewRequest(http.MethodGet, "/api/stats/history?since=yesterday", nil)
+	rec := httptest.NewRecorder()
+	h.GetStatsHistory(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_ExactNegativeCacheDroppedOnPackSizeChange(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500), cache.NewMemoryCache(100))
+
+	rec := calculateWithQuery(h, "?exact=1", `{"amount": 300}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), codeNotExact) {
+		t.Fatalf("Status = %d, body = %s, want 422 NOT_EXACT", rec.Code, rec.Body.String())
+	}
+
+	// The infeasible outcome is now served from the negative cache
+	if rec := calculateWithQuery(h, "?exact=1", `{"amount": 300}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Repeat status = %d, want 422", rec.Code)
+	}
+	if n := h.calculations.Load(); n != 1 {
+		t.Fatalf("Calculator ran %d times, want 1 (negative entry not used)", n)
+	}
+
+	// Adding 300 makes the amount exactly packable; the stale negative must not be served
+	if rec := addPackSize(h, 300); rec.Code != http.StatusCreated {
+		t.Fatalf("Add status = %d, want 201", rec.Code)
+	}
+	rec = calculateWithQuery(h, "?exact=1", `{"amount": 300}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status after adding 300 = %d, body = %s, want 200", rec.Code, rec.Body.String())
+	}
+	var result models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TotalItems != 300 || result.Packs[300] != 1 {
+		t.Errorf("Result = %+v, want a single 300 pack", result)
+	}
+}
+
+func TestCalculatePacks_ExactAndPlainModesCachedSeparately(t *testing.T) {
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(newFakeStore(250, 500), memCache)
+	sizes := []int{250, 500}
+
+	// Each mode computes once, then is served from its own entry
+	for i, query := range []string{"", "?exact=1", "", "?exact=1"} {
+		if rec := calculateWithQuery(h, query, `{"amount": 750}`); rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, body = %s", query, rec.Code, rec.Body.String())
+		}
+		if want := int64(min(i+1, 2)); h.calculations.Load() != want {
+			t.Errorf("After request %d (%q): %d calculations, want %d", i, query, h.calculations.Load(), want)
+		}
+	}
+	for _, key := range []string{cache.GenerateCacheKey(750, sizes), cache.GenerateCacheKey(750, sizes, cache.ModeExact)} {
+		if _, total, found := memCache.Get(key); !found || total != 750 {
+			t.Errorf("Entry %s: found=%v total=%d, want 750", key, found, total)
+		}
+	}
+
+	// A plain entry that overshoots is never served to an exact request as a result
+	if rec := calculate(h, `{"amount": 300}`); rec.Code != http.StatusOK {
+		t.Fatalf("Plain 300 status = %d", rec.Code)
+	}
+	if rec := calculateWithQuery(h, "?exact=1", `{"amount": 300}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Exact 300 status = %d, want 422", rec.Code)
+	}
+}
+
+func TestCalculatePacks_StrictExact(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StrictExact = true
+	h := NewHandlerWithConfig(newFakeStore(250, 500), cache.NewMemoryCache(100), cfg)
+
+	rec := calculate(h, `{"amount": 750}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Feasible 750: status = %d, body = %s, want 200", rec.Code, rec.Body.String())
+	}
+	var result models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TotalItems != 750 {
+		t.Errorf("Feasible 750: total = %d, want 750", result.TotalItems)
+	}
+
+	rec = calculate(h, `{"amount": 300}`)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), codeNotExact) {
+		t.Errorf("Infeasible 300: status = %d, body = %s, want 422 NOT_EXACT", rec.Code, rec.Body.String())
+	}
+
+	// A request may still opt into overshoot
+	rec = calculateWithQuery(h, "?allow_overshoot=1", `{"amount": 300}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Opt-in 300: status = %d, want 200", rec.Code)
+	}
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TotalItems != 500 {
+		t.Errorf("Opt-in 300: total = %d, want 500", result.TotalItems)
+	}
+}
+
+func TestCalculatePacks_RequestPackSizes(t *testing.T) {
+	store := newFakeStore(250, 500)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+
+	rec := calculate(h, `{"amount": 263, "pack_sizes": [53, 23, 31]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var result models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TotalItems != 263 || result.Packs[250] != 0 {
+		t.Errorf("Result = %+v, want 263 packed from the request's sizes", result)
+	}
+
+	// The configured sizes are untouched and the result is cached under the custom set
+	if len(store.sizes) != 2 {
+		t.Errorf("Configured sizes = %v, want the original two", store.sizes)
+	}
+	if _, _, found := memCache.Get(cache.GenerateCacheKey(263, []int{23, 31, 53})); !found {
+		t.Error("Result not cached under the request's pack sizes")
+	}
+	if rec := calculateWithQuery(h, "?save=false", `{"amount": 263, "pack_sizes": [23, 31, 53]}`); rec.Code != http.StatusOK || h.calculations.Load() != 1 {
+		t.Errorf("Repeat: status = %d, calculations = %d, want a cache hit", rec.Code, h.calculations.Load())
+	}
+
+	// Orders record the sizes they were packed from, custom or configured
+	if rec := calculate(h, `{"amount": 750}`); rec.Code != http.StatusOK {
+		t.Fatalf("Configured sizes: status = %d", rec.Code)
+	}
+	if len(store.orders) != 2 {
+		t.Fatalf("Saved %d orders, want 2", len(store.orders))
+	}
+	if got := store.orders[0].PackSizes; !reflect.DeepEqual(got, []int{23, 31, 53}) {
+		t.Errorf("Custom order pack sizes = %v, want [23 31 53]", got)
+	}
+	if got := store.orders[1].PackSizes; !reflect.DeepEqual(got, []int{250, 500}) {
+		t.Errorf("Configured order pack sizes = %v, want [250 500]", got)
+	}
+
+	for _, body := range []string{
+		`{"amount": 100, "pack_sizes": [0, 50]}`,
+		`{"amount": 100, "pack_sizes": [-5]}`,
+		`{"amount": 100, "pack_sizes": [50, 25, 50]}`,
+	} {
+		if rec := calculate(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+	if rec := calculateWithQuery(h, "?tier=retail", `{"amount": 100, "pack_sizes": [50]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("With tier: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_Variants(t *testing.T) {
+	h := NewHandler(newFakeStore(3, 5, 7), cache.NewMemoryCache(100))
+
+	decode := func(rec *httptest.ResponseRecorder) models.PackCalculationResult {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result
+	}
+
+	// 10 is 7+3 or 5+5; the alternative is whichever the result did not use, also on a cache hit
+	for i := 0; i < 2; i++ {
+		result := decode(calculateWithQuery(h, "?variants=true", `{"amount": 10}`))
+		if len(result.Alternatives) != 1 {
+			t.Fatalf("Alternatives = %+v, want one", result.Alternatives)
+		}
+		alt := result.Alternatives[0]
+		if reflect.DeepEqual(alt.Packs, result.Packs) || alt.TotalItems != 10 || alt.TotalPacks != 2 {
+			t.Errorf("Alternative = %+v for result %v, want the other two-pack combination", alt, result.Packs)
+		}
+	}
+
+	// No ties, or no flag: no alternatives
+	if result := decode(calculateWithQuery(h, "?variants=true", `{"amount": 14}`)); result.Alternatives != nil {
+		t.Errorf("Amount 14: alternatives = %+v, want none", result.Alternatives)
+	}
+	if result := decode(calculate(h, `{"amount": 10}`)); result.Alternatives != nil {
+		t.Errorf("Without variants: alternatives = %+v, want none", result.Alternatives)
+	}
+	if rec := calculateWithQuery(h, "?variants=maybe", `{"amount": 10}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid flag: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_NextExact(t *testing.T) {
+	h := NewHandler(newFakeStore(23, 31, 53), cache.NewMemoryCache(100))
+
+	decode := func(rec *httptest.ResponseRecorder) models.PackCalculationResult {
+		t.Helper()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result
+	}
+
+	// 60 cannot be packed exactly; 62 (2x31) is the nearest amount that can
+	result := decode(calculateWithQuery(h, "?next_exact=1", `{"amount": 60}`))
+	next := result.NextExact
+	if next == nil || next.Amount != 62 || next.Total != 62 || next.Extra != 2 || next.Packs[31] != 2 {
+		t.Errorf("NextExact = %+v, want 62 (2 more) as 2x31", next)
+	}
+
+	// Exact amounts get no suggestion, cached or not
+	for i := 0; i < 2; i++ {
+		if result := decode(calculateWithQuery(h, "?next_exact=1", `{"amount": 54}`)); result.NextExact != nil {
+			t.Errorf("Exact amount got NextExact = %+v", result.NextExact)
+		}
+	}
+
+	// Only on request, and packs follow the summary view
+	if result := decode(calculate(h, `{"amount": 60}`)); result.NextExact != nil {
+		t.Errorf("NextExact without the flag = %+v", result.NextExact)
+	}
+	result = decode(calculateWithQuery(h, "?next_exact=1&summary_only=1", `{"amount": 60}`))
+	if result.NextExact == nil || result.NextExact.Amount != 62 || result.NextExact.Packs != nil {
+		t.Errorf("Summary NextExact = %+v, want 62 without packs", result.NextExact)
+	}
+
+	// With round_to the suggestion is still measured from the requested amount, not
+	// from the rounded 100 that was packed
+	result = decode(calculateWithQuery(h, "?next_exact=1", `{"amount": 60, "round_to": 100}`))
+	next = result.NextExact
+	if result.RoundedAmount != 100 || next == nil || next.Amount != 62 || next.Extra != 2 || next.Packs[31] != 2 {
+		t.Errorf("Rounded result %d with NextExact = %+v, want 62 (2 more) as 2x31", result.RoundedAmount, next)
+	}
+}
+
+func calculateBatch(h *Handler, body string) (*httptest.ResponseRecorder, []models.PackCalculationResult) {
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate/batch", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.CalculateBatch(rec, req)
+
+	var results []models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &results)
+	return rec, results
+}
+
+func TestCalculateBatch_OrderErrorsAndOrders(t *testing.T) {
+	store := newFakeStore(250, 500, 1000)
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+
+	// 1250 is cached beforehand and must not be recalculated
+	if rec := calculate(h, `{"amount": 1250}`); rec.Code != http.StatusOK {
+		t.Fatalf("Warm-up status = %d", rec.Code)
+	}
+	store.orders = nil
+
+	rec, results := calculateBatch(h, `{"amounts": [1250, 0, 251, -5, 750, 20000000]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if len(results) != 6 {
+		t.Fatalf("Got %d results, want 6", len(results))
+	}
+	want := []struct {
+		amount, total int
+		failed        bool
+	}{{1250, 1250, false}, {0, 0, true}, {251, 500, false}, {-5, 0, true}, {750, 750, false}, {20000000, 0, true}}
+	for i, w := range want {
+		got := results[i]
+		if got.Amount != w.amount || got.TotalItems != w.total || (got.Error != "") != w.failed {
+			t.Errorf("Result %d = %+v, want amount %d, total %d, failed %v", i, got, w.amount, w.total, w.failed)
+		}
+	}
+	if n := h.calculations.Load(); n != 3 {
+		t.Errorf("Calculations = %d, want 3 (1250 once before the batch, then 251 and 750)", n)
+	}
+
+	// Only the valid amounts were saved, in request order
+	if len(store.orders) != 3 || store.orders[0].Amount != 1250 || store.orders[1].Amount != 251 || store.orders[2].Amount != 750 {
+		t.Errorf("Saved orders = %+v, want 1250, 251 and 750", store.orders)
+	}
+}
+
+func TestCalculateBatch_Limits(t *testing.T) {
+	h := NewHandlerWithConfig(newFakeStore(250), nil, Config{MaxBatchAmounts: 2})
+
+	if rec, _ := calculateBatch(h, `{"amounts": [1, 2, 3]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Over the cap: status = %d, want 400", rec.Code)
+	}
+	if rec, _ := calculateBatch(h, `{"amounts": []}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Empty: status = %d, want 400", rec.Code)
+	}
+	if rec, results := calculateBatch(h, `{"amounts": [1, 2]}`); rec.Code != http.StatusOK || len(results) != 2 {
+		t.Errorf("At the cap: status = %d, %d results, want 200 with 2", rec.Code, len(results))
+	}
+}
+
+func TestCalculateBatch_StrictExactAndValidator(t *testing.T) {
+	store := newFakeStore(250, 500)
+	h := NewHandlerWithConfig(store, cache.NewMemoryCache(100), Config{MaxBatchAmounts: 10, StrictExact: true, OrderSampleRate: 1})
+	h.SetResultValidator(func(req models.PackCalculationRequest, result models.PackCalculationResult) error {
+		if result.TotalPacks > 2 {
+			return fmt.Errorf("%d packs exceeds the limit of 2", result.TotalPacks)
+		}
+		return nil
+	})
+
+	// 251 overshoots and 1500 needs three packs; only 750 passes both
+	rec, results := calculateBatch(h, `{"amounts": [251, 750, 1500]}`)
+	if rec.Code != http.StatusOK || len(results) != 3 {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(results[0].Error, "cannot be packed exactly") || results[0].TotalItems != 0 {
+		t.Errorf("Overshooting result = %+v, want a not-exact error", results[0])
+	}
+	if results[1].Error != "" || results[1].TotalItems != 750 {
+		t.Errorf("Exact result = %+v, want 750 items", results[1])
+	}
+	if !strings.Contains(results[2].Error, "exceeds the limit") {
+		t.Errorf("Rejected result = %+v, want the validator's error", results[2])
+	}
+	if len(store.orders) != 1 || store.orders[0].Amount != 750 {
+		t.Errorf("Saved orders = %+v, want only 750", store.orders)
+	}
+
+	// allow_overshoot opts the batch back in, but the validator still applies
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate/batch?allow_overshoot=1", strings.NewReader(`{"amounts": [251]}`))
+	rec = httptest.NewRecorder()
+	h.CalculateBatch(rec, req)
+	var overshot []models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &overshot)
+	if len(overshot) != 1 || overshot[0].Error != "" || overshot[0].TotalItems != 500 {
+		t.Errorf("With allow_overshoot = %+v, want 500 items", overshot)
+	}
+}
+
+func calculateConsolidated(h *Handler, body string) (*httptest.ResponseRecorder, models.ConsolidatedResult) {
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate/consolidated", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.CalculateConsolidated(rec, req)
+
+	var result models.ConsolidatedResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	return rec, result
+}
+
+func TestCalculateConsolidated_SavesItems(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	// 251 alone needs a 500; two of them together fit in 750
+	rec, result := calculateConsolidated(h, `{"amounts": [251, 251]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if result.SeparateTotalItems != 1000 || result.Consolidated.TotalItems != 750 || result.ItemsSaved != 250 {
+		t.Errorf("Result = %+v, want 1000 separate, 750 consolidated, 250 saved", result)
+	}
+	if result.Consolidated.Amount != 502 || len(result.Orders) != 2 || result.Orders[0].Amount != 251 {
+		t.Errorf("Result = %+v, want two 251 orders consolidated into 502", result)
+	}
+	if len(store.orders) != 0 {
+		t.Error("Consolidated calculation saved orders")
+	}
+}
+
+func TestCalculateConsolidated_NeverWorseThanSeparate(t *testing.T) {
+	h := NewHandler(newFakeStore(23, 31, 53), nil)
+
+	for _, amounts := range []string{"[1, 2, 3]", "[100, 250, 263]", "[500000, 12, 7]", "[53]", "[22, 24, 30, 32]"} {
+		rec, result := calculateConsolidated(h, `{"amounts": `+amounts+`}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", amounts, rec.Code)
+		}
+		sum := 0
+		for _, order := range result.Orders {
+			sum += order.TotalItems
+		}
+		if sum != result.SeparateTotalItems || result.Consolidated.TotalItems > sum || result.ItemsSaved < 0 {
+			t.Errorf("%s: consolidated %d items vs %d separate (saved %d)", amounts, result.Consolidated.TotalItems, sum, result.ItemsSaved)
+		}
+	}
+}
+
+func TestCalculateConsolidated_Validation(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+	for _, body := range []string{`{"amounts": []}`, `{"amounts": [5, 0]}`, `{"amounts": [9000000, 9000000]}`} {
+		if rec, _ := calculateConsolidated(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func comparePackSets(h *Handler, body string) (*httptest.ResponseRecorder, models.PackSetComparison) {
+	req := httptest.NewRequest(http.MethodPost, "/api/packs/compare", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	h.ComparePackSets(rec, req)
+
+	var result models.PackSetComparison
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	return rec, result
+}
+
+func TestComparePackSets_KnownSets(t *testing.T) {
+	c := cache.NewMemoryCache(100)
+	h := NewHandler(newFakeStore(250, 500, 1000, 2000, 5000), c)
+
+	// Current: 251->500, 750->750, 1001->1250 (2500 items, 5 packs)
+	// Proposed: 251->300, 750->750, 1050 for 1001 (2100 items, 12 packs)
+	rec, result := comparePackSets(h, `{"proposed": [250, 100, 250], "amounts": [251, 750, 1001]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if result.SampleSize != 3 || result.SampleSource != "request" {
+		t.Errorf("Sample = %d from %q, want 3 from request", result.SampleSize, result.SampleSource)
+	}
+
+	want := models.PackSetMetrics{
+		PackSizes: []int{250, 500, 1000, 2000, 5000}, TotalItems: 2500, TotalPacks: 5,
+		AverageOvershoot: 166, AveragePacks: 1.6667, Efficiency: 0.8008, ExactCount: 1,
+	}
+	if !reflect.DeepEqual(result.Current, want) {
+		t.Errorf("Current = %+v, want %+v", result.Current, want)
+	}
+	want = models.PackSetMetrics{
+		PackSizes: []int{100, 250}, TotalItems: 2100, TotalPacks: 12,
+		AverageOvershoot: 32.6667, AveragePacks: 4, Efficiency: 0.9533, ExactCount: 1,
+	}
+	if !reflect.DeepEqual(result.Proposed, want) {
+		t.Errorf("Proposed = %+v, want %+v", result.Proposed, want)
+	}
+
+	// Only the current set's results are cached
+	if size := c.Stats().Size; size != 3 {
+		t.Errorf("Cache size = %d, want 3", size)
+	}
+}
+
+func TestComparePackSets_CapsTotalCost(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500), nil)
+
+	// Each amount packs through a DP table of about 9 million totals per proposed size
+	amounts := strings.TrimSuffix(strings.Repeat("9000000, ", 20), ", ")
+	rec, _ := comparePackSets(h, `{"proposed": [4999999, 4999987], "amounts": [`+amounts+`]}`)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "too expensive") {
+		t.Errorf("Status = %d, body = %s, want 400 too expensive", rec.Code, rec.Body.String())
+	}
+}
+
+func TestComparePackSets_SamplesOrders(t *testing.T) {
+	store := newFakeStore(250, 500)
+	store.orders = []models.Order{{Amount: 251}, {Amount: 251}, {Amount: 500}}
+	h := NewHandler(store, nil)
+
+	rec, result := comparePackSets(h, `{"proposed": [251]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if result.SampleSize != 3 || result.SampleSource != "orders" {
+		t.Errorf("Sample = %d from %q, want 3 from orders", result.SampleSize, result.SampleSource)
+	}
+	if result.Current.TotalItems != 1500 || result.Proposed.TotalItems != 1004 || result.Proposed.ExactCount != 2 {
+		t.Errorf("Result = %+v, want 1500 current and 1004 proposed items", result)
+	}
+	if len(store.orders) != 3 {
+		t.Error("Comparison saved orders")
+	}
+}
+
+func TestComparePackSets_Validation(t *testing.T) {
+	h := NewHandler(newFakeStore(250), nil)
+	for _, body := range []string{
+		`{"proposed": []}`,
+		`{"proposed": [0, 250], "amounts": [10]}`,
+		`{"proposed": [250], "amounts": [0]}`,
+		`{"proposed": [250], "amounts": [20000000]}`,
+		`{"proposed": [250]}`, // No amounts and no orders
+	} {
+		if rec, _ := comparePackSets(h, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+}
+
+func TestComparePackSets_CustomSizePolicy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CustomSizeMin = 100
+	cfg.CustomSizesAllowed = []int{100, 250, 750}
+	h := NewHandlerWithConfig(newFakeStore(250, 500), nil, cfg)
+
+	if rec, result := comparePackSets(h, `{"proposed": [250, 750], "amounts": [1000]}`); rec.Code != http.StatusOK || result.Proposed.TotalItems != 1000 {
+		t.Errorf("Permitted sizes: status = %d, result = %+v", rec.Code, result)
+	}
+	for _, body := range []string{
+		`{"proposed": [250, 1], "amounts": [1000]}`,   // Below the floor
+		`{"proposed": [250, 300], "amounts": [1000]}`, // Not on the allow-list
+	} {
+		rec, _ := comparePackSets(h, body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", body, rec.Code)
+		}
+	}
+
+	// Without an allow-list only the floor applies
+	cfg.CustomSizesAllowed = nil
+	h = NewHandlerWithConfig(newFakeStore(250, 500), nil, cfg)
+	if rec, _ := comparePackSets(h, `{"proposed": [300], "amounts": [1000]}`); rec.Code != http.StatusOK {
+		t.Errorf("Floor only: status = %d, want 200", rec.Code)
+	}
+	if rec, _ := comparePackSets(h, `{"proposed": [99], "amounts": [1000]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Floor only, size 99: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_ServerTiming(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000, 2000, 5000), cache.NewMemoryCache(100))
+	metric := regexp.MustCompile(`^(\w+);dur=\d+\.\d{3}$`)
+
+	metrics := func(rec *httptest.ResponseRecorder) []string {
+		header := rec.Header().Get("Server-Timing")
+		if header == "" {
+			t.Fatal("Server-Timing header missing")
+		}
+		var names []string
+		for _, part := range strings.Split(header, ", ") {
+			m := metric.FindStringSubmatch(part)
+			if m == nil {
+				t.Fatalf("Malformed Server-Timing metric %q in %q", part, header)
+			}
+			names = append(names, m[1])
+		}
+		return names
+	}
+
+	rec := calculate(h, `{"amount": 12001}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d", rec.Code)
+	}
+	if got := metrics(rec); !reflect.DeepEqual(got, []string{"cache", "calc", "db", "serialize"}) {
+		t.Errorf("Miss metrics = %v, want cache, calc, db, serialize", got)
+	}
+
+	rec = calculate(h, `{"amount": 12001}`)
+	if got := metrics(rec); !reflect.DeepEqual(got, []string{"cache", "db", "serialize"}) {
+		t.Errorf("Hit metrics = %v, want cache, db, serialize", got)
+	}
+	rec = calculateWithQuery(h, "?save=false", `{"amount": 12001}`)
+	if got := metrics(rec); !reflect.DeepEqual(got, []string{"cache", "serialize"}) {
+		t.Errorf("Unsaved hit metrics = %v, want cache, serialize", got)
+	}
+	var result models.PackCalculationResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || result.TotalItems != 12250 {
+		t.Errorf("Body = %s, want a decodable result of 12250 items", rec.Body.String())
+	}
+}
+
+func TestTenants_Isolated(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	do := func(tenant string, fn http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if tenant != "" {
+			req.Header.Set(middleware.TenantHeader, tenant)
+		}
+		rec := httptest.NewRecorder()
+		middleware.NewAPIKeyAuth("").TenantMiddleware(fn)(rec, req)
+		return rec
+	}
+
+	// Each tenant starts empty and configures its own sizes
+	if rec := do("acme", h.CalculatePacks, http.MethodPost, "/api/calculate", `{"amount": 251}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Calculate for a tenant without sizes = %d, want 400", rec.Code)
+	}
+	for tenant, sizes := range map[string][]int{"acme": {100}, "globex": {250, 500}} {
+		for _, size := range sizes {
+			if rec := do(tenant, h.AddPackSize, http.MethodPost, "/api/packs", fmt.Sprintf(`{"size": %d}`, size)); rec.Code != http.StatusCreated {
+				t.Fatalf("%s: add %d = %d", tenant, size, rec.Code)
+			}
+		}
+	}
+
+	totals := map[string]int{"": 500, "acme": 300, "globex": 500}
+	for tenant, want := range totals {
+		rec := do(tenant, h.CalculatePacks, http.MethodPost, "/api/calculate", `{"amount": 251}`)
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if rec.Code != http.StatusOK || result.TotalItems != want {
+			t.Errorf("Tenant %q: calculate 251 = %d with %d items, want %d", tenant, rec.Code, result.TotalItems, want)
+		}
+	}
+	// Default and globex pack {250, 500, ...} vs {250, 500}: different sets, and globex's
+	// entry is namespaced anyway, so every calculation above missed the cache
+	if n := h.calculations.Load(); n != 3 {
+		t.Errorf("Calculations = %d, want 3 (no cross-tenant cache hits)", n)
+	}
+
+	for tenant, want := range map[string]int{"": 1, "acme": 1, "globex": 1} {
+		rec := do(tenant, h.GetOrders, http.MethodGet, "/api/orders", "")
+		var orders []models.Order
+		json.Unmarshal(rec.Body.Bytes(), &orders)
+		if len(orders) != want {
+			t.Errorf("Tenant %q: %d orders, want %d", tenant, len(orders), want)
+		}
+	}
+	if sizes, _ := store.GetPackSizesAsSlice(repository.DefaultProfile); len(sizes) != 5 {
+		t.Errorf("Default tenant sizes = %v, want the original five", sizes)
+	}
+
+	if rec := do("Not/Valid", h.GetPackSizes, http.MethodGet, "/api/packs", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Invalid tenant = %d, want 400", rec.Code)
+	}
+}
+
+func TestTenants_CacheNamespaced(t *testing.T) {
+	store := newFakeStore(250, 500)
+	store.ForTenant("acme").AddPackSize(250)
+	store.ForTenant("acme").AddPackSize(500)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	for _, tenant := range []string{"", "acme", "acme"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(`{"amount": 251}`))
+		if tenant != "" {
+			req.Header.Set(middleware.TenantHeader, tenant)
+		}
+		middleware.NewAPIKeyAuth("").TenantMiddleware(h.CalculatePacks)(httptest.NewRecorder(), req)
+	}
+	// Same pack set, but each tenant computes and caches its own entry
+	if n := h.calculations.Load(); n != 2 {
+		t.Errorf("Calculations = %d, want 2", n)
+	}
+
+	// A pack change invalidates only the changing tenant's entries
+	acmeKey := cache.NamespacedKey("acme", cache.GenerateCacheKey(251, []int{250, 500}))
+	if _, _, found := h.cache.Get(acmeKey); !found {
+		t.Fatal("Tenant's entry not cached")
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/packs", strings.NewReader(`{"size": 1000}`))
+	req.Header.Set(middleware.TenantHeader, "acme")
+	middleware.NewAPIKeyAuth("").TenantMiddleware(h.AddPackSize)(httptest.NewRecorder(), req)
+	if _, _, found := h.cache.Get(cache.GenerateCacheKey(251, []int{250, 500})); !found {
+		t.Error("Default tenant's entry was invalidated by another tenant's pack change")
+	}
+	if _, _, found := h.cache.Get(acmeKey); found {
+		t.Error("Changing tenant's entry survived its pack change")
+	}
+}
+
+func TestCalculatePacks_ResultValidator(t *testing.T) {
+	store := newFakeStore(250, 500, 1000, 2000, 5000)
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	// Reject results that overshoot the requested amount by more than 100 items
+	h.SetResultValidator(func(req models.PackCalculationRequest, result models.PackCalculationResult) error {
+		if overshoot := result.TotalItems - req.Amount; overshoot > 100 {
+			return fmt.Errorf("overshoot of %d items exceeds the limit of 100", overshoot)
+		}
+		return nil
+	})
+
+	rec := calculate(h, `{"amount": 251}`) // Needs 500, overshoot 249
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Status = %d, want 422", rec.Code)
+	}
+	var body map[string]string
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["code"] != codeResultRejected || body["error"] != "overshoot of 249 items exceeds the limit of 100" {
+		t.Errorf("Body = %v, want the validator's message", body)
+	}
+	if len(store.orders) != 0 {
+		t.Error("Rejected result was saved as an order")
+	}
+
+	// Accepted results pass through unchanged
+	rec = calculate(h, `{"amount": 480}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	var result models.PackCalculationResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.TotalItems != 500 || len(store.orders) != 1 {
+		t.Errorf("Result = %+v with %d orders, want 500 items saved once", result, len(store.orders))
+	}
+
+	// The rule also applies to results served from the cache
+	h.SetResultValidator(func(req models.PackCalculationRequest, result models.PackCalculationResult) error {
+		return errors.New("closed for maintenance")
+	})
+	if rec := calculate(h, `{"amount": 480}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Cached status = %d, want 422", rec.Code)
+	}
+}
+
+// TestOrders_StorageAndTransportCompression saves an order with packs storage
+// compression on and reads it back through the gzip middleware, guarding against the
+// two layers double-encoding each other. Requires TEST_DATABASE_URL.
+func TestOrders_StorageAndTransportCompression(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set; skipping database test")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	repo := repository.NewRepository(db)
+	if err := repo.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() error = %v", err)
+	}
+	if _, err := db.Exec(`TRUNCATE pack_sizes, orders RESTART IDENTITY`); err != nil {
+		t.Fatalf("Failed to truncate tables: %v", err)
+	}
+	for _, size := range []int{23, 31, 53} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+	repo.SetPacksCompression(true)
+
+	h := NewHandler(repo, nil)
+	gzipped := middleware.CompressionMiddlewareWithMinLength(1)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate", strings.NewReader(`{"amount": 500000}`))
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	gzipped(h.CalculatePacks)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Calculate status = %d", rec.Code)
+	}
+
+	var stored string
+	var storedGz []byte
+	if err := db.QueryRow(`SELECT packs_json, packs_gz FROM orders`).Scan(&stored, &storedGz); err != nil {
+		t.Fatalf("Failed to read stored order: %v", err)
+	}
+	if stored != "" || len(storedGz) == 0 {
+		t.Fatalf("Packs stored uncompressed: %s", stored)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec = httptest.NewRecorder()
+	gzipped(h.GetOrders)(rec, req)
+	if rec.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", rec.Header().Get("Content-Encoding"))
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatalf("Response is not gzip: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to decompress response: %v", err)
+	}
+
+	// Packs must arrive as a JSON object, not a still-compressed string
+	var orders []models.Order
+	if err := json.Unmarshal(body, &orders); err != nil {
+		t.Fatalf("Failed to decode orders: %v (body %s)", err, body)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("Got %d orders, want 1", len(orders))
+	}
+	want := map[int]int{23: 2, 31: 7, 53: 9429}
+	if fmt.Sprint(orders[0].Packs) != fmt.Sprint(want) || orders[0].TotalItems != 500000 {
+		t.Errorf("Order = %+v, want packs %v totalling 500000", orders[0], want)
+	}
+}
+
+func TestGetPackSizes_IncludeUsage(t *testing.T) {
+	store := newFakeStore(250, 500, 1000)
+	older := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 3, 2, 9, 0, 0, 0, time.UTC)
+	store.orders = []models.Order{
+		{ID: 1, Packs: map[int]int{250: 1, 500: 1}, CreatedAt: older},
+		{ID: 2, Packs: map[int]int{500: 2}, CreatedAt: newer},
+	}
+	h := NewHandler(store, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/packs?include=usage", nil)
+	rec := httptest.NewRecorder()
+	h.GetPackSizes(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"last_used_at":null`) {
+		t.Errorf("Body %s has no null last_used_at for the unused size", rec.Body.String())
+	}
+
+	var usage []models.PackSizeUsage
+	if err := json.Unmarshal(rec.Body.Bytes(), &usage); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	want := map[int]*time.Time{250: &older, 500: &newer, 1000: nil}
+	for _, u := range usage {
+		expected := want[u.Size]
+		switch {
+		case expected == nil && u.LastUsedAt != nil:
+			t.Errorf("Size %d last used %v, want never", u.Size, u.LastUsedAt)
+		case expected != nil && (u.LastUsedAt == nil || !u.LastUsedAt.Equal(*expected)):
+			t.Errorf("Size %d last used %v, want %v", u.Size, u.LastUsedAt, expected)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/packs?include=everything", nil)
+	rec = httptest.NewRecorder()
+	h.GetPackSizes(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Unknown include status = %d, want 400", rec.Code)
+	}
+}
+
+func TestCalculatePacks_TieredPackLines(t *testing.T) {
+	store := newFakeStore()
+	for size, tier := range map[int]string{250: "retail", 500: "retail", 1000: "bulk", 5000: "bulk"} {
+		store.sizes[size] = models.PackSize{ID: size, Size: size, Tier: tier}
+	}
+	h := NewHandler(store, cache.NewMemoryCache(100))
+
+	tests := []struct {
+		query string
+		want  []models.PackLine
+	}{
+		{"", []models.PackLine{{Size: 1000, Tier: "bulk", Count: 1}, {Size: 250, Tier: "retail", Count: 1}}},
+		{"?tier=retail", []models.PackLine{{Size: 500, Tier: "retail", Count: 2}, {Size: 250, Tier: "retail", Count: 1}}},
+		{"?tier=BULK", []models.PackLine{{Size: 1000, Tier: "bulk", Count: 2}}},
+	}
+	for _, tt := range tests {
+		rec := calculateWithQuery(h, tt.query, `{"amount": 1250}`)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status = %d, body = %s", tt.query, rec.Code, rec.Body.String())
+		}
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if fmt.Sprint(result.PackLines) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: pack_lines = %v, want %v", tt.query, result.PackLines, tt.want)
+		}
+		for _, line := range result.PackLines {
+			if result.Packs[line.Size] != line.Count {
+				t.Errorf("%q: packs %v disagree with line %+v", tt.query, result.Packs, line)
+			}
+		}
+	}
+
+	if rec := calculateWithQuery(h, "?tier=pallet", `{"amount": 1250}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Unknown tier status = %d, want 400", rec.Code)
+	}
+
+	// Untiered deployments keep the original response shape
+	rec := calculate(NewHandler(newFakeStore(250, 500), nil), `{"amount": 750}`)
+	if strings.Contains(rec.Body.String(), "pack_lines") {
+		t.Errorf("Untiered response has pack_lines: %s", rec.Body.String())
+	}
+}
+
+func TestPackLines_DuplicateSizeUsesFirstTier(t *testing.T) {
+	records := []models.PackSize{
+		{Size: 500, Tier: "retail"},
+		{Size: 500, Tier: "bulk"},
+		{Size: 250},
+	}
+	lines := packLines(map[int]int{500: 2, 250: 1}, records)
+	want := []models.PackLine{{Size: 500, Tier: "bulk", Count: 2}, {Size: 250, Tier: "", Count: 1}}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("packLines() = %v, want %v", lines, want)
+	}
+
+	sizes, _ := sizesAndStock(records)
+	if fmt.Sprint(sizes) != "[250 500]" {
+		t.Errorf("sizesAndStock() sizes = %v, want [250 500]", sizes)
+	}
+}
+
+// pingerFunc adapts a function to DatabasePinger and cache.Pinger
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) PingContext(ctx context.Context) error { return f(ctx) }
+
+// pingingCache is a cache whose server reachability is set by the test
+type pingingCache struct {
+	cache.Cache
+	err error
+}
+
+func (c *pingingCache) Ping(ctx context.Context) error { return c.err }
+
+func TestReady_ChecksDatabaseAndCache(t *testing.T) {
+	errDown := errors.New("connection refused")
+	sharedCache := &pingingCache{Cache: cache.NewMemoryCache(10)}
+	h := NewHandler(newFakeStore(250), sharedCache)
+
+	ready := func() (int, map[string]string) {
+		rec := httptest.NewRecorder()
+		h.Ready(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		var body map[string]string
+		json.Unmarshal(rec.Body.Bytes(), &body)
+		return rec.Code, body
+	}
+	health := func() int {
+		rec := httptest.NewRecorder()
+		h.HealthCheck(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+		return rec.Code
+	}
+
+	var pingErr error
+	h.SetDatabase(pingerFunc(func(ctx context.Context) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Database pinged without a deadline")
+		}
+		return pingErr
+	}))
+
+	tests := []struct {
+		name              string
+		dbErr, cacheErr   error
+		want              int
+		wantDB, wantCache string
+	}{
+		{"all up", nil, nil, http.StatusOK, "up", "up"},
+		{"database down", errDown, nil, http.StatusServiceUnavailable, "down", "up"},
+		{"cache down", nil, errDown, http.StatusServiceUnavailable, "up", "down"},
+	}
+	for _, tt := range tests {
+		pingErr, sharedCache.err = tt.dbErr, tt.cacheErr
+		code, body := ready()
+		if code != tt.want || body["database"] != tt.wantDB || body["cache"] != tt.wantCache {
+			t.Errorf("%s: Ready = %d %v, want %d with database %s and cache %s", tt.name, code, body, tt.want, tt.wantDB, tt.wantCache)
+		}
+		// Liveness never depends on the database
+		if code := health(); code != http.StatusOK {
+			t.Errorf("%s: health status = %d, want 200", tt.name, code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.Ready(rec, httptest.NewRequest(http.MethodPost, "/ready", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
+
+func TestReadyForTraffic_AfterWarmUp(t *testing.T) {
+	store := newFakeStore(250, 500, 1000)
+	store.orders = []models.Order{{ID: 1, Amount: 251}, {ID: 2, Amount: 1001}, {ID: 3, Amount: 251}}
+	memCache := cache.NewMemoryCache(100)
+	h := NewHandler(store, memCache)
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		h.ReadyForTraffic(rec, httptest.NewRequest(http.MethodGet, "/api/ready-for-traffic", nil))
+		return rec.Code
+	}
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("Status before warm-up = %d, want 503", code)
+	}
+
+	if err := h.WarmUp(context.Background(), 100); err != nil {
+		t.Fatalf("WarmUp() error = %v", err)
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("Status after warm-up = %d, want 200", code)
+	}
+	if stats := memCache.Stats(); stats.Size != 2 {
+		t.Errorf("Cache size after warm-up = %d, want 2 distinct amounts", stats.Size)
+	}
+}
+
+func TestCalculatePacks_EfficiencyRounding(t *testing.T) {
+	tests := []struct {
+		name             string
+		sizes            []int
+		amount           int
+		decimals         int
+		wantEfficiency   float64
+		wantOvershoot    int
+		wantOvershootPct float64
+	}{
+		// {23:2, 31:7, 53:9429} packs 500000 exactly
+		{"edge case exact", []int{23, 31, 53}, 500000, 0, 1.0, 0, 0},
+		{"exact match", []int{250, 500}, 750, 0, 1.0, 0, 0},
+		// 1/3 and 200% overshoot, at the default 4 decimals and at 2
+		{"one third", []int{3}, 1, 0, 0.3333, 2, 200},
+		{"two decimals", []int{23}, 1, 2, 0.04, 22, 2200},
+		{"two thirds", []int{3, 9}, 2, 0, 0.6667, 1, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandlerWithConfig(newFakeStore(tt.sizes...), nil, Config{EfficiencyDecimals: tt.decimals})
+			rec := calculate(h, fmt.Sprintf(`{"amount": %d}`, tt.amount))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Status = %d", rec.Code)
+			}
+
+			var result models.PackCalculationResult
+			json.Unmarshal(rec.Body.Bytes(), &result)
+			if result.Efficiency != tt.wantEfficiency || result.Overshoot != tt.wantOvershoot || result.OvershootPercent != tt.wantOvershootPct {
+				t.Errorf("efficiency/overshoot/percent = %v/%d/%v, want %v/%d/%v",
+					result.Efficiency, result.Overshoot, result.OvershootPercent, tt.wantEfficiency, tt.wantOvershoot, tt.wantOvershootPct)
+			}
+			if result.TotalItems != tt.amount+tt.wantOvershoot {
+				t.Errorf("TotalItems = %d, want the unrounded %d", result.TotalItems, tt.amount+tt.wantOvershoot)
+			}
+		})
+	}
+}
+
+func TestRecomputeOrders(t *testing.T) {
+	store := newFakeStore(250, 500)
+	store.orders = []models.Order{
+		{ID: 1, Amount: 750, TotalItems: 999, TotalPacks: 7, Packs: map[int]int{250: 1, 500: 1}},
+		{ID: 2, Amount: 500, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}},
+	}
+	h := NewHandler(store, nil)
+
+	rec := httptest.NewRecorder()
+	h.RecomputeOrders(rec, httptest.NewRequest(http.MethodPost, "/api/orders/recompute", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Status = %d, want 200", rec.Code)
+	}
+	var result repository.RecomputeResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if result.Scanned != 2 || result.Corrected != 1 {
+		t.Errorf("Result = %+v, want 2 scanned and 1 corrected", result)
+	}
+	if store.orders[0].TotalItems != 750 || store.orders[0].TotalPacks != 2 {
+		t.Errorf("Order 1 totals = %d/%d, want 750/2", store.orders[0].TotalItems, store.orders[0].TotalPacks)
+	}
+}
+
+func TestCalculateFast_ApproximateResult(t *testing.T) {
+	store := newFakeStore(23, 31, 53)
+	h := NewHandler(store, nil)
+
+	for _, amount := range []int{1, 263, 500000, min(20_000_000_000, maxFastAmount)} {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate/fast", strings.NewReader(fmt.Sprintf(`{"amount": %d}`, amount)))
+		rec := httptest.NewRecorder()
+		h.CalculateFast(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Amount %d: status = %d, body = %s", amount, rec.Code, rec.Body.String())
+		}
+
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		if !result.Approximate {
+			t.Errorf("Amount %d: approximate flag not set", amount)
+		}
+		if result.TotalItems < amount || result.TotalItems >= amount+2*53 {
+			t.Errorf("Amount %d: total %d outside [amount, amount + 2 largest packs)", amount, result.TotalItems)
+		}
+	}
+	if len(store.orders) != 0 {
+		t.Error("Fast calculation saved orders")
+	}
+}
+
+func TestCalculatePacks_DebugTraceAndDPHistogram(t *testing.T) {
+	h := NewHandler(newFakeStore(250, 500, 1000, 2000, 5000), cache.NewMemoryCache(100))
+
+	trace := func(rec *httptest.ResponseRecorder) *models.CalculationTrace {
+		var result models.PackCalculationResult
+		json.Unmarshal(rec.Body.Bytes(), &result)
+		return result.Trace
+	}
+
+	if got := trace(calculateWithQuery(h, "?debug=1", `{"amount": 1000}`)); got == nil || got.CacheHit || got.DPMaxTarget != 6000 || got.DPIterations != 125 {
+		t.Errorf("Miss trace = %+v, want DP bound 6000 and 125 iterations", got)
+	}
+	if got := trace(calculateWithQuery(h, "?debug=1", `{"amount": 1000}`)); got == nil || !got.CacheHit || got.DPMaxTarget != 0 {
+		t.Errorf("Hit trace = %+v, want a cache hit without DP stats", got)
+	}
+	if got := trace(calculate(h, `{"amount": 1000}`)); got != nil {
+		t.Errorf("Trace without debug = %+v, want none", got)
+	}
+	calculate(h, `{"amount": 20000}`) // DP bound 25000
+
+	rec := httptest.NewRecorder()
+	h.GetDPStats(rec, httptest.NewRequest(http.MethodGet, "/api/stats/dp", nil))
+	var body struct {
+		Buckets []struct {
+			UpperBound *int  `json:"le"`
+			Count      int64 `json:"count"`
+		} `json:"buckets"`
+		Count       int64 `json:"count"`
+		MaxDPTarget int64 `json:"max_dp_target"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if body.Count != 2 || body.MaxDPTarget != 25000 {
+		t.Errorf("count = %d, max = %d, want 2 runs with max 25000", body.Count, body.MaxDPTarget)
+	}
+	// 6000 lands in the <=10k bucket and 25000 in the <=100k bucket
+	if len(body.Buckets) != 6 || body.Buckets[1].Count != 1 || body.Buckets[2].Count != 1 || body.Buckets[5].UpperBound != nil {
+		t.Errorf("Buckets = %+v", body.Buckets)
+	}
+}
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"pack-calculator/internal/cache"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// IdempotencyKeyHeader carries the client-chosen key identifying a retried request
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyWindow is how long a stored response is replayed for a key
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// MaxIdempotentBodyBytes bounds the request body read to fingerprint a keyed request
+const MaxIdempotentBodyBytes = 1 << 20
+
+// idempotencyKeyPrefix namespaces idempotency entries in the backing cache
+const idempotencyKeyPrefix = "idempotency:"
+
+// idempotencyReservationTTL bounds how long a key stays reserved by a request that
+// never completes, such as one on an instance that crashed mid-request
+const idempotencyReservationTTL = 5 * time.Minute
+
+// storedResponse is a recorded response replayed for repeated keys, encoded as JSON
+// in the backing cache. A pending entry reserves its key while the first request runs.
+type storedResponse struct {
+	Pending     bool        `json:"pending,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+	RequestHash []byte      `json:"request_hash"` // SHA-256 of the body that produced the response
+}
+
+// IdempotencyStore keeps responses for idempotency keys within a replay window, in
+// a cache that can hold byte values: a memory cache for one instance, or the Redis
+// cache so every instance replays the same responses. The backend expires keys after
+// the window, and its own sweeping and capacity bound how many are kept.
+type IdempotencyStore struct {
+	backend cache.BlobStore
+	window  time.Duration
+}
+
+// NewIdempotencyStore creates a store that replays responses from backend for window
+func NewIdempotencyStore(backend cache.BlobStore, window time.Duration) *IdempotencyStore {
+	if window <= 0 {
+		window = DefaultIdempotencyWindow
+	}
+	return &IdempotencyStore{backend: backend, window: window}
+}
+
+// get returns the entry stored for key, pending or complete. Entries that cannot be
+// decoded are treated as absent.
+func (s *IdempotencyStore) get(key string) (*storedResponse, bool) {
+	val, ok := s.backend.GetBlob(idempotencyKeyPrefix + key)
+	if !ok {
+		return nil, false
+	}
+	var resp storedResponse
+	if err := json.Unmarshal(val, &resp); err != nil {
+		return nil, false
+	}
+	return &resp, true
+}
+
+// reserve claims key for a request with requestHash. If another request already
+// holds the key, its entry is returned instead, pending or complete.
+func (s *IdempotencyStore) reserve(key string, requestHash []byte) (*storedResponse, bool) {
+	val, err := json.Marshal(&storedResponse{Pending: true, RequestHash: requestHash})
+	if err != nil {
+		return nil, false
+	}
+	ttl := idempotencyReservationTTL
+	if s.window < ttl {
+		ttl = s.window
+	}
+	if s.backend.AddBlob(idempotencyKeyPrefix+key, val, ttl) {
+		return nil, true
+	}
+	resp, _ := s.get(key)
+	return resp, false
+}
+
+// put records a response for key, replacing its reservation and starting a new window
+func (s *IdempotencyStore) put(key string, resp *storedResponse) {
+	val, err := json.Marshal(resp)
+	if err != nil {
+		s.release(key)
+		return
+	}
+	s.backend.SetBlob(idempotencyKeyPrefix+key, val, s.window)
+}
+
+// release drops the reservation of a request whose response is not stored
+func (s *IdempotencyStore) release(key string) {
+	s.backend.DeleteBlob(idempotencyKeyPrefix + key)
+}
+
+// IdempotencyMiddleware replays the stored response for a repeated Idempotency-Key
+// on the same method and path within the store's window, so a retried POST, PUT or
+// DELETE is not executed twice. Other methods, and requests without the header, pass
+// through. Reusing a key with a different body is a client error answered with 422,
+// as replaying a response for another request would be wrong. Server errors (5xx)
+// are not stored, so they can be retried. A duplicate arriving while the first request
+// is still running gets 409 Conflict. Keys are scoped to the tenant and, when the
+// request carries a valid one, the API key. Bodies over MaxIdempotentBodyBytes are
+// rejected with 413 before the handler runs.
+func IdempotencyMiddleware(store *IdempotencyStore) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !idempotentMethod(r.Method) {
+				next(w, r)
+				return
+			}
+			// Scoped to the tenant and the API key, so one caller's key never replays another's response
+			identity, _ := APIKeyIdentityFromContext(r.Context())
+			storeKey := TenantFromContext(r.Context()) + " " + identity + " " + r.Method + " " + r.URL.Path + " " + key
+
+			// The body is fingerprinted so a reused key can be told apart from a retry
+			var body []byte
+			if r.Body != nil {
+				var err error
+				if body, err = io.ReadAll(http.MaxBytesReader(w, r.Body, MaxIdempotentBodyBytes)); err != nil {
+					var tooLarge *http.MaxBytesError
+					if errors.As(err, &tooLarge) {
+						http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+						return
+					}
+					http.Error(w, "Failed to read request body", http.StatusBadRequest)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			requestHash := sha256.Sum256(body)
+
+			// Reserve the key before running the handler, so a concurrent duplicate is
+			// turned away rather than executed a second time
+			if resp, reserved := store.reserve(storeKey, requestHash[:]); !reserved {
+				switch {
+				case resp != nil && !bytes.Equal(resp.RequestHash, requestHash[:]):
+					http.Error(w, "Idempotency-Key was already used with a different request body", http.StatusUnprocessableEntity)
+				case resp == nil || resp.Pending:
+					http.Error(w, "A request with this Idempotency-Key is still in progress", http.StatusConflict)
+				default:
+					for name, values := range resp.Header {
+						w.Header()[name] = values
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(resp.Status)
+					w.Write(resp.Body)
+				}
+				return
+			}
+
+			// The reservation is dropped unless a response is stored, including when next panics
+			stored := false
+			defer func() {
+				if !stored {
+					store.release(storeKey)
+				}
+			}()
+
+			rec := &recordingResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next(rec, r)
+
+			if rec.status < http.StatusInternalServerError {
+				store.put(storeKey, &storedResponse{
+					Status:      rec.status,
+					Header:      w.Header().Clone(),
+					Body:        rec.body.Bytes(),
+					RequestHash: requestHash[:],
+				})
+				stored = true
+			}
+		}
+	}
+}
+
+// idempotentMethod reports whether requests with method are deduplicated by key
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// recordingResponseWriter passes a response through while keeping a copy
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *recordingResponseWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,266 @@
+package middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"pack-calculator/internal/cache"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency_ReplayWindow(t *testing.T) {
+	store := NewIdempotencyStore(cache.NewMemoryCache(100), 100*time.Millisecond)
+
+	calls := 0
+	handler := IdempotencyMiddleware(store)(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, `{"call":%d}`, calls)
+	})
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := do("order-1")
+	if first.Code != http.StatusCreated || first.Body.String() != `{"call":1}` {
+		t.Fatalf("First response = %d %s", first.Code, first.Body.String())
+	}
+
+	// Replayed within the window: stored response, handler not called
+	replay := do("order-1")
+	if calls != 1 {
+		t.Errorf("Handler called %d times, want 1", calls)
+	}
+	if replay.Code != http.StatusCreated || replay.Body.String() != `{"call":1}` {
+		t.Errorf("Replay = %d %s, want the stored response", replay.Code, replay.Body.String())
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" || replay.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Replay headers = %v", replay.Header())
+	}
+
+	// After the window the key is new again and recomputes
+	time.Sleep(150 * time.Millisecond)
+	if rec := do("order-1"); rec.Body.String() != `{"call":2}` || calls != 2 {
+		t.Errorf("After expiry = %s with %d calls, want a fresh response", rec.Body.String(), calls)
+	}
+
+	// Requests without a key are never replayed
+	do("")
+	do("")
+	if calls != 4 {
+		t.Errorf("Handler called %d times, want 4", calls)
+	}
+}
+
+func TestIdempotency_BackendExpiresKeys(t *testing.T) {
+	backend := cache.NewMemoryCache(100)
+	backend.StartJanitor(5 * time.Millisecond)
+	defer backend.Close()
+	store := NewIdempotencyStore(backend, 20*time.Millisecond)
+
+	store.put("old", &storedResponse{Status: http.StatusOK})
+	if _, ok := store.get("old"); !ok {
+		t.Fatal("Stored key not found within the window")
+	}
+
+	// The backend's janitor purges the key once the window has passed
+	deadline := time.Now().Add(time.Second)
+	for backend.Stats().Size != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Backend still holds %d keys after the window", backend.Stats().Size)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestIdempotency_ConcurrentDuplicateConflicts(t *testing.T) {
+	started, finish := make(chan struct{}), make(chan struct{})
+	calls := 0
+	handler := IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		close(started)
+		<-finish
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/stock/reserve", strings.NewReader(`{"amount":500}`))
+		req.Header.Set(IdempotencyKeyHeader, "reserve-1")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	first := make(chan *httptest.ResponseRecorder)
+	go func() { first <- do() }()
+	<-started
+
+	// The first request holds the key until it completes
+	if rec := do(); rec.Code != http.StatusConflict {
+		t.Errorf("Duplicate while in flight: status = %d, want 409", rec.Code)
+	}
+	close(finish)
+	if rec := <-first; rec.Code != http.StatusCreated {
+		t.Errorf("First request status = %d, want 201", rec.Code)
+	}
+	if rec := do(); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Retry after completion = %d %v, want the replayed 201", rec.Code, rec.Header())
+	}
+	if calls != 1 {
+		t.Errorf("Handler called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotency_ScopedToAPIKey(t *testing.T) {
+	auth := NewAPIKeyAuth("key-a,key-b")
+	calls := 0
+	handler := auth.Identify(IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, "%d", calls)
+	}))
+
+	bodies := make([]string, 0, 3)
+	for _, apiKey := range []string{"key-a", "key-b", "key-a"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		req.Header.Set(IdempotencyKeyHeader, "order-1")
+		req.Header.Set("X-API-Key", apiKey)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		bodies = append(bodies, rec.Body.String())
+	}
+
+	if calls != 2 || bodies[0] != "1" || bodies[1] != "2" || bodies[2] != "1" {
+		t.Errorf("Bodies = %q with %d calls, want each API key's own response", bodies, calls)
+	}
+}
+
+func TestIdempotency_KeysCapped(t *testing.T) {
+	backend := cache.NewMemoryCache(2)
+	handler := IdempotencyMiddleware(NewIdempotencyStore(backend, time.Minute))(func(w http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		req.Header.Set(IdempotencyKeyHeader, fmt.Sprintf("order-%d", i))
+		handler(httptest.NewRecorder(), req)
+	}
+	if size := backend.Stats().Size; size != 2 {
+		t.Errorf("Backend holds %d keys, want the cap of 2", size)
+	}
+}
+
+func TestIdempotency_BodyTooLarge(t *testing.T) {
+	calls := 0
+	handler := IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/packs/import", strings.NewReader(strings.Repeat("1\n", MaxIdempotentBodyBytes)))
+	req.Header.Set(IdempotencyKeyHeader, "import-1")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge || calls != 0 {
+		t.Errorf("Oversized body: status = %d with %d calls, want 413 without calling the handler", rec.Code, calls)
+	}
+}
+
+func TestIdempotency_ServerErrorsNotStored(t *testing.T) {
+	store := NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute)
+	calls := 0
+	handler := IdempotencyMiddleware(store)(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		handler(httptest.NewRecorder(), req)
+	}
+	if calls != 2 {
+		t.Errorf("Handler called %d times, want 2 (5xx must be retryable)", calls)
+	}
+}
+
+func TestIdempotency_ScopedToTenant(t *testing.T) {
+	calls := 0
+	handler := NewAPIKeyAuth("").TenantMiddleware(IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, "%s %d", TenantFromContext(r.Context()), calls)
+	}))
+
+	bodies := make([]string, 0, 3)
+	for _, tenant := range []string{"acme", "globex", "acme"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+		req.Header.Set(IdempotencyKeyHeader, "order-1")
+		req.Header.Set(TenantHeader, tenant)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		bodies = append(bodies, rec.Body.String())
+	}
+
+	if calls != 2 || bodies[0] != "acme 1" || bodies[1] != "globex 2" || bodies[2] != "acme 1" {
+		t.Errorf("Bodies = %q with %d calls, want each tenant's own response", bodies, calls)
+	}
+}
+
+func TestIdempotency_DifferentBodyRejected(t *testing.T) {
+	calls := 0
+	handler := IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})
+
+	do := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/packs", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "add-750")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// The handler still sees the body the middleware fingerprinted
+	if rec := do(`{"size":750}`); rec.Code != http.StatusCreated || rec.Body.String() != `{"size":750}` {
+		t.Fatalf("First response = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(`{"size":750}`); rec.Code != http.StatusCreated || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Retry = %d %v, want the replayed 201", rec.Code, rec.Header())
+	}
+	if rec := do(`{"size":800}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Reused key with another body: status = %d, want 422", rec.Code)
+	}
+	if calls != 1 {
+		t.Errorf("Handler called %d times, want 1", calls)
+	}
+}
+
+func TestIdempotency_OnlyMutatingMethods(t *testing.T) {
+	calls := 0
+	handler := IdempotencyMiddleware(NewIdempotencyStore(cache.NewMemoryCache(100), time.Minute))(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, "%d", calls)
+	})
+
+	for _, method := range []string{http.MethodGet, http.MethodGet, http.MethodPut, http.MethodPut, http.MethodDelete, http.MethodDelete} {
+		req := httptest.NewRequest(method, "/api/packs/750", nil)
+		req.Header.Set(IdempotencyKeyHeader, "k")
+		handler(httptest.NewRecorder(), req)
+	}
+	// Both GETs run; PUT and DELETE each run once and replay on the retry
+	if calls != 4 {
+		t.Errorf("Handler called %d times, want 4", calls)
+	}
+}
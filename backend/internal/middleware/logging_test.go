@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	json "github.com/goccy/go-json"
+)
+
+// captureLogs routes slog.Default to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestLoggingMiddleware_RequestIDAndFields(t *testing.T) {
+	logs := captureLogs(t)
+
+	var seenID string
+	handler := LoggingMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		seenID = RequestIDFromContext(r.Context())
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("short and stout"))
+	})
+
+	// A client-supplied id is kept, exposed to the handler and echoed
+	req := httptest.NewRequest(http.MethodPost, "/api/calculate", nil)
+	req.Header.Set(RequestIDHeader, "client-abc-123")
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+	if seenID != "client-abc-123" || rec.Header().Get(RequestIDHeader) != "client-abc-123" {
+		t.Errorf("Request id in context %q, header %q; want client-abc-123", seenID, rec.Header().Get(RequestIDHeader))
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Log line is not JSON: %v: %s", err, logs.String())
+	}
+	want := map[string]interface{}{
+		"msg": "request", "request_id": "client-abc-123", "method": "POST",
+		"path": "/api/calculate", "status": float64(http.StatusTeapot), "bytes": float64(15),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("Log %s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["duration"]; !ok {
+		t.Error("Log entry has no duration")
+	}
+
+	// Missing or unusable ids are replaced with a generated UUID
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for _, incoming := range []string{"", "has spaces", string(bytes.Repeat([]byte("x"), 200))} {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		req.Header.Set(RequestIDHeader, incoming)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		if got := rec.Header().Get(RequestIDHeader); !uuid.MatchString(got) || got != seenID {
+			t.Errorf("Incoming %q: request id %q (context %q), want a matching UUID", incoming, got, seenID)
+		}
+	}
+}
+
+func TestStatusRecorder_DefaultsAndFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sr := NewStatusRecorder(rec)
+	sr.Write([]byte("abc"))
+	sr.Flush()
+	if sr.Status != http.StatusOK || sr.Bytes != 3 || !rec.Flushed {
+		t.Errorf("Recorder = status %d, %d bytes, flushed %v; want 200, 3, true", sr.Status, sr.Bytes, rec.Flushed)
+	}
+}
@@ -2,8 +2,13 @@ package middleware
 
 import (
 	"compress/gzip"
-	"io"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -11,10 +16,15 @@ import (
 
 // RateLimiter implements a simple token bucket rate limiter
 type RateLimiter struct {
-	visitors map[string]*Visitor
-	mu       sync.RWMutex
-	rate     time.Duration
-	burst    int
+	visitors    map[string]*Visitor
+	mu          sync.RWMutex
+	rate        time.Duration
+	burst       int
+	keyByAPIKey bool
+	trusted     []*net.IPNet // Proxies whose X-Forwarded-For is believed
+
+	done     chan struct{} // Closed by Stop to end the cleanup goroutine
+	stopOnce sync.Once
 }
 
 // Visitor tracks rate limit state for an IP
@@ -32,6 +42,7 @@ func NewRateLimiter(rate time.Duration, burst int) *RateLimiter {
 		visitors: make(map[string]*Visitor),
 		rate:     rate,
 		burst:    burst,
+		done:     make(chan struct{}),
 	}
 
 	// Clean up old visitors every 5 minutes
@@ -40,6 +51,64 @@ func NewRateLimiter(rate time.Duration, burst int) *RateLimiter {
 	return rl
 }
 
+// SetKeyByAPIKey makes the limiter bucket authenticated requests by API key
+// identity instead of client IP. Anonymous requests still use the IP.
+func (rl *RateLimiter) SetKeyByAPIKey(enabled bool) {
+	rl.keyByAPIKey = enabled
+}
+
+// SetTrustedProxies sets the proxies allowed to report the client address via
+// X-Forwarded-For. With none, the header is ignored and the peer address is used.
+func (rl *RateLimiter) SetTrustedProxies(nets []*net.IPNet) {
+	rl.trusted = nets
+}
+
+// ClientIP returns the address a request is rate limited by. X-Forwarded-For is
+// only honored when the peer is a trusted proxy; its entries are then walked from
+// the right, skipping further trusted proxies, so a client cannot pick its own
+// key by prepending addresses to the header.
+func (rl *RateLimiter) ClientIP(r *http.Request) string {
+	peer := parseIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if !rl.isTrusted(peer) {
+		return peer.String()
+	}
+
+	client := peer
+	entries := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(entries) - 1; i >= 0; i-- {
+		ip := parseIP(strings.TrimSpace(entries[i]))
+		if ip == nil {
+			break // Unparseable hop: stop at the last address we could verify
+		}
+		client = ip
+		if !rl.isTrusted(ip) {
+			break
+		}
+	}
+	return client.String()
+}
+
+// isTrusted reports whether ip belongs to a trusted proxy network
+func (rl *RateLimiter) isTrusted(ip net.IP) bool {
+	for _, n := range rl.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIP parses an address with or without a port, including bracketed IPv6
+func parseIP(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(addr)
+}
+
 // getVisitor returns or creates a visitor for an IP
 func (rl *RateLimiter) getVisitor(ip string) *Visitor {
 	rl.mu.Lock()
@@ -57,8 +126,9 @@ func (rl *RateLimiter) getVisitor(ip string) *Visitor {
 	return v
 }
 
-// Allow checks if a request should be allowed
-func (rl *RateLimiter) Allow(ip string) bool {
+// Allow checks if a request should be allowed. When it is not, the returned
+// duration is how long until the visitor's next token is added.
+func (rl *RateLimiter) Allow(ip string) (bool, time.Duration) {
 	visitor := rl.getVisitor(ip)
 
 	visitor.mu.Lock()
@@ -80,10 +150,12 @@ func (rl *RateLimiter) Allow(ip string) bool {
 	// Check if we have tokens available
 	if visitor.tokens > 0 {
 		visitor.tokens--
-		return true
+		return true, 0
 	}
 
-	return false
+	// lastSeen only advances when tokens are added, so the next one is due a full
+	// interval after it
+	return false, visitor.lastSeen.Add(rl.rate).Sub(now)
 }
 
 // cleanupVisitors removes visitors that haven't been seen in 5 minutes
@@ -91,7 +163,13 @@ func (rl *RateLimiter) cleanupVisitors() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ticker.C:
+		case <-rl.done:
+			return
+		}
+
 		rl.mu.Lock()
 		for ip, v := range rl.visitors {
 			v.mu.Lock()
@@ -104,17 +182,27 @@ func (rl *RateLimiter) cleanupVisitors() {
 	}
 }
 
+// Stop ends the visitor cleanup goroutine. The limiter keeps working; idle visitors
+// are just no longer removed. Safe to call more than once.
+func (rl *RateLimiter) Stop() {
+	rl.stopOnce.Do(func() { close(rl.done) })
+}
+
 // RateLimitMiddleware returns a middleware that enforces rate limiting
 func RateLimitMiddleware(rl *RateLimiter) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
-			// Get IP address
-			ip := r.RemoteAddr
-			if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-				ip = forwarded
+			key := rl.ClientIP(r)
+
+			// Authenticated clients get their own bucket when keyed by API key
+			if rl.keyByAPIKey {
+				if identity, ok := APIKeyIdentityFromContext(r.Context()); ok {
+					key = "key:" + identity
+				}
 			}
 
-			if !rl.Allow(ip) {
+			if ok, wait := rl.Allow(key); !ok {
+				w.Header().Set("Retry-After", retryAfterSeconds(wait))
 				http.Error(w, "Rate limit exceeded. Please try again later.", http.StatusTooManyRequests)
 				return
 			}
@@ -124,14 +212,127 @@ func RateLimitMiddleware(rl *RateLimiter) func(http.HandlerFunc) http.HandlerFun
 	}
 }
 
+// retryAfterSeconds formats a wait as whole seconds for Retry-After, rounding up so
+// a client that honors it never retries early
+func retryAfterSeconds(wait time.Duration) string {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return strconv.FormatInt(secs, 10)
+}
+
 // APIKeyAuth implements simple API key authentication for admin operations
 type APIKeyAuth struct {
-	apiKey string
+	apiKey        string
+	keys          map[string]bool
+	tenants       map[string]string // Tenant each tenant key is bound to, see SetTenantKey
+	allowQueryKey bool
 }
 
-// NewAPIKeyAuth creates a new API key authenticator
+// NewAPIKeyAuth creates a new API key authenticator.
+// apiKey may hold several comma-separated keys, each of which is accepted.
 func NewAPIKeyAuth(apiKey string) *APIKeyAuth {
-	return &APIKeyAuth{apiKey: apiKey}
+	a := &APIKeyAuth{apiKey: apiKey, keys: make(map[string]bool), tenants: make(map[string]string)}
+	for _, key := range strings.Split(apiKey, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			a.keys[key] = true
+		}
+	}
+	return a
+}
+
+// SetAllowQueryKey also accepts the key from the ?api_key= query parameter. It is off by
+// default because query strings end up in access logs and browser history.
+func (a *APIKeyAuth) SetAllowQueryKey(enabled bool) {
+	a.allowQueryKey = enabled
+}
+
+// requestKey returns the API key sent with r: the X-API-Key header, or the ?api_key=
+// query parameter when allowed. queryRejected reports a query key that was ignored.
+func (a *APIKeyAuth) requestKey(r *http.Request) (key string, queryRejected bool) {
+	if key = r.Header.Get("X-API-Key"); key != "" {
+		return key, false
+	}
+	query := r.URL.Query().Get("api_key")
+	if !a.allowQueryKey {
+		return "", query != ""
+	}
+	return query, false
+}
+
+// respondUnauthorized rejects a request without a valid key, pointing clients that
+// sent a disallowed query key at the header
+func respondUnauthorized(w http.ResponseWriter, queryRejected bool) {
+	if queryRejected {
+		http.Error(w, "Unauthorized: API key must be sent in the X-API-Key header", http.StatusUnauthorized)
+		return
+	}
+	http.Error(w, "Unauthorized: Invalid or missing API key", http.StatusUnauthorized)
+}
+
+// SetTenantKey binds key to tenant: requests sending it are scoped to that tenant by
+// TenantMiddleware and may change its data. Tenant keys are not admin keys, so
+// RequireAPIKey still refuses them.
+func (a *APIKeyAuth) SetTenantKey(tenant, key string) error {
+	if !ValidTenantID(tenant) {
+		return fmt.Errorf("invalid tenant %q: use up to %d lowercase letters, digits, '-' or '_'", tenant, MaxTenantIDLength)
+	}
+	if key == "" || a.keys[key] {
+		return fmt.Errorf("tenant %q needs a key of its own", tenant)
+	}
+	if bound, ok := a.tenants[key]; ok && bound != tenant {
+		return fmt.Errorf("key for tenant %q is already bound to tenant %q", tenant, bound)
+	}
+	a.tenants[key] = tenant
+	return nil
+}
+
+// valid reports whether key is one of the configured API keys
+func (a *APIKeyAuth) valid(key string) bool {
+	return key != "" && a.keys[key]
+}
+
+// tenantOf returns the tenant key is bound to, if it is a tenant key
+func (a *APIKeyAuth) tenantOf(key string) (string, bool) {
+	tenant, ok := a.tenants[key]
+	return tenant, ok && key != ""
+}
+
+// disabled reports whether no key of any kind is configured, which leaves every
+// endpoint open for backward compatibility
+func (a *APIKeyAuth) disabled() bool {
+	return a.apiKey == "" && len(a.tenants) == 0
+}
+
+type contextKey string
+
+const apiKeyIdentityKey contextKey = "api_key_identity"
+
+// APIKeyIdentityFromContext returns the identity of the authenticated API key, if any
+func APIKeyIdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(apiKeyIdentityKey).(string)
+	return identity, ok
+}
+
+// apiKeyIdentity derives a stable, non-secret identity for an API key
+func apiKeyIdentity(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Identify returns a middleware that annotates the request context with the
+// identity of a valid API key without enforcing authentication
+func (a *APIKeyAuth) Identify(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		apiKey, _ := a.requestKey(r)
+		if _, tenantKey := a.tenantOf(apiKey); a.valid(apiKey) || tenantKey {
+			ctx := context.WithValue(r.Context(), apiKeyIdentityKey, apiKeyIdentity(apiKey))
+			r = r.WithContext(ctx)
+		}
+
+		next(w, r)
+	}
 }
 
 // AuthMiddleware returns a middleware that checks API key for protected endpoints
@@ -143,10 +344,11 @@ func (a *APIKeyAuth) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		// Check API key for write operations
-		apiKey := r.Header.Get("X-API-Key")
-		if apiKey == "" {
-			apiKey = r.URL.Query().Get("api_key")
+		// Tenant keys may write their tenant's data (see TenantMiddleware)
+		apiKey, queryRejected := a.requestKey(r)
+		if _, tenantKey := a.tenantOf(apiKey); tenantKey {
+			next(w, r)
+			return
 		}
 
 		// If no API key configured, allow (backward compatibility)
@@ -155,8 +357,9 @@ func (a *APIKeyAuth) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		if apiKey != a.apiKey {
-			http.Error(w, "Unauthorized: Invalid or missing API key", http.StatusUnauthorized)
+		// Check API key for write operations
+		if !a.valid(apiKey) {
+			respondUnauthorized(w, queryRejected)
 			return
 		}
 
@@ -164,50 +367,173 @@ func (a *APIKeyAuth) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-// LoggingMiddleware logs all requests
-func LoggingMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// RequireAPIKey returns a middleware that checks the API key for every method,
+// including GET, for admin-only endpoints
+func (a *APIKeyAuth) RequireAPIKey(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+		// If no API key configured, allow (backward compatibility)
+		if a.apiKey == "" {
+			next(w, r)
+			return
+		}
 
-		// Call next handler
-		next(w, r)
+		apiKey, queryRejected := a.requestKey(r)
+		if !a.valid(apiKey) {
+			respondUnauthorized(w, queryRejected)
+			return
+		}
 
-		// Log request
-		duration := time.Since(start)
-		// In production, use proper logging library
-		_ = duration // Suppress unused warning
-		// log.Printf("%s %s - %v", r.Method, r.URL.Path, duration)
+		next(w, r)
 	}
 }
 
-// CompressionMiddleware adds gzip compression for responses
-func CompressionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+// RequireAdminKey is RequireAPIKey for endpoints that must never be public: with no
+// API key configured it refuses every request instead of allowing it
+func (a *APIKeyAuth) RequireAdminKey(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// Check if client accepts gzip
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			next(w, r)
+		if a.apiKey == "" {
+			http.Error(w, "Forbidden: set API_KEY to enable this endpoint", http.StatusForbidden)
 			return
 		}
+		a.RequireAPIKey(next)(w, r)
+	}
+}
+
+// TrimTrailingSlash strips trailing slashes from the request path before routing, so
+// /api/orders/ and /api/orders reach the same handler. Prefix routes still match their
+// subpaths: /api/packs/250/ becomes /api/packs/250. The root path is left alone.
+func TrimTrailingSlash(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if path := r.URL.Path; len(path) > 1 && strings.HasSuffix(path, "/") {
+			trimmed := strings.TrimRight(path, "/")
+			if trimmed == "" {
+				trimmed = "/"
+			}
+			u := *r.URL
+			u.Path = trimmed
+			u.RawPath = ""
+			r2 := *r
+			r2.URL = &u
+			r = &r2
+		}
+		next(w, r)
+	}
+}
+
+// DefaultCompressionMinLength is the body size below which responses are sent uncompressed
+const DefaultCompressionMinLength = 1024
+
+// CompressionMiddleware adds gzip compression for responses larger than DefaultCompressionMinLength
+func CompressionMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return CompressionMiddlewareWithMinLength(DefaultCompressionMinLength)(next)
+}
+
+// CompressionMiddlewareWithMinLength returns a gzip middleware that buffers the start of the
+// body and only compresses once it exceeds minLength bytes. Smaller bodies are sent as-is
+// with an accurate Content-Length.
+func CompressionMiddlewareWithMinLength(minLength int) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
 
-		// Create gzip writer
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
+			// Check if client accepts gzip
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+				next(w, r)
+				return
+			}
 
-		// Wrap response writer
-		gzw := &gzipResponseWriter{Writer: gz, ResponseWriter: w}
-		gzw.Header().Set("Content-Encoding", "gzip")
-		gzw.Header().Del("Content-Length") // Let gzip set this
+			gzw := &gzipResponseWriter{ResponseWriter: w, minLength: minLength, status: http.StatusOK}
+			defer gzw.Close()
 
-		next(gzw, r)
+			next(gzw, r)
+		}
 	}
 }
 
-// gzipResponseWriter wraps http.ResponseWriter with gzip compression
+// gzipResponseWriter buffers output until minLength is exceeded, then switches to gzip.
+// The status code is held back until the encoding decision is made so headers stay consistent.
 type gzipResponseWriter struct {
-	io.Writer
 	http.ResponseWriter
+	gz          *gzip.Writer
+	buf         []byte
+	minLength   int
+	status      int
+	wroteHeader bool // WriteHeader was called by the handler
+	sentHeader  bool // Headers were sent to the client
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
 }
 
 func (w *gzipResponseWriter) Write(b []byte) (int, error) {
-	return w.Writer.Write(b)
+	w.wroteHeader = true
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	if w.sentHeader {
+		return w.ResponseWriter.Write(b)
+	}
+
+	w.buf = append(w.buf, b...)
+	if len(w.buf) > w.minLength {
+		if err := w.startGzip(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// startGzip commits to a compressed response and flushes the buffered bytes through gzip
+func (w *gzipResponseWriter) startGzip() error {
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length") // Length is unknown once compressed
+	w.ResponseWriter.WriteHeader(w.status)
+	w.sentHeader = true
+
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	_, err := w.gz.Write(w.buf)
+	w.buf = nil
+	return err
+}
+
+// Flush sends buffered data immediately, compressing it so streaming responses keep working
+func (w *gzipResponseWriter) Flush() {
+	if w.gz == nil && !w.sentHeader {
+		if err := w.startGzip(); err != nil {
+			return
+		}
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close finishes the response: closes the gzip stream, or writes a small body uncompressed
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.sentHeader {
+		return nil
+	}
+
+	w.sentHeader = true
+	if !w.wroteHeader {
+		return nil // Handler wrote nothing; let net/http send its default response
+	}
+	if len(w.buf) > 0 {
+		w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
 }
@@ -0,0 +1,458 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+func TestRateLimit_KeyedByAPIKey(t *testing.T) {
+	auth := NewAPIKeyAuth("key-a,key-b")
+	rl := NewRateLimiter(time.Hour, 1)
+	rl.SetKeyByAPIKey(true)
+	handler := auth.Identify(RateLimitMiddleware(rl)(okHandler))
+
+	do := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/calculate", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Code
+	}
+
+	// Each key has its own single-token bucket even though the IP is shared
+	if code := do("key-a"); code != http.StatusOK {
+		t.Errorf("key-a first request = %d, want 200", code)
+	}
+	if code := do("key-b"); code != http.StatusOK {
+		t.Errorf("key-b first request = %d, want 200", code)
+	}
+	if code := do("key-a"); code != http.StatusTooManyRequests {
+		t.Errorf("key-a second request = %d, want 429", code)
+	}
+
+	// Anonymous and invalid-key requests fall back to the shared IP bucket
+	if code := do(""); code != http.StatusOK {
+		t.Errorf("anonymous first request = %d, want 200", code)
+	}
+	if code := do("bogus"); code != http.StatusTooManyRequests {
+		t.Errorf("invalid key request = %d, want 429 from the IP bucket", code)
+	}
+}
+
+func TestRateLimit_KeyedByIPWhenDisabled(t *testing.T) {
+	auth := NewAPIKeyAuth("key-a,key-b")
+	rl := NewRateLimiter(time.Hour, 1)
+	handler := auth.Identify(RateLimitMiddleware(rl)(okHandler))
+
+	codes := make([]int, 0, 2)
+	for _, key := range []string{"key-a", "key-b"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/calculate", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Codes = %v, want [200 429] when sharing the IP bucket", codes)
+	}
+}
+
+func TestRateLimiter_Stop(t *testing.T) {
+	rl := NewRateLimiter(time.Hour, 1)
+	rl.Stop()
+	rl.Stop() // Idempotent
+
+	// Stopping only ends cleanup; limiting still applies
+	first, _ := rl.Allow("10.0.0.1")
+	second, _ := rl.Allow("10.0.0.1")
+	if !first || second {
+		t.Error("Allow() after Stop: want one request allowed with burst 1")
+	}
+}
+
+func TestRateLimit_RetryAfter(t *testing.T) {
+	rl := NewRateLimiter(30*time.Second, 2)
+	defer rl.Stop()
+	handler := RateLimitMiddleware(rl)(okHandler)
+
+	do := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/calculate", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	// Exhaust the bucket; allowed requests carry no hint
+	for i := 0; i < 2; i++ {
+		if rec := do(); rec.Code != http.StatusOK || rec.Header().Get("Retry-After") != "" {
+			t.Fatalf("Request %d = %d with Retry-After %q, want 200 without it", i+1, rec.Code, rec.Header().Get("Retry-After"))
+		}
+	}
+
+	rec := do()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Request after exhausting bucket = %d, want 429", rec.Code)
+	}
+	secs, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+	if err != nil {
+		t.Fatalf("Retry-After = %q, want whole seconds", rec.Header().Get("Retry-After"))
+	}
+	if secs < 29 || secs > 30 {
+		t.Errorf("Retry-After = %d, want about 30 (one refill interval)", secs)
+	}
+
+	if _, wait := rl.Allow("10.0.0.1"); wait <= 0 || wait > 30*time.Second {
+		t.Errorf("Allow() wait = %s, want within one interval", wait)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := map[time.Duration]string{
+		0:                       "1",
+		100 * time.Millisecond:  "1",
+		time.Second:             "1",
+		1500 * time.Millisecond: "2",
+	}
+	for wait, want := range tests {
+		if got := retryAfterSeconds(wait); got != want {
+			t.Errorf("retryAfterSeconds(%s) = %q, want %q", wait, got, want)
+		}
+	}
+}
+
+func TestRateLimiter_ClientIP(t *testing.T) {
+	var trusted []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "2001:db8::1/128"} {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatalf("ParseCIDR(%s) error = %v", cidr, err)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	rl := NewRateLimiter(time.Hour, 1)
+	defer rl.Stop()
+	rl.SetTrustedProxies(trusted)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"port is dropped", "203.0.113.7:5555", nil, "203.0.113.7"},
+		{"spoofed header from untrusted peer", "203.0.113.7:5555", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy forwards client", "10.1.2.3:80", []string{" 198.51.100.1 "}, "198.51.100.1"},
+		{"client-prepended entries are skipped", "10.1.2.3:80", []string{"1.1.1.1, 198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"repeated headers are combined", "10.1.2.3:80", []string{"1.1.1.1", "198.51.100.1"}, "198.51.100.1"},
+		{"only proxies in chain", "10.1.2.3:80", []string{"10.4.4.4, 10.5.5.5"}, "10.4.4.4"},
+		{"garbage entry stops the walk", "10.1.2.3:80", []string{"198.51.100.1, not-an-ip"}, "10.1.2.3"},
+		{"trusted proxy without header", "10.1.2.3:80", nil, "10.1.2.3"},
+		{"IPv6 peer", "[2001:db8::7]:443", []string{"198.51.100.1"}, "2001:db8::7"},
+		{"trusted IPv6 proxy", "[2001:db8::1]:443", []string{"2001:DB8:0:0::42"}, "2001:db8::42"},
+		{"bracketed IPv6 entry with port", "10.1.2.3:80", []string{"[2001:db8::42]:9000"}, "2001:db8::42"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/calculate", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if got := rl.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimit_IgnoresSpoofedForwardedFor(t *testing.T) {
+	rl := NewRateLimiter(time.Hour, 1)
+	defer rl.Stop()
+	handler := RateLimitMiddleware(rl)(okHandler)
+
+	// Without trusted proxies a fresh header per request must not buy a fresh bucket
+	codes := make([]int, 0, 2)
+	for _, spoof := range []string{"1.1.1.1", "2.2.2.2"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/calculate", nil)
+		req.RemoteAddr = "203.0.113.7:" + strconv.Itoa(1000+len(codes)) // New port per connection
+		req.Header.Set("X-Forwarded-For", spoof)
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusTooManyRequests {
+		t.Errorf("Codes = %v, want [200 429] from the shared peer bucket", codes)
+	}
+}
+
+func TestAPIKeyAuth_QueryKey(t *testing.T) {
+	do := func(auth *APIKeyAuth, wrap func(http.HandlerFunc) http.HandlerFunc, header, query string) *httptest.ResponseRecorder {
+		target := "/api/packs"
+		if query != "" {
+			target += "?api_key=" + query
+		}
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if header != "" {
+			req.Header.Set("X-API-Key", header)
+		}
+		rec := httptest.NewRecorder()
+		wrap(okHandler)(rec, req)
+		return rec
+	}
+
+	auth := NewAPIKeyAuth("secret")
+	for name, wrap := range map[string]func(http.HandlerFunc) http.HandlerFunc{
+		"AuthMiddleware": auth.AuthMiddleware,
+		"RequireAPIKey":  auth.RequireAPIKey,
+	} {
+		// Rejected by default, with a hint to use the header
+		rec := do(auth, wrap, "", "secret")
+		if rec.Code != http.StatusUnauthorized || !strings.Contains(rec.Body.String(), "X-API-Key header") {
+			t.Errorf("%s: query key by default = %d %q, want 401 pointing at the header", name, rec.Code, rec.Body.String())
+		}
+		if rec := do(auth, wrap, "secret", ""); rec.Code != http.StatusOK {
+			t.Errorf("%s: header key = %d, want 200", name, rec.Code)
+		}
+	}
+
+	auth.SetAllowQueryKey(true)
+	if rec := do(auth, auth.AuthMiddleware, "", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("Query key when enabled = %d, want 200", rec.Code)
+	}
+	if rec := do(auth, auth.RequireAPIKey, "", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Wrong query key when enabled = %d, want 401", rec.Code)
+	}
+}
+
+func TestAPIKeyAuth_RequireAdminKey(t *testing.T) {
+	do := func(auth *APIKeyAuth, apiKey string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/config", nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		rec := httptest.NewRecorder()
+		auth.RequireAdminKey(okHandler)(rec, req)
+		return rec.Code
+	}
+
+	// Unlike RequireAPIKey, no configured key means no access at all
+	if code := do(NewAPIKeyAuth(""), ""); code != http.StatusForbidden {
+		t.Errorf("Without API_KEY = %d, want 403", code)
+	}
+	auth := NewAPIKeyAuth("secret")
+	if code := do(auth, ""); code != http.StatusUnauthorized {
+		t.Errorf("Missing key = %d, want 401", code)
+	}
+	if code := do(auth, "secret"); code != http.StatusOK {
+		t.Errorf("Admin key = %d, want 200", code)
+	}
+}
+
+func TestTenantMiddleware_FromAPIKey(t *testing.T) {
+	auth := NewAPIKeyAuth("admin-key")
+	if err := auth.SetTenantKey("acme", "acme-key"); err != nil {
+		t.Fatalf("SetTenantKey() error = %v", err)
+	}
+	if err := auth.SetTenantKey("Not/Valid", "other-key"); err == nil {
+		t.Error("SetTenantKey() accepted a malformed tenant")
+	}
+	if err := auth.SetTenantKey("globex", "admin-key"); err == nil {
+		t.Error("SetTenantKey() accepted the admin key")
+	}
+
+	do := func(apiKey, tenant string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/packs", nil)
+		if apiKey != "" {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		if tenant != "" {
+			req.Header.Set(TenantHeader, tenant)
+		}
+		rec := httptest.NewRecorder()
+		auth.TenantMiddleware(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, TenantFromContext(r.Context()))
+		})(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	tests := []struct {
+		name, apiKey, tenant string
+		wantCode             int
+		wantTenant           string
+	}{
+		{"tenant key", "acme-key", "", http.StatusOK, "acme"},
+		{"tenant key repeating its tenant", "acme-key", "ACME", http.StatusOK, "acme"},
+		{"tenant key naming another tenant", "acme-key", "globex", http.StatusForbidden, ""},
+		{"anonymous", "", "", http.StatusOK, DefaultTenant},
+		{"anonymous naming a tenant", "", "acme", http.StatusForbidden, ""},
+		{"unknown key naming a tenant", "guess", "acme", http.StatusForbidden, ""},
+		{"admin key naming a tenant", "admin-key", "globex", http.StatusOK, "globex"},
+		{"malformed tenant", "admin-key", "Not/Valid", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		code, tenant := do(tt.apiKey, tt.tenant)
+		if code != tt.wantCode || (code == http.StatusOK && tenant != tt.wantTenant) {
+			t.Errorf("%s: %d %q, want %d %q", tt.name, code, tenant, tt.wantCode, tt.wantTenant)
+		}
+	}
+
+	// Tenant keys may write their own data but are not admin keys
+	req := httptest.NewRequest(http.MethodPost, "/api/packs", nil)
+	req.Header.Set("X-API-Key", "acme-key")
+	rec := httptest.NewRecorder()
+	auth.AuthMiddleware(okHandler)(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("AuthMiddleware with a tenant key = %d, want 200", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	auth.RequireAPIKey(okHandler)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("RequireAPIKey with a tenant key = %d, want 401", rec.Code)
+	}
+}
+
+func TestCompression_MinLength(t *testing.T) {
+	small := `{"error":"Invalid size"}`
+	large := `{"data":"` + strings.Repeat("x", 2048) + `"}`
+
+	tests := []struct {
+		name           string
+		body           string
+		status         int
+		wantCompressed bool
+	}{
+		{"small body passes through", small, http.StatusBadRequest, false},
+		{"large body is compressed", large, http.StatusCreated, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressionMiddlewareWithMinLength(1024)(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip, deflate")
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Status = %d, want %d", rec.Code, tt.status)
+			}
+
+			gotBody := rec.Body.String()
+			if tt.wantCompressed {
+				if enc := rec.Header().Get("Content-Encoding"); enc != "gzip" {
+					t.Fatalf("Content-Encoding = %q, want gzip", enc)
+				}
+				if cl := rec.Header().Get("Content-Length"); cl != "" {
+					t.Errorf("Content-Length = %q, want unset for gzip", cl)
+				}
+				gz, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("gzip.NewReader() error = %v", err)
+				}
+				decoded, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatalf("Failed to decompress: %v", err)
+				}
+				gotBody = string(decoded)
+			} else {
+				if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+					t.Errorf("Content-Encoding = %q, want none", enc)
+				}
+				if cl := rec.Header().Get("Content-Length"); cl != strconv.Itoa(len(tt.body)) {
+					t.Errorf("Content-Length = %q, want %d", cl, len(tt.body))
+				}
+			}
+
+			if gotBody != tt.body {
+				t.Errorf("Body round-trip mismatch: got %d bytes, want %d", len(gotBody), len(tt.body))
+			}
+		})
+	}
+}
+
+func TestTrimTrailingSlash_RoutesConsistently(t *testing.T) {
+	// Mirrors the route table in cmd/api, including the /api/packs/ prefix route
+	mux := http.NewServeMux()
+	for _, pattern := range []string{
+		"/health", "/ready", "/api/ready-for-traffic",
+		"/api/calculate", "/api/calculate/fast", "/api/calculate/range/stream",
+		"/api/calculate/feasibility", "/api/calculate/consolidated", "/api/calculate/slip",
+		"/api/packs", "/api/packs/", "/api/packs/import", "/api/packs/bulk", "/api/packs/validate", "/api/packs/compare",
+		"/api/profiles", "/api/stock", "/api/stock/reserve", "/api/orders", "/api/orders/recompute",
+		"/api/cache/memory", "/api/stats/dp", "/api/stats/history", "/api/config", "/",
+	} {
+		pattern := pattern
+		mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Route", pattern)
+			w.Header().Set("X-Path", r.URL.Path)
+		})
+	}
+	handler := TrimTrailingSlash(mux.ServeHTTP)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodGet, path+"?amount=1", nil))
+		return rec
+	}
+
+	cases := map[string]string{
+		"/health":               "/health",
+		"/api/calculate":        "/api/calculate",
+		"/api/calculate/slip":   "/api/calculate/slip",
+		"/api/packs":            "/api/packs",
+		"/api/packs/250":        "/api/packs/",
+		"/api/packs/import":     "/api/packs/import",
+		"/api/packs/bulk":       "/api/packs/bulk",
+		"/api/packs/validate":   "/api/packs/validate",
+		"/api/packs/compare":    "/api/packs/compare",
+		"/api/stock/reserve":    "/api/stock/reserve",
+		"/api/orders":           "/api/orders",
+		"/api/orders/recompute": "/api/orders/recompute",
+		"/api/config":           "/api/config",
+		"/api/unknown":          "/",
+	}
+	for path, route := range cases {
+		for _, variant := range []string{path, path + "/", path + "//"} {
+			rec := serve(variant)
+			if rec.Code != http.StatusOK {
+				t.Errorf("%s: status %d, want 200", variant, rec.Code)
+				continue
+			}
+			if got := rec.Header().Get("X-Route"); got != route {
+				t.Errorf("%s: routed to %q, want %q", variant, got, route)
+			}
+			if got := rec.Header().Get("X-Path"); got != path {
+				t.Errorf("%s: handler saw path %q, want %q", variant, got, path)
+			}
+		}
+	}
+
+	if got := serve("/").Header().Get("X-Path"); got != "/" {
+		t.Errorf("root path rewritten to %q", got)
+	}
+}
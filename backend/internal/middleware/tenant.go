@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// TenantHeader selects the tenant whose pack sizes, orders and cache a request uses
+const TenantHeader = "X-Tenant-ID"
+
+// DefaultTenant is used by requests without a tenant header, and holds all data
+// created before tenants existed
+const DefaultTenant = "default"
+
+// MaxTenantIDLength bounds the tenant header
+const MaxTenantIDLength = 64
+
+const tenantKey contextKey = "tenant"
+
+// TenantFromContext returns the request's tenant, or DefaultTenant if none was set
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey).(string); ok {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// WithTenant returns a copy of ctx scoped to tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
+// ValidTenantID reports whether id may be used as a tenant: 1 to MaxTenantIDLength
+// lowercase letters, digits, '-' or '_'
+func ValidTenantID(id string) bool {
+	if id == "" || len(id) > MaxTenantIDLength {
+		return false
+	}
+	for _, ch := range id {
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// TenantMiddleware scopes the request to a tenant taken from its credentials rather
+// than from the X-Tenant-ID header alone. A tenant key (see SetTenantKey) selects its
+// own tenant, and the header may only repeat it. Admin keys, and every request while
+// no key is configured at all, may name any tenant with the header. Other requests use
+// DefaultTenant. Naming a tenant the credentials do not grant is refused with 403, and
+// malformed tenant IDs with 400.
+func (a *APIKeyAuth) TenantMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requested := strings.ToLower(strings.TrimSpace(r.Header.Get(TenantHeader)))
+		if requested != "" && !ValidTenantID(requested) {
+			http.Error(w, "Invalid X-Tenant-ID: use up to 64 letters, digits, '-' or '_'", http.StatusBadRequest)
+			return
+		}
+
+		apiKey, _ := a.requestKey(r)
+		tenant := DefaultTenant
+		switch bound, tenantKey := a.tenantOf(apiKey); {
+		case tenantKey:
+			tenant = bound
+		case requested != "" && (a.disabled() || a.valid(apiKey)):
+			tenant = requested
+		}
+		if requested != "" && requested != tenant {
+			http.Error(w, "Forbidden: the API key does not grant access to this X-Tenant-ID", http.StatusForbidden)
+			return
+		}
+
+		next(w, r.WithContext(WithTenant(r.Context(), tenant)))
+	}
+}
@@ -1,25 +1,304 @@
 package models
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 // PackSize represents a pack size configuration
 type PackSize struct {
-	ID        int       `json:"id" db:"id"`
-	Size      int       `json:"size" db:"size"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	ID        int        `json:"id" db:"id"`
+	Size      int        `json:"size" db:"size"`
+	Label     string     `json:"label,omitempty" db:"label"`
+	Tier      string     `json:"tier,omitempty" db:"tier"`
+	Stock     *int       `json:"stock,omitempty" db:"stock"` // Nil when stock is not tracked
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"` // Set once soft-deleted
+}
+
+// PackSizeUsage is a pack size annotated with when it last appeared in an order
+type PackSizeUsage struct {
+	PackSize
+	LastUsedAt *time.Time `json:"last_used_at"` // Nil when no order has used the size
+}
+
+// Limits for optional pack size fields
+const (
+	MaxPackLabelLength = 64
+	MaxPackTierLength  = 32
+)
+
+// AddPackSizeRequest represents the input for adding a pack size.
+// Label and Tier are optional; an empty Tier means the size is untiered.
+type AddPackSizeRequest struct {
+	Size  int    `json:"size"`
+	Label string `json:"label,omitempty"`
+	Tier  string `json:"tier,omitempty"`
+}
+
+// Normalize trims the optional fields and lowercases the tier
+func (r *AddPackSizeRequest) Normalize() {
+	r.Label = strings.TrimSpace(r.Label)
+	r.Tier = strings.ToLower(strings.TrimSpace(r.Tier))
+}
+
+// Validate checks the request fields, returning a user-facing error message
+func (r *AddPackSizeRequest) Validate() error {
+	if r.Size < 1 {
+		return errors.New("Size must be at least 1")
+	}
+	if len(r.Label) > MaxPackLabelLength {
+		return fmt.Errorf("Label must be at most %d characters", MaxPackLabelLength)
+	}
+	if len(r.Tier) > MaxPackTierLength {
+		return fmt.Errorf("Tier must be at most %d characters", MaxPackTierLength)
+	}
+	for _, ch := range r.Tier {
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+			return errors.New("Tier may only contain letters, digits, '-' and '_'")
+		}
+	}
+	return nil
+}
+
+// UpdatePackSizeRequest represents the input for changing a pack size's value
+type UpdatePackSizeRequest struct {
+	Size int `json:"size"`
+}
+
+// MaxProfileNameLength bounds pack size profile names
+const MaxProfileNameLength = 64
+
+// CreateProfileRequest represents the input for creating a named pack size profile
+type CreateProfileRequest struct {
+	Name      string `json:"name"`
+	PackSizes []int  `json:"pack_sizes"`
+}
+
+// Normalize trims and lowercases the name
+func (r *CreateProfileRequest) Normalize() {
+	r.Name = strings.ToLower(strings.TrimSpace(r.Name))
+}
+
+// Validate checks the request fields, returning a user-facing error message
+func (r *CreateProfileRequest) Validate() error {
+	if err := ValidateProfileName(r.Name); err != nil {
+		return err
+	}
+	if len(r.PackSizes) == 0 {
+		return errors.New("At least one pack size is required")
+	}
+	for _, size := range r.PackSizes {
+		if size < 1 {
+			return errors.New("Pack sizes must be at least 1")
+		}
+	}
+	return nil
+}
+
+// ValidateProfileName checks a normalized profile name: 1 to MaxProfileNameLength
+// lowercase letters, digits, '-' or '_'
+func ValidateProfileName(name string) error {
+	if name == "" || len(name) > MaxProfileNameLength {
+		return fmt.Errorf("Profile name must be 1 to %d characters", MaxProfileNameLength)
+	}
+	for _, ch := range name {
+		if !(ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_') {
+			return errors.New("Profile name may only contain letters, digits, '-' and '_'")
+		}
+	}
+	return nil
 }
 
 // PackCalculationRequest represents the input for pack calculation
+// Amount may instead be derived from TargetWeight and ItemWeight
 type PackCalculationRequest struct {
-	Amount int `json:"amount" binding:"required,min=1"`
+	Amount       int     `json:"amount" binding:"required,min=1"`
+	TargetWeight float64 `json:"target_weight,omitempty"`
+	ItemWeight   float64 `json:"item_weight,omitempty"`
+	RoundTo      *int    `json:"round_to,omitempty"`   // Round the amount up to a multiple of this before packing
+	PackSizes    []int   `json:"pack_sizes,omitempty"` // One-off sizes used instead of the configured set
+
+	// CacheTTLSeconds overrides how long the result is cached, within a server maximum;
+	// 0 skips caching it
+	CacheTTLSeconds *int `json:"cache_ttl_seconds,omitempty"`
+
+	// MaxPacks caps the packs in the result, trading overshoot for fewer packs; 0 means
+	// no cap
+	MaxPacks int `json:"max_packs,omitempty"`
 }
 
 // PackCalculationResult represents the result of pack calculation
 type PackCalculationResult struct {
-	Amount     int         `json:"amount"`
+	Amount        int         `json:"amount"`
+	TotalItems    int         `json:"total_items"`
+	TotalPacks    int         `json:"total_packs"`
+	Packs         map[int]int `json:"packs,omitempty"`         // map[packSize]quantity; omitted in summary-only mode
+	OmittedLines  int         `json:"omitted_lines,omitempty"` // Pack sizes left out by max_lines
+	TargetWeight  float64     `json:"target_weight,omitempty"` // Set when amount was derived from weight
+	ItemWeight    float64     `json:"item_weight,omitempty"`
+	RoundedAmount int         `json:"rounded_amount,omitempty"` // Amount actually packed when round_to was given
+	PackLines     []PackLine  `json:"pack_lines,omitempty"`     // Packs keyed by size and tier; set when tiers are configured
+	PacksList     []PackLine  `json:"packs_list,omitempty"`     // Packs largest size first, for a deterministic order
+
+	// Efficiency is amount / total_items and OvershootPercent is overshoot / amount * 100,
+	// both rounded for display; total_items carries the exact figure
+	Efficiency       float64 `json:"efficiency"`
+	Overshoot        int     `json:"overshoot"` // total_items - amount
+	OvershootPercent float64 `json:"overshoot_percent"`
+
+	Approximate bool `json:"approximate,omitempty"` // Set by /api/calculate/fast; may not be optimal
+
+	Error string `json:"error,omitempty"` // Set by /api/calculate/batch for an amount that could not be packed
+
+	NextExact *NextExact `json:"next_exact,omitempty"` // Set with ?next_exact=1 when the result overshoots
+
+	Alternatives []PackAlternative `json:"alternatives,omitempty"` // Other tied optimal combinations, with ?variants=true
+
+	Trace *CalculationTrace `json:"trace,omitempty"` // Set with ?debug=1
+}
+
+// NextExact suggests the smallest amount at or above the request that packs with no
+// overshoot, so a client can offer "order N more to ship with zero waste"
+type NextExact struct {
+	Amount int         `json:"amount"`
+	Total  int         `json:"total"`
+	Extra  int         `json:"extra"`           // Amount minus the requested amount
+	Packs  map[int]int `json:"packs,omitempty"` // Omitted in summary-only mode
+}
+
+// PackAlternative is another combination with the same total items and packs as the result
+type PackAlternative struct {
+	Packs      map[int]int `json:"packs"`
 	TotalItems int         `json:"total_items"`
 	TotalPacks int         `json:"total_packs"`
-	Packs      map[int]int `json:"packs"` // map[packSize]quantity
+}
+
+// CalculationTrace reports how a result was produced, for diagnosing slow calculations
+type CalculationTrace struct {
+	CacheHit     bool  `json:"cache_hit"`
+	DPMaxTarget  int   `json:"dp_max_target,omitempty"` // Largest total the DP table covered
+	DPIterations int64 `json:"dp_iterations,omitempty"` // DP transitions evaluated
+}
+
+// PackLine is one line of a tiered result: count packs of a size from a tier.
+// Packs stays keyed by size alone for untiered clients.
+type PackLine struct {
+	Size  int    `json:"size"`
+	Tier  string `json:"tier"` // Empty for untiered sizes
+	Count int    `json:"count"`
+}
+
+// BatchCalculationRequest lists amounts to calculate independently in one request
+type BatchCalculationRequest struct {
+	Amounts []int `json:"amounts"`
+}
+
+// ConsolidatedRequest lists the order amounts of one shipment
+type ConsolidatedRequest struct {
+	Amounts []int `json:"amounts"`
+}
+
+// ConsolidatedResult compares packing each order separately with packing their sum
+type ConsolidatedResult struct {
+	Orders             []PackCalculationResult `json:"orders"`       // Per-order packing, in request order
+	Consolidated       PackCalculationResult   `json:"consolidated"` // Packing of the summed amount
+	SeparateTotalItems int                     `json:"separate_total_items"`
+	SeparateTotalPacks int                     `json:"separate_total_packs"`
+	ItemsSaved         int                     `json:"items_saved"` // Separate minus consolidated items; never negative
+	PacksSaved         int                     `json:"packs_saved"` // May be negative when fewer items need more packs
+}
+
+// PackSetCompareRequest proposes a pack size set to compare against the current one.
+// Without amounts the sample is taken from recent order amounts.
+type PackSetCompareRequest struct {
+	Proposed []int `json:"proposed"`
+	Amounts  []int `json:"amounts,omitempty"`
+}
+
+// PackSetMetrics aggregates how one pack size set packs the sample amounts
+type PackSetMetrics struct {
+	PackSizes        []int   `json:"pack_sizes"`
+	TotalItems       int     `json:"total_items"`
+	TotalPacks       int     `json:"total_packs"`
+	AverageOvershoot float64 `json:"average_overshoot"` // Items sent beyond the amount, per amount
+	AveragePacks     float64 `json:"average_packs"`
+	Efficiency       float64 `json:"efficiency"`  // Sum of amounts over total items
+	ExactCount       int     `json:"exact_count"` // Amounts packed with no overshoot
+}
+
+// PackSetComparison compares the current and a proposed pack size set over the same sample
+type PackSetComparison struct {
+	SampleSize   int            `json:"sample_size"`
+	SampleSource string         `json:"sample_source"` // "request" or "orders"
+	Current      PackSetMetrics `json:"current"`
+	Proposed     PackSetMetrics `json:"proposed"`
+}
+
+// SetStockRequest sets or clears (null) the stock level of a pack size
+type SetStockRequest struct {
+	Size  int  `json:"size"`
+	Stock *int `json:"stock"`
+}
+
+// ReserveStockRequest reserves packs (map[packSize]quantity) against stock
+type ReserveStockRequest struct {
+	Packs map[int]int `json:"packs"`
+}
+
+// BulkAddPackSizesRequest represents the input for adding many pack sizes at once
+type BulkAddPackSizesRequest struct {
+	Sizes []int `json:"sizes"`
+}
+
+// BulkAddPackSizesSummary reports which sizes a bulk add inserted and which it skipped
+// as already configured or repeated in the request
+type BulkAddPackSizesSummary struct {
+	Added   []int `json:"added"`
+	Skipped []int `json:"skipped"`
+}
+
+// PackSizeValidation reports whether a proposed pack size could be added, from
+// POST /api/packs/validate
+type PackSizeValidation struct {
+	Size  int    `json:"size"`
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"` // Why the size would be rejected
+}
+
+// PackImportRowError describes an import row that could not be imported
+type PackImportRowError struct {
+	Line  int    `json:"line"`  // 1-based line in the uploaded CSV
+	Value string `json:"value"` // Raw row as uploaded
+	Error string `json:"error"`
+}
+
+// PackImportSummary reports the outcome of a pack size import
+type PackImportSummary struct {
+	Imported []int                `json:"imported"`
+	Errors   []PackImportRowError `json:"errors"`
+	Aborted  bool                 `json:"aborted,omitempty"` // Set when strict mode stopped at the first error
+}
+
+// StatsSnapshot is a point-in-time copy of the cache stats and calculation counters.
+// Counters are cumulative since process start; throughput is the difference between snapshots.
+type StatsSnapshot struct {
+	ID                int       `json:"id" db:"id"`
+	CacheHits         int64     `json:"cache_hits" db:"cache_hits"`
+	CacheMisses       int64     `json:"cache_misses" db:"cache_misses"`
+	CacheHitRatio     float64   `json:"cache_hit_ratio" db:"cache_hit_ratio"`
+	CacheSize         int       `json:"cache_size" db:"cache_size"`
+	Calculations      int64     `json:"calculations" db:"calculations"`
+	CalculationErrors int64     `json:"calculation_errors" db:"calculation_errors"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// OrderPage is one page of order history; NextCursor is empty on the last page
+type OrderPage struct {
+	Orders     []Order `json:"orders"`
+	NextCursor string  `json:"next_cursor,omitempty"`
 }
 
 // Order represents a saved order calculation
@@ -28,7 +307,11 @@ type Order struct {
 	Amount     int         `json:"amount" db:"amount"`
 	TotalItems int         `json:"total_items" db:"total_items"`
 	TotalPacks int         `json:"total_packs" db:"total_packs"`
-	PacksJSON  string      `json:"-" db:"packs_json"` // JSON string for DB storage
-	Packs      map[int]int `json:"packs" db:"-"`      // Parsed packs
-	CreatedAt  time.Time   `json:"created_at" db:"created_at"`
+	PacksJSON  string      `json:"-" db:"packs_json"`                    // JSON string for DB storage
+	Packs      map[int]int `json:"packs" db:"-"`                         // Parsed packs
+	PackSizes  []int       `json:"pack_sizes,omitempty" db:"pack_sizes"` // Sizes the order was packed from; unset on older rows
+	Checksum   string      `json:"-" db:"checksum"`                      // Hash of amount, totals and packs
+	Corrupted  bool        `json:"corrupted,omitempty" db:"-"`           // Set on read when Checksum does not match
+	CreatedAt  time.Time   `json:"created_at" db:"created_at"`           // Always UTC from the store
+	UpdatedAt  time.Time   `json:"updated_at" db:"updated_at"`           // When the totals were last rewritten; created_at until then
 }
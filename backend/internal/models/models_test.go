@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAddPackSizeRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     AddPackSizeRequest
+		wantErr bool
+	}{
+		{"size only", AddPackSizeRequest{Size: 250}, false},
+		{"all fields", AddPackSizeRequest{Size: 250, Label: "Small", Tier: "retail"}, false},
+		{"zero size", AddPackSizeRequest{Size: 0}, true},
+		{"negative size", AddPackSizeRequest{Size: -1}, true},
+		{"label too long", AddPackSizeRequest{Size: 250, Label: strings.Repeat("x", MaxPackLabelLength+1)}, true},
+		{"label at limit", AddPackSizeRequest{Size: 250, Label: strings.Repeat("x", MaxPackLabelLength)}, false},
+		{"tier too long", AddPackSizeRequest{Size: 250, Tier: strings.Repeat("t", MaxPackTierLength+1)}, true},
+		{"tier with spaces", AddPackSizeRequest{Size: 250, Tier: "bulk tier"}, true},
+		{"tier with symbols", AddPackSizeRequest{Size: 250, Tier: "bulk-tier_2"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAddPackSizeRequest_Normalize(t *testing.T) {
+	req := AddPackSizeRequest{Size: 250, Label: "  Small pack ", Tier: "  Retail "}
+	req.Normalize()
+
+	if req.Label != "Small pack" {
+		t.Errorf("Label = %q, want %q", req.Label, "Small pack")
+	}
+	if req.Tier != "retail" {
+		t.Errorf("Tier = %q, want %q", req.Tier, "retail")
+	}
+	if err := req.Validate(); err != nil {
+		t.Errorf("Validate() after Normalize error = %v", err)
+	}
+}
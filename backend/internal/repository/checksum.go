@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// orderChecksum hashes an order's amount, totals and packs so corruption of any stored
+// field (notably packs_json) can be detected on read. Packs are hashed in size order,
+// so the result does not depend on map iteration or on the packs_json encoding.
+func orderChecksum(amount, totalItems, totalPacks int, packs map[int]int) string {
+	sizes := make([]int, 0, len(packs))
+	for size := range packs {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d|%d|%d", amount, totalItems, totalPacks)
+	for _, size := range sizes {
+		fmt.Fprintf(&b, "|%d:%d", size, packs[size])
+	}
+
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
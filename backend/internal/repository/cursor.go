@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"encoding/base64"
+	"errors"
+	"pack-calculator/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when parsing a cursor that was not produced by Encode
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// OrderCursor is a position in the newest-first order listing: the creation time and
+// id of the last order on a page. Keying on both keeps pages stable when orders are
+// added or share a timestamp, which offsets cannot.
+type OrderCursor struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// CursorAfter returns the cursor positioned after order
+func CursorAfter(order models.Order) OrderCursor {
+	return OrderCursor{CreatedAt: order.CreatedAt, ID: order.ID}
+}
+
+// Encode returns the cursor as an opaque URL-safe token
+func (c OrderCursor) Encode() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixNano(), 10) + ":" + strconv.Itoa(c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseOrderCursor decodes a token produced by Encode
+func ParseOrderCursor(token string) (OrderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return OrderCursor{}, ErrInvalidCursor
+	}
+	return OrderCursor{CreatedAt: time.Unix(0, n).UTC(), ID: i}, nil
+}
+
+// Precedes reports whether an order created at createdAt with id comes after the
+// cursor in the newest-first listing, i.e. belongs to a later page
+func (c OrderCursor) Precedes(createdAt time.Time, id int) bool {
+	return createdAt.Before(c.CreatedAt) || createdAt.Equal(c.CreatedAt) && id < c.ID
+}
@@ -0,0 +1,690 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"pack-calculator/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is an in-memory Store for tests and local development without Postgres.
+// It follows the Repository's semantics, including tenant scoping, but nothing is persisted.
+type MemoryStore struct {
+	data   *memoryData
+	tenant string
+	inTx   bool // Set on the view WithTx passes to its callback
+}
+
+// memoryData is the state shared by a MemoryStore and its tenant views
+type memoryData struct {
+	mu          sync.Mutex
+	txMu        sync.Mutex                         // Serializes WithTx calls
+	sizes       map[string]map[int]models.PackSize // Tenant -> size -> record
+	deleted     map[string][]models.PackSize       // Tenant -> soft-deleted records, oldest first
+	profiles    map[string]map[string]map[int]bool // Tenant -> profile name -> sizes
+	orders      []memoryOrder                      // Oldest first
+	snapshots   []models.StatsSnapshot
+	nextSizeID  int
+	nextOrderID int
+	nextStatsID int
+}
+
+// memoryOrder is a stored order with its owning tenant
+type memoryOrder struct {
+	tenant string
+	order  models.Order
+}
+
+// NewMemoryStore creates an empty in-memory store for the default tenant
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		data: &memoryData{
+			sizes:    make(map[string]map[int]models.PackSize),
+			deleted:  make(map[string][]models.PackSize),
+			profiles: make(map[string]map[string]map[int]bool),
+		},
+		tenant: DefaultTenant,
+	}
+}
+
+// ForTenant returns a view of the store scoped to tenant, sharing its data
+func (m *MemoryStore) ForTenant(tenant string) Store {
+	return &MemoryStore{data: m.data, tenant: tenant, inTx: m.inTx}
+}
+
+// WithTx runs fn with a view of the store and, if fn returns an error, restores the
+// state from before fn ran. Transactions are serialized with each other, but writes
+// made outside one while it fails are rolled back with it: unlike the Repository this
+// store is for tests and local development. Inside a transaction WithTx simply runs fn.
+func (m *MemoryStore) WithTx(fn func(tx Store) error) error {
+	if m.inTx {
+		return fn(m)
+	}
+	m.data.txMu.Lock()
+	defer m.data.txMu.Unlock()
+
+	m.data.mu.Lock()
+	saved := m.data.snapshot()
+	m.data.mu.Unlock()
+
+	if err := fn(&MemoryStore{data: m.data, tenant: m.tenant, inTx: true}); err != nil {
+		m.data.mu.Lock()
+		m.data.restore(saved)
+		m.data.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// snapshot returns a copy of the state for WithTx to restore; mu must be held
+func (d *memoryData) snapshot() *memoryData {
+	saved := &memoryData{
+		sizes:       make(map[string]map[int]models.PackSize, len(d.sizes)),
+		deleted:     make(map[string][]models.PackSize, len(d.deleted)),
+		profiles:    make(map[string]map[string]map[int]bool, len(d.profiles)),
+		orders:      append([]memoryOrder(nil), d.orders...),
+		snapshots:   append([]models.StatsSnapshot(nil), d.snapshots...),
+		nextSizeID:  d.nextSizeID,
+		nextOrderID: d.nextOrderID,
+		nextStatsID: d.nextStatsID,
+	}
+	for tenant, sizes := range d.sizes {
+		copied := make(map[int]models.PackSize, len(sizes))
+		for size, ps := range sizes {
+			copied[size] = copyPackSize(ps)
+		}
+		saved.sizes[tenant] = copied
+	}
+	for tenant, records := range d.deleted {
+		saved.deleted[tenant] = append([]models.PackSize(nil), records...)
+	}
+	for tenant, profiles := range d.profiles {
+		copied := make(map[string]map[int]bool, len(profiles))
+		for name, members := range profiles {
+			copiedMembers := make(map[int]bool, len(members))
+			for size := range members {
+				copiedMembers[size] = true
+			}
+			copied[name] = copiedMembers
+		}
+		saved.profiles[tenant] = copied
+	}
+	return saved
+}
+
+// restore replaces the state with one taken by snapshot; mu must be held
+func (d *memoryData) restore(saved *memoryData) {
+	d.sizes, d.deleted, d.profiles = saved.sizes, saved.deleted, saved.profiles
+	d.orders, d.snapshots = saved.orders, saved.snapshots
+	d.nextSizeID, d.nextOrderID, d.nextStatsID = saved.nextSizeID, saved.nextOrderID, saved.nextStatsID
+}
+
+// tenantSizes returns the tenant's pack sizes, creating the map if needed; mu must be held
+func (m *MemoryStore) tenantSizes() map[int]models.PackSize {
+	sizes := m.data.sizes[m.tenant]
+	if sizes == nil {
+		sizes = make(map[int]models.PackSize)
+		m.data.sizes[m.tenant] = sizes
+	}
+	return sizes
+}
+
+// Pack size operations
+
+// GetAllPackSizes retrieves the tenant's pack sizes in ascending order
+func (m *MemoryStore) GetAllPackSizes() ([]models.PackSize, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	packSizes := make([]models.PackSize, 0, len(sizes))
+	for _, ps := range sizes {
+		packSizes = append(packSizes, copyPackSize(ps))
+	}
+	sort.Slice(packSizes, func(i, j int) bool { return packSizes[i].Size < packSizes[j].Size })
+	return packSizes, nil
+}
+
+// GetAllPackSizesIncludingDeleted retrieves the tenant's pack sizes and soft-deleted
+// records, ordered by size with the live record (if any) before the most recently deleted
+func (m *MemoryStore) GetAllPackSizesIncludingDeleted() ([]models.PackSize, error) {
+	packSizes, err := m.GetAllPackSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for _, ps := range m.data.deleted[m.tenant] {
+		packSizes = append(packSizes, copyPackSize(ps))
+	}
+	sort.SliceStable(packSizes, func(i, j int) bool {
+		a, b := packSizes[i], packSizes[j]
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+		if a.DeletedAt == nil || b.DeletedAt == nil {
+			return a.DeletedAt == nil && b.DeletedAt != nil
+		}
+		return a.DeletedAt.After(*b.DeletedAt)
+	})
+	return packSizes, nil
+}
+
+// GetPackSizesAsSlice returns a profile's pack sizes in ascending order. An empty
+// profile means DefaultProfile. Returns ErrProfileNotFound for an unknown profile.
+func (m *MemoryStore) GetPackSizesAsSlice(profile string) ([]int, error) {
+	if profile != "" && profile != DefaultProfile {
+		m.data.mu.Lock()
+		defer m.data.mu.Unlock()
+
+		members, exists := m.data.profiles[m.tenant][profile]
+		if !exists {
+			return nil, fmt.Errorf("%w: %q", ErrProfileNotFound, profile)
+		}
+		sizes := make([]int, 0, len(members))
+		for size := range members {
+			sizes = append(sizes, size)
+		}
+		sort.Ints(sizes)
+		return sizes, nil
+	}
+
+	packSizes, err := m.GetAllPackSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := make([]int, len(packSizes))
+	for i, ps := range packSizes {
+		sizes[i] = ps.Size
+	}
+	return sizes, nil
+}
+
+// GetPackSizesWithUsage returns every pack size with the time of the tenant's most
+// recent order that used it
+func (m *MemoryStore) GetPackSizesWithUsage() ([]models.PackSizeUsage, error) {
+	packSizes, err := m.GetAllPackSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	usage := make([]models.PackSizeUsage, len(packSizes))
+	for i, ps := range packSizes {
+		usage[i].PackSize = ps
+		for _, stored := range m.data.orders {
+			order := stored.order
+			if stored.tenant != m.tenant || order.Packs[ps.Size] == 0 {
+				continue
+			}
+			if usage[i].LastUsedAt == nil || order.CreatedAt.After(*usage[i].LastUsedAt) {
+				usedAt := order.CreatedAt
+				usage[i].LastUsedAt = &usedAt
+			}
+		}
+	}
+	return usage, nil
+}
+
+// AddPackSize adds a new pack size with no label or tier and returns it.
+// Returns ErrPackSizeExists if the size is already configured.
+func (m *MemoryStore) AddPackSize(size int) (models.PackSize, error) {
+	return m.AddPackSizeWithDetails(models.AddPackSizeRequest{Size: size})
+}
+
+// AddPackSizeWithDetails adds a new pack size with its optional label and tier and
+// returns it. Returns ErrPackSizeExists if the size is already configured.
+func (m *MemoryStore) AddPackSizeWithDetails(req models.AddPackSizeRequest) (models.PackSize, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	if _, exists := sizes[req.Size]; exists {
+		return models.PackSize{}, fmt.Errorf("failed to add pack size %d: %w", req.Size, ErrPackSizeExists)
+	}
+	m.data.nextSizeID++
+	ps := models.PackSize{
+		ID:        m.data.nextSizeID,
+		Size:      req.Size,
+		Label:     req.Label,
+		Tier:      req.Tier,
+		CreatedAt: time.Now().UTC(),
+	}
+	sizes[req.Size] = ps
+	return ps, nil
+}
+
+// AddPackSizes adds several pack sizes; either all are added or, if any is already
+// configured, none are and ErrPackSizeExists is returned
+func (m *MemoryStore) AddPackSizes(sizes []int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	existing := m.tenantSizes()
+	seen := make(map[int]bool, len(sizes))
+	for _, size := range sizes {
+		if _, exists := existing[size]; exists || seen[size] {
+			return fmt.Errorf("failed to add pack size %d: %w", size, ErrPackSizeExists)
+		}
+		seen[size] = true
+	}
+	now := time.Now().UTC()
+	for _, size := range sizes {
+		m.data.nextSizeID++
+		existing[size] = models.PackSize{ID: m.data.nextSizeID, Size: size, CreatedAt: now}
+	}
+	return nil
+}
+
+// LockPackSizes is a no-op, as WithTx already serializes transactions. Like the
+// Repository it must be called inside WithTx.
+func (m *MemoryStore) LockPackSizes() error {
+	if !m.inTx {
+		return errors.New("LockPackSizes must be called inside WithTx")
+	}
+	return nil
+}
+
+// DeletePackSize removes a pack size
+func (m *MemoryStore) DeletePackSize(size int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	record, exists := sizes[size]
+	if !exists {
+		return fmt.Errorf("pack size %d not found", size)
+	}
+	deletedAt := time.Now().UTC()
+	record.DeletedAt = &deletedAt
+	m.data.deleted[m.tenant] = append(m.data.deleted[m.tenant], record)
+	delete(sizes, size)
+	return nil
+}
+
+// RestorePackSize undeletes the most recently deleted record for size.
+// Returns ErrPackSizeExists if the size has since been added again and
+// ErrPackSizeNotFound if it has no deleted record.
+func (m *MemoryStore) RestorePackSize(size int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	deleted := m.data.deleted[m.tenant]
+	for i := len(deleted) - 1; i >= 0; i-- {
+		if deleted[i].Size != size {
+			continue
+		}
+		sizes := m.tenantSizes()
+		if _, exists := sizes[size]; exists {
+			return fmt.Errorf("failed to restore pack size %d: %w", size, ErrPackSizeExists)
+		}
+		record := deleted[i]
+		record.DeletedAt = nil
+		sizes[size] = record
+		m.data.deleted[m.tenant] = append(deleted[:i:i], deleted[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("%w: no deleted pack size %d", ErrPackSizeNotFound, size)
+}
+
+// UpdatePackSize changes a pack size's value, keeping the rest of its record
+func (m *MemoryStore) UpdatePackSize(oldSize, newSize int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	record, exists := sizes[oldSize]
+	if !exists {
+		return fmt.Errorf("%w: %d", ErrPackSizeNotFound, oldSize)
+	}
+	if newSize == oldSize {
+		return nil
+	}
+	if _, taken := sizes[newSize]; taken {
+		return fmt.Errorf("failed to update pack size %d: %w", oldSize, ErrPackSizeExists)
+	}
+	delete(sizes, oldSize)
+	record.Size = newSize
+	sizes[newSize] = record
+	return nil
+}
+
+// PackSizeExists checks if a pack size exists
+func (m *MemoryStore) PackSizeExists(size int) (bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	_, exists := m.tenantSizes()[size]
+	return exists, nil
+}
+
+// PackSizesExist reports which of sizes already exist. Sizes that do not exist are
+// absent from the map.
+func (m *MemoryStore) PackSizesExist(sizes []int) (map[int]bool, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	configured := m.tenantSizes()
+	existing := make(map[int]bool)
+	for _, size := range sizes {
+		if _, exists := configured[size]; exists {
+			existing[size] = true
+		}
+	}
+	return existing, nil
+}
+
+// Profile operations
+
+// CreateProfile adds a named profile with sizes for the tenant.
+// Returns ErrProfileExists if the name is taken, including by DefaultProfile.
+func (m *MemoryStore) CreateProfile(name string, sizes []int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	profiles := m.data.profiles[m.tenant]
+	if profiles == nil {
+		profiles = make(map[string]map[int]bool)
+		m.data.profiles[m.tenant] = profiles
+	}
+	if _, exists := profiles[name]; exists || name == DefaultProfile {
+		return fmt.Errorf("failed to create profile %q: %w", name, ErrProfileExists)
+	}
+	members := make(map[int]bool, len(sizes))
+	for _, size := range sizes {
+		members[size] = true
+	}
+	profiles[name] = members
+	return nil
+}
+
+// AddProfilePackSizes associates sizes with a profile, ignoring any it already has.
+// Sizes added to DefaultProfile become the tenant's pack sizes.
+// Returns ErrProfileNotFound for an unknown profile.
+func (m *MemoryStore) AddProfilePackSizes(name string, sizes []int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if name == DefaultProfile {
+		configured := m.tenantSizes()
+		for _, size := range sizes {
+			if _, exists := configured[size]; !exists {
+				m.data.nextSizeID++
+				configured[size] = models.PackSize{ID: m.data.nextSizeID, Size: size, CreatedAt: time.Now().UTC()}
+			}
+		}
+		return nil
+	}
+
+	members, exists := m.data.profiles[m.tenant][name]
+	if !exists {
+		return fmt.Errorf("%w: %q", ErrProfileNotFound, name)
+	}
+	for _, size := range sizes {
+		members[size] = true
+	}
+	return nil
+}
+
+// Stock operations
+
+// SetStock sets the stock level of a pack size. A nil stock stops tracking it (unlimited).
+func (m *MemoryStore) SetStock(size int, stock *int) error {
+	if stock != nil && *stock < 0 {
+		return fmt.Errorf("stock cannot be negative")
+	}
+
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	ps, exists := sizes[size]
+	if !exists {
+		return ErrPackSizeNotFound
+	}
+	ps.Stock = nil
+	if stock != nil {
+		value := *stock
+		ps.Stock = &value
+	}
+	sizes[size] = ps
+	return nil
+}
+
+// ReserveStock decrements stock by the given pack counts, all or nothing. Untracked
+// sizes are unlimited.
+func (m *MemoryStore) ReserveStock(packs map[int]int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	sizes := m.tenantSizes()
+	for size, count := range packs {
+		ps, exists := sizes[size]
+		if !exists {
+			return ErrPackSizeNotFound
+		}
+		if ps.Stock != nil && *ps.Stock < count {
+			return ErrInsufficientStock
+		}
+	}
+	for size, count := range packs {
+		if ps := sizes[size]; ps.Stock != nil {
+			remaining := *ps.Stock - count
+			ps.Stock = &remaining
+			sizes[size] = ps
+		}
+	}
+	return nil
+}
+
+// Order operations
+
+// SaveOrder saves an order and populates its ID and creation time
+func (m *MemoryStore) SaveOrder(order *models.Order) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.insertOrder(order, time.Now().UTC())
+	return nil
+}
+
+// SaveOrders saves a batch of orders; either every order is saved or none are
+func (m *MemoryStore) SaveOrders(orders []*models.Order) error {
+	return m.SaveOrdersContext(context.Background(), orders)
+}
+
+// SaveOrdersContext is SaveOrders bound to ctx. Nothing is saved if ctx is already cancelled.
+func (m *MemoryStore) SaveOrdersContext(ctx context.Context, orders []*models.Order) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, order := range orders {
+		m.insertOrder(order, now)
+	}
+	return nil
+}
+
+// insertOrder stores a copy of order for the tenant; mu must be held
+func (m *MemoryStore) insertOrder(order *models.Order, createdAt time.Time) {
+	m.data.nextOrderID++
+	order.ID = m.data.nextOrderID
+	order.CreatedAt = createdAt
+	order.UpdatedAt = createdAt
+	order.Checksum = orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs)
+	m.data.orders = append(m.data.orders, memoryOrder{tenant: m.tenant, order: copyOrder(*order)})
+}
+
+// GetAllOrders retrieves the tenant's newest orders
+func (m *MemoryStore) GetAllOrders(limit int) ([]models.Order, error) {
+	return m.QueryOrders(OrderFilter{Limit: limit})
+}
+
+// QueryOrders retrieves the tenant's newest orders matching the filter
+func (m *MemoryStore) QueryOrders(filter OrderFilter) ([]models.Order, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	orders := make([]models.Order, 0)
+	for i := len(m.data.orders) - 1; i >= 0 && len(orders) < filter.Limit; i-- {
+		stored := m.data.orders[i]
+		order := stored.order
+		if stored.tenant != m.tenant ||
+			filter.Amount != nil && order.Amount != *filter.Amount ||
+			filter.MinAmount != nil && order.Amount < *filter.MinAmount ||
+			filter.MaxAmount != nil && order.Amount > *filter.MaxAmount ||
+			filter.After != nil && !filter.After.Precedes(order.CreatedAt, order.ID) {
+			continue
+		}
+		orders = append(orders, copyOrder(order))
+	}
+	return orders, nil
+}
+
+// DeleteOrder deletes one of the tenant's orders, returning ErrOrderNotFound if absent
+func (m *MemoryStore) DeleteOrder(id int) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	for i, stored := range m.data.orders {
+		if stored.tenant == m.tenant && stored.order.ID == id {
+			m.data.orders = append(m.data.orders[:i], m.data.orders[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %d", ErrOrderNotFound, id)
+}
+
+// RecomputeOrderTotals re-derives every order's totals from its packs, across all
+// tenants, skipping and reporting orders that fail checksum verification
+func (m *MemoryStore) RecomputeOrderTotals(batchSize int) (RecomputeResult, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	var result RecomputeResult
+	now := time.Now().UTC()
+	for i := range m.data.orders {
+		order := &m.data.orders[i].order
+		result.Scanned++
+		if order.Checksum != "" && order.Checksum != orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs) {
+			result.reportCorrupted(order.ID)
+			continue
+		}
+		totalItems, totalPacks := 0, 0
+		for size, count := range order.Packs {
+			totalItems += size * count
+			totalPacks += count
+		}
+		if totalItems != order.TotalItems || totalPacks != order.TotalPacks {
+			order.TotalItems, order.TotalPacks = totalItems, totalPacks
+			if order.Checksum != "" {
+				order.Checksum = orderChecksum(order.Amount, totalItems, totalPacks, order.Packs)
+			}
+			order.UpdatedAt = now
+			result.Corrected++
+		}
+	}
+	return result, nil
+}
+
+// PruneOrders deletes orders created before the cutoff, across all tenants
+func (m *MemoryStore) PruneOrders(before time.Time, batchSize int) (int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	kept := m.data.orders[:0]
+	for _, stored := range m.data.orders {
+		if !stored.order.CreatedAt.Before(before) {
+			kept = append(kept, stored)
+		}
+	}
+	pruned := int64(len(m.data.orders) - len(kept))
+	m.data.orders = kept
+	return pruned, nil
+}
+
+// Stats snapshot operations
+
+// SaveStatsSnapshot stores a snapshot and populates its ID and creation time
+func (m *MemoryStore) SaveStatsSnapshot(snapshot *models.StatsSnapshot) error {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	m.data.nextStatsID++
+	snapshot.ID = m.data.nextStatsID
+	snapshot.CreatedAt = time.Now().UTC()
+	m.data.snapshots = append(m.data.snapshots, *snapshot)
+	return nil
+}
+
+// GetStatsSnapshots returns the newest limit snapshots created at or after since, oldest first
+func (m *MemoryStore) GetStatsSnapshots(since time.Time, limit int) ([]models.StatsSnapshot, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	snapshots := []models.StatsSnapshot{}
+	for _, snapshot := range m.data.snapshots {
+		if !snapshot.CreatedAt.Before(since) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	if len(snapshots) > limit {
+		snapshots = snapshots[len(snapshots)-limit:]
+	}
+	return snapshots, nil
+}
+
+// PruneStatsSnapshots deletes snapshots created before the cutoff
+func (m *MemoryStore) PruneStatsSnapshots(before time.Time) (int64, error) {
+	m.data.mu.Lock()
+	defer m.data.mu.Unlock()
+
+	kept := m.data.snapshots[:0]
+	for _, snapshot := range m.data.snapshots {
+		if !snapshot.CreatedAt.Before(before) {
+			kept = append(kept, snapshot)
+		}
+	}
+	pruned := int64(len(m.data.snapshots) - len(kept))
+	m.data.snapshots = kept
+	return pruned, nil
+}
+
+// copyPackSize returns ps with its own copies of the stock level and deletion time
+func copyPackSize(ps models.PackSize) models.PackSize {
+	if ps.Stock != nil {
+		stock := *ps.Stock
+		ps.Stock = &stock
+	}
+	if ps.DeletedAt != nil {
+		deletedAt := *ps.DeletedAt
+		ps.DeletedAt = &deletedAt
+	}
+	return ps
+}
+
+// copyOrder returns order with its own packs map and pack sizes, so callers cannot
+// modify stored orders
+func copyOrder(order models.Order) models.Order {
+	packs := make(map[int]int, len(order.Packs))
+	for size, count := range order.Packs {
+		packs[size] = count
+	}
+	order.Packs = packs
+	order.PackSizes = append([]int(nil), order.PackSizes...)
+	return order
+}
+
+// Compile-time check that MemoryStore satisfies Store
+var _ Store = (*MemoryStore)(nil)
@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	json "github.com/goccy/go-json"
+)
+
+// An order's packs are stored either as plain JSON in packs_json or, compressed, as raw
+// gzip data in the packs_gz bytea column with packs_json left empty.
+
+// encodePacks serializes packs for the packs_json and packs_gz columns. Compressed packs
+// leave packsJSON empty; plain ones leave packsGz nil.
+func encodePacks(packs map[int]int, compress bool) (packsJSON string, packsGz []byte, err error) {
+	data, err := json.Marshal(packs)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal packs: %w", err)
+	}
+	if !compress {
+		return string(data), nil, nil
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return "", nil, fmt.Errorf("failed to compress packs: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", nil, fmt.Errorf("failed to compress packs: %w", err)
+	}
+	return "", buf.Bytes(), nil
+}
+
+// decodePacks parses an order's stored packs from the gzip data in packsGz if there is
+// any, and from the plain JSON in packsJSON otherwise
+func decodePacks(packsJSON string, packsGz []byte) (map[int]int, error) {
+	data := []byte(packsJSON)
+	if len(packsGz) > 0 {
+		var err error
+		if data, err = gunzip(packsGz); err != nil {
+			return nil, err
+		}
+	}
+
+	var packs map[int]int
+	if err := json.Unmarshal(data, &packs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal packs: %w", err)
+	}
+	return packs, nil
+}
+
+// gunzip decompresses gzip data
+func gunzip(compressed []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress packs: %w", err)
+	}
+	defer gz.Close()
+
+	data, err := io.ReadAll(gz)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress packs: %w", err)
+	}
+	return data, nil
+}
@@ -1,67 +1,245 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"pack-calculator/internal/models"
+	"sort"
+	"strings"
 	"time"
 
-	json "github.com/goccy/go-json"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
+// ErrPackSizeExists is returned when inserting a pack size that is already configured
+var ErrPackSizeExists = errors.New("pack size already exists")
+
+// Profile errors
+var (
+	ErrProfileExists   = errors.New("profile already exists")
+	ErrProfileNotFound = errors.New("profile not found")
+)
+
+// ErrOrderNotFound is returned when deleting an order that does not exist for the tenant
+var ErrOrderNotFound = errors.New("order not found")
+
+// Stock errors
+var (
+	ErrPackSizeNotFound  = errors.New("pack size not found")
+	ErrInsufficientStock = errors.New("insufficient stock")
+)
+
+// uniqueViolationCode is the Postgres SQLSTATE for unique constraint violations
+const uniqueViolationCode = "23505"
+
+// Store is the set of repository operations used by the HTTP handlers
+type Store interface {
+	GetAllPackSizes() ([]models.PackSize, error)
+	GetAllPackSizesIncludingDeleted() ([]models.PackSize, error)
+	GetPackSizesAsSlice(profile string) ([]int, error)
+	GetPackSizesWithUsage() ([]models.PackSizeUsage, error)
+	AddPackSize(size int) (models.PackSize, error)
+	AddPackSizeWithDetails(req models.AddPackSizeRequest) (models.PackSize, error)
+	AddPackSizes(sizes []int) error
+	LockPackSizes() error
+	DeletePackSize(size int) error
+	RestorePackSize(size int) error
+	UpdatePackSize(oldSize, newSize int) error
+	PackSizeExists(size int) (bool, error)
+	PackSizesExist(sizes []int) (map[int]bool, error)
+	CreateProfile(name string, sizes []int) error
+	AddProfilePackSizes(name string, sizes []int) error
+	SaveOrder(order *models.Order) error
+	SaveOrdersContext(ctx context.Context, orders []*models.Order) error
+	GetAllOrders(limit int) ([]models.Order, error)
+	QueryOrders(filter OrderFilter) ([]models.Order, error)
+	DeleteOrder(id int) error
+	RecomputeOrderTotals(batchSize int) (RecomputeResult, error)
+	PruneOrders(before time.Time, batchSize int) (int64, error)
+	SetStock(size int, stock *int) error
+	ReserveStock(packs map[int]int) error
+	SaveStatsSnapshot(snapshot *models.StatsSnapshot) error
+	GetStatsSnapshots(since time.Time, limit int) ([]models.StatsSnapshot, error)
+	PruneStatsSnapshots(before time.Time) (int64, error)
+	ForTenant(tenant string) Store
+	WithTx(fn func(tx Store) error) error
+}
+
+// DefaultTenant owns all rows created before tenants existed and is used when no
+// tenant is given
+const DefaultTenant = "default"
+
+// DefaultProfile names the tenant's own pack sizes, seeded with DefaultPackSizes and
+// managed through the pack size operations. Other profiles are separate named sets.
+const DefaultProfile = "default"
+
+// isUniqueViolation reports whether err is a Postgres unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolationCode
+}
+
+// dbtx is the part of *sql.DB and *sql.Tx the repository queries through, so the same
+// operations run on the connection pool or inside WithTx's transaction
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
 // Repository handles database operations
 type Repository struct {
-	db                 *sql.DB
+	pool               *sql.DB // Opens transactions and prepares statements
+	db                 dbtx    // The pool, or the transaction inside WithTx
+	tx                 *sql.Tx // Set inside WithTx
 	getPackSizesStmt   *sql.Stmt
 	addPackSizeStmt    *sql.Stmt
 	deletePackSizeStmt *sql.Stmt
 	saveOrderStmt      *sql.Stmt
 	getOrdersStmt      *sql.Stmt
+	deleteOrderStmt    *sql.Stmt
+	compressPacks      bool
+	tenant             string // Scopes pack size and order queries
 }
 
 // NewRepository creates a new repository instance with prepared statements
 func NewRepository(db *sql.DB) *Repository {
-	repo := &Repository{db: db}
+	repo := &Repository{pool: db, db: db, tenant: DefaultTenant}
 
 	// Prepare statements (will be initialized after schema is created)
 	return repo
 }
 
+// SetPacksCompression enables gzip compression of packs for newly saved orders.
+// Reading always accepts both compressed and plain rows.
+func (r *Repository) SetPacksCompression(enabled bool) {
+	r.compressPacks = enabled
+}
+
+// ForTenant returns a repository sharing this one's connection and statements whose
+// pack size and order operations only see tenant's rows. Stats snapshots and order
+// total recomputation are deployment-wide and are not scoped.
+func (r *Repository) ForTenant(tenant string) Store {
+	scoped := *r
+	scoped.tenant = tenant
+	return &scoped
+}
+
+// WithTx runs fn with a store whose operations all run in one transaction, which is
+// committed if fn returns nil and rolled back otherwise. Prepared statements are
+// rebound to the transaction, and methods that open their own transaction, such as
+// SaveOrders, join it instead, so fn should return any error they report. Pack sizes
+// read inside the transaction are locked against deletion and updates until it ends.
+// Inside a transaction WithTx simply runs fn.
+func (r *Repository) WithTx(fn func(tx Store) error) error {
+	return r.inTx(func(tx *Repository) error { return fn(tx) })
+}
+
+// inTx is WithTx for the repository's own methods, which need the concrete type
+func (r *Repository) inTx(fn func(*Repository) error) error {
+	if r.tx != nil {
+		return fn(r)
+	}
+
+	tx, err := r.pool.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	scoped := *r
+	scoped.db = tx
+	scoped.tx = tx
+	if err := fn(&scoped); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// methodTx is a transaction a repository method writes in. Inside WithTx it is the
+// enclosing transaction, which only WithTx commits or rolls back.
+type methodTx struct {
+	*sql.Tx
+	joined bool
+}
+
+func (t methodTx) Commit() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Commit()
+}
+
+func (t methodTx) Rollback() error {
+	if t.joined {
+		return nil
+	}
+	return t.Tx.Rollback()
+}
+
+// begin starts a transaction for a method's writes, or joins WithTx's
+func (r *Repository) begin(ctx context.Context) (methodTx, error) {
+	if r.tx != nil {
+		return methodTx{Tx: r.tx, joined: true}, nil
+	}
+	tx, err := r.pool.BeginTx(ctx, nil)
+	return methodTx{Tx: tx}, err
+}
+
+// stmt returns a prepared statement bound to the transaction inside WithTx
+func (r *Repository) stmt(s *sql.Stmt) *sql.Stmt {
+	if r.tx != nil {
+		return r.tx.Stmt(s)
+	}
+	return s
+}
+
 // PrepareStatements prepares SQL statements for better performance
 func (r *Repository) PrepareStatements() error {
 	var err error
 
 	// Prepare get pack sizes statement
-	r.getPackSizesStmt, err = r.db.Prepare(`SELECT id, size, created_at FROM pack_sizes ORDER BY size ASC`)
+	r.getPackSizesStmt, err = r.pool.Prepare(`SELECT id, size, label, tier, stock, created_at, deleted_at FROM pack_sizes WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY size ASC`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare get pack sizes statement: %w", err)
 	}
 
 	// Prepare add pack size statement
-	r.addPackSizeStmt, err = r.db.Prepare(`INSERT INTO pack_sizes (size, created_at) VALUES ($1, $2)`)
+	r.addPackSizeStmt, err = r.pool.Prepare(`INSERT INTO pack_sizes (size, label, tier, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare add pack size statement: %w", err)
 	}
 
-	// Prepare delete pack size statement
-	r.deletePackSizeStmt, err = r.db.Prepare(`DELETE FROM pack_sizes WHERE size = $1`)
+	// Prepare delete pack size statement (a soft delete)
+	r.deletePackSizeStmt, err = r.pool.Prepare(`UPDATE pack_sizes SET deleted_at = $3 WHERE size = $1 AND tenant_id = $2 AND deleted_at IS NULL`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare delete pack size statement: %w", err)
 	}
 
 	// Prepare save order statement
-	r.saveOrderStmt, err = r.db.Prepare(`INSERT INTO orders (amount, total_items, total_packs, packs_json, created_at) VALUES ($1, $2, $3, $4, $5) RETURNING id`)
+	r.saveOrderStmt, err = r.pool.Prepare(`INSERT INTO orders (amount, total_items, total_packs, packs_json, packs_gz, checksum, created_at, updated_at, tenant_id) VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8) RETURNING id`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare save order statement: %w", err)
 	}
 
 	// Prepare get orders statement
-	r.getOrdersStmt, err = r.db.Prepare(`SELECT id, amount, total_items, total_packs, packs_json, created_at FROM orders ORDER BY created_at DESC LIMIT $1`)
+	r.getOrdersStmt, err = r.pool.Prepare(`SELECT id, amount, total_items, total_packs, packs_json, packs_gz, checksum, created_at, updated_at FROM orders WHERE tenant_id = $1 ORDER BY created_at DESC LIMIT $2`)
 	if err != nil {
 		return fmt.Errorf("failed to prepare get orders statement: %w", err)
 	}
 
+	// Prepare delete order statement
+	r.deleteOrderStmt, err = r.pool.Prepare(`DELETE FROM orders WHERE id = $1 AND tenant_id = $2`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare delete order statement: %w", err)
+	}
+
 	return nil
 }
 
@@ -89,7 +267,7 @@ func (r *Repository) InitSchema() error {
 		`CREATE TABLE IF NOT EXISTS pack_sizes (
 			id SERIAL PRIMARY KEY,
 			size INTEGER NOT NULL UNIQUE,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
 		)`,
 		`CREATE TABLE IF NOT EXISTS orders (
 			id SERIAL PRIMARY KEY,
@@ -97,10 +275,52 @@ func (r *Repository) InitSchema() error {
 			total_items INTEGER NOT NULL,
 			total_packs INTEGER NOT NULL,
 			packs_json TEXT NOT NULL,
-			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`ALTER TABLE pack_sizes ADD COLUMN IF NOT EXISTS label TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE pack_sizes ADD COLUMN IF NOT EXISTS tier TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS checksum TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE pack_sizes ADD COLUMN IF NOT EXISTS stock INTEGER CHECK (stock >= 0)`,
+		`CREATE TABLE IF NOT EXISTS stats_snapshots (
+			id SERIAL PRIMARY KEY,
+			cache_hits BIGINT NOT NULL,
+			cache_misses BIGINT NOT NULL,
+			cache_hit_ratio DOUBLE PRECISION NOT NULL,
+			cache_size INTEGER NOT NULL,
+			calculations BIGINT NOT NULL,
+			calculation_errors BIGINT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL
 		)`,
+		`CREATE INDEX IF NOT EXISTS idx_stats_snapshots_created_at ON stats_snapshots(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_pack_sizes_size ON pack_sizes(size)`,
 		`CREATE INDEX IF NOT EXISTS idx_orders_created_at ON orders(created_at DESC)`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_amount ON orders(amount)`,
+		// Tenants: existing rows belong to the default tenant, and sizes are unique per tenant
+		`ALTER TABLE pack_sizes ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default'`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default'`,
+		`ALTER TABLE pack_sizes DROP CONSTRAINT IF EXISTS pack_sizes_size_key`,
+		// Soft deletes: sizes are only unique among a tenant's rows that are not deleted
+		`ALTER TABLE pack_sizes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ`,
+		`DROP INDEX IF EXISTS idx_pack_sizes_tenant_size`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_pack_sizes_tenant_size_active ON pack_sizes(tenant_id, size) WHERE deleted_at IS NULL`,
+		`CREATE INDEX IF NOT EXISTS idx_orders_tenant_created_at ON orders(tenant_id, created_at DESC)`,
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS pack_sizes INTEGER[]`,
+		`CREATE TABLE IF NOT EXISTS pack_profiles (
+			id SERIAL PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (tenant_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS pack_profile_sizes (
+			profile_id INTEGER NOT NULL REFERENCES pack_profiles(id) ON DELETE CASCADE,
+			size INTEGER NOT NULL CHECK (size > 0),
+			PRIMARY KEY (profile_id, size)
+		)`,
+		// Orders record when their totals were last rewritten; older rows fall back to created_at
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ`,
+		// Compressed packs are raw gzip data, leaving packs_json empty
+		`ALTER TABLE orders ADD COLUMN IF NOT EXISTS packs_gz BYTEA`,
 	}
 
 	for _, query := range queries {
@@ -109,41 +329,285 @@ func (r *Repository) InitSchema() error {
 		}
 	}
 
+	return r.initPackSizeUsage()
+}
+
+// initPackSizeUsage creates pack_size_usage, the newest order time per tenant and size,
+// and fills it from the existing orders in the same transaction, so a start that fails
+// partway retries the backfill. Saving an order keeps the table current after that.
+func (r *Repository) initPackSizeUsage() error {
+	var exists bool
+	if err := r.db.QueryRow(`SELECT to_regclass('pack_size_usage') IS NOT NULL`).Scan(&exists); err != nil {
+		return fmt.Errorf("failed to check pack size usage table: %w", err)
+	}
+	if exists {
+		return nil
+	}
+
+	return r.inTx(func(tx *Repository) error {
+		if _, err := tx.db.Exec(`CREATE TABLE pack_size_usage (
+			tenant_id TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			last_used_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (tenant_id, size)
+		)`); err != nil {
+			return fmt.Errorf("failed to create pack size usage table: %w", err)
+		}
+		return tx.backfillPackSizeUsage()
+	})
+}
+
+// backfillPackSizeUsage records the newest use of each size by the stored orders,
+// decoding every order's packs once
+func (r *Repository) backfillPackSizeUsage() error {
+	type usageKey struct {
+		tenant string
+		size   int
+	}
+	latest := make(map[usageKey]time.Time)
+
+	rows, err := r.db.Query(`SELECT tenant_id, packs_json, packs_gz, created_at FROM orders`)
+	if err != nil {
+		return fmt.Errorf("failed to query order usage: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var tenant, packsJSON string
+		var packsGz []byte
+		var createdAt time.Time
+		if err := rows.Scan(&tenant, &packsJSON, &packsGz, &createdAt); err != nil {
+			return fmt.Errorf("failed to scan order usage: %w", err)
+		}
+		packs, err := decodePacks(packsJSON, packsGz)
+		if err != nil {
+			return err
+		}
+		for size, count := range packs {
+			key := usageKey{tenant, size}
+			if used, seen := latest[key]; count > 0 && (!seen || createdAt.After(used)) {
+				latest[key] = createdAt
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read order usage: %w", err)
+	}
+	rows.Close() // Before the inserts on the same connection
+
+	for key, usedAt := range latest {
+		if _, err := r.db.Exec(packSizeUsageUpsert, key.tenant, pq.Int64Array{int64(key.size)}, usedAt); err != nil {
+			return fmt.Errorf("failed to record pack size usage: %w", err)
+		}
+	}
 	return nil
 }
 
+// packSizeUsageUpsert records that the distinct sizes $2 were used by tenant $1's
+// orders at $3, keeping the newer time for sizes used before
+const packSizeUsageUpsert = `INSERT INTO pack_size_usage (tenant_id, size, last_used_at)
+	SELECT $1, unnest($2::int[]), $3
+	ON CONFLICT (tenant_id, size) DO UPDATE SET last_used_at = GREATEST(pack_size_usage.last_used_at, EXCLUDED.last_used_at)`
+
+// usedSizes returns the sizes the orders use at least once, deduplicated for
+// packSizeUsageUpsert and sorted so concurrent saves lock the rows in the same order
+func usedSizes(orders ...*models.Order) pq.Int64Array {
+	seen := make(map[int]bool)
+	var sizes pq.Int64Array
+	for _, order := range orders {
+		for size, count := range order.Packs {
+			if count > 0 && !seen[size] {
+				seen[size] = true
+				sizes = append(sizes, int64(size))
+			}
+		}
+	}
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i] < sizes[j] })
+	return sizes
+}
+
+// legacyTimestampColumns are the columns earlier versions created as TIMESTAMP, which
+// holds wall times without a zone. Both were written with time.Now(), in the API
+// server's local zone. Columns added since are created as TIMESTAMPTZ, and nothing
+// writes UTC into a legacy column, so no row in one is UTC.
+var legacyTimestampColumns = []struct{ table, column string }{
+	{"pack_sizes", "created_at"},
+	{"orders", "created_at"},
+}
+
+// LegacyTimestampColumns returns the "table.column" names still stored as TIMESTAMP,
+// which MigrateTimestamps converts
+func (r *Repository) LegacyTimestampColumns() ([]string, error) {
+	var pending []string
+	for _, c := range legacyTimestampColumns {
+		legacy, err := r.isLegacyTimestamp(c.table, c.column)
+		if err != nil {
+			return nil, err
+		}
+		if legacy {
+			pending = append(pending, c.table+"."+c.column)
+		}
+	}
+	return pending, nil
+}
+
+// isLegacyTimestamp reports whether a column exists with the TIMESTAMP type
+func (r *Repository) isLegacyTimestamp(table, column string) (bool, error) {
+	var legacy bool
+	err := r.db.QueryRow(`SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 AND column_name = $2
+			AND data_type = 'timestamp without time zone'
+	)`, table, column).Scan(&legacy)
+	if err != nil {
+		return false, fmt.Errorf("failed to check type of %s.%s: %w", table, column, err)
+	}
+	return legacy, nil
+}
+
+// MigrateTimestamps converts the legacy TIMESTAMP columns to TIMESTAMPTZ in one
+// transaction, reading their values AT TIME ZONE localZone, the IANA name of the zone
+// they were written in, such as "Europe/Amsterdam". Each conversion rewrites and locks its table, so this
+// is an explicit step, enabled with MIGRATE_TIMESTAMPS, rather than part of InitSchema.
+// Returns the converted columns; already converted ones are left alone.
+func (r *Repository) MigrateTimestamps(localZone string) ([]string, error) {
+	var migrated []string
+	err := r.inTx(func(tx *Repository) error {
+		for _, c := range legacyTimestampColumns {
+			legacy, err := tx.isLegacyTimestamp(c.table, c.column)
+			if err != nil {
+				return err
+			}
+			if !legacy {
+				continue
+			}
+
+			column := pq.QuoteIdentifier(c.column)
+			query := fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN %s TYPE TIMESTAMPTZ USING %s AT TIME ZONE %s`,
+				pq.QuoteIdentifier(c.table), column, column, pq.QuoteLiteral(localZone))
+			if _, err := tx.db.Exec(query); err != nil {
+				return fmt.Errorf("failed to migrate %s.%s: %w", c.table, c.column, err)
+			}
+			migrated = append(migrated, c.table+"."+c.column)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return migrated, nil
+}
+
 // PackSize operations
 
-// GetAllPackSizes retrieves all pack sizes from the database
+// GetAllPackSizes retrieves all pack sizes from the database, excluding deleted ones
 func (r *Repository) GetAllPackSizes() ([]models.PackSize, error) {
 	// Use prepared statement if available, otherwise use direct query
 	var rows *sql.Rows
 	var err error
 
-	if r.getPackSizesStmt != nil {
-		rows, err = r.getPackSizesStmt.Query()
-	} else {
-		rows, err = r.db.Query(`SELECT id, size, created_at FROM pack_sizes ORDER BY size ASC`)
+	// Inside a transaction the rows are share-locked, so a set read to check an order
+	// against cannot change before the order commits
+	switch {
+	case r.tx != nil:
+		rows, err = r.db.Query(`SELECT id, size, label, tier, stock, created_at, deleted_at FROM pack_sizes WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY size ASC FOR SHARE`, r.tenant)
+	case r.getPackSizesStmt != nil:
+		rows, err = r.getPackSizesStmt.Query(r.tenant)
+	default:
+		rows, err = r.db.Query(`SELECT id, size, label, tier, stock, created_at, deleted_at FROM pack_sizes WHERE tenant_id = $1 AND deleted_at IS NULL ORDER BY size ASC`, r.tenant)
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to query pack sizes: %w", err)
 	}
+	return scanPackSizes(rows)
+}
+
+// GetAllPackSizesIncludingDeleted retrieves every pack size row, including soft-deleted
+// ones, ordered by size with the live row (if any) before the most recently deleted
+func (r *Repository) GetAllPackSizesIncludingDeleted() ([]models.PackSize, error) {
+	rows, err := r.db.Query(
+		`SELECT id, size, label, tier, stock, created_at, deleted_at FROM pack_sizes WHERE tenant_id = $1 ORDER BY size ASC, deleted_at DESC NULLS FIRST`,
+		r.tenant,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pack sizes: %w", err)
+	}
+	return scanPackSizes(rows)
+}
+
+// scanPackSizes reads and closes rows of id, size, label, tier, stock, created_at, deleted_at
+func scanPackSizes(rows *sql.Rows) ([]models.PackSize, error) {
 	defer rows.Close()
 
 	var packSizes []models.PackSize
 	for rows.Next() {
 		var ps models.PackSize
-		if err := rows.Scan(&ps.ID, &ps.Size, &ps.CreatedAt); err != nil {
+		var stock sql.NullInt64
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&ps.ID, &ps.Size, &ps.Label, &ps.Tier, &stock, &ps.CreatedAt, &deletedAt); err != nil {
 			return nil, fmt.Errorf("failed to scan pack size: %w", err)
 		}
+		setPackSizeColumns(&ps, stock, deletedAt)
 		packSizes = append(packSizes, ps)
 	}
 
-	return packSizes, nil
+	return packSizes, rows.Err()
 }
 
-// GetPackSizesAsSlice returns pack sizes as a slice of integers
-func (r *Repository) GetPackSizesAsSlice() ([]int, error) {
+// setPackSizeColumns fills in a scanned pack size's nullable columns and normalizes its times to UTC
+func setPackSizeColumns(ps *models.PackSize, stock sql.NullInt64, deletedAt sql.NullTime) {
+	ps.CreatedAt = ps.CreatedAt.UTC()
+	if stock.Valid {
+		n := int(stock.Int64)
+		ps.Stock = &n
+	}
+	if deletedAt.Valid {
+		deleted := deletedAt.Time.UTC()
+		ps.DeletedAt = &deleted
+	}
+}
+
+// GetPackSizesWithUsage returns every pack size with the creation time of the newest
+// order that used it, read from pack_size_usage, which saving an order keeps current.
+// Usage outlives the orders it came from, so pruning or deleting them keeps it.
+func (r *Repository) GetPackSizesWithUsage() ([]models.PackSizeUsage, error) {
+	query := `SELECT p.id, p.size, p.label, p.tier, p.stock, p.created_at, p.deleted_at, u.last_used_at
+			  FROM pack_sizes p
+			  LEFT JOIN pack_size_usage u ON u.tenant_id = p.tenant_id AND u.size = p.size
+			  WHERE p.tenant_id = $1 AND p.deleted_at IS NULL
+			  ORDER BY p.size`
+
+	rows, err := r.db.Query(query, r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pack size usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []models.PackSizeUsage
+	for rows.Next() {
+		var u models.PackSizeUsage
+		var stock sql.NullInt64
+		var deletedAt, lastUsedAt sql.NullTime
+		if err := rows.Scan(&u.ID, &u.Size, &u.Label, &u.Tier, &stock, &u.CreatedAt, &deletedAt, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan pack size usage: %w", err)
+		}
+		setPackSizeColumns(&u.PackSize, stock, deletedAt)
+		if lastUsedAt.Valid {
+			usedAt := lastUsedAt.Time.UTC()
+			u.LastUsedAt = &usedAt
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
+}
+
+// GetPackSizesAsSlice returns a profile's pack sizes in ascending order. An empty
+// profile means DefaultProfile. Returns ErrProfileNotFound for an unknown profile.
+func (r *Repository) GetPackSizesAsSlice(profile string) ([]int, error) {
+	if profile != "" && profile != DefaultProfile {
+		return r.getProfilePackSizes(profile)
+	}
+
 	packSizes, err := r.GetAllPackSizes()
 	if err != nil {
 		return nil, err
@@ -157,24 +621,87 @@ func (r *Repository) GetPackSizesAsSlice() ([]int, error) {
 	return sizes, nil
 }
 
-// AddPackSize adds a new pack size to the database
-func (r *Repository) AddPackSize(size int) error {
-	var err error
+// AddPackSize adds a new pack size with no label or tier and returns the inserted row.
+// Returns ErrPackSizeExists if the size violates the unique constraint.
+func (r *Repository) AddPackSize(size int) (models.PackSize, error) {
+	return r.AddPackSizeWithDetails(models.AddPackSizeRequest{Size: size})
+}
+
+// AddPackSizeWithDetails adds a new pack size with its optional label and tier and
+// returns the inserted row. Returns ErrPackSizeExists if the size violates the unique constraint.
+func (r *Repository) AddPackSizeWithDetails(req models.AddPackSizeRequest) (models.PackSize, error) {
+	ps := models.PackSize{Size: req.Size, Label: req.Label, Tier: req.Tier}
+	var row *sql.Row
 	if r.addPackSizeStmt != nil {
-		_, err = r.addPackSizeStmt.Exec(size, time.Now())
+		row = r.stmt(r.addPackSizeStmt).QueryRow(req.Size, req.Label, req.Tier, time.Now().UTC(), r.tenant)
 	} else {
-		_, err = r.db.Exec(`INSERT INTO pack_sizes (size, created_at) VALUES ($1, $2)`, size, time.Now())
+		row = r.db.QueryRow(`INSERT INTO pack_sizes (size, label, tier, created_at, tenant_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+			req.Size, req.Label, req.Tier, time.Now().UTC(), r.tenant)
+	}
+	err := row.Scan(&ps.ID, &ps.CreatedAt)
+	if isUniqueViolation(err) {
+		return models.PackSize{}, fmt.Errorf("failed to add pack size %d: %w", req.Size, ErrPackSizeExists)
+	}
+	if err != nil {
+		return models.PackSize{}, fmt.Errorf("failed to add pack size: %w", err)
+	}
+	ps.CreatedAt = ps.CreatedAt.UTC()
+	return ps, nil
+}
+
+// AddPackSizes adds several pack sizes, with no label or tier, in a single multi-row
+// insert. Either every size is added or, if any is already configured,
+// none are and ErrPackSizeExists is returned.
+func (r *Repository) AddPackSizes(sizes []int) error {
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	var b strings.Builder
+	b.WriteString(`INSERT INTO pack_sizes (size, created_at, tenant_id) VALUES `)
+	args := []interface{}{time.Now().UTC(), r.tenant}
+	for i, size := range sizes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		args = append(args, size)
+		fmt.Fprintf(&b, "($%d, $1, $2)", len(args))
+	}
+
+	_, err := r.db.Exec(b.String(), args...)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("failed to add pack sizes: %w", ErrPackSizeExists)
 	}
 	if err != nil {
-		return fmt.Errorf("failed to add pack size: %w", err)
+		return fmt.Errorf("failed to add pack sizes: %w", err)
 	}
 	return nil
 }
 
-// DeletePackSize removes a pack size from the database
+// LockPackSizes holds off other LockPackSizes callers for the tenant until the enclosing
+// WithTx transaction ends, so a limit checked on the pack sizes it reads still holds when
+// its own additions commit. Row locks cannot do this, as they do not stop new rows being
+// inserted. It must be called inside WithTx.
+func (r *Repository) LockPackSizes() error {
+	if r.tx == nil {
+		return errors.New("LockPackSizes must be called inside WithTx")
+	}
+	if _, err := r.db.Exec(`SELECT pg_advisory_xact_lock(hashtext('pack_sizes'), hashtext($1))`, r.tenant); err != nil {
+		return fmt.Errorf("failed to lock pack sizes: %w", err)
+	}
+	return nil
+}
+
+// DeletePackSize soft-deletes a pack size: the row is kept with deleted_at set, so it
+// stops being offered but its history remains and RestorePackSize can bring it back
 func (r *Repository) DeletePackSize(size int) error {
-	query := `DELETE FROM pack_sizes WHERE size = $1`
-	result, err := r.db.Exec(query, size)
+	var result sql.Result
+	var err error
+	if r.deletePackSizeStmt != nil {
+		result, err = r.stmt(r.deletePackSizeStmt).Exec(size, r.tenant, time.Now().UTC())
+	} else {
+		result, err = r.db.Exec(`UPDATE pack_sizes SET deleted_at = $3 WHERE size = $1 AND tenant_id = $2 AND deleted_at IS NULL`, size, r.tenant, time.Now().UTC())
+	}
 	if err != nil {
 		return fmt.Errorf("failed to delete pack size: %w", err)
 	}
@@ -191,37 +718,433 @@ func (r *Repository) DeletePackSize(size int) error {
 	return nil
 }
 
+// RestorePackSize undeletes the most recently deleted row for size.
+// Returns ErrPackSizeExists if the size has since been added again and
+// ErrPackSizeNotFound if it has no deleted row.
+func (r *Repository) RestorePackSize(size int) error {
+	result, err := r.db.Exec(
+		`UPDATE pack_sizes SET deleted_at = NULL WHERE id = (
+			SELECT id FROM pack_sizes WHERE size = $1 AND tenant_id = $2 AND deleted_at IS NOT NULL
+			ORDER BY deleted_at DESC LIMIT 1
+		)`,
+		size, r.tenant,
+	)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("failed to restore pack size %d: %w", size, ErrPackSizeExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore pack size: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: no deleted pack size %d", ErrPackSizeNotFound, size)
+	}
+	return nil
+}
+
+// UpdatePackSize changes a pack size's value in place, keeping its id, details and
+// created_at. Returns ErrPackSizeExists if newSize is already configured and
+// ErrPackSizeNotFound if oldSize is not.
+func (r *Repository) UpdatePackSize(oldSize, newSize int) error {
+	result, err := r.db.Exec(`UPDATE pack_sizes SET size = $1 WHERE size = $2 AND tenant_id = $3 AND deleted_at IS NULL`, newSize, oldSize, r.tenant)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("failed to update pack size %d: %w", oldSize, ErrPackSizeExists)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update pack size: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrPackSizeNotFound, oldSize)
+	}
+	return nil
+}
+
 // PackSizeExists checks if a pack size exists
 func (r *Repository) PackSizeExists(size int) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM pack_sizes WHERE size = $1)`
+	query := `SELECT EXISTS(SELECT 1 FROM pack_sizes WHERE size = $1 AND tenant_id = $2 AND deleted_at IS NULL)`
 	var exists bool
-	err := r.db.QueryRow(query, size).Scan(&exists)
+	err := r.db.QueryRow(query, size, r.tenant).Scan(&exists)
 	return exists, err
 }
 
+// PackSizesExist reports which of sizes already exist, in a single query. Sizes that do
+// not exist are absent from the map.
+func (r *Repository) PackSizesExist(sizes []int) (map[int]bool, error) {
+	existing := make(map[int]bool)
+	if len(sizes) == 0 {
+		return existing, nil
+	}
+
+	rows, err := r.db.Query(`SELECT DISTINCT size FROM pack_sizes WHERE size = ANY($1) AND tenant_id = $2 AND deleted_at IS NULL`, packSizesArray(sizes), r.tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var size int
+		if err := rows.Scan(&size); err != nil {
+			return nil, err
+		}
+		existing[size] = true
+	}
+	return existing, rows.Err()
+}
+
+// Profile operations
+
+// CreateProfile adds a named profile with sizes for the tenant, in one transaction so
+// a failure never leaves the profile without its sizes.
+// Returns ErrProfileExists if the name is taken, including by DefaultProfile.
+func (r *Repository) CreateProfile(name string, sizes []int) error {
+	if name == DefaultProfile {
+		return fmt.Errorf("failed to create profile %q: %w", name, ErrProfileExists)
+	}
+
+	return r.inTx(func(tx *Repository) error {
+		_, err := tx.db.Exec(`INSERT INTO pack_profiles (tenant_id, name, created_at) VALUES ($1, $2, $3)`, tx.tenant, name, time.Now().UTC())
+		if isUniqueViolation(err) {
+			return fmt.Errorf("failed to create profile %q: %w", name, ErrProfileExists)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create profile: %w", err)
+		}
+		if len(sizes) == 0 {
+			return nil
+		}
+		return tx.AddProfilePackSizes(name, sizes)
+	})
+}
+
+// AddProfilePackSizes associates sizes with a profile, ignoring any it already has.
+// Sizes added to DefaultProfile become the tenant's pack sizes.
+// Returns ErrProfileNotFound for an unknown profile.
+func (r *Repository) AddProfilePackSizes(name string, sizes []int) error {
+	if name == DefaultProfile {
+		_, err := r.db.Exec(
+			`INSERT INTO pack_sizes (size, created_at, tenant_id) SELECT unnest($1::int[]), $2, $3 ON CONFLICT (tenant_id, size) WHERE deleted_at IS NULL DO NOTHING`,
+			packSizesArray(sizes), time.Now().UTC(), r.tenant,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to add pack sizes: %w", err)
+		}
+		return nil
+	}
+
+	var id int
+	err := r.db.QueryRow(`SELECT id FROM pack_profiles WHERE tenant_id = $1 AND name = $2`, r.tenant, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("%w: %q", ErrProfileNotFound, name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get profile: %w", err)
+	}
+
+	if _, err := r.db.Exec(
+		`INSERT INTO pack_profile_sizes (profile_id, size) SELECT $1, unnest($2::int[]) ON CONFLICT DO NOTHING`,
+		id, packSizesArray(sizes),
+	); err != nil {
+		return fmt.Errorf("failed to add profile pack sizes: %w", err)
+	}
+	return nil
+}
+
+// getProfilePackSizes returns a named profile's sizes in ascending order. The left
+// join yields one NULL row for an empty profile, telling it apart from a missing one.
+func (r *Repository) getProfilePackSizes(name string) ([]int, error) {
+	rows, err := r.db.Query(
+		`SELECT s.size FROM pack_profiles p LEFT JOIN pack_profile_sizes s ON s.profile_id = p.id
+		 WHERE p.tenant_id = $1 AND p.name = $2 ORDER BY s.size ASC`,
+		r.tenant, name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query profile pack sizes: %w", err)
+	}
+	defer rows.Close()
+
+	found := false
+	sizes := []int{}
+	for rows.Next() {
+		found = true
+		var size sql.NullInt64
+		if err := rows.Scan(&size); err != nil {
+			return nil, fmt.Errorf("failed to scan profile pack size: %w", err)
+		}
+		if size.Valid {
+			sizes = append(sizes, int(size.Int64))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read profile pack sizes: %w", err)
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: %q", ErrProfileNotFound, name)
+	}
+	return sizes, nil
+}
+
+// Stock operations
+
+// SetStock sets the stock level of a pack size. A nil stock stops tracking it (unlimited).
+func (r *Repository) SetStock(size int, stock *int) error {
+	var value interface{}
+	if stock != nil {
+		if *stock < 0 {
+			return fmt.Errorf("stock cannot be negative")
+		}
+		value = *stock
+	}
+
+	result, err := r.db.Exec(`UPDATE pack_sizes SET stock = $1 WHERE size = $2 AND tenant_id = $3 AND deleted_at IS NULL`, value, size, r.tenant)
+	if err != nil {
+		return fmt.Errorf("failed to set stock: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("pack size %d: %w", size, ErrPackSizeNotFound)
+	}
+	return nil
+}
+
+// ReserveStock decrements stock for every pack size in packs in one transaction.
+// Each decrement is conditional on enough stock remaining, so concurrent reservations
+// serialize on the row locks and can never oversell; if any size is short, nothing is
+// reserved and ErrInsufficientStock is returned. Untracked sizes are not decremented.
+func (r *Repository) ReserveStock(packs map[int]int) error {
+	// Lock rows in a consistent order so concurrent multi-size reservations cannot deadlock
+	sizes := make([]int, 0, len(packs))
+	for size, count := range packs {
+		if count <= 0 {
+			return fmt.Errorf("reservation count for pack size %d must be positive", size)
+		}
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	tx, err := r.begin(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, size := range sizes {
+		result, err := tx.Exec(
+			`UPDATE pack_sizes SET stock = stock - $1 WHERE size = $2 AND tenant_id = $3 AND deleted_at IS NULL AND (stock IS NULL OR stock >= $1)`,
+			packs[size], size, r.tenant,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to reserve stock: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rows == 0 {
+			var exists bool
+			if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM pack_sizes WHERE size = $1 AND tenant_id = $2 AND deleted_at IS NULL)`, size, r.tenant).Scan(&exists); err != nil {
+				return fmt.Errorf("failed to reserve stock: %w", err)
+			}
+			if !exists {
+				return fmt.Errorf("pack size %d: %w", size, ErrPackSizeNotFound)
+			}
+			return fmt.Errorf("pack size %d: %w", size, ErrInsufficientStock)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit reservation: %w", err)
+	}
+	return nil
+}
+
 // Order operations
 
 // SaveOrder saves an order calculation to the database
 func (r *Repository) SaveOrder(order *models.Order) error {
-	// Convert packs map to JSON
-	packsJSON, err := json.Marshal(order.Packs)
+	// Convert packs map to its stored JSON form
+	packsJSON, packsGz, err := encodePacks(order.Packs, r.compressPacks)
 	if err != nil {
-		return fmt.Errorf("failed to marshal packs: %w", err)
+		return err
 	}
 
-	query := `INSERT INTO orders (amount, total_items, total_packs, packs_json, created_at) 
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
+	query := `INSERT INTO orders (amount, total_items, total_packs, packs_json, packs_gz, checksum, created_at, updated_at, tenant_id, pack_sizes) 
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $7, $8, $9) RETURNING id`
 
-	err = r.db.QueryRow(query,
-		order.Amount,
-		order.TotalItems,
-		order.TotalPacks,
-		string(packsJSON),
-		time.Now(),
-	).Scan(&order.ID)
+	order.Checksum = orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs)
+	now := time.Now().UTC() // Stored as UTC; responses convert on request
+	var id int
+	err = r.inTx(func(tx *Repository) error {
+		err := tx.db.QueryRow(query,
+			order.Amount,
+			order.TotalItems,
+			order.TotalPacks,
+			packsJSON,
+			packsGz,
+			order.Checksum,
+			now,
+			tx.tenant,
+			packSizesArray(order.PackSizes),
+		).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to save order: %w", err)
+		}
+		if _, err := tx.db.Exec(packSizeUsageUpsert, tx.tenant, usedSizes(order), now); err != nil {
+			return fmt.Errorf("failed to record pack size usage: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	order.ID = id
+	order.CreatedAt, order.UpdatedAt = now, now
+
+	return nil
+}
 
+// packSizesArray converts an order's pack sizes to their column value; nil stays NULL
+func packSizesArray(sizes []int) pq.Int64Array {
+	if sizes == nil {
+		return nil
+	}
+	values := make(pq.Int64Array, len(sizes))
+	for i, size := range sizes {
+		values[i] = int64(size)
+	}
+	return values
+}
+
+// SaveOrders saves a batch of orders in a single transaction using multi-row inserts.
+// Either every order is persisted or none are; each order's ID is populated from RETURNING.
+func (r *Repository) SaveOrders(orders []*models.Order) error {
+	return r.SaveOrdersContext(context.Background(), orders)
+}
+
+// SaveOrdersContext is SaveOrders bound to ctx. If ctx is cancelled before the commit,
+// for example by a client disconnect, the whole batch is rolled back and ctx's error returned.
+// On any error the orders' IDs and timestamps are cleared, so none looks saved. Inside
+// WithTx the caller owns the commit and must do the same if the transaction rolls back.
+func (r *Repository) SaveOrdersContext(ctx context.Context, orders []*models.Order) (err error) {
+	if len(orders) == 0 {
+		return nil
+	}
+	defer func() {
+		if err != nil {
+			for _, order := range orders {
+				order.ID = 0
+				order.CreatedAt, order.UpdatedAt = time.Time{}, time.Time{}
+			}
+		}
+	}()
+
+	tx, err := r.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for start := 0; start < len(orders); start += saveOrdersChunkSize {
+		// Checked between chunks as well as by the driver, so a cancellation never commits
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + saveOrdersChunkSize
+		if end > len(orders) {
+			end = len(orders)
+		}
+		if err := r.insertOrderChunk(ctx, tx.Tx, orders[start:end], now); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit orders: %w", err)
+	}
+
+	return nil
+}
+
+// saveOrdersChunkSize keeps each multi-row insert well below the Postgres parameter limit (65535)
+const saveOrdersChunkSize = 1000
+
+// insertOrderChunk writes one multi-row INSERT and assigns each order its ID. Postgres
+// does not promise RETURNING rows in VALUES order, so IDs are drawn from the sequence
+// beside a client-side ordinal and the ordinal says which order each belongs to.
+func (r *Repository) insertOrderChunk(ctx context.Context, tx *sql.Tx, chunk []*models.Order, createdAt time.Time) error {
+	var b strings.Builder
+	b.WriteString(`WITH chunk (ord, amount, total_items, total_packs, packs_json, packs_gz, checksum, pack_sizes) AS (VALUES `)
+
+	args := make([]interface{}, 0, 2+len(chunk)*7)
+	args = append(args, createdAt, r.tenant)
+	for i, order := range chunk {
+		packsJSON, packsGz, err := encodePacks(order.Packs, r.compressPacks)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		order.Checksum = orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs)
+		n := len(args)
+		fmt.Fprintf(&b, "(%d, $%d::integer, $%d::integer, $%d::integer, $%d::text, $%d::bytea, $%d::text, $%d::integer[])", i, n+1, n+2, n+3, n+4, n+5, n+6, n+7)
+		args = append(args, order.Amount, order.TotalItems, order.TotalPacks, packsJSON, packsGz, order.Checksum, packSizesArray(order.PackSizes))
+	}
+	b.WriteString(`),
+		numbered AS (SELECT nextval(pg_get_serial_sequence('orders', 'id')) AS id, * FROM chunk),
+		inserted AS (
+			INSERT INTO orders (id, amount, total_items, total_packs, packs_json, packs_gz, checksum, created_at, updated_at, tenant_id, pack_sizes)
+			SELECT id, amount, total_items, total_packs, packs_json, packs_gz, checksum, $1::timestamptz, $1::timestamptz, $2::text, pack_sizes FROM numbered
+		)
+		SELECT ord, id FROM numbered`)
+
+	rows, err := tx.QueryContext(ctx, b.String(), args...)
 	if err != nil {
-		return fmt.Errorf("failed to save order: %w", err)
+		return fmt.Errorf("failed to save orders: %w", err)
+	}
+	defer rows.Close()
+
+	assigned := make([]bool, len(chunk))
+	count := 0
+	for rows.Next() {
+		var ord, id int
+		if err := rows.Scan(&ord, &id); err != nil {
+			return fmt.Errorf("failed to scan order id: %w", err)
+		}
+		if ord < 0 || ord >= len(chunk) || assigned[ord] {
+			return fmt.Errorf("failed to save orders: unexpected returned ordinal %d", ord)
+		}
+		chunk[ord].ID = id
+		chunk[ord].CreatedAt, chunk[ord].UpdatedAt = createdAt, createdAt
+		assigned[ord] = true
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to save orders: %w", err)
+	}
+	if count != len(chunk) {
+		return fmt.Errorf("failed to save orders: expected %d ids, got %d", len(chunk), count)
+	}
+	rows.Close() // Before the usage upsert on the same transaction
+
+	if _, err := tx.ExecContext(ctx, packSizeUsageUpsert, r.tenant, usedSizes(chunk...), createdAt); err != nil {
+		return fmt.Errorf("failed to record pack size usage: %w", err)
 	}
 
 	return nil
@@ -229,10 +1152,53 @@ func (r *Repository) SaveOrder(order *models.Order) error {
 
 // GetAllOrders retrieves all orders from the database
 func (r *Repository) GetAllOrders(limit int) ([]models.Order, error) {
-	query := `SELECT id, amount, total_items, total_packs, packs_json, created_at 
-			  FROM orders ORDER BY created_at DESC LIMIT $1`
+	return r.QueryOrders(OrderFilter{Limit: limit})
+}
+
+// OrderFilter narrows an order query. Nil amount bounds are not applied.
+type OrderFilter struct {
+	Amount    *int         // Exact amount
+	MinAmount *int         // Inclusive lower bound
+	MaxAmount *int         // Inclusive upper bound
+	After     *OrderCursor // Only orders on later pages than this position
+	Limit     int
+}
+
+// QueryOrders retrieves the newest orders matching the filter, newest first with ties
+// broken by descending id. Conditions are only ever added as placeholders, never
+// interpolated values.
+func (r *Repository) QueryOrders(filter OrderFilter) ([]models.Order, error) {
+	var conditions []string
+	var args []interface{}
+	addCondition := func(clause string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(clause, len(args)))
+	}
+
+	addCondition("tenant_id = $%d", r.tenant)
+	if filter.Amount != nil {
+		addCondition("amount = $%d", *filter.Amount)
+	}
+	if filter.MinAmount != nil {
+		addCondition("amount >= $%d", *filter.MinAmount)
+	}
+	if filter.MaxAmount != nil {
+		addCondition("amount <= $%d", *filter.MaxAmount)
+	}
+	if filter.After != nil {
+		// The plain bound on created_at lets the planner seek the (tenant_id, created_at
+		// DESC) index; the second condition only resolves ties within one timestamp
+		addCondition("created_at <= $%d", filter.After.CreatedAt.UTC())
+		args = append(args, filter.After.ID)
+		conditions = append(conditions, fmt.Sprintf("(created_at < $%d OR id < $%d)", len(args)-1, len(args)))
+	}
 
-	rows, err := r.db.Query(query, limit)
+	query := `SELECT id, amount, total_items, total_packs, packs_json, packs_gz, checksum, created_at, updated_at, pack_sizes FROM orders`
+	query += " WHERE " + strings.Join(conditions, " AND ")
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query orders: %w", err)
 	}
@@ -241,33 +1207,315 @@ func (r *Repository) GetAllOrders(limit int) ([]models.Order, error) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
+		var updatedAt sql.NullTime
+		var packSizes pq.Int64Array
+		var packsGz []byte
 		if err := rows.Scan(
 			&order.ID,
 			&order.Amount,
 			&order.TotalItems,
 			&order.TotalPacks,
 			&order.PacksJSON,
+			&packsGz,
+			&order.Checksum,
 			&order.CreatedAt,
+			&updatedAt,
+			&packSizes,
 		); err != nil {
 			return nil, fmt.Errorf("failed to scan order: %w", err)
 		}
+		// The driver returns the session's zone; orders are always reported in UTC
+		order.CreatedAt = order.CreatedAt.UTC()
+		order.UpdatedAt = order.CreatedAt
+		if updatedAt.Valid {
+			order.UpdatedAt = updatedAt.Time.UTC()
+		}
+		for _, size := range packSizes {
+			order.PackSizes = append(order.PackSizes, int(size))
+		}
 
-		// Parse the JSON packs
-		if err := json.Unmarshal([]byte(order.PacksJSON), &order.Packs); err != nil {
-			return nil, fmt.Errorf("failed to unmarshal packs: %w", err)
+		// Parse the JSON packs, decompressing if needed
+		packs, err := decodePacks(order.PacksJSON, packsGz)
+		if err != nil {
+			return nil, err
+		}
+		order.Packs = packs
+
+		// Flag rows whose stored fields no longer match their checksum.
+		// Rows saved before checksums existed have none and are not checked.
+		if order.Checksum != "" && order.Checksum != orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs) {
+			order.Corrupted = true
 		}
 
 		orders = append(orders, order)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query orders: %w", err)
+	}
 
 	return orders, nil
 }
 
-// SeedDefaultPackSizes adds default pack sizes if the table is empty
+// Stats snapshot operations
+
+// SaveStatsSnapshot stores a stats snapshot, filling in its ID and UTC creation time
+func (r *Repository) SaveStatsSnapshot(snapshot *models.StatsSnapshot) error {
+	snapshot.CreatedAt = time.Now().UTC()
+	err := r.db.QueryRow(
+		`INSERT INTO stats_snapshots (cache_hits, cache_misses, cache_hit_ratio, cache_size, calculations, calculation_errors, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		snapshot.CacheHits,
+		snapshot.CacheMisses,
+		snapshot.CacheHitRatio,
+		snapshot.CacheSize,
+		snapshot.Calculations,
+		snapshot.CalculationErrors,
+		snapshot.CreatedAt,
+	).Scan(&snapshot.ID)
+	if err != nil {
+		return fmt.Errorf("failed to save stats snapshot: %w", err)
+	}
+	return nil
+}
+
+// GetStatsSnapshots returns the newest limit snapshots taken at or after since, oldest
+// first. The limit drops the oldest snapshots, so a long window still ends at the latest.
+func (r *Repository) GetStatsSnapshots(since time.Time, limit int) ([]models.StatsSnapshot, error) {
+	rows, err := r.db.Query(
+		`SELECT id, cache_hits, cache_misses, cache_hit_ratio, cache_size, calculations, calculation_errors, created_at
+		 FROM stats_snapshots WHERE created_at >= $1 ORDER BY created_at DESC, id DESC LIMIT $2`,
+		since.UTC(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stats snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	snapshots := []models.StatsSnapshot{}
+	for rows.Next() {
+		var snapshot models.StatsSnapshot
+		if err := rows.Scan(
+			&snapshot.ID,
+			&snapshot.CacheHits,
+			&snapshot.CacheMisses,
+			&snapshot.CacheHitRatio,
+			&snapshot.CacheSize,
+			&snapshot.Calculations,
+			&snapshot.CalculationErrors,
+			&snapshot.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan stats snapshot: %w", err)
+		}
+		snapshot.CreatedAt = snapshot.CreatedAt.UTC()
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Read newest first for the limit; returned oldest first
+	for i, j := 0, len(snapshots)-1; i < j; i, j = i+1, j-1 {
+		snapshots[i], snapshots[j] = snapshots[j], snapshots[i]
+	}
+	return snapshots, nil
+}
+
+// PruneStatsSnapshots deletes snapshots taken before the cutoff and returns how many were removed
+func (r *Repository) PruneStatsSnapshots(before time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM stats_snapshots WHERE created_at < $1`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune stats snapshots: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+// PruneOrders deletes orders created before the cutoff, across all tenants, and returns
+// how many were removed. Rows go in batches of batchSize, each its own statement, so
+// locks are held briefly and concurrent inserts (always newer than the cutoff) proceed.
+func (r *Repository) PruneOrders(before time.Time, batchSize int) (int64, error) {
+	if batchSize <= 0 {
+		batchSize = saveOrdersChunkSize
+	}
+
+	var pruned int64
+	for {
+		result, err := r.db.Exec(
+			`DELETE FROM orders WHERE id IN (SELECT id FROM orders WHERE created_at < $1 ORDER BY id LIMIT $2)`,
+			before.UTC(), batchSize,
+		)
+		if err != nil {
+			return pruned, fmt.Errorf("failed to prune orders: %w", err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return pruned, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		pruned += rows
+		if rows < int64(batchSize) {
+			return pruned, nil
+		}
+	}
+}
+
+// DeleteOrder deletes one of the tenant's orders, returning ErrOrderNotFound if there is
+// no order with that id. Uses the prepared statement once PrepareStatements has run.
+func (r *Repository) DeleteOrder(id int) error {
+	var result sql.Result
+	var err error
+	if r.deleteOrderStmt != nil {
+		result, err = r.stmt(r.deleteOrderStmt).Exec(id, r.tenant)
+	} else {
+		result, err = r.db.Exec(`DELETE FROM orders WHERE id = $1 AND tenant_id = $2`, id, r.tenant)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete order: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %d", ErrOrderNotFound, id)
+	}
+	return nil
+}
+
+// RecomputeResult summarizes a RecomputeOrderTotals run
+type RecomputeResult struct {
+	Scanned   int `json:"scanned"`
+	Corrected int `json:"corrected"`
+	Skipped   int `json:"skipped"` // Rows whose packs could not be decoded
+
+	// Rows failing checksum verification, left untouched for review; at most
+	// maxReportedCorruptedIDs of their IDs are listed
+	Corrupted    int   `json:"corrupted"`
+	CorruptedIDs []int `json:"corrupted_ids,omitempty"`
+}
+
+// maxReportedCorruptedIDs bounds the IDs a RecomputeResult lists
+const maxReportedCorruptedIDs = 100
+
+// reportCorrupted records an order that failed checksum verification
+func (res *RecomputeResult) reportCorrupted(id int) {
+	res.Corrupted++
+	if len(res.CorruptedIDs) < maxReportedCorruptedIDs {
+		res.CorruptedIDs = append(res.CorruptedIDs, id)
+	}
+}
+
+// RecomputeOrderTotals recomputes total_items and total_packs from each order's stored
+// packs and rewrites rows that disagree, along with their checksum. Only rows whose
+// checksum verifies are re-signed: rows that fail it were changed outside the
+// application and are reported as corrupted instead, and rows saved before checksums
+// existed are corrected but stay unsigned. It covers every
+// tenant's orders, as totals follow from the packs alone. Orders are walked by
+// ID in batches of batchSize, each locked and updated in its own transaction, so a large
+// table is never held in one transaction and progress survives a failed batch.
+func (r *Repository) RecomputeOrderTotals(batchSize int) (RecomputeResult, error) {
+	var result RecomputeResult
+	if batchSize <= 0 {
+		batchSize = saveOrdersChunkSize
+	}
+
+	lastID := 0
+	for {
+		scanned, err := r.recomputeBatch(&lastID, batchSize, &result)
+		if err != nil {
+			return result, err
+		}
+		if scanned < batchSize {
+			return result, nil
+		}
+	}
+}
+
+// recomputeBatch corrects the next batch of orders after *lastID, advancing it
+func (r *Repository) recomputeBatch(lastID *int, batchSize int, result *RecomputeResult) (int, error) {
+	tx, err := r.begin(context.Background())
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, amount, total_items, total_packs, packs_json, packs_gz, checksum FROM orders WHERE id > $1 ORDER BY id LIMIT $2 FOR UPDATE`,
+		*lastID, batchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query orders: %w", err)
+	}
+
+	var stale []models.Order
+	scanned := 0
+	for rows.Next() {
+		var order models.Order
+		var packsGz []byte
+		if err := rows.Scan(&order.ID, &order.Amount, &order.TotalItems, &order.TotalPacks, &order.PacksJSON, &packsGz, &order.Checksum); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan order: %w", err)
+		}
+		scanned++
+		*lastID = order.ID
+
+		packs, err := decodePacks(order.PacksJSON, packsGz)
+		if err != nil {
+			result.Skipped++
+			continue
+		}
+		// Re-signing a row that fails its checksum would vouch for whatever changed it
+		if order.Checksum != "" && order.Checksum != orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, packs) {
+			result.reportCorrupted(order.ID)
+			continue
+		}
+		totalItems, totalPacks := 0, 0
+		for size, count := range packs {
+			totalItems += size * count
+			totalPacks += count
+		}
+		if totalItems != order.TotalItems || totalPacks != order.TotalPacks {
+			order.Packs = packs
+			order.TotalItems = totalItems
+			order.TotalPacks = totalPacks
+			stale = append(stale, order)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read orders: %w", err)
+	}
+
+	now := time.Now().UTC()
+	for _, order := range stale {
+		checksum := order.Checksum // Unsigned rows stay unsigned
+		if checksum != "" {
+			checksum = orderChecksum(order.Amount, order.TotalItems, order.TotalPacks, order.Packs)
+		}
+		if _, err := tx.Exec(
+			`UPDATE orders SET total_items = $1, total_packs = $2, checksum = $3, updated_at = $4 WHERE id = $5`,
+			order.TotalItems, order.TotalPacks, checksum, now, order.ID,
+		); err != nil {
+			return 0, fmt.Errorf("failed to update order %d: %w", order.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit recomputed orders: %w", err)
+	}
+	result.Scanned += scanned
+	result.Corrected += len(stale)
+	return scanned, nil
+}
+
+// DefaultPackSizes are the pack sizes from the problem statement
+var DefaultPackSizes = []int{250, 500, 1000, 2000, 5000}
+
+// SeedDefaultPackSizes adds default pack sizes if the tenant has none that are not
+// deleted, as it did before deletes were soft
 func (r *Repository) SeedDefaultPackSizes() error {
 	// Check if pack sizes already exist
 	var count int
-	err := r.db.QueryRow(`SELECT COUNT(*) FROM pack_sizes`).Scan(&count)
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM pack_sizes WHERE tenant_id = $1 AND deleted_at IS NULL`, r.tenant).Scan(&count)
 	if err != nil {
 		return fmt.Errorf("failed to count pack sizes: %w", err)
 	}
@@ -277,14 +1525,41 @@ func (r *Repository) SeedDefaultPackSizes() error {
 		return nil
 	}
 
-	// Default pack sizes from the problem statement
-	defaultSizes := []int{250, 500, 1000, 2000, 5000}
-
-	for _, size := range defaultSizes {
-		if err := r.AddPackSize(size); err != nil {
+	for _, size := range DefaultPackSizes {
+		if _, err := r.AddPackSize(size); err != nil {
 			return fmt.Errorf("failed to seed pack size %d: %w", size, err)
 		}
 	}
 
 	return nil
 }
+
+// ReconcileDefaultPackSizes ensures every default pack size is present, adding any
+// that are missing and leaving operator-added sizes untouched. Defaults the operator
+// deleted stay deleted; RestorePackSize brings them back. Returns the sizes added.
+func (r *Repository) ReconcileDefaultPackSizes() ([]int, error) {
+	var added []int
+	for _, size := range DefaultPackSizes {
+		result, err := r.db.Exec(
+			`INSERT INTO pack_sizes (size, created_at, tenant_id)
+			SELECT $1, $2, $3 WHERE NOT EXISTS (
+				SELECT 1 FROM pack_sizes WHERE tenant_id = $3 AND size = $1 AND deleted_at IS NOT NULL
+			)
+			ON CONFLICT (tenant_id, size) WHERE deleted_at IS NULL DO NOTHING`,
+			size, time.Now().UTC(), r.tenant,
+		)
+		if err != nil {
+			return added, fmt.Errorf("failed to reconcile pack size %d: %w", size, err)
+		}
+
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return added, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+		if rows > 0 {
+			added = append(added, size)
+		}
+	}
+
+	return added, nil
+}
@@ -0,0 +1,1299 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"pack-calculator/internal/models"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// newTestRepository connects to the database in TEST_DATABASE_URL and returns a
+// repository with a freshly initialized, empty schema. Tests are skipped when unset.
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set; skipping database test")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to ping database: %v", err)
+	}
+
+	repo := NewRepository(db)
+	if err := repo.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() error = %v", err)
+	}
+	if _, err := db.Exec(`TRUNCATE pack_sizes, orders, stats_snapshots, pack_profiles, pack_profile_sizes, pack_size_usage RESTART IDENTITY`); err != nil {
+		t.Fatalf("Failed to truncate tables: %v", err)
+	}
+
+	return repo
+}
+
+func countOrders(t *testing.T, repo *Repository) int {
+	t.Helper()
+	var count int
+	if err := repo.db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count orders: %v", err)
+	}
+	return count
+}
+
+func TestSaveOrders_InsertsAllWithIDs(t *testing.T) {
+	repo := newTestRepository(t)
+
+	orders := []*models.Order{
+		{Amount: 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}},
+		{Amount: 251, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}},
+		{Amount: 501, TotalItems: 750, TotalPacks: 2, Packs: map[int]int{500: 1, 250: 1}},
+	}
+
+	if err := repo.SaveOrders(orders); err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+
+	seen := make(map[int]bool)
+	for _, order := range orders {
+		if order.ID == 0 {
+			t.Errorf("Order for amount %d has no ID", order.Amount)
+		}
+		if seen[order.ID] {
+			t.Errorf("Duplicate ID %d", order.ID)
+		}
+		seen[order.ID] = true
+
+		// Each ID is the row holding that order
+		var amount int
+		if err := repo.db.QueryRow(`SELECT amount FROM orders WHERE id = $1`, order.ID).Scan(&amount); err != nil || amount != order.Amount {
+			t.Errorf("Order %d has amount %d (%v), want %d", order.ID, amount, err, order.Amount)
+		}
+	}
+
+	if got := countOrders(t, repo); got != len(orders) {
+		t.Errorf("Order count = %d, want %d", got, len(orders))
+	}
+}
+
+func TestSaveOrders_RollsBackOnFailure(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Span more than one chunk so the failure happens after rows were already inserted
+	orders := make([]*models.Order, saveOrdersChunkSize+10)
+	for i := range orders {
+		orders[i] = &models.Order{Amount: i + 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}
+	}
+	// Rejected by a constraint, so the second chunk fails
+	if _, err := repo.db.Exec(`ALTER TABLE orders ADD CONSTRAINT test_rejected_amount CHECK (amount <> -1)`); err != nil {
+		t.Fatalf("Failed to add constraint: %v", err)
+	}
+	t.Cleanup(func() { repo.db.Exec(`ALTER TABLE orders DROP CONSTRAINT IF EXISTS test_rejected_amount`) })
+	orders[len(orders)-1].Amount = -1
+
+	if err := repo.SaveOrders(orders); err == nil {
+		t.Fatal("SaveOrders() expected error, got nil")
+	}
+
+	if got := countOrders(t, repo); got != 0 {
+		t.Errorf("Order count after failed batch = %d, want 0", got)
+	}
+	// The first chunk's IDs were rolled back with it
+	for _, order := range orders {
+		if order.ID != 0 || !order.CreatedAt.IsZero() {
+			t.Fatalf("Order for amount %d kept ID %d after rollback", order.Amount, order.ID)
+		}
+	}
+}
+
+// cancelAfterCtx reports cancellation once remaining Err calls have passed. Its Done
+// channel is never closed, so the driver never interrupts a query and the point of
+// cancellation is decided by SaveOrdersContext's own checks.
+type cancelAfterCtx struct {
+	context.Context
+	mu        sync.Mutex
+	remaining int
+}
+
+func (c *cancelAfterCtx) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.remaining <= 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+func TestSaveOrdersContext_CancelledMidBatchPersistsNothing(t *testing.T) {
+	repo := newTestRepository(t)
+
+	orders := make([]*models.Order, 2*saveOrdersChunkSize+10)
+	for i := range orders {
+		orders[i] = &models.Order{Amount: i + 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}
+	}
+
+	// Cancel after the first chunk has been inserted into the transaction
+	ctx := &cancelAfterCtx{Context: context.Background(), remaining: 1}
+	if err := repo.SaveOrdersContext(ctx, orders); !errors.Is(err, context.Canceled) {
+		t.Fatalf("SaveOrdersContext() error = %v, want context.Canceled", err)
+	}
+	if got := countOrders(t, repo); got != 0 {
+		t.Errorf("Order count after cancelled batch = %d, want 0", got)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := repo.SaveOrdersContext(cancelled, orders[:3]); err == nil {
+		t.Fatal("SaveOrdersContext() with a cancelled context expected error, got nil")
+	}
+	if got := countOrders(t, repo); got != 0 {
+		t.Errorf("Order count after pre-cancelled batch = %d, want 0", got)
+	}
+}
+
+func TestForTenant_IsolatesPackSizesAndOrders(t *testing.T) {
+	repo := newTestRepository(t)
+	acme, globex := repo.ForTenant("acme"), repo.ForTenant("globex")
+
+	// The same size may exist once per tenant
+	for _, store := range []Store{repo, acme, globex} {
+		if _, err := store.AddPackSize(250); err != nil {
+			t.Fatalf("AddPackSize(250) error = %v", err)
+		}
+	}
+	if _, err := acme.AddPackSize(250); !errors.Is(err, ErrPackSizeExists) {
+		t.Errorf("Duplicate AddPackSize(250) for acme error = %v, want ErrPackSizeExists", err)
+	}
+	if _, err := acme.AddPackSize(500); err != nil {
+		t.Fatalf("AddPackSize(500) error = %v", err)
+	}
+	if err := globex.DeletePackSize(500); err == nil {
+		t.Error("globex deleted acme's pack size 500")
+	}
+
+	sizes := map[string][]int{}
+	for name, store := range map[string]Store{"default": repo, "acme": acme, "globex": globex} {
+		got, err := store.GetPackSizesAsSlice(DefaultProfile)
+		if err != nil {
+			t.Fatalf("%s: GetPackSizesAsSlice() error = %v", name, err)
+		}
+		sizes[name] = got
+	}
+	want := map[string][]int{"default": {250}, "acme": {250, 500}, "globex": {250}}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("Pack sizes = %v, want %v", sizes, want)
+	}
+
+	if err := acme.SaveOrder(&models.Order{Amount: 251, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}}); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+	if err := globex.SaveOrdersContext(context.Background(), []*models.Order{
+		{Amount: 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}},
+		{Amount: 2, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}},
+	}); err != nil {
+		t.Fatalf("SaveOrdersContext() error = %v", err)
+	}
+	for name, tt := range map[string]struct {
+		store Store
+		want  int
+	}{"default": {repo, 0}, "acme": {acme, 1}, "globex": {globex, 2}} {
+		orders, err := tt.store.GetAllOrders(10)
+		if err != nil {
+			t.Fatalf("%s: GetAllOrders() error = %v", name, err)
+		}
+		if len(orders) != tt.want {
+			t.Errorf("%s: %d orders, want %d", name, len(orders), tt.want)
+		}
+	}
+}
+
+func TestProfiles_NamedSetsPerTenant(t *testing.T) {
+	repo := newTestRepository(t)
+	acme := repo.ForTenant("acme")
+	if _, err := repo.AddPackSize(250); err != nil {
+		t.Fatalf("AddPackSize(250) error = %v", err)
+	}
+
+	if err := repo.CreateProfile(DefaultProfile, nil); !errors.Is(err, ErrProfileExists) {
+		t.Errorf("CreateProfile(default) error = %v, want ErrProfileExists", err)
+	}
+	if err := repo.CreateProfile("retail", nil); err != nil {
+		t.Fatalf("CreateProfile(retail) error = %v", err)
+	}
+	if err := repo.CreateProfile("retail", []int{6}); !errors.Is(err, ErrProfileExists) {
+		t.Errorf("Duplicate CreateProfile(retail) error = %v, want ErrProfileExists", err)
+	}
+
+	// An empty profile exists but has no sizes
+	if sizes, err := repo.GetPackSizesAsSlice("retail"); err != nil || len(sizes) != 0 {
+		t.Errorf("Empty profile sizes = %v, %v; want [] and no error", sizes, err)
+	}
+	if err := repo.AddProfilePackSizes("retail", []int{12, 6, 24}); err != nil {
+		t.Fatalf("AddProfilePackSizes() error = %v", err)
+	}
+	if err := repo.AddProfilePackSizes("retail", []int{6, 48}); err != nil {
+		t.Fatalf("AddProfilePackSizes() with an existing size error = %v", err)
+	}
+	if sizes, err := repo.GetPackSizesAsSlice("retail"); err != nil || !reflect.DeepEqual(sizes, []int{6, 12, 24, 48}) {
+		t.Errorf("Profile sizes = %v, %v; want [6 12 24 48]", sizes, err)
+	}
+
+	// The default profile is the tenant's own pack sizes
+	if err := repo.AddProfilePackSizes(DefaultProfile, []int{250, 500}); err != nil {
+		t.Fatalf("AddProfilePackSizes(default) error = %v", err)
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(""); !reflect.DeepEqual(sizes, []int{250, 500}) {
+		t.Errorf("Default profile sizes = %v, want [250 500]", sizes)
+	}
+
+	// Profiles are scoped to their tenant
+	if _, err := acme.GetPackSizesAsSlice("retail"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("acme GetPackSizesAsSlice(retail) error = %v, want ErrProfileNotFound", err)
+	}
+	if err := acme.AddProfilePackSizes("retail", []int{1}); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("acme AddProfilePackSizes(retail) error = %v, want ErrProfileNotFound", err)
+	}
+	if err := acme.CreateProfile("retail", nil); err != nil {
+		t.Errorf("acme CreateProfile(retail) error = %v, want the name free per tenant", err)
+	}
+}
+
+func TestCreateProfile_AddsSizesAtomically(t *testing.T) {
+	repo := newTestRepository(t)
+
+	if err := repo.CreateProfile("retail", []int{12, 6}); err != nil {
+		t.Fatalf("CreateProfile() error = %v", err)
+	}
+	if sizes, err := repo.GetPackSizesAsSlice("retail"); err != nil || !reflect.DeepEqual(sizes, []int{6, 12}) {
+		t.Errorf("Profile sizes = %v, %v; want [6 12]", sizes, err)
+	}
+
+	// A size the column rejects rolls back the profile row as well
+	if err := repo.CreateProfile("bulk", []int{1000, -1}); err == nil {
+		t.Fatal("CreateProfile() with an invalid size succeeded")
+	}
+	if _, err := repo.GetPackSizesAsSlice("bulk"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("GetPackSizesAsSlice(bulk) error = %v, want ErrProfileNotFound after the rollback", err)
+	}
+}
+
+func TestPruneOrders_RemovesOnlyOldOrders(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for i := 0; i < 5; i++ {
+		if err := repo.SaveOrder(&models.Order{Amount: i + 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}); err != nil {
+			t.Fatalf("SaveOrder() error = %v", err)
+		}
+	}
+	// Age the first three orders past the cutoff
+	if _, err := repo.db.Exec(`UPDATE orders SET created_at = $1 WHERE id <= 3`, time.Now().UTC().Add(-48*time.Hour)); err != nil {
+		t.Fatalf("Failed to age orders: %v", err)
+	}
+
+	// A batch size of 2 needs two batches for the three old orders
+	pruned, err := repo.PruneOrders(time.Now().Add(-24*time.Hour), 2)
+	if err != nil {
+		t.Fatalf("PruneOrders() error = %v", err)
+	}
+	if pruned != 3 {
+		t.Errorf("PruneOrders() = %d, want 3", pruned)
+	}
+
+	orders, err := repo.GetAllOrders(10)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	if len(orders) != 2 {
+		t.Fatalf("%d orders left, want 2", len(orders))
+	}
+	for _, order := range orders {
+		if order.ID <= 3 {
+			t.Errorf("Old order %d survived", order.ID)
+		}
+	}
+}
+
+func TestDeleteOrder_PreparedAndScopedToTenant(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.PrepareStatements(); err != nil {
+		t.Fatalf("PrepareStatements() error = %v", err)
+	}
+
+	order := &models.Order{Amount: 251, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}}
+	if err := repo.SaveOrder(order); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+
+	// Another tenant cannot delete it
+	if err := repo.ForTenant("acme").DeleteOrder(order.ID); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("DeleteOrder() from other tenant error = %v, want ErrOrderNotFound", err)
+	}
+	if err := repo.DeleteOrder(order.ID); err != nil {
+		t.Fatalf("DeleteOrder() error = %v", err)
+	}
+	if err := repo.DeleteOrder(order.ID); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("Second DeleteOrder() error = %v, want ErrOrderNotFound", err)
+	}
+	if n := countOrders(t, repo); n != 0 {
+		t.Errorf("%d orders left, want 0", n)
+	}
+}
+
+func TestQueryOrders_CursorPagesWithoutGapsOrDuplicates(t *testing.T) {
+	repo := newTestRepository(t)
+	for i := 0; i < 12; i++ {
+		if err := repo.SaveOrder(&models.Order{Amount: i + 1, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}); err != nil {
+			t.Fatalf("SaveOrder() error = %v", err)
+		}
+	}
+	// Half the orders share a timestamp, so pages must break ties by id
+	if _, err := repo.db.Exec(`UPDATE orders SET created_at = $1 WHERE id <= 6`, time.Now().UTC().Add(-time.Hour)); err != nil {
+		t.Fatalf("Failed to age orders: %v", err)
+	}
+
+	seen := make(map[int]bool)
+	var after *OrderCursor
+	for page := 0; ; page++ {
+		orders, err := repo.QueryOrders(OrderFilter{Limit: 5, After: after})
+		if err != nil {
+			t.Fatalf("QueryOrders() error = %v", err)
+		}
+		for _, order := range orders {
+			if seen[order.ID] {
+				t.Errorf("Order %d returned twice", order.ID)
+			}
+			seen[order.ID] = true
+		}
+		if len(orders) < 5 || page > 12 {
+			break
+		}
+		cursor := CursorAfter(orders[len(orders)-1])
+		after = &cursor
+	}
+	if len(seen) != 12 {
+		t.Errorf("Paged %d distinct orders, want 12", len(seen))
+	}
+}
+
+func TestOrderCursor_RoundTrip(t *testing.T) {
+	cursor := OrderCursor{CreatedAt: time.Date(2024, 3, 1, 12, 0, 0, 123456000, time.UTC), ID: 42}
+	parsed, err := ParseOrderCursor(cursor.Encode())
+	if err != nil || parsed.ID != 42 || !parsed.CreatedAt.Equal(cursor.CreatedAt) {
+		t.Errorf("ParseOrderCursor(Encode()) = %+v, %v; want %+v", parsed, err, cursor)
+	}
+
+	for _, token := range []string{"", "!!!", "bm90LWEtY3Vyc29y", "MTIzOmFiYw"} {
+		if _, err := ParseOrderCursor(token); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ParseOrderCursor(%q) error = %v, want ErrInvalidCursor", token, err)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"unique violation", &pq.Error{Code: "23505"}, true},
+		{"wrapped unique violation", fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}), true},
+		{"other pq error", &pq.Error{Code: "23502"}, false},
+		{"plain error", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isUniqueViolation(tt.err); got != tt.want {
+				t.Errorf("isUniqueViolation() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddPackSize_DuplicateReturnsErrPackSizeExists(t *testing.T) {
+	repo := newTestRepository(t)
+
+	if _, err := repo.AddPackSize(250); err != nil {
+		t.Fatalf("AddPackSize() error = %v", err)
+	}
+	if _, err := repo.AddPackSize(250); !errors.Is(err, ErrPackSizeExists) {
+		t.Errorf("AddPackSize() duplicate error = %v, want ErrPackSizeExists", err)
+	}
+}
+
+func TestAddPackSize_ReturnsInsertedRow(t *testing.T) {
+	repo := newTestRepository(t)
+
+	check := func(name string, store Store) {
+		t.Helper()
+		ps, err := store.AddPackSizeWithDetails(models.AddPackSizeRequest{Size: 250, Label: "Small"})
+		if err != nil {
+			t.Fatalf("%s: AddPackSizeWithDetails() error = %v", name, err)
+		}
+		if ps.ID == 0 || ps.Size != 250 || ps.Label != "Small" || ps.CreatedAt.Location() != time.UTC {
+			t.Errorf("%s: returned %+v, want the inserted row in UTC", name, ps)
+		}
+
+		all, err := store.GetAllPackSizes()
+		if err != nil || len(all) != 1 || all[0].ID != ps.ID || !all[0].CreatedAt.Equal(ps.CreatedAt) {
+			t.Errorf("%s: stored %+v (err %v), want the returned row %+v", name, all, err, ps)
+		}
+	}
+
+	check("ad hoc", repo)
+	if err := repo.PrepareStatements(); err != nil {
+		t.Fatalf("PrepareStatements() error = %v", err)
+	}
+	check("prepared", repo.ForTenant("acme"))
+}
+
+func TestAddPackSizes_AllOrNothing(t *testing.T) {
+	repo := newTestRepository(t)
+
+	if err := repo.AddPackSizes([]int{250, 500, 1000}); err != nil {
+		t.Fatalf("AddPackSizes() error = %v", err)
+	}
+	// 500 is already configured, so 2000 must not be added either
+	if err := repo.AddPackSizes([]int{2000, 500}); !errors.Is(err, ErrPackSizeExists) {
+		t.Errorf("AddPackSizes() with a duplicate error = %v, want ErrPackSizeExists", err)
+	}
+
+	sizes, err := repo.GetPackSizesAsSlice(DefaultProfile)
+	if err != nil {
+		t.Fatalf("GetPackSizesAsSlice() error = %v", err)
+	}
+	if !reflect.DeepEqual(sizes, []int{250, 500, 1000}) {
+		t.Errorf("Pack sizes = %v, want [250 500 1000]", sizes)
+	}
+}
+
+func TestWithTx_CommitsOrRollsBackEverything(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.PrepareStatements(); err != nil {
+		t.Fatalf("PrepareStatements() error = %v", err)
+	}
+	order := func(amount int) *models.Order {
+		return &models.Order{Amount: amount, TotalItems: amount, TotalPacks: 1, Packs: map[int]int{amount: 1}}
+	}
+
+	// Prepared statements, plain queries and methods with their own transaction all
+	// roll back with the callback's error
+	errFailed := errors.New("failed after writing")
+	err := repo.WithTx(func(tx Store) error {
+		if _, err := tx.AddPackSize(250); err != nil {
+			return err
+		}
+		if err := tx.SaveOrder(order(250)); err != nil {
+			return err
+		}
+		if err := tx.SaveOrdersContext(context.Background(), []*models.Order{order(500), order(1000)}); err != nil {
+			return err
+		}
+		return errFailed
+	})
+	if !errors.Is(err, errFailed) {
+		t.Fatalf("WithTx() error = %v, want the callback's error", err)
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); len(sizes) != 0 || countOrders(t, repo) != 0 {
+		t.Fatalf("After rollback: sizes %v and %d orders, want none", sizes, countOrders(t, repo))
+	}
+
+	// A failing repository method rolls back the writes before it
+	err = repo.WithTx(func(tx Store) error {
+		if err := tx.AddPackSizes([]int{250, 500}); err != nil {
+			return err
+		}
+		return tx.AddPackSizes([]int{500})
+	})
+	if !errors.Is(err, ErrPackSizeExists) {
+		t.Fatalf("WithTx() error = %v, want ErrPackSizeExists", err)
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); len(sizes) != 0 {
+		t.Fatalf("After rollback: sizes %v, want none", sizes)
+	}
+
+	err = repo.WithTx(func(tx Store) error {
+		if _, err := tx.AddPackSize(250); err != nil {
+			return err
+		}
+		return tx.SaveOrder(order(250))
+	})
+	if err != nil {
+		t.Fatalf("WithTx() error = %v", err)
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); !reflect.DeepEqual(sizes, []int{250}) || countOrders(t, repo) != 1 {
+		t.Errorf("After commit: sizes %v and %d orders, want [250] and 1", sizes, countOrders(t, repo))
+	}
+}
+
+func TestLockPackSizes_SerializesLimitChecks(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.LockPackSizes(); err == nil {
+		t.Error("LockPackSizes() outside WithTx: expected error")
+	}
+
+	// Each transaction adds a size only while the set has fewer than one; without the
+	// lock both would read an empty set and add theirs
+	const limit = 1
+	locked := make(chan struct{})
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	addWithinLimit := func(size int, hold bool) {
+		errs <- repo.WithTx(func(tx Store) error {
+			if err := tx.LockPackSizes(); err != nil {
+				return err
+			}
+			if hold {
+				close(locked)
+				<-release
+			}
+			sizes, err := tx.GetPackSizesAsSlice(DefaultProfile)
+			if err != nil || len(sizes) >= limit {
+				return err
+			}
+			_, err = tx.AddPackSize(size)
+			return err
+		})
+	}
+	go addWithinLimit(250, true)
+	<-locked
+	go addWithinLimit(500, false)
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("WithTx() error = %v", err)
+		}
+	}
+
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); !reflect.DeepEqual(sizes, []int{250}) {
+		t.Errorf("Sizes = %v, want only [250] within the limit", sizes)
+	}
+}
+
+func TestUpdatePackSize_KeepsRecordAndReportsConflicts(t *testing.T) {
+	repo := newTestRepository(t)
+	for _, size := range []int{250, 500} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+	before, err := repo.GetAllPackSizes()
+	if err != nil {
+		t.Fatalf("GetAllPackSizes() error = %v", err)
+	}
+
+	if err := repo.UpdatePackSize(250, 500); !errors.Is(err, ErrPackSizeExists) {
+		t.Errorf("UpdatePackSize(250, 500) error = %v, want ErrPackSizeExists", err)
+	}
+	if err := repo.UpdatePackSize(300, 350); !errors.Is(err, ErrPackSizeNotFound) {
+		t.Errorf("UpdatePackSize(300, 350) error = %v, want ErrPackSizeNotFound", err)
+	}
+	if err := repo.UpdatePackSize(250, 300); err != nil {
+		t.Fatalf("UpdatePackSize(250, 300) error = %v", err)
+	}
+
+	after, err := repo.GetAllPackSizes()
+	if err != nil {
+		t.Fatalf("GetAllPackSizes() error = %v", err)
+	}
+	if len(after) != 2 || after[0].Size != 300 || after[0].ID != before[0].ID || !after[0].CreatedAt.Equal(before[0].CreatedAt) {
+		t.Errorf("Pack sizes after update = %+v, want 250 renamed to 300 with its id and created_at", after)
+	}
+}
+
+func TestDeletePackSize_SoftDeletesAndRestores(t *testing.T) {
+	repo := newTestRepository(t)
+	if err := repo.PrepareStatements(); err != nil {
+		t.Fatalf("PrepareStatements() error = %v", err)
+	}
+	for _, size := range []int{250, 500} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+
+	if err := repo.DeletePackSize(250); err != nil {
+		t.Fatalf("DeletePackSize() error = %v", err)
+	}
+	if err := repo.DeletePackSize(250); err == nil {
+		t.Error("Deleting an already deleted size: expected error")
+	}
+	if exists, _ := repo.PackSizeExists(250); exists {
+		t.Error("PackSizeExists(250) = true after delete")
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); !reflect.DeepEqual(sizes, []int{500}) {
+		t.Errorf("Sizes after delete = %v, want [500]", sizes)
+	}
+	all, err := repo.GetAllPackSizesIncludingDeleted()
+	if err != nil {
+		t.Fatalf("GetAllPackSizesIncludingDeleted() error = %v", err)
+	}
+	if len(all) != 2 || all[0].Size != 250 || all[0].DeletedAt == nil || all[1].DeletedAt != nil {
+		t.Errorf("All sizes = %+v, want deleted 250 and live 500", all)
+	}
+
+	// The deleted row does not block adding the size again, but then blocks restoring it
+	if _, err := repo.AddPackSize(250); err != nil {
+		t.Fatalf("Re-adding a deleted size error = %v", err)
+	}
+	if err := repo.RestorePackSize(250); !errors.Is(err, ErrPackSizeExists) {
+		t.Errorf("RestorePackSize() over a live size error = %v, want ErrPackSizeExists", err)
+	}
+	if err := repo.DeletePackSize(250); err != nil {
+		t.Fatalf("DeletePackSize() error = %v", err)
+	}
+	if err := repo.RestorePackSize(250); err != nil {
+		t.Fatalf("RestorePackSize() error = %v", err)
+	}
+	if err := repo.RestorePackSize(500); !errors.Is(err, ErrPackSizeNotFound) {
+		t.Errorf("RestorePackSize() of a live size error = %v, want ErrPackSizeNotFound", err)
+	}
+
+	// Seeding only counts live sizes
+	if err := repo.SeedDefaultPackSizes(); err != nil {
+		t.Fatalf("SeedDefaultPackSizes() error = %v", err)
+	}
+	if sizes, _ := repo.GetPackSizesAsSlice(DefaultProfile); !reflect.DeepEqual(sizes, []int{250, 500}) {
+		t.Errorf("Sizes after restore and seed = %v, want [250 500]", sizes)
+	}
+}
+
+func TestPackSizesExist_OneQueryForMixedSizes(t *testing.T) {
+	repo := newTestRepository(t)
+	for _, size := range []int{250, 500} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+
+	existing, err := repo.PackSizesExist([]int{250, 300, 500, 1000, 250})
+	if err != nil {
+		t.Fatalf("PackSizesExist() error = %v", err)
+	}
+	if want := map[int]bool{250: true, 500: true}; !reflect.DeepEqual(existing, want) {
+		t.Errorf("PackSizesExist() = %v, want %v", existing, want)
+	}
+
+	existing, err = repo.PackSizesExist(nil)
+	if err != nil || existing == nil || len(existing) != 0 {
+		t.Errorf("PackSizesExist(nil) = %v, %v, want an empty map", existing, err)
+	}
+}
+
+func TestSaveOrder_PackSizesRoundTrip(t *testing.T) {
+	repo := newTestRepository(t)
+
+	withSizes := &models.Order{Amount: 263, TotalItems: 263, TotalPacks: 7, Packs: map[int]int{23: 2, 31: 7}, PackSizes: []int{23, 31, 53}}
+	legacy := &models.Order{Amount: 250, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}
+	if err := repo.SaveOrder(withSizes); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+	if err := repo.SaveOrders([]*models.Order{legacy}); err != nil {
+		t.Fatalf("SaveOrders() error = %v", err)
+	}
+
+	orders, err := repo.GetAllOrders(10)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	byID := make(map[int]models.Order)
+	for _, order := range orders {
+		byID[order.ID] = order
+	}
+	if got := byID[withSizes.ID].PackSizes; !reflect.DeepEqual(got, []int{23, 31, 53}) {
+		t.Errorf("PackSizes = %v, want [23 31 53]", got)
+	}
+	if got := byID[legacy.ID].PackSizes; got != nil {
+		t.Errorf("Order saved without sizes: PackSizes = %v, want nil", got)
+	}
+}
+
+func TestPacksCodec_RoundTrip(t *testing.T) {
+	packs := map[int]int{23: 2, 31: 7, 53: 9429}
+
+	for _, compress := range []bool{false, true} {
+		packsJSON, packsGz, err := encodePacks(packs, compress)
+		if err != nil {
+			t.Fatalf("encodePacks(compress=%v) error = %v", compress, err)
+		}
+		if (packsJSON == "") != compress || (len(packsGz) > 0) != compress {
+			t.Errorf("compress=%v: stored JSON %q and %d gzip bytes", compress, packsJSON, len(packsGz))
+		}
+
+		decoded, err := decodePacks(packsJSON, packsGz)
+		if err != nil {
+			t.Fatalf("decodePacks(compress=%v) error = %v", compress, err)
+		}
+		if !reflect.DeepEqual(decoded, packs) {
+			t.Errorf("compress=%v: decoded = %v, want %v", compress, decoded, packs)
+		}
+	}
+
+	if _, err := decodePacks("", []byte("not gzip")); err == nil {
+		t.Error("decodePacks() expected error for corrupt gzip data")
+	}
+}
+
+func TestGetAllOrders_ReadsCompressedAndLegacyRows(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Legacy row written before compression existed
+	if _, err := repo.db.Exec(
+		`INSERT INTO orders (amount, total_items, total_packs, packs_json) VALUES ($1, $2, $3, $4)`,
+		251, 500, 1, `{"500":1}`,
+	); err != nil {
+		t.Fatalf("Failed to insert legacy order: %v", err)
+	}
+
+	repo.SetPacksCompression(true)
+	compressed := &models.Order{Amount: 501, TotalItems: 750, TotalPacks: 2, Packs: map[int]int{500: 1, 250: 1}}
+	if err := repo.SaveOrder(compressed); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+
+	var stored string
+	var storedGz []byte
+	if err := repo.db.QueryRow(`SELECT packs_json, packs_gz FROM orders WHERE id = $1`, compressed.ID).Scan(&stored, &storedGz); err != nil {
+		t.Fatalf("Failed to read stored packs: %v", err)
+	}
+	if stored != "" || len(storedGz) == 0 {
+		t.Errorf("Stored packs_json = %q with %d gzip bytes, want only gzip data", stored, len(storedGz))
+	}
+
+	orders, err := repo.GetAllOrders(10)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	byAmount := make(map[int]map[int]int)
+	for _, o := range orders {
+		byAmount[o.Amount] = o.Packs
+	}
+	if !reflect.DeepEqual(byAmount[251], map[int]int{500: 1}) {
+		t.Errorf("Legacy order packs = %v, want map[500:1]", byAmount[251])
+	}
+	if !reflect.DeepEqual(byAmount[501], compressed.Packs) {
+		t.Errorf("Compressed order packs = %v, want %v", byAmount[501], compressed.Packs)
+	}
+}
+
+func packSizeSet(t *testing.T, repo *Repository) map[int]bool {
+	t.Helper()
+	sizes, err := repo.GetPackSizesAsSlice(DefaultProfile)
+	if err != nil {
+		t.Fatalf("GetPackSizesAsSlice() error = %v", err)
+	}
+	set := make(map[int]bool, len(sizes))
+	for _, size := range sizes {
+		set[size] = true
+	}
+	return set
+}
+
+func TestSeedDefaultPackSizes_EmptyTable(t *testing.T) {
+	repo := newTestRepository(t)
+
+	if err := repo.SeedDefaultPackSizes(); err != nil {
+		t.Fatalf("SeedDefaultPackSizes() error = %v", err)
+	}
+
+	set := packSizeSet(t, repo)
+	if len(set) != len(DefaultPackSizes) {
+		t.Errorf("Got %d sizes, want %d", len(set), len(DefaultPackSizes))
+	}
+	for _, size := range DefaultPackSizes {
+		if !set[size] {
+			t.Errorf("Default size %d missing", size)
+		}
+	}
+}
+
+func TestReconcileDefaultPackSizes(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Operator customized: one default removed, one custom size added
+	for _, size := range []int{250, 500, 1000, 2000, 750} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+
+	// Reconcile off: seeding a non-empty table changes nothing
+	if err := repo.SeedDefaultPackSizes(); err != nil {
+		t.Fatalf("SeedDefaultPackSizes() error = %v", err)
+	}
+	if set := packSizeSet(t, repo); set[5000] || len(set) != 5 {
+		t.Errorf("Seed on non-empty table changed sizes: %v", set)
+	}
+
+	added, err := repo.ReconcileDefaultPackSizes()
+	if err != nil {
+		t.Fatalf("ReconcileDefaultPackSizes() error = %v", err)
+	}
+	if !reflect.DeepEqual(added, []int{5000}) {
+		t.Errorf("Added = %v, want [5000]", added)
+	}
+
+	set := packSizeSet(t, repo)
+	if !set[5000] || !set[750] || len(set) != 6 {
+		t.Errorf("Sizes after reconcile = %v, want defaults plus 750", set)
+	}
+
+	// Running again is a no-op
+	if added, err := repo.ReconcileDefaultPackSizes(); err != nil || len(added) != 0 {
+		t.Errorf("Second reconcile added %v, err %v; want nothing", added, err)
+	}
+
+	// A default the operator deleted is not brought back
+	if err := repo.DeletePackSize(2000); err != nil {
+		t.Fatalf("DeletePackSize(2000) error = %v", err)
+	}
+	if added, err := repo.ReconcileDefaultPackSizes(); err != nil || len(added) != 0 {
+		t.Errorf("Reconcile after delete added %v, err %v; want nothing", added, err)
+	}
+	if set := packSizeSet(t, repo); set[2000] || len(set) != 5 {
+		t.Errorf("Sizes after reconcile = %v, want 2000 still deleted", set)
+	}
+}
+
+func TestQueryOrders_AmountFilters(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for _, amount := range []int{100, 250, 251, 500, 1000} {
+		order := &models.Order{Amount: amount, TotalItems: amount, TotalPacks: 1, Packs: map[int]int{amount: 1}}
+		if err := repo.SaveOrder(order); err != nil {
+			t.Fatalf("SaveOrder() error = %v", err)
+		}
+	}
+
+	intPtr := func(n int) *int { return &n }
+	tests := []struct {
+		name   string
+		filter OrderFilter
+		want   map[int]bool
+	}{
+		{"exact", OrderFilter{Amount: intPtr(251), Limit: 10}, map[int]bool{251: true}},
+		{"range", OrderFilter{MinAmount: intPtr(250), MaxAmount: intPtr(500), Limit: 10}, map[int]bool{250: true, 251: true, 500: true}},
+		{"empty", OrderFilter{Amount: intPtr(7), Limit: 10}, map[int]bool{}},
+		{"limit", OrderFilter{MinAmount: intPtr(0), Limit: 2}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orders, err := repo.QueryOrders(tt.filter)
+			if err != nil {
+				t.Fatalf("QueryOrders() error = %v", err)
+			}
+			if tt.want == nil {
+				if len(orders) != tt.filter.Limit {
+					t.Errorf("Got %d orders, want %d", len(orders), tt.filter.Limit)
+				}
+				return
+			}
+			got := make(map[int]bool, len(orders))
+			for _, o := range orders {
+				got[o.Amount] = true
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Amounts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderChecksum_IndependentOfMapOrder(t *testing.T) {
+	a := orderChecksum(12001, 12250, 4, map[int]int{5000: 2, 2000: 1, 250: 1})
+	b := orderChecksum(12001, 12250, 4, map[int]int{250: 1, 2000: 1, 5000: 2})
+	if a != b {
+		t.Errorf("Checksums differ for equal packs: %s vs %s", a, b)
+	}
+	if c := orderChecksum(12001, 12250, 4, map[int]int{5000: 2, 2000: 1, 500: 1}); c == a {
+		t.Error("Checksum unchanged after packs changed")
+	}
+}
+
+func TestGetAllOrders_DetectsTamperedPacks(t *testing.T) {
+	repo := newTestRepository(t)
+
+	valid := &models.Order{Amount: 251, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}}
+	tampered := &models.Order{Amount: 501, TotalItems: 750, TotalPacks: 2, Packs: map[int]int{500: 1, 250: 1}}
+	for _, o := range []*models.Order{valid, tampered} {
+		if err := repo.SaveOrder(o); err != nil {
+			t.Fatalf("SaveOrder() error = %v", err)
+		}
+		if o.Checksum == "" {
+			t.Fatal("SaveOrder() did not set a checksum")
+		}
+	}
+
+	// Simulate silent corruption of the stored packs
+	if _, err := repo.db.Exec(`UPDATE orders SET packs_json = $1 WHERE id = $2`, `{"250":3}`, tampered.ID); err != nil {
+		t.Fatalf("Failed to tamper with order: %v", err)
+	}
+
+	orders, err := repo.GetAllOrders(10)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	corrupted := make(map[int]bool)
+	for _, o := range orders {
+		corrupted[o.ID] = o.Corrupted
+	}
+	if corrupted[valid.ID] {
+		t.Error("Valid order flagged as corrupted")
+	}
+	if !corrupted[tampered.ID] {
+		t.Error("Tampered order not flagged as corrupted")
+	}
+}
+
+func TestReserveStock_ConcurrentNeverOversells(t *testing.T) {
+	repo := newTestRepository(t)
+	if _, err := repo.AddPackSize(250); err != nil {
+		t.Fatalf("AddPackSize() error = %v", err)
+	}
+	stock := 10
+	if err := repo.SetStock(250, &stock); err != nil {
+		t.Fatalf("SetStock() error = %v", err)
+	}
+
+	const attempts = 25
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := repo.ReserveStock(map[int]int{250: 1})
+			if err != nil && !errors.Is(err, ErrInsufficientStock) {
+				t.Errorf("ReserveStock() unexpected error = %v", err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if succeeded != stock {
+		t.Errorf("%d reservations succeeded, want %d", succeeded, stock)
+	}
+	sizes, err := repo.GetAllPackSizes()
+	if err != nil {
+		t.Fatalf("GetAllPackSizes() error = %v", err)
+	}
+	if len(sizes) != 1 || sizes[0].Stock == nil || *sizes[0].Stock != 0 {
+		t.Errorf("Remaining stock = %+v, want 0", sizes)
+	}
+}
+
+func TestReserveStock_AllOrNothing(t *testing.T) {
+	repo := newTestRepository(t)
+	for _, size := range []int{250, 500} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize() error = %v", err)
+		}
+	}
+	five, one := 5, 1
+	repo.SetStock(250, &five)
+	repo.SetStock(500, &one)
+
+	if err := repo.ReserveStock(map[int]int{250: 2, 500: 2}); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("ReserveStock() error = %v, want ErrInsufficientStock", err)
+	}
+	sizes, _ := repo.GetAllPackSizes()
+	for _, ps := range sizes {
+		if ps.Size == 250 && *ps.Stock != 5 {
+			t.Errorf("250 stock = %d after failed reservation, want 5", *ps.Stock)
+		}
+	}
+
+	if err := repo.ReserveStock(map[int]int{1000: 1}); !errors.Is(err, ErrPackSizeNotFound) {
+		t.Errorf("Unknown size error = %v, want ErrPackSizeNotFound", err)
+	}
+}
+
+func TestStatsSnapshots_HistoryAndPrune(t *testing.T) {
+	repo := newTestRepository(t)
+
+	for i := int64(1); i <= 3; i++ {
+		if err := repo.SaveStatsSnapshot(&models.StatsSnapshot{CacheHits: i, Calculations: i * 10}); err != nil {
+			t.Fatalf("SaveStatsSnapshot() error = %v", err)
+		}
+	}
+	// Backdate the first snapshot past the retention cutoff
+	if _, err := repo.db.Exec(`UPDATE stats_snapshots SET created_at = created_at - INTERVAL '2 hours' WHERE id = 1`); err != nil {
+		t.Fatalf("Failed to backdate snapshot: %v", err)
+	}
+
+	snapshots, err := repo.GetStatsSnapshots(time.Now().Add(-time.Hour), 10)
+	if err != nil {
+		t.Fatalf("GetStatsSnapshots() error = %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].CacheHits != 2 || snapshots[1].CacheHits != 3 {
+		t.Errorf("Snapshots = %+v, want hits 2 then 3", snapshots)
+	}
+
+	// The limit keeps the newest snapshots
+	snapshots, err = repo.GetStatsSnapshots(time.Now().Add(-3*time.Hour), 2)
+	if err != nil {
+		t.Fatalf("GetStatsSnapshots() error = %v", err)
+	}
+	if len(snapshots) != 2 || snapshots[0].CacheHits != 2 || snapshots[1].CacheHits != 3 {
+		t.Errorf("Limited snapshots = %+v, want hits 2 then 3", snapshots)
+	}
+
+	pruned, err := repo.PruneStatsSnapshots(time.Now().Add(-time.Hour))
+	if err != nil || pruned != 1 {
+		t.Errorf("PruneStatsSnapshots() = %d, %v, want 1", pruned, err)
+	}
+}
+
+func TestGetPackSizesWithUsage(t *testing.T) {
+	repo := newTestRepository(t)
+	for _, size := range []int{250, 500, 1000} {
+		if _, err := repo.AddPackSize(size); err != nil {
+			t.Fatalf("AddPackSize(%d) error = %v", size, err)
+		}
+	}
+
+	// One plain and one compressed order
+	if err := repo.SaveOrder(&models.Order{Amount: 500, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}}); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+	repo.SetPacksCompression(true)
+	if err := repo.SaveOrder(&models.Order{Amount: 750, TotalItems: 750, TotalPacks: 2, Packs: map[int]int{250: 1, 500: 1}}); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+
+	var first, second time.Time
+	repo.db.QueryRow(`SELECT created_at FROM orders WHERE id = 1`).Scan(&first)
+	repo.db.QueryRow(`SELECT created_at FROM orders WHERE id = 2`).Scan(&second)
+
+	// Saving keeps usage current
+	checkPackSizeUsage(t, repo, map[int]time.Time{250: second, 500: second})
+
+	// The backfill decodes both kinds of order and keeps each size's newest use, here
+	// the first order's once the second is backdated
+	if _, err := repo.db.Exec(`UPDATE orders SET created_at = created_at - INTERVAL '1 day' WHERE id = 2`); err != nil {
+		t.Fatalf("Failed to backdate order: %v", err)
+	}
+	repo.db.QueryRow(`SELECT created_at FROM orders WHERE id = 2`).Scan(&second)
+	if _, err := repo.db.Exec(`DELETE FROM pack_size_usage`); err != nil {
+		t.Fatalf("Failed to clear usage: %v", err)
+	}
+	if err := repo.backfillPackSizeUsage(); err != nil {
+		t.Fatalf("backfillPackSizeUsage() error = %v", err)
+	}
+	checkPackSizeUsage(t, repo, map[int]time.Time{250: second, 500: first})
+
+	// Usage outlives the orders it came from
+	if _, err := repo.db.Exec(`DELETE FROM orders`); err != nil {
+		t.Fatalf("Failed to delete orders: %v", err)
+	}
+	checkPackSizeUsage(t, repo, map[int]time.Time{250: second, 500: first})
+}
+
+// checkPackSizeUsage compares the 250, 500 and 1000 sizes' last use with want,
+// where a missing size means it was never used
+func checkPackSizeUsage(t *testing.T, repo *Repository, want map[int]time.Time) {
+	t.Helper()
+
+	usage, err := repo.GetPackSizesWithUsage()
+	if err != nil {
+		t.Fatalf("GetPackSizesWithUsage() error = %v", err)
+	}
+	if len(usage) != 3 {
+		t.Fatalf("Got %d sizes, want 3", len(usage))
+	}
+	for _, u := range usage {
+		wantAt, used := want[u.Size]
+		switch {
+		case !used && u.LastUsedAt != nil:
+			t.Errorf("%d last used %v, want nil", u.Size, u.LastUsedAt)
+		case used && (u.LastUsedAt == nil || !u.LastUsedAt.Equal(wantAt)):
+			t.Errorf("%d last used %v, want %v", u.Size, u.LastUsedAt, wantAt)
+		}
+	}
+}
+
+func TestRecomputeOrderTotals_FixesMismatchedRows(t *testing.T) {
+	repo := newTestRepository(t)
+
+	orders := []*models.Order{
+		{Amount: 251, TotalItems: 500, TotalPacks: 1, Packs: map[int]int{500: 1}},
+		{Amount: 750, TotalItems: 750, TotalPacks: 2, Packs: map[int]int{250: 1, 500: 1}},
+		{Amount: 1000, TotalItems: 1000, TotalPacks: 1, Packs: map[int]int{1000: 1}},
+	}
+	for _, order := range orders {
+		if err := repo.SaveOrder(order); err != nil {
+			t.Fatalf("SaveOrder() error = %v", err)
+		}
+	}
+	// Simulate a past bug that stored and signed totals inconsistent with the packs
+	if _, err := repo.db.Exec(`UPDATE orders SET total_items = 999, total_packs = 7, checksum = $1 WHERE id = 2`,
+		orderChecksum(750, 999, 7, orders[1].Packs)); err != nil {
+		t.Fatalf("Failed to corrupt totals: %v", err)
+	}
+	// And an edit outside the application, which the checksum no longer covers
+	if _, err := repo.db.Exec(`UPDATE orders SET total_items = 5 WHERE id = 3`); err != nil {
+		t.Fatalf("Failed to tamper with totals: %v", err)
+	}
+
+	// A batch size of 1 exercises the batching across several transactions
+	result, err := repo.RecomputeOrderTotals(1)
+	if err != nil {
+		t.Fatalf("RecomputeOrderTotals() error = %v", err)
+	}
+	if result.Scanned != 3 || result.Corrected != 1 || result.Skipped != 0 || result.Corrupted != 1 ||
+		len(result.CorruptedIDs) != 1 || result.CorruptedIDs[0] != 3 {
+		t.Errorf("Result = %+v, want 3 scanned, 1 corrected and order 3 reported corrupted", result)
+	}
+
+	got, err := repo.GetAllOrders(10)
+	if err != nil {
+		t.Fatalf("GetAllOrders() error = %v", err)
+	}
+	for _, order := range got {
+		if order.ID == 2 && (order.TotalItems != 750 || order.TotalPacks != 2) {
+			t.Errorf("Order 2 totals = %d/%d, want 750/2", order.TotalItems, order.TotalPacks)
+		}
+		if order.Corrupted != (order.ID == 3) {
+			t.Errorf("Order %d corrupted = %v after recompute, want only order 3", order.ID, order.Corrupted)
+		}
+		if order.ID == 3 && order.TotalItems != 5 {
+			t.Errorf("Tampered order 3 was rewritten to %d items", order.TotalItems)
+		}
+	}
+
+	// A second run finds nothing left to fix
+	if result, err := repo.RecomputeOrderTotals(2); err != nil || result.Corrected != 0 {
+		t.Errorf("Second run = %+v, %v, want nothing corrected", result, err)
+	}
+}
+
+func TestTimestamps_UTCRegardlessOfSessionZone(t *testing.T) {
+	repo := newTestRepository(t)
+	// One connection, so the session zone applies to every query below
+	repo.pool.SetMaxOpenConns(1)
+	if _, err := repo.db.Exec(`SET TIME ZONE 'Asia/Tokyo'`); err != nil {
+		t.Fatalf("Failed to set session zone: %v", err)
+	}
+
+	before := time.Now().Add(-time.Second)
+	if err := repo.SaveOrder(&models.Order{Amount: 250, TotalItems: 250, TotalPacks: 1, Packs: map[int]int{250: 1}}); err != nil {
+		t.Fatalf("SaveOrder() error = %v", err)
+	}
+	if _, err := repo.AddPackSize(250); err != nil {
+		t.Fatalf("AddPackSize() error = %v", err)
+	}
+
+	orders, err := repo.GetAllOrders(10)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("GetAllOrders() = %v, %v, want one order", orders, err)
+	}
+	order := orders[0]
+	if order.CreatedAt.Location() != time.UTC || order.CreatedAt.Before(before) || order.CreatedAt.After(time.Now()) {
+		t.Errorf("created_at = %v, want the current time in UTC", order.CreatedAt)
+	}
+	if !order.UpdatedAt.Equal(order.CreatedAt) || order.UpdatedAt.Location() != time.UTC {
+		t.Errorf("updated_at = %v, want created_at %v", order.UpdatedAt, order.CreatedAt)
+	}
+
+	sizes, err := repo.GetAllPackSizes()
+	if err != nil || len(sizes) != 1 {
+		t.Fatalf("GetAllPackSizes() = %v, %v, want one size", sizes, err)
+	}
+	if sizes[0].CreatedAt.Location() != time.UTC || sizes[0].CreatedAt.Before(before) {
+		t.Errorf("Pack size created_at = %v, want the current time in UTC", sizes[0].CreatedAt)
+	}
+
+	// A rewrite by RecomputeOrderTotals moves updated_at but not created_at
+	// (the row is made unsigned, as a tampered signed row would be left alone)
+	if _, err := repo.db.Exec(`UPDATE orders SET total_items = 999, checksum = ''`); err != nil {
+		t.Fatalf("Failed to corrupt totals: %v", err)
+	}
+	if _, err := repo.RecomputeOrderTotals(10); err != nil {
+		t.Fatalf("RecomputeOrderTotals() error = %v", err)
+	}
+	orders, _ = repo.GetAllOrders(10)
+	if !orders[0].CreatedAt.Equal(order.CreatedAt) || !orders[0].UpdatedAt.After(order.UpdatedAt) {
+		t.Errorf("After recompute created_at = %v, updated_at = %v; want %v and later", orders[0].CreatedAt, orders[0].UpdatedAt, order.CreatedAt)
+	}
+}
+
+func TestMigrateTimestamps_ReadsLegacyRowsInTheirLocalZone(t *testing.T) {
+	repo := newTestRepository(t)
+
+	// Rows written by the baseline: zoneless columns holding the API server's local wall
+	// time, here Amsterdam's (UTC+1 in March), for both the order and the pack size
+	for _, table := range []string{"orders", "pack_sizes"} {
+		if _, err := repo.db.Exec(`ALTER TABLE ` + table + ` ALTER COLUMN created_at TYPE TIMESTAMP`); err != nil {
+			t.Fatalf("Failed to restore legacy column: %v", err)
+		}
+	}
+	t.Cleanup(func() { repo.MigrateTimestamps("UTC") }) // Should the test fail before migrating
+	if _, err := repo.db.Exec(
+		`INSERT INTO orders (amount, total_items, total_packs, packs_json, created_at) VALUES (250, 250, 1, '{"250":1}', '2024-03-15 13:30:00')`,
+	); err != nil {
+		t.Fatalf("Failed to insert legacy order: %v", err)
+	}
+	if _, err := repo.db.Exec(`INSERT INTO pack_sizes (size, created_at) VALUES (250, '2024-03-15 13:30:00')`); err != nil {
+		t.Fatalf("Failed to insert legacy pack size: %v", err)
+	}
+
+	// Starting up leaves the columns alone and reports them
+	if err := repo.InitSchema(); err != nil {
+		t.Fatalf("InitSchema() error = %v", err)
+	}
+	legacy, err := repo.LegacyTimestampColumns()
+	if err != nil || !reflect.DeepEqual(legacy, []string{"pack_sizes.created_at", "orders.created_at"}) {
+		t.Fatalf("LegacyTimestampColumns() = %v, %v, want the two restored columns", legacy, err)
+	}
+
+	// Migrating again must not shift already migrated values
+	for i := 0; i < 2; i++ {
+		if _, err := repo.MigrateTimestamps("Europe/Amsterdam"); err != nil {
+			t.Fatalf("MigrateTimestamps() run %d error = %v", i+1, err)
+		}
+	}
+	if legacy, err := repo.LegacyTimestampColumns(); err != nil || len(legacy) != 0 {
+		t.Errorf("LegacyTimestampColumns() after migrating = %v, %v, want none", legacy, err)
+	}
+
+	want := time.Date(2024, 3, 15, 12, 30, 0, 0, time.UTC)
+	orders, err := repo.GetAllOrders(10)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("GetAllOrders() = %v, %v, want one order", orders, err)
+	}
+	if !orders[0].CreatedAt.Equal(want) || !orders[0].UpdatedAt.Equal(want) {
+		t.Errorf("Migrated order created_at/updated_at = %v/%v, want %v", orders[0].CreatedAt, orders[0].UpdatedAt, want)
+	}
+	packSizes, err := repo.GetAllPackSizes()
+	if err != nil || len(packSizes) != 1 {
+		t.Fatalf("GetAllPackSizes() = %v, %v, want one size", packSizes, err)
+	}
+	if !packSizes[0].CreatedAt.Equal(want) {
+		t.Errorf("Migrated pack size created_at = %v, want %v", packSizes[0].CreatedAt, want)
+	}
+}
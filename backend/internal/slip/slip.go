@@ -0,0 +1,98 @@
+package slip
+
+import (
+	"bytes"
+	"fmt"
+	"pack-calculator/internal/models"
+	"sort"
+	"strings"
+)
+
+// ContentType is the MIME type of a rendered slip
+const ContentType = "application/pdf"
+
+const (
+	pageWidth  = 595 // A4 in points
+	pageHeight = 842
+	marginLeft = 56
+	marginTop  = 72
+	lineHeight = 18
+)
+
+// Render produces a single-page packing slip PDF for a calculation result,
+// listing each pack size with its count and subtotal followed by the grand totals.
+// Pack sizes are listed largest first. The content stream is left uncompressed.
+func Render(result models.PackCalculationResult) []byte {
+	sizes := make([]int, 0, len(result.Packs))
+	for size := range result.Packs {
+		sizes = append(sizes, size)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	lines := []string{
+		"Packing Slip",
+		fmt.Sprintf("Ordered items: %d", result.Amount),
+		"",
+		"Pack size    Count    Subtotal",
+	}
+	for _, size := range sizes {
+		count := result.Packs[size]
+		lines = append(lines, fmt.Sprintf("%9d    %5d    %8d", size, count, size*count))
+	}
+	lines = append(lines,
+		"",
+		fmt.Sprintf("Total packs: %d", result.TotalPacks),
+		fmt.Sprintf("Total items: %d", result.TotalItems),
+	)
+
+	return buildPDF(contentStream(lines))
+}
+
+// contentStream lays out lines top to bottom in a monospaced font
+func contentStream(lines []string) string {
+	var b strings.Builder
+	b.WriteString("BT\n/F1 12 Tf\n")
+	fmt.Fprintf(&b, "%d TL\n", lineHeight)
+	fmt.Fprintf(&b, "%d %d Td\n", marginLeft, pageHeight-marginTop)
+	for _, line := range lines {
+		fmt.Fprintf(&b, "(%s) Tj T*\n", escapeText(line))
+	}
+	b.WriteString("ET\n")
+	return b.String()
+}
+
+// escapeText escapes the characters that delimit PDF literal strings
+func escapeText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`)
+	return r.Replace(s)
+}
+
+// buildPDF assembles the object graph and cross-reference table for one page
+func buildPDF(content string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>", pageWidth, pageHeight),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", len(content), content),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xrefOffset := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n", len(objects)+1)
+	buf.WriteString("0000000000 65535 f \n")
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return buf.Bytes()
+}
@@ -0,0 +1,55 @@
+package slip
+
+import (
+	"bytes"
+	"fmt"
+	"pack-calculator/internal/models"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	result := models.PackCalculationResult{
+		Amount:     12001,
+		TotalItems: 12250,
+		TotalPacks: 4,
+		Packs:      map[int]int{5000: 2, 2000: 1, 250: 1},
+	}
+
+	pdf := Render(result)
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) {
+		t.Fatalf("Missing PDF header: %q", pdf[:min(len(pdf), 16)])
+	}
+	if !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Error("Missing EOF trailer")
+	}
+
+	for _, want := range []string{
+		"(Total items: 12250)",
+		"(Total packs: 4)",
+		fmt.Sprintf("(%9d    %5d    %8d)", 5000, 2, 10000),
+		fmt.Sprintf("(%9d    %5d    %8d)", 250, 1, 250),
+	} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+
+	// startxref must point at the xref table
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(pdf)
+	if m == nil {
+		t.Fatal("Missing startxref")
+	}
+	offset, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(pdf[offset:], []byte("xref\n")) {
+		t.Errorf("startxref %d does not point at the xref table", offset)
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	if got := escapeText(`a(b)\c`); got != `a\(b\)\\c` {
+		t.Errorf("escapeText() = %q", got)
+	}
+}
@@ -0,0 +1,105 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	json "github.com/goccy/go-json"
+)
+
+// Pack size change event types
+const (
+	EventPackSizeAdded    = "pack_size.added"
+	EventPackSizeDeleted  = "pack_size.deleted"
+	EventPackSizeUpdated  = "pack_size.updated"
+	EventPackSizeRestored = "pack_size.restored"
+)
+
+// Event is the payload POSTed to each webhook URL
+type Event struct {
+	Type      string    `json:"type"`
+	Size      int       `json:"size"`
+	OldSize   int       `json:"old_size,omitempty"` // Set for updates
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Notifier delivers events to configured URLs asynchronously with retries
+type Notifier struct {
+	urls       []string
+	client     *http.Client
+	maxRetries int
+	backoff    time.Duration
+	wg         sync.WaitGroup
+}
+
+// NewNotifier creates a notifier for the given URLs.
+// timeout bounds each delivery attempt; failed attempts are retried with linear backoff.
+func NewNotifier(urls []string, timeout time.Duration) *Notifier {
+	return &Notifier{
+		urls:       urls,
+		client:     &http.Client{Timeout: timeout},
+		maxRetries: 3,
+		backoff:    500 * time.Millisecond,
+	}
+}
+
+// Notify sends the event to every URL in the background. It never blocks on delivery.
+func (n *Notifier) Notify(event Event) {
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Webhook: failed to marshal event: %v", err)
+		return
+	}
+
+	for _, url := range n.urls {
+		n.wg.Add(1)
+		go func(url string) {
+			defer n.wg.Done()
+			if err := n.deliver(url, payload); err != nil {
+				log.Printf("Webhook: delivery to %s failed: %v", url, err)
+			}
+		}(url)
+	}
+}
+
+// NotifyPackSizeChange sends a pack size event of the given type
+func (n *Notifier) NotifyPackSizeChange(eventType string, size, oldSize int) {
+	n.Notify(Event{Type: eventType, Size: size, OldSize: oldSize})
+}
+
+// Wait blocks until all in-flight deliveries have finished
+func (n *Notifier) Wait() {
+	n.wg.Wait()
+}
+
+// deliver POSTs the payload, retrying on transport errors and non-2xx responses
+func (n *Notifier) deliver(url string, payload []byte) error {
+	var lastErr error
+	for attempt := 0; attempt <= n.maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * n.backoff)
+		}
+
+		resp, err := n.client.Post(url, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		lastErr = fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", n.maxRetries+1, lastErr)
+}
@@ -0,0 +1,30 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNotifier_RetriesUntilSuccess(t *testing.T) {
+	var attempts int32
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer receiver.Close()
+
+	n := NewNotifier([]string{receiver.URL}, time.Second)
+	n.backoff = time.Millisecond
+	n.NotifyPackSizeChange(EventPackSizeAdded, 250, 0)
+	n.Wait()
+
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("Attempts = %d, want 3", got)
+	}
+}
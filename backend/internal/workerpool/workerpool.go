@@ -0,0 +1,210 @@
+package workerpool
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSaturated is returned when all workers are busy and the wait queue is full
+var ErrSaturated = errors.New("calculation pool saturated")
+
+// DefaultAgingRate is how much estimated cost a queued call is forgiven per second of
+// waiting, so an expensive call is eventually served ahead of newer cheap ones
+const DefaultAgingRate = 10_000_000
+
+// Pool bounds how many calculations run concurrently and how many may wait for a slot.
+// Waiting calls are served cheapest first by estimated cost, with aging so expensive
+// calls are not starved. It tracks an exponentially weighted average run time to
+// estimate queueing delay.
+type Pool struct {
+	mu        sync.Mutex
+	running   int
+	queue     waitQueue
+	agingRate float64 // Cost units forgiven per second of waiting
+
+	workers  int
+	maxQueue int
+	start    time.Time // Origin of the queue's virtual clock
+	avgNanos int64     // EWMA of run durations
+}
+
+// NewPool creates a pool with the given number of workers and queue capacity.
+// initialEstimate seeds the average run time until real measurements arrive.
+func NewPool(workers, maxQueue int, initialEstimate time.Duration) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if maxQueue < 0 {
+		maxQueue = 0
+	}
+	return &Pool{
+		agingRate: DefaultAgingRate,
+		workers:   workers,
+		maxQueue:  maxQueue,
+		start:     time.Now(),
+		avgNanos:  int64(initialEstimate),
+	}
+}
+
+// SetAgingRate sets how much estimated cost a queued call is forgiven per second of
+// waiting. Zero serves strictly by cost, which may starve expensive calls.
+func (p *Pool) SetAgingRate(costPerSecond float64) {
+	if costPerSecond < 0 {
+		costPerSecond = 0
+	}
+	p.mu.Lock()
+	p.agingRate = costPerSecond
+	p.mu.Unlock()
+}
+
+// Do runs fn once a worker slot is free, queued as a zero-cost call: such calls are
+// served in arrival order, ahead of any costed call that has not aged past them.
+func (p *Pool) Do(ctx context.Context, fn func()) error {
+	return p.DoCost(ctx, 0, fn)
+}
+
+// DoCost runs fn once a worker slot is free. While all workers are busy, waiting calls
+// are started in order of cost minus the aging allowance for the time already waited,
+// so cheap calls overtake expensive ones but not indefinitely. It returns ErrSaturated
+// without running fn when the queue is full, or the context error if ctx ends while waiting.
+func (p *Pool) DoCost(ctx context.Context, cost int64, fn func()) error {
+	p.mu.Lock()
+	if p.running < p.workers {
+		p.running++
+		p.mu.Unlock()
+	} else {
+		if p.queue.Len() >= p.maxQueue {
+			p.mu.Unlock()
+			return ErrSaturated
+		}
+		// Every waiter ages at the same rate, so ordering by cost plus the aging
+		// allowance accrued before arrival is the same as by cost minus time waited
+		w := &waiter{
+			priority: float64(cost) + p.agingRate*time.Since(p.start).Seconds(),
+			ready:    make(chan struct{}),
+		}
+		heap.Push(&p.queue, w)
+		p.mu.Unlock()
+
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			p.mu.Lock()
+			if w.index >= 0 {
+				heap.Remove(&p.queue, w.index)
+				p.mu.Unlock()
+				return ctx.Err()
+			}
+			p.mu.Unlock()
+			// The slot was handed over as ctx ended; pass it on
+			p.release()
+			return ctx.Err()
+		}
+	}
+	defer p.release()
+
+	start := time.Now()
+	fn()
+	p.record(time.Since(start))
+	return nil
+}
+
+// release hands the caller's slot to the highest priority waiter, or frees it
+func (p *Pool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.queue.Len() > 0 {
+		w := heap.Pop(&p.queue).(*waiter)
+		close(w.ready)
+		return
+	}
+	p.running--
+}
+
+// record folds a run duration into the moving average (alpha = 1/8)
+func (p *Pool) record(d time.Duration) {
+	for {
+		old := atomic.LoadInt64(&p.avgNanos)
+		next := old + (int64(d)-old)/8
+		if atomic.CompareAndSwapInt64(&p.avgNanos, old, next) {
+			return
+		}
+	}
+}
+
+// Stats describes the current pool load
+type Stats struct {
+	Workers     int
+	InFlight    int
+	Queued      int
+	AverageTime time.Duration
+}
+
+// Stats returns a snapshot of the pool load
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	inFlight, queued := p.running, p.queue.Len()
+	p.mu.Unlock()
+
+	return Stats{
+		Workers:     p.workers,
+		InFlight:    inFlight,
+		Queued:      queued,
+		AverageTime: time.Duration(atomic.LoadInt64(&p.avgNanos)),
+	}
+}
+
+// RetryAfter estimates how long until a new request could start: the queued and
+// in-flight work divided across workers, times the average run time, rounded up
+// to whole seconds (minimum one second).
+func (p *Pool) RetryAfter() time.Duration {
+	stats := p.Stats()
+	depth := stats.InFlight + stats.Queued
+	rounds := (depth + p.workers - 1) / p.workers
+	estimate := time.Duration(rounds) * stats.AverageTime
+
+	seconds := int64((estimate + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// waiter is a call queued for a worker slot
+type waiter struct {
+	priority float64       // Lower is served first
+	ready    chan struct{} // Closed when the slot is handed over
+	index    int           // Position in the heap; -1 once popped
+}
+
+// waitQueue is a min-heap of waiters by priority. Equal priorities have no defined
+// order; zero-cost calls arriving at different times still differ by their aging term.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int           { return len(q) }
+func (q waitQueue) Less(i, j int) bool { return q[i].priority < q[j].priority }
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *waitQueue) Push(x interface{}) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *waitQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
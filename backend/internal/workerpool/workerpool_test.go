@@ -0,0 +1,177 @@
+package workerpool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPool_QueueAndSaturation(t *testing.T) {
+	p := NewPool(1, 1, time.Second)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	// Second caller waits in the queue
+	queued := make(chan error, 1)
+	go func() { queued <- p.Do(context.Background(), func() {}) }()
+	for p.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Third caller is rejected immediately
+	if err := p.Do(context.Background(), func() { t.Error("saturated call ran") }); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Do() error = %v, want ErrSaturated", err)
+	}
+
+	// One running plus one queued on a single worker at ~1s each
+	if got := p.RetryAfter(); got != 2*time.Second {
+		t.Errorf("RetryAfter() = %v, want 2s", got)
+	}
+
+	close(release)
+	if err := <-queued; err != nil {
+		t.Errorf("Queued Do() error = %v", err)
+	}
+}
+
+func TestPool_ContextCancelledWhileQueued(t *testing.T) {
+	p := NewPool(1, 1, time.Second)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Do(ctx, func() { t.Error("cancelled call ran") }); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want context.Canceled", err)
+	}
+	if q := p.Stats().Queued; q != 0 {
+		t.Errorf("Queued = %d after cancellation, want 0", q)
+	}
+}
+
+// queueBehind occupies the pool's only worker and returns a func that frees it
+func queueBehind(t *testing.T, p *Pool) func() {
+	t.Helper()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go p.Do(context.Background(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+	return func() { close(release) }
+}
+
+func TestPool_CheapCallsOvertakeExpensive(t *testing.T) {
+	p := NewPool(1, 10, time.Second)
+	p.SetAgingRate(0)
+	release := queueBehind(t, p)
+
+	var mu sync.Mutex
+	var order []string
+	run := func(name string, cost int64, done chan<- struct{}) {
+		p.DoCost(context.Background(), cost, func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		})
+		done <- struct{}{}
+	}
+
+	// The huge call queues first, then the tiny ones
+	done := make(chan struct{}, 4)
+	go run("huge", 1_000_000_000, done)
+	for p.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		go run("tiny", 10, done)
+	}
+	for p.Stats().Queued != 4 {
+		time.Sleep(time.Millisecond)
+	}
+
+	release()
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	want := []string{"tiny", "tiny", "tiny", "huge"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("run order = %v, want %v", order, want)
+	}
+}
+
+func TestPool_AgingPreventsStarvation(t *testing.T) {
+	p := NewPool(1, 10, time.Second)
+	p.SetAgingRate(1e12) // A millisecond of waiting forgives a billion cost units
+	release := queueBehind(t, p)
+
+	var mu sync.Mutex
+	var order []string
+	done := make(chan struct{}, 2)
+	run := func(name string, cost int64) {
+		p.DoCost(context.Background(), cost, func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		})
+		done <- struct{}{}
+	}
+
+	go run("huge", 1_000_000)
+	for p.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(5 * time.Millisecond)
+	go run("tiny", 1)
+	for p.Stats().Queued != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	release()
+	<-done
+	<-done
+	if want := []string{"huge", "tiny"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("run order = %v, want %v", order, want)
+	}
+}
+
+func TestPool_CancelledWaiterLeavesQueue(t *testing.T) {
+	p := NewPool(1, 10, time.Second)
+	release := queueBehind(t, p)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- p.DoCost(ctx, 5, func() { t.Error("cancelled call ran") }) }()
+	for p.Stats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("DoCost() error = %v, want context.Canceled", err)
+	}
+
+	release()
+	if err := p.DoCost(context.Background(), 1, func() {}); err != nil {
+		t.Errorf("DoCost() after release error = %v", err)
+	}
+	if stats := p.Stats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Errorf("Stats() = %+v, want idle", stats)
+	}
+}